@@ -6,9 +6,13 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 
 	"code.cloudfoundry.org/lager"
+	"github.com/go-acme/lego/v4/certificate"
 	"github.com/pivotal-cf/brokerapi"
 
 	"github.com/cloud-gov/cf-cdn-service-broker/cf"
@@ -18,12 +22,55 @@ import (
 )
 
 type Options struct {
-	Domain         string   `json:"domain"`
-	Origin         string   `json:"origin"`
-	Path           string   `json:"path"`
-	InsecureOrigin bool     `json:"insecure_origin"`
-	Cookies        bool     `json:"cookies"`
-	Headers        []string `json:"headers"`
+	Domain              string   `json:"domain"`
+	Origin              string   `json:"origin"`
+	Path                string   `json:"path"`
+	InsecureOrigin      bool     `json:"insecure_origin"`
+	Cookies             bool     `json:"cookies"`
+	CookieNames         []string `json:"cookie_names"`
+	QueryStringKeys     []string `json:"query_string_keys"`
+	Headers             []string `json:"headers"`
+	OriginHeaders       []string `json:"origin_headers"`
+	CertificateProvider string   `json:"certificate_provider"`
+	DNSProvider         string   `json:"dns_provider"`
+	KeyType             string   `json:"key_type"`
+	Certificate         string   `json:"certificate"`
+	CertificateChain    string   `json:"certificate_chain"`
+	PrivateKey          string   `json:"private_key"`
+	CredhubRef          string   `json:"credhub_ref"`
+
+	CacheBehaviors  []CacheBehaviorOptions `json:"cache_behaviors"`
+	ResponseHeaders *utils.ResponseHeaders `json:"response_headers"`
+	ErrorResponses  []utils.ErrorResponse  `json:"error_responses"`
+	MaintenancePage *bool                  `json:"maintenance_page"`
+}
+
+// CacheBehaviorOptions configure how requests for paths matching
+// PathPattern are cached and forwarded. Unset options take the values of the
+// instance's default behavior.
+type CacheBehaviorOptions struct {
+	PathPattern     string   `json:"path_pattern"`
+	MinTTL          *int64   `json:"min_ttl"`
+	DefaultTTL      *int64   `json:"default_ttl"`
+	MaxTTL          *int64   `json:"max_ttl"`
+	AllowedMethods  []string `json:"allowed_methods"`
+	Headers         []string `json:"headers"`
+	OriginHeaders   []string `json:"origin_headers"`
+	Cookies         *bool    `json:"cookies"`
+	CookieNames     []string `json:"cookie_names"`
+	QueryString     *bool    `json:"query_string"`
+	QueryStringKeys []string `json:"query_string_keys"`
+}
+
+// customCertificate returns the instance's own certificate, if one was given.
+func (o Options) customCertificate() (utils.CustomCertificate, bool) {
+	cert := utils.CustomCertificate{
+		Certificate: o.Certificate,
+		Chain:       o.CertificateChain,
+		PrivateKey:  o.PrivateKey,
+		CredhubRef:  o.CredhubRef,
+	}
+	return cert, cert != utils.CustomCertificate{}
 }
 
 type CdnServiceBroker struct {
@@ -49,6 +96,17 @@ func New(
 
 var (
 	MAX_HEADER_COUNT = 10
+
+	// CloudFront allows 25 cache behaviors, two of which serve ACME
+	// challenges and the maintenance page.
+	MAX_CACHE_BEHAVIORS = 23
+
+	// CloudFront keys the cache on up to 10 named cookies and 10 query
+	// string parameters.
+	MAX_COOKIE_NAMES      = 10
+	MAX_QUERY_STRING_KEYS = 10
+
+	pathPatternRegexp = regexp.MustCompile(`^[A-Za-z0-9_.*$/~"'@:+&-]+$`)
 )
 
 func (*CdnServiceBroker) Services(context context.Context) ([]brokerapi.Service, error) {
@@ -91,6 +149,36 @@ func (b *CdnServiceBroker) Provision(
 		return spec, err
 	}
 
+	originHeaders, err := b.getOriginHeaders(options.OriginHeaders)
+	if err != nil {
+		return spec, err
+	}
+
+	cookieNames, err := getNames("cookie_names", options.CookieNames, MAX_COOKIE_NAMES)
+	if err != nil {
+		return spec, err
+	}
+
+	queryStringKeys, err := getNames("query_string_keys", options.QueryStringKeys, MAX_QUERY_STRING_KEYS)
+	if err != nil {
+		return spec, err
+	}
+
+	cacheBehaviors, err := b.getCacheBehaviors(options)
+	if err != nil {
+		return spec, err
+	}
+
+	responseHeaders, err := b.getResponseHeaders(options.ResponseHeaders, true)
+	if err != nil {
+		return spec, err
+	}
+
+	errorResponses, err := b.getErrorResponses(options, true)
+	if err != nil {
+		return spec, err
+	}
+
 	tags := map[string]string{
 		"Organization": details.OrganizationGUID,
 		"Space":        details.SpaceGUID,
@@ -98,7 +186,17 @@ func (b *CdnServiceBroker) Provision(
 		"Plan":         details.PlanID,
 	}
 
-	_, err = b.manager.Create(instanceID, options.Domain, options.Origin, options.Path, options.InsecureOrigin, headers, options.Cookies, tags)
+	// Check the instance's own certificate before creating its distribution.
+	var cert *certificate.Resource
+	if custom, ok := options.customCertificate(); ok {
+		resolved, err := b.manager.ResolveCertificate(custom, strings.Split(options.Domain, ","))
+		if err != nil {
+			return spec, fmt.Errorf("invalid certificate: %v", err)
+		}
+		cert = &resolved
+	}
+
+	_, err = b.manager.Create(instanceID, options.Domain, options.Origin, options.Path, options.InsecureOrigin, headers, originHeaders, options.Cookies, cookieNames, queryStringKeys, cacheBehaviors, responseHeaders, errorResponses, options.CertificateProvider, options.DNSProvider, options.KeyType, cert, tags)
 	if err != nil {
 		return spec, err
 	}
@@ -126,17 +224,46 @@ func (b *CdnServiceBroker) LastOperation(
 		})
 	}
 
+	var caaErrs utils.CAAErrors
+	if route.State == models.Provisioning && errors.As(err, &caaErrs) {
+		return brokerapi.LastOperation{
+			State: brokerapi.InProgress,
+			Description: fmt.Sprintf(
+				"Provisioning blocked [%s => %s]; %s. To fix this, %s",
+				route.DomainExternal, route.Origin, caaErrs.Error(), caaErrs.Fix(),
+			),
+		}, nil
+	}
+
 	switch route.State {
 	case models.Provisioning:
-		instructions, err := b.manager.GetDNSInstructions(route)
+		statuses, err := b.manager.GetDomainStatus(route)
 		if err != nil {
 			return brokerapi.LastOperation{}, err
 		}
+		domains := []string{}
+		for _, status := range statuses {
+			domains = append(domains, status.String())
+		}
 		description := fmt.Sprintf(
-			"Provisioning in progress [%s => %s]; CNAME or ALIAS domain %s to %s or create TXT record(s): \n%s",
-			route.DomainExternal, route.Origin, route.DomainExternal, route.DomainInternal,
-			strings.Join(instructions, "\n"),
+			"Provisioning in progress [%s => %s]; CNAME or ALIAS each domain to %s or create its TXT record: \n%s",
+			route.DomainExternal, route.Origin, route.DomainInternal,
+			strings.Join(domains, "\n"),
 		)
+		switch route.CertificateProvider {
+		case models.CertificateProviderAcm:
+			description = fmt.Sprintf(
+				"Provisioning in progress [%s => %s]; CNAME or ALIAS each domain to %s and create its CNAME record to validate the certificate: \n%s",
+				route.DomainExternal, route.Origin, route.DomainInternal,
+				strings.Join(domains, "\n"),
+			)
+		case models.CertificateProviderCustom:
+			description = fmt.Sprintf(
+				"Provisioning in progress [%s => %s]; CNAME or ALIAS each domain to %s: \n%s",
+				route.DomainExternal, route.Origin, route.DomainInternal,
+				strings.Join(domains, "\n"),
+			)
+		}
 		return brokerapi.LastOperation{
 			State:       brokerapi.InProgress,
 			Description: description,
@@ -154,6 +281,23 @@ func (b *CdnServiceBroker) LastOperation(
 			State:       brokerapi.Failed,
 			Description: "Failure while provisioning instance",
 		}, nil
+	case models.RenewalAtRisk:
+		if route.CertificateProvider == models.CertificateProviderCustom {
+			return brokerapi.LastOperation{
+				State: brokerapi.Succeeded,
+				Description: fmt.Sprintf(
+					"Service instance provisioned [%s => %s]; CDN domain %s; the instance's certificate expires soon, replace it with `cf update-service` and the `certificate` and `private_key` or `credhub_ref` parameters",
+					route.DomainExternal, route.Origin, route.DomainInternal,
+				),
+			}, nil
+		}
+		return brokerapi.LastOperation{
+			State: brokerapi.Succeeded,
+			Description: fmt.Sprintf(
+				"Service instance provisioned [%s => %s]; CDN domain %s; certificate renewal is failing, check that the domain still points at the CDN domain",
+				route.DomainExternal, route.Origin, route.DomainInternal,
+			),
+		}, nil
 	default:
 		return brokerapi.LastOperation{
 			State: brokerapi.Succeeded,
@@ -214,7 +358,7 @@ func (b *CdnServiceBroker) Update(
 		return brokerapi.UpdateServiceSpec{}, brokerapi.ErrAsyncRequired
 	}
 
-	options, err := b.parseUpdateDetails(details)
+	options, err := b.parseUpdateDetails(instanceID, details)
 	if err != nil {
 		return brokerapi.UpdateServiceSpec{}, err
 	}
@@ -224,11 +368,71 @@ func (b *CdnServiceBroker) Update(
 		return brokerapi.UpdateServiceSpec{}, err
 	}
 
-	err = b.manager.Update(instanceID, options.Domain, options.Origin, options.Path, options.InsecureOrigin, headers, options.Cookies)
+	originHeaders, err := b.getOriginHeaders(options.OriginHeaders)
+	if err != nil {
+		return brokerapi.UpdateServiceSpec{}, err
+	}
+
+	cookieNames, err := getNames("cookie_names", options.CookieNames, MAX_COOKIE_NAMES)
+	if err != nil {
+		return brokerapi.UpdateServiceSpec{}, err
+	}
+
+	queryStringKeys, err := getNames("query_string_keys", options.QueryStringKeys, MAX_QUERY_STRING_KEYS)
+	if err != nil {
+		return brokerapi.UpdateServiceSpec{}, err
+	}
+
+	cacheBehaviors, err := b.getCacheBehaviors(options)
+	if err != nil {
+		return brokerapi.UpdateServiceSpec{}, err
+	}
+
+	responseHeaders, err := b.getResponseHeaders(options.ResponseHeaders, false)
+	if err != nil {
+		return brokerapi.UpdateServiceSpec{}, err
+	}
+
+	errorResponses, err := b.getErrorResponses(options, false)
 	if err != nil {
 		return brokerapi.UpdateServiceSpec{}, err
 	}
 
+	custom, replaceCertificate := options.customCertificate()
+	var cert certificate.Resource
+	if replaceCertificate {
+		route, err := b.manager.Get(instanceID)
+		if err != nil {
+			return brokerapi.UpdateServiceSpec{}, err
+		}
+		domains := route.GetDomains()
+		if options.Domain != "" {
+			domains = strings.Split(options.Domain, ",")
+		}
+		cert, err = b.manager.ResolveCertificate(custom, domains)
+		if err != nil {
+			return brokerapi.UpdateServiceSpec{}, fmt.Errorf("invalid certificate: %v", err)
+		}
+	}
+
+	// A new certificate alone leaves the distribution's settings as they are.
+	if !onlyCertificate(details.RawParameters) {
+		err = b.manager.Update(instanceID, options.Domain, options.Origin, options.Path, options.InsecureOrigin, headers, originHeaders, options.Cookies, cookieNames, queryStringKeys, cacheBehaviors, responseHeaders, errorResponses, options.KeyType)
+		if err != nil {
+			return brokerapi.UpdateServiceSpec{}, err
+		}
+	}
+
+	if replaceCertificate {
+		route, err := b.manager.Get(instanceID)
+		if err != nil {
+			return brokerapi.UpdateServiceSpec{}, err
+		}
+		if err := b.manager.ImportCertificate(route, cert); err != nil {
+			return brokerapi.UpdateServiceSpec{}, err
+		}
+	}
+
 	return brokerapi.UpdateServiceSpec{IsAsync: true}, nil
 }
 
@@ -261,55 +465,215 @@ func (b *CdnServiceBroker) parseProvisionDetails(details brokerapi.ProvisionDeta
 		err = errors.New("must pass non-empty `domain`")
 		return
 	}
+	_, custom := options.customCertificate()
+	if options.CertificateProvider == "" && custom {
+		options.CertificateProvider = models.CertificateProviderCustom
+	}
+	if options.CertificateProvider == "" {
+		options.CertificateProvider = b.settings.CertificateProvider
+	}
+	switch options.CertificateProvider {
+	case models.CertificateProviderLetsEncrypt, models.CertificateProviderAcm, models.CertificateProviderCustom:
+	default:
+		err = fmt.Errorf("`certificate_provider` must be %q, %q or %q", models.CertificateProviderLetsEncrypt, models.CertificateProviderAcm, models.CertificateProviderCustom)
+		return
+	}
+	if err = checkCustomCertificate(options, options.CertificateProvider); err != nil {
+		return
+	}
+	if options.DNSProvider != "" && options.CertificateProvider != models.CertificateProviderLetsEncrypt {
+		err = fmt.Errorf("`dns_provider` can't be used with the %q certificate provider", options.CertificateProvider)
+		return
+	}
+	if options.KeyType != "" && options.CertificateProvider != models.CertificateProviderLetsEncrypt {
+		err = fmt.Errorf("`key_type` can't be used with the %q certificate provider", options.CertificateProvider)
+		return
+	}
+	if err = b.checkKeyType(options.KeyType); err != nil {
+		return
+	}
 	if options.Origin == b.settings.DefaultOrigin {
 		err = b.checkDomain(options.Domain, details.OrganizationGUID)
-		if err != nil {
-			return
-		}
+	} else if domains := wildcardDomains(options.Domain); domains != "" {
+		err = b.checkDomain(domains, details.OrganizationGUID)
+	}
+	if err != nil {
+		return
 	}
+	err = b.checkCAA(options.Domain, options.CertificateProvider)
 	return
 }
 
 // parseUpdateDetails will attempt to parse the update details and then verify that at least "domain" or "origin"
 // are provided.
-func (b *CdnServiceBroker) parseUpdateDetails(details brokerapi.UpdateDetails) (options Options, err error) {
+func (b *CdnServiceBroker) parseUpdateDetails(instanceID string, details brokerapi.UpdateDetails) (options Options, err error) {
 	options, err = b.createBrokerOptions(details.RawParameters)
 	if err != nil {
 		return
 	}
-	if options.Domain == "" && options.Origin == "" {
-		err = errors.New("must pass non-empty `domain` or `origin`")
+	_, custom := options.customCertificate()
+	if options.Domain == "" && options.Origin == "" && !custom {
+		err = errors.New("must pass non-empty `domain`, `origin` or `certificate`")
+		return
+	}
+	if options.CertificateProvider != "" {
+		err = errors.New("`certificate_provider` can't be changed after provisioning")
+		return
+	}
+	if options.DNSProvider != "" {
+		err = errors.New("`dns_provider` can't be changed after provisioning")
+		return
+	}
+	if err = b.checkKeyType(options.KeyType); err != nil {
 		return
 	}
 	if options.Domain != "" && options.Origin == b.settings.DefaultOrigin {
 		err = b.checkDomain(options.Domain, details.PreviousValues.OrgID)
+	} else if domains := wildcardDomains(options.Domain); domains != "" {
+		err = b.checkDomain(domains, details.PreviousValues.OrgID)
+	}
+	if err != nil {
+		return
+	}
+	if options.Domain != "" || custom || options.KeyType != "" {
+		var route *models.Route
+		route, err = b.manager.Get(instanceID)
 		if err != nil {
 			return
 		}
+		if options.KeyType != "" && (route.CertificateProvider == models.CertificateProviderAcm || route.CertificateProvider == models.CertificateProviderCustom) {
+			err = fmt.Errorf("`key_type` can't be used with the %q certificate provider", route.CertificateProvider)
+			return
+		}
+		if custom {
+			if err = checkCustomCertificate(options, route.CertificateProvider); err != nil {
+				return
+			}
+		} else if route.CertificateProvider == models.CertificateProviderCustom {
+			err = errors.New("must pass a `certificate` covering the new `domain`")
+			return
+		}
+		if options.Domain != "" {
+			err = b.checkCAA(options.Domain, route.CertificateProvider)
+		}
 	}
 	return
 }
 
+// onlyCertificate reports whether update parameters only replace the
+// instance's own certificate.
+func onlyCertificate(raw []byte) bool {
+	var params map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &params); err != nil {
+		return false
+	}
+	for param := range params {
+		switch param {
+		case "certificate", "certificate_chain", "private_key", "credhub_ref":
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// checkCustomCertificate checks that an instance's own certificate is given
+// in full, and only to instances with the custom certificate provider.
+func checkCustomCertificate(options Options, certificateProvider string) error {
+	_, custom := options.customCertificate()
+	if certificateProvider != models.CertificateProviderCustom {
+		if custom {
+			return fmt.Errorf("`certificate` can't be used with the %q certificate provider", certificateProvider)
+		}
+		return nil
+	}
+
+	if options.CredhubRef != "" {
+		if options.Certificate != "" || options.CertificateChain != "" || options.PrivateKey != "" {
+			return errors.New("must pass either `credhub_ref` or `certificate`, not both")
+		}
+		return nil
+	}
+	if options.Certificate == "" || options.PrivateKey == "" {
+		return fmt.Errorf("the %q certificate provider needs `certificate` and `private_key`, or `credhub_ref`", models.CertificateProviderCustom)
+	}
+	return nil
+}
+
+// checkCAA refuses domains whose CAA records don't permit the certificate
+// provider's CA to issue certificates for them.
+func (b *CdnServiceBroker) checkCAA(domain, certificateProvider string) error {
+	if !b.settings.CaaCheck || certificateProvider == models.CertificateProviderCustom {
+		return nil
+	}
+
+	err := b.manager.CheckCAA(strings.Split(domain, ","), certificateProvider)
+	var caaErrs utils.CAAErrors
+	if errors.As(err, &caaErrs) {
+		return fmt.Errorf("%s. To fix this, %s", caaErrs.Error(), caaErrs.Fix())
+	}
+	return err
+}
+
+func (b *CdnServiceBroker) checkKeyType(keyType string) error {
+	if keyType == "" {
+		return nil
+	}
+	if _, err := utils.ParseKeyType(keyType); err != nil {
+		return fmt.Errorf("`key_type` must be one of %s, %s, %s or %s", utils.KeyTypeRSA2048, utils.KeyTypeRSA4096, utils.KeyTypeEC256, utils.KeyTypeEC384)
+	}
+	return nil
+}
+
+// wildcardDomains returns the domains in the comma separated list domain that
+// have wildcards. Whatever the origin, those must be covered by a private
+// domain of the instance's organization.
+func wildcardDomains(domain string) string {
+	wildcards := []string{}
+	for _, name := range strings.Split(domain, ",") {
+		if strings.Contains(name, "*") {
+			wildcards = append(wildcards, name)
+		}
+	}
+	return strings.Join(wildcards, ",")
+}
+
 func (b *CdnServiceBroker) checkDomain(domain, orgGUID string) error {
 	// domain can be a comma separated list so we need to check each one individually
 	domains := strings.Split(domain, ",")
 	var errorlist []string
 
 	orgName := "<organization>"
+	getOrgName := func() string {
+		if orgName == "<organization>" {
+			org, err := b.cfclient.GetOrgByGuid(orgGUID)
+			if err == nil {
+				orgName = org.Name
+			}
+		}
+		return orgName
+	}
 
 	for _, domain := range domains {
-		if _, err := b.cfclient.GetDomainByName(domain); err != nil {
+		// A wildcard covers the subdomains of a domain the organization owns,
+		// so it's the parent domain that has to exist.
+		name := strings.TrimPrefix(domain, "*.")
+		if strings.Contains(name, "*") {
+			return fmt.Errorf("Invalid domain %s; wildcards are only supported as the first label, e.g. *.agency.gov", domain)
+		}
+
+		cfDomain, err := b.cfclient.GetDomainByName(name)
+		if err != nil {
 			b.logger.Error("Error checking domain", err, lager.Data{
 				"domain":  domain,
 				"orgGUID": orgGUID,
 			})
-			if orgName == "<organization>" {
-				org, err := b.cfclient.GetOrgByGuid(orgGUID)
-				if err == nil {
-					orgName = org.Name
-				}
-			}
-			errorlist = append(errorlist, fmt.Sprintf("`cf create-domain %s %s`", orgName, domain))
+			errorlist = append(errorlist, fmt.Sprintf("`cf create-domain %s %s`", getOrgName(), name))
+			continue
+		}
+
+		if name != domain && cfDomain.OwningOrganizationGuid != orgGUID {
+			return fmt.Errorf("Wildcard domain %s requires %s to be a private domain of organization %s", domain, name, getOrgName())
 		}
 	}
 
@@ -351,3 +715,302 @@ func (b *CdnServiceBroker) getHeaders(options Options) (headers utils.Headers, e
 
 	return
 }
+
+// getOriginHeaders checks the headers that are forwarded to the origin
+// without being part of the cache key. These need cache policies.
+func (b *CdnServiceBroker) getOriginHeaders(originHeaders []string) (headers utils.Headers, err error) {
+	headers = utils.Headers{}
+	if len(originHeaders) > 0 && !b.settings.CloudFrontPolicies {
+		err = errors.New("`origin_headers` need CloudFront cache policies, which this broker doesn't use")
+		return
+	}
+	for _, header := range originHeaders {
+		if headers.Contains(header) {
+			err = fmt.Errorf("must not pass duplicated origin header '%s'", header)
+			return
+		}
+		headers.Add(header)
+	}
+
+	if headers.Contains("*") && len(headers) > 1 {
+		err = errors.New("must not pass origin headers alongside wildcard")
+		return
+	}
+
+	if len(headers) > MAX_HEADER_COUNT {
+		err = fmt.Errorf("must not set more than %d origin headers; got %d", MAX_HEADER_COUNT, len(headers))
+		return
+	}
+
+	return
+}
+
+// getResponseHeaders checks the headers that an instance's distribution adds
+// to responses. Instances provisioned without them get the broker's default
+// headers, and instances updated without them keep their own.
+func (b *CdnServiceBroker) getResponseHeaders(responseHeaders *utils.ResponseHeaders, provisioning bool) (*utils.ResponseHeaders, error) {
+	if responseHeaders == nil {
+		if !provisioning {
+			return nil, nil
+		}
+		return utils.ParseResponseHeaders(b.settings.ResponseHeaders)
+	}
+	if err := responseHeaders.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid `response_headers`: %v", err)
+	}
+	return responseHeaders, nil
+}
+
+// getErrorResponses checks the responses that an instance's distribution
+// serves in place of origin errors. Unless the instance turns it off, the
+// broker's maintenance page stands in for the 502, 503 and 504 errors it
+// doesn't configure itself. Instances updated without either option keep
+// their error responses.
+func (b *CdnServiceBroker) getErrorResponses(options Options, provisioning bool) ([]utils.ErrorResponse, error) {
+	if !provisioning && options.ErrorResponses == nil && options.MaintenancePage == nil {
+		return nil, nil
+	}
+
+	maintenancePage := b.settings.MaintenancePage != ""
+	if options.MaintenancePage != nil {
+		if *options.MaintenancePage && !maintenancePage {
+			return nil, errors.New("`maintenance_page` isn't offered by this broker")
+		}
+		maintenancePage = *options.MaintenancePage
+	}
+
+	responses := []utils.ErrorResponse{}
+	codes := map[int64]bool{}
+	for _, response := range options.ErrorResponses {
+		code := response.ErrorCode
+		if !containsCode(utils.ErrorCodes, code) {
+			return nil, fmt.Errorf("`error_code` must be one of %s; got %d", joinCodes(utils.ErrorCodes), code)
+		}
+		if codes[code] {
+			return nil, fmt.Errorf("must not pass duplicated `error_code` %d", code)
+		}
+		codes[code] = true
+
+		if response.CachingMinTTL != nil && *response.CachingMinTTL < 0 {
+			return nil, fmt.Errorf("error response %d: `caching_min_ttl` must not be negative", code)
+		}
+		if response.ResponsePagePath == "" {
+			if response.ResponseCode != 0 {
+				return nil, fmt.Errorf("error response %d: must not pass `response_code` without `response_page_path`", code)
+			}
+			responses = append(responses, response)
+			continue
+		}
+		if !strings.HasPrefix(response.ResponsePagePath, "/") || len(response.ResponsePagePath) > 4096 {
+			return nil, fmt.Errorf("error response %d: `response_page_path` must be a path starting with /", code)
+		}
+		if response.ResponsePagePath == utils.MaintenancePagePath && b.settings.MaintenancePage == "" {
+			return nil, fmt.Errorf("error response %d: the maintenance page isn't offered by this broker", code)
+		}
+		if response.ResponseCode == 0 {
+			response.ResponseCode = code
+		}
+		if !containsCode(utils.ResponseCodes, response.ResponseCode) {
+			return nil, fmt.Errorf("error response %d: `response_code` must be one of %s; got %d", code, joinCodes(utils.ResponseCodes), response.ResponseCode)
+		}
+		responses = append(responses, response)
+	}
+
+	if maintenancePage {
+		for _, response := range utils.MaintenanceErrorResponses() {
+			if !codes[response.ErrorCode] {
+				responses = append(responses, response)
+			}
+		}
+	}
+	return responses, nil
+}
+
+func containsCode(codes []int64, code int64) bool {
+	for _, c := range codes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+func joinCodes(codes []int64) string {
+	strs := []string{}
+	for _, code := range codes {
+		strs = append(strs, strconv.FormatInt(code, 10))
+	}
+	return strings.Join(strs, ", ")
+}
+
+// getNames checks a list of cookie names or query string keys to cache on,
+// passed as param. It returns nil if the list is empty, so that everything or
+// nothing is forwarded instead.
+func getNames(param string, names []string, max int) ([]string, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	seen := map[string]bool{}
+	for _, name := range names {
+		if name == "" {
+			return nil, fmt.Errorf("must not pass empty `%s`", param)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("must not pass duplicated `%s` '%s'", param, name)
+		}
+		seen[name] = true
+	}
+
+	if len(names) > max {
+		return nil, fmt.Errorf("must not set more than %d `%s`; got %d", max, param, len(names))
+	}
+	return names, nil
+}
+
+// getCacheBehaviors checks the instance's cache behaviors and fills in their
+// defaults. It returns nil if none were passed, so that updates keep the
+// instance's current behaviors.
+func (b *CdnServiceBroker) getCacheBehaviors(options Options) ([]utils.CacheBehavior, error) {
+	if options.CacheBehaviors == nil {
+		return nil, nil
+	}
+	if len(options.CacheBehaviors) > MAX_CACHE_BEHAVIORS {
+		return nil, fmt.Errorf("must not pass more than %d `cache_behaviors`; got %d", MAX_CACHE_BEHAVIORS, len(options.CacheBehaviors))
+	}
+
+	cookieNames, err := getNames("cookie_names", options.CookieNames, MAX_COOKIE_NAMES)
+	if err != nil {
+		return nil, err
+	}
+	queryStringKeys, err := getNames("query_string_keys", options.QueryStringKeys, MAX_QUERY_STRING_KEYS)
+	if err != nil {
+		return nil, err
+	}
+
+	behaviors := []utils.CacheBehavior{}
+	patterns := map[string]bool{}
+	for _, opts := range options.CacheBehaviors {
+		pattern := opts.PathPattern
+		if pattern == "" || len(pattern) > 255 || !pathPatternRegexp.MatchString(pattern) {
+			return nil, fmt.Errorf("invalid cache behavior `path_pattern` %q", pattern)
+		}
+		if strings.HasPrefix("/"+strings.TrimPrefix(pattern, "/"), "/.well-known/acme-challenge") {
+			return nil, fmt.Errorf("cache behavior `path_pattern` %q is reserved for certificate validation", pattern)
+		}
+		if strings.HasPrefix("/"+strings.TrimPrefix(pattern, "/"), "/.well-known/cdn-maintenance") {
+			return nil, fmt.Errorf("cache behavior `path_pattern` %q is reserved for the maintenance page", pattern)
+		}
+		if patterns[pattern] {
+			return nil, fmt.Errorf("must not pass duplicated cache behavior `path_pattern` %q", pattern)
+		}
+		patterns[pattern] = true
+
+		behavior := utils.CacheBehavior{
+			PathPattern:     pattern,
+			Cookies:         options.Cookies,
+			CookieNames:     cookieNames,
+			QueryString:     true,
+			QueryStringKeys: queryStringKeys,
+		}
+		if opts.Cookies != nil {
+			behavior.Cookies = *opts.Cookies
+			behavior.CookieNames = nil
+		}
+		if opts.QueryString != nil {
+			behavior.QueryString = *opts.QueryString
+			behavior.QueryStringKeys = nil
+		}
+
+		if opts.CookieNames != nil {
+			if behavior.CookieNames, err = getNames("cookie_names", opts.CookieNames, MAX_COOKIE_NAMES); err != nil {
+				return nil, fmt.Errorf("cache behavior %q: %v", pattern, err)
+			}
+		}
+		if opts.QueryStringKeys != nil {
+			if behavior.QueryStringKeys, err = getNames("query_string_keys", opts.QueryStringKeys, MAX_QUERY_STRING_KEYS); err != nil {
+				return nil, fmt.Errorf("cache behavior %q: %v", pattern, err)
+			}
+		}
+		if !behavior.QueryString && len(behavior.QueryStringKeys) > 0 {
+			return nil, fmt.Errorf("cache behavior %q: must not pass `query_string_keys` without forwarding query strings", pattern)
+		}
+		if behavior.MinTTL, behavior.DefaultTTL, behavior.MaxTTL, err = cacheTTLs(opts); err != nil {
+			return nil, fmt.Errorf("cache behavior %q: %v", pattern, err)
+		}
+		if behavior.AllowedMethods, err = allowedMethods(opts.AllowedMethods); err != nil {
+			return nil, fmt.Errorf("cache behavior %q: %v", pattern, err)
+		}
+
+		headers, err := b.getHeaders(Options{Origin: options.Origin, Headers: opts.Headers})
+		if err != nil {
+			return nil, fmt.Errorf("cache behavior %q: %v", pattern, err)
+		}
+		behavior.Headers = headers.Strings()
+		sort.Strings(behavior.Headers)
+
+		originHeaders, err := b.getOriginHeaders(opts.OriginHeaders)
+		if err != nil {
+			return nil, fmt.Errorf("cache behavior %q: %v", pattern, err)
+		}
+		if len(originHeaders) > 0 {
+			behavior.OriginHeaders = originHeaders.Strings()
+			sort.Strings(behavior.OriginHeaders)
+		}
+
+		behaviors = append(behaviors, behavior)
+	}
+	return behaviors, nil
+}
+
+// cacheTTLs fills in a cache behavior's TTLs. The default TTL defaults to a
+// day, within the minimum and maximum.
+func cacheTTLs(opts CacheBehaviorOptions) (min, def, max int64, err error) {
+	min, def, max = 0, 86400, 31536000
+	if opts.MinTTL != nil {
+		min = *opts.MinTTL
+	}
+	if opts.MaxTTL != nil {
+		max = *opts.MaxTTL
+	}
+	if opts.DefaultTTL != nil {
+		def = *opts.DefaultTTL
+	} else if def < min {
+		def = min
+	} else if def > max {
+		def = max
+	}
+
+	if min < 0 || def < 0 || max < 0 {
+		err = errors.New("TTLs must not be negative")
+	} else if min > def || def > max {
+		err = errors.New("must have `min_ttl` <= `default_ttl` <= `max_ttl`")
+	}
+	return
+}
+
+// allowedMethods checks that methods are one of the sets CloudFront accepts,
+// and defaults to all methods.
+func allowedMethods(methods []string) ([]string, error) {
+	if len(methods) == 0 {
+		return utils.AllMethods, nil
+	}
+
+	given := map[string]bool{}
+	for _, method := range methods {
+		given[strings.ToUpper(method)] = true
+	}
+	for _, set := range [][]string{utils.ReadMethods, utils.ReadOptionsMethods, utils.AllMethods} {
+		if len(given) != len(set) {
+			continue
+		}
+		matches := true
+		for _, method := range set {
+			matches = matches && given[method]
+		}
+		if matches {
+			return set, nil
+		}
+	}
+	return nil, errors.New("`allowed_methods` must be GET and HEAD; GET, HEAD and OPTIONS; or all methods")
+}
@@ -16,6 +16,7 @@ import (
 	"github.com/cloud-gov/cf-cdn-service-broker/config"
 	"github.com/cloud-gov/cf-cdn-service-broker/models"
 	"github.com/cloud-gov/cf-cdn-service-broker/models/mocks"
+	"github.com/cloud-gov/cf-cdn-service-broker/utils"
 )
 
 func TestLastOperation(t *testing.T) {
@@ -92,7 +93,9 @@ func (s *LastOperationSuite) TestLastOperationProvisioning() {
 		ChallengeJSON:  []byte("[]"),
 	}
 	manager.On("Get", "123").Return(route, nil)
-	manager.On("GetDNSInstructions", route).Return([]string{"token"}, nil)
+	manager.On("GetDomainStatus", route).Return([]utils.DomainStatus{
+		{Domain: "cdn.cloud.gov", Status: utils.DomainReady, Found: "CNAME to abc.cloudfront.net"},
+	}, nil)
 	manager.On("Poll", route).Return(nil)
 	b := broker.New(
 		&manager,
@@ -104,9 +107,43 @@ func (s *LastOperationSuite) TestLastOperationProvisioning() {
 	operation, err := b.LastOperation(s.ctx, "123", "")
 	s.Equal(operation.State, brokerapi.InProgress)
 	s.True(strings.Contains(operation.Description, "Provisioning in progress [cdn.cloud.gov => cdn.apps.cloud.gov]"))
+	s.Contains(operation.Description, "cdn.cloud.gov: ready (CNAME to abc.cloudfront.net)")
 	s.Nil(err)
 }
 
+func (s *LastOperationSuite) TestLastOperationProvisioningDomainStatus() {
+	manager := mocks.RouteManagerIface{}
+	route := &models.Route{
+		State:          models.Provisioning,
+		DomainExternal: "cdn.cloud.gov,www.cdn.cloud.gov",
+		DomainInternal: "abc.cloudfront.net",
+		Origin:         "cdn.apps.cloud.gov",
+	}
+	manager.On("Get", "123").Return(route, nil)
+	manager.On("GetDomainStatus", route).Return([]utils.DomainStatus{
+		{Domain: "cdn.cloud.gov", Status: utils.DomainWrongTarget, Found: "CNAME to old.example.com", Record: &utils.ValidationRecord{
+			Name:  "_acme-challenge.cdn.cloud.gov.",
+			Type:  "TXT",
+			Value: "token",
+		}},
+		{Domain: "www.cdn.cloud.gov", Status: utils.DomainNXDomain},
+	}, nil)
+	manager.On("Poll", route).Return(nil)
+	b := broker.New(
+		&manager,
+		&s.cfclient,
+		s.settings,
+		s.logger,
+	)
+
+	operation, err := b.LastOperation(s.ctx, "123", "")
+	s.Nil(err)
+	s.Equal(brokerapi.InProgress, operation.State)
+	s.Contains(operation.Description, "CNAME or ALIAS each domain to abc.cloudfront.net")
+	s.Contains(operation.Description, "cdn.cloud.gov: wrong target (CNAME to old.example.com); TXT record name: _acme-challenge.cdn.cloud.gov., value: token, type: TXT")
+	s.Contains(operation.Description, "\nwww.cdn.cloud.gov: NXDOMAIN")
+}
+
 func (s *LastOperationSuite) TestLastOperationDeprovisioning() {
 	manager := mocks.RouteManagerIface{}
 	route := &models.Route{
@@ -129,3 +166,80 @@ func (s *LastOperationSuite) TestLastOperationDeprovisioning() {
 	s.Equal(operation.Description, "Deprovisioning in progress [cdn.cloud.gov => cdn.apps.cloud.gov]; CDN domain abc.cloudfront.net")
 	s.Nil(err)
 }
+
+func (s *LastOperationSuite) TestLastOperationRenewalAtRisk() {
+	manager := mocks.RouteManagerIface{}
+	route := &models.Route{
+		State:          models.RenewalAtRisk,
+		DomainExternal: "cdn.cloud.gov",
+		DomainInternal: "abc.cloudfront.net",
+		Origin:         "cdn.apps.cloud.gov",
+	}
+	manager.On("Get", "123").Return(route, nil)
+	manager.On("Poll", route).Return(nil)
+	b := broker.New(
+		&manager,
+		&s.cfclient,
+		s.settings,
+		s.logger,
+	)
+
+	operation, err := b.LastOperation(s.ctx, "123", "")
+	s.Equal(operation.State, brokerapi.Succeeded)
+	s.True(strings.Contains(operation.Description, "certificate renewal is failing"))
+	s.Nil(err)
+}
+
+func (s *LastOperationSuite) TestLastOperationCustomCertificateExpiring() {
+	manager := mocks.RouteManagerIface{}
+	route := &models.Route{
+		State:               models.RenewalAtRisk,
+		DomainExternal:      "cdn.cloud.gov",
+		DomainInternal:      "abc.cloudfront.net",
+		Origin:              "cdn.apps.cloud.gov",
+		CertificateProvider: models.CertificateProviderCustom,
+	}
+	manager.On("Get", "123").Return(route, nil)
+	manager.On("Poll", route).Return(nil)
+	b := broker.New(
+		&manager,
+		&s.cfclient,
+		s.settings,
+		s.logger,
+	)
+
+	operation, err := b.LastOperation(s.ctx, "123", "")
+	s.Equal(operation.State, brokerapi.Succeeded)
+	s.True(strings.Contains(operation.Description, "the instance's certificate expires soon"))
+	s.Nil(err)
+}
+
+func (s *LastOperationSuite) TestLastOperationCAABlocked() {
+	manager := mocks.RouteManagerIface{}
+	route := &models.Route{
+		State:          models.Provisioning,
+		DomainExternal: "cdn.cloud.gov",
+		Origin:         "cdn.apps.cloud.gov",
+	}
+	manager.On("Get", "123").Return(route, nil)
+	manager.On("Poll", route).Return(utils.CAAErrors{{
+		Domain:       "cdn.cloud.gov",
+		RecordDomain: "cloud.gov.",
+		Tag:          "issue",
+		Records:      []string{`0 issue "digicert.com"`},
+		Identities:   []string{"letsencrypt.org"},
+	}})
+	b := broker.New(
+		&manager,
+		&s.cfclient,
+		s.settings,
+		lager.NewLogger("broker.last-operation.test"),
+	)
+
+	operation, err := b.LastOperation(s.ctx, "123", "")
+	s.Nil(err)
+	s.Equal(brokerapi.InProgress, operation.State)
+	s.Contains(operation.Description, "Provisioning blocked")
+	s.Contains(operation.Description, "`cloud.gov. CAA 0 issue \"letsencrypt.org\"` for cdn.cloud.gov")
+	manager.AssertNotCalled(s.T(), "GetDomainStatus", route)
+}
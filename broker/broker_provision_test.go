@@ -3,12 +3,16 @@ package broker_test
 import (
 	"context"
 	"errors"
+	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/suite"
 
 	"code.cloudfoundry.org/lager"
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/cloudfoundry-community/go-cfclient"
+	"github.com/go-acme/lego/v4/certificate"
 	"github.com/pivotal-cf/brokerapi"
 
 	"github.com/cloud-gov/cf-cdn-service-broker/broker"
@@ -38,7 +42,8 @@ func (s *ProvisionSuite) SetupTest() {
 	s.cfclient = cfmock.Client{}
 	s.logger = lager.NewLogger("broker.provision.test")
 	s.settings = config.Settings{
-		DefaultOrigin: "origin.cloud.gov",
+		DefaultOrigin:       "origin.cloud.gov",
+		CertificateProvider: "letsencrypt",
 	}
 	s.Broker = broker.New(
 		&s.Manager,
@@ -90,8 +95,8 @@ func (s *ProvisionSuite) TestSuccess() {
 	s.Manager.On("Get", "123").Return(&models.Route{}, errors.New("not found"))
 	route := &models.Route{State: models.Provisioning}
 	s.cfclient.On("GetDomainByName", "domain.gov").Return(cfclient.Domain{}, nil)
-	s.Manager.On("Create", "123", "domain.gov", "origin.cloud.gov", "", false, utils.Headers{"Host": true}, true,
-		map[string]string{"Organization": "", "Space": "", "Service": "", "Plan": ""}).Return(route, nil)
+	s.Manager.On("Create", "123", "domain.gov", "origin.cloud.gov", "", false, utils.Headers{"Host": true}, utils.Headers{}, true, []string(nil), []string(nil), []utils.CacheBehavior(nil), (*utils.ResponseHeaders)(nil), []utils.ErrorResponse{}, "letsencrypt", "", "",
+		(*certificate.Resource)(nil), map[string]string{"Organization": "", "Space": "", "Service": "", "Plan": ""}).Return(route, nil)
 
 	details := brokerapi.ProvisionDetails{
 		RawParameters: []byte(`{"domain": "domain.gov"}`),
@@ -103,8 +108,8 @@ func (s *ProvisionSuite) TestSuccess() {
 func (s *ProvisionSuite) TestSuccessCustomOrigin() {
 	s.Manager.On("Get", "123").Return(&models.Route{}, errors.New("not found"))
 	route := &models.Route{State: models.Provisioning}
-	s.Manager.On("Create", "123", "domain.gov", "custom.cloud.gov", "", false, utils.Headers{}, true,
-		map[string]string{"Organization": "", "Space": "", "Service": "", "Plan": ""}).Return(route, nil)
+	s.Manager.On("Create", "123", "domain.gov", "custom.cloud.gov", "", false, utils.Headers{}, utils.Headers{}, true, []string(nil), []string(nil), []utils.CacheBehavior(nil), (*utils.ResponseHeaders)(nil), []utils.ErrorResponse{}, "letsencrypt", "", "",
+		(*certificate.Resource)(nil), map[string]string{"Organization": "", "Space": "", "Service": "", "Plan": ""}).Return(route, nil)
 
 	details := brokerapi.ProvisionDetails{
 		RawParameters: []byte(`{"domain": "domain.gov", "origin": "custom.cloud.gov"}`),
@@ -113,6 +118,182 @@ func (s *ProvisionSuite) TestSuccessCustomOrigin() {
 	s.Nil(err)
 }
 
+func (s *ProvisionSuite) TestSuccessAcmCertificate() {
+	s.Manager.On("Get", "123").Return(&models.Route{}, errors.New("not found"))
+	route := &models.Route{State: models.Provisioning}
+	s.cfclient.On("GetDomainByName", "domain.gov").Return(cfclient.Domain{}, nil)
+	s.Manager.On("Create", "123", "domain.gov", "origin.cloud.gov", "", false, utils.Headers{"Host": true}, utils.Headers{}, true, []string(nil), []string(nil), []utils.CacheBehavior(nil), (*utils.ResponseHeaders)(nil), []utils.ErrorResponse{}, "acm", "", "",
+		(*certificate.Resource)(nil), map[string]string{"Organization": "", "Space": "", "Service": "", "Plan": ""}).Return(route, nil)
+
+	details := brokerapi.ProvisionDetails{
+		RawParameters: []byte(`{"domain": "domain.gov", "certificate_provider": "acm"}`),
+	}
+	_, err := s.Broker.Provision(s.ctx, "123", details, true)
+	s.Nil(err)
+}
+
+func (s *ProvisionSuite) TestUnknownCertificateProvider() {
+	details := brokerapi.ProvisionDetails{
+		RawParameters: []byte(`{"domain": "domain.gov", "certificate_provider": "digicert"}`),
+	}
+	_, err := s.Broker.Provision(s.ctx, "123", details, true)
+	s.NotNil(err)
+	s.Contains(err.Error(), "certificate_provider")
+}
+
+func (s *ProvisionSuite) TestSuccessDNSProvider() {
+	s.Manager.On("Get", "123").Return(&models.Route{}, errors.New("not found"))
+	route := &models.Route{State: models.Provisioning}
+	s.cfclient.On("GetDomainByName", "domain.gov").Return(cfclient.Domain{}, nil)
+	s.Manager.On("Create", "123", "domain.gov", "origin.cloud.gov", "", false, utils.Headers{"Host": true}, utils.Headers{}, true, []string(nil), []string(nil), []utils.CacheBehavior(nil), (*utils.ResponseHeaders)(nil), []utils.ErrorResponse{}, "letsencrypt", "agency-bind", "",
+		(*certificate.Resource)(nil), map[string]string{"Organization": "", "Space": "", "Service": "", "Plan": ""}).Return(route, nil)
+
+	details := brokerapi.ProvisionDetails{
+		RawParameters: []byte(`{"domain": "domain.gov", "dns_provider": "agency-bind"}`),
+	}
+	_, err := s.Broker.Provision(s.ctx, "123", details, true)
+	s.Nil(err)
+}
+
+func (s *ProvisionSuite) TestDNSProviderWithAcmCertificate() {
+	details := brokerapi.ProvisionDetails{
+		RawParameters: []byte(`{"domain": "domain.gov", "certificate_provider": "acm", "dns_provider": "agency-bind"}`),
+	}
+	_, err := s.Broker.Provision(s.ctx, "123", details, true)
+	s.NotNil(err)
+	s.Contains(err.Error(), "dns_provider")
+}
+
+func (s *ProvisionSuite) TestSuccessKeyType() {
+	s.Manager.On("Get", "123").Return(&models.Route{}, errors.New("not found"))
+	route := &models.Route{State: models.Provisioning}
+	s.cfclient.On("GetDomainByName", "domain.gov").Return(cfclient.Domain{}, nil)
+	s.Manager.On("Create", "123", "domain.gov", "origin.cloud.gov", "", false, utils.Headers{"Host": true}, utils.Headers{}, true, []string(nil), []string(nil), []utils.CacheBehavior(nil), (*utils.ResponseHeaders)(nil), []utils.ErrorResponse{}, "letsencrypt", "", "EC_prime256v1",
+		(*certificate.Resource)(nil), map[string]string{"Organization": "", "Space": "", "Service": "", "Plan": ""}).Return(route, nil)
+
+	details := brokerapi.ProvisionDetails{
+		RawParameters: []byte(`{"domain": "domain.gov", "key_type": "EC_prime256v1"}`),
+	}
+	_, err := s.Broker.Provision(s.ctx, "123", details, true)
+	s.Nil(err)
+}
+
+func (s *ProvisionSuite) TestUnknownKeyType() {
+	details := brokerapi.ProvisionDetails{
+		RawParameters: []byte(`{"domain": "domain.gov", "key_type": "DSA_1024"}`),
+	}
+	_, err := s.Broker.Provision(s.ctx, "123", details, true)
+	s.NotNil(err)
+	s.Contains(err.Error(), "key_type")
+}
+
+func (s *ProvisionSuite) TestKeyTypeWithAcmCertificate() {
+	details := brokerapi.ProvisionDetails{
+		RawParameters: []byte(`{"domain": "domain.gov", "certificate_provider": "acm", "key_type": "EC_prime256v1"}`),
+	}
+	_, err := s.Broker.Provision(s.ctx, "123", details, true)
+	s.NotNil(err)
+	s.Contains(err.Error(), "key_type")
+}
+
+func (s *ProvisionSuite) TestSuccessCustomCertificate() {
+	s.Manager.On("Get", "123").Return(&models.Route{}, errors.New("not found"))
+	route := &models.Route{State: models.Provisioning, CertificateProvider: "custom"}
+	cert := certificate.Resource{Domain: "domain.gov"}
+	s.cfclient.On("GetDomainByName", "domain.gov").Return(cfclient.Domain{}, nil)
+	s.Manager.On("ResolveCertificate", utils.CustomCertificate{Certificate: "cert", PrivateKey: "key"}, []string{"domain.gov"}).Return(cert, nil)
+	s.Manager.On("Create", "123", "domain.gov", "origin.cloud.gov", "", false, utils.Headers{"Host": true}, utils.Headers{}, true, []string(nil), []string(nil), []utils.CacheBehavior(nil), (*utils.ResponseHeaders)(nil), []utils.ErrorResponse{}, "custom", "", "",
+		&cert, map[string]string{"Organization": "", "Space": "", "Service": "", "Plan": ""}).Return(route, nil)
+
+	details := brokerapi.ProvisionDetails{
+		RawParameters: []byte(`{"domain": "domain.gov", "certificate": "cert", "private_key": "key"}`),
+	}
+	_, err := s.Broker.Provision(s.ctx, "123", details, true)
+	s.Nil(err)
+	s.Manager.AssertExpectations(s.T())
+}
+
+func (s *ProvisionSuite) TestSuccessCredhubCertificate() {
+	s.Manager.On("Get", "123").Return(&models.Route{}, errors.New("not found"))
+	route := &models.Route{State: models.Provisioning, CertificateProvider: "custom"}
+	cert := certificate.Resource{Domain: "domain.gov"}
+	s.cfclient.On("GetDomainByName", "domain.gov").Return(cfclient.Domain{}, nil)
+	s.Manager.On("ResolveCertificate", utils.CustomCertificate{CredhubRef: "/agency/cert"}, []string{"domain.gov"}).Return(cert, nil)
+	s.Manager.On("Create", "123", "domain.gov", "origin.cloud.gov", "", false, utils.Headers{"Host": true}, utils.Headers{}, true, []string(nil), []string(nil), []utils.CacheBehavior(nil), (*utils.ResponseHeaders)(nil), []utils.ErrorResponse{}, "custom", "", "",
+		&cert, map[string]string{"Organization": "", "Space": "", "Service": "", "Plan": ""}).Return(route, nil)
+
+	details := brokerapi.ProvisionDetails{
+		RawParameters: []byte(`{"domain": "domain.gov", "credhub_ref": "/agency/cert"}`),
+	}
+	_, err := s.Broker.Provision(s.ctx, "123", details, true)
+	s.Nil(err)
+	s.Manager.AssertExpectations(s.T())
+}
+
+func (s *ProvisionSuite) TestInvalidCustomCertificate() {
+	s.Manager.On("Get", "123").Return(&models.Route{}, errors.New("not found"))
+	s.cfclient.On("GetDomainByName", "domain.gov").Return(cfclient.Domain{}, nil)
+	s.Manager.On("ResolveCertificate", utils.CustomCertificate{Certificate: "cert", PrivateKey: "key"}, []string{"domain.gov"}).
+		Return(certificate.Resource{}, errors.New("certificate doesn't cover domain.gov"))
+
+	details := brokerapi.ProvisionDetails{
+		RawParameters: []byte(`{"domain": "domain.gov", "certificate": "cert", "private_key": "key"}`),
+	}
+	_, err := s.Broker.Provision(s.ctx, "123", details, true)
+	s.NotNil(err)
+	s.Equal("invalid certificate: certificate doesn't cover domain.gov", err.Error())
+	s.Manager.AssertNotCalled(s.T(), "Create")
+}
+
+func (s *ProvisionSuite) TestCustomCertificateWithoutKey() {
+	details := brokerapi.ProvisionDetails{
+		RawParameters: []byte(`{"domain": "domain.gov", "certificate": "cert"}`),
+	}
+	_, err := s.Broker.Provision(s.ctx, "123", details, true)
+	s.NotNil(err)
+	s.Contains(err.Error(), "private_key")
+}
+
+func (s *ProvisionSuite) TestCustomCertificateAndCredhubRef() {
+	details := brokerapi.ProvisionDetails{
+		RawParameters: []byte(`{"domain": "domain.gov", "certificate": "cert", "private_key": "key", "credhub_ref": "/agency/cert"}`),
+	}
+	_, err := s.Broker.Provision(s.ctx, "123", details, true)
+	s.NotNil(err)
+	s.Contains(err.Error(), "credhub_ref")
+}
+
+func (s *ProvisionSuite) TestCustomCertificateWithAcmCertificate() {
+	details := brokerapi.ProvisionDetails{
+		RawParameters: []byte(`{"domain": "domain.gov", "certificate_provider": "acm", "certificate": "cert", "private_key": "key"}`),
+	}
+	_, err := s.Broker.Provision(s.ctx, "123", details, true)
+	s.NotNil(err)
+	s.Contains(err.Error(), "`certificate` can't be used")
+}
+
+func (s *ProvisionSuite) TestCAAForbidsIssuance() {
+	s.settings.CaaCheck = true
+	s.Broker = broker.New(&s.Manager, &s.cfclient, s.settings, s.logger)
+
+	s.cfclient.On("GetDomainByName", "domain.gov").Return(cfclient.Domain{}, nil)
+	s.Manager.On("CheckCAA", []string{"domain.gov"}, "letsencrypt").Return(utils.CAAErrors{{
+		Domain:       "domain.gov",
+		RecordDomain: "domain.gov.",
+		Tag:          "issue",
+		Records:      []string{`0 issue "digicert.com"`},
+		Identities:   []string{"letsencrypt.org"},
+	}})
+
+	details := brokerapi.ProvisionDetails{
+		RawParameters: []byte(`{"domain": "domain.gov"}`),
+	}
+	_, err := s.Broker.Provision(s.ctx, "123", details, true)
+	s.NotNil(err)
+	s.Contains(err.Error(), "`domain.gov. CAA 0 issue \"letsencrypt.org\"`")
+	s.Manager.AssertNotCalled(s.T(), "Create")
+}
+
 func (s *ProvisionSuite) TestDomainNotExists() {
 	s.cfclient.On("GetDomainByName", "domain.gov").Return(cfclient.Domain{}, errors.New("fail"))
 	details := brokerapi.ProvisionDetails{
@@ -154,6 +335,146 @@ func (s *ProvisionSuite) TestMultipleDomainsMoreThanOneNotExists() {
 	s.Contains(err.Error(), "domain3.gov")
 }
 
+func (s *ProvisionSuite) TestWildcardDomain() {
+	s.Manager.On("Get", "123").Return(&models.Route{}, errors.New("not found"))
+	route := &models.Route{State: models.Provisioning}
+	s.cfclient.On("GetDomainByName", "domain.gov").Return(cfclient.Domain{
+		OwningOrganizationGuid: "dfb39134-ab7d-489e-ae59-4ed5c6f42fb5",
+	}, nil)
+	s.Manager.On("Create", "123", "domain.gov,*.domain.gov", "origin.cloud.gov", "", false, utils.Headers{"Host": true}, utils.Headers{}, true, []string(nil), []string(nil), []utils.CacheBehavior(nil), (*utils.ResponseHeaders)(nil), []utils.ErrorResponse{}, "letsencrypt", "", "",
+		(*certificate.Resource)(nil), map[string]string{"Organization": "dfb39134-ab7d-489e-ae59-4ed5c6f42fb5", "Space": "", "Service": "", "Plan": ""}).Return(route, nil)
+
+	details := brokerapi.ProvisionDetails{
+		OrganizationGUID: "dfb39134-ab7d-489e-ae59-4ed5c6f42fb5",
+		RawParameters:    []byte(`{"domain": "domain.gov,*.domain.gov"}`),
+	}
+	_, err := s.Broker.Provision(s.ctx, "123", details, true)
+	s.Nil(err)
+}
+
+func (s *ProvisionSuite) TestWildcardDomainNotOwned() {
+	s.Manager.On("Get", "123").Return(&models.Route{}, errors.New("not found"))
+	s.cfclient.On("GetDomainByName", "domain.gov").Return(cfclient.Domain{
+		OwningOrganizationGuid: "another-org",
+	}, nil)
+
+	details := brokerapi.ProvisionDetails{
+		OrganizationGUID: "dfb39134-ab7d-489e-ae59-4ed5c6f42fb5",
+		RawParameters:    []byte(`{"domain": "*.domain.gov"}`),
+	}
+	_, err := s.Broker.Provision(s.ctx, "123", details, true)
+	s.NotNil(err)
+	s.Contains(err.Error(), "requires domain.gov to be a private domain of organization my-org")
+	s.Manager.AssertNotCalled(s.T(), "Create")
+}
+
+func (s *ProvisionSuite) TestWildcardDomainNotOwnedCustomOrigin() {
+	s.Manager.On("Get", "123").Return(&models.Route{}, errors.New("not found"))
+	s.cfclient.On("GetDomainByName", "domain.gov").Return(cfclient.Domain{
+		OwningOrganizationGuid: "another-org",
+	}, nil)
+
+	details := brokerapi.ProvisionDetails{
+		OrganizationGUID: "dfb39134-ab7d-489e-ae59-4ed5c6f42fb5",
+		RawParameters:    []byte(`{"domain": "www.agency.gov,*.domain.gov", "origin": "custom.cloud.gov"}`),
+	}
+	_, err := s.Broker.Provision(s.ctx, "123", details, true)
+	s.NotNil(err)
+	s.Contains(err.Error(), "requires domain.gov to be a private domain of organization my-org")
+	s.cfclient.AssertNotCalled(s.T(), "GetDomainByName", "www.agency.gov")
+	s.Manager.AssertNotCalled(s.T(), "Create")
+}
+
+func (s *ProvisionSuite) TestWildcardDomainInvalid() {
+	s.Manager.On("Get", "123").Return(&models.Route{}, errors.New("not found"))
+
+	details := brokerapi.ProvisionDetails{
+		OrganizationGUID: "dfb39134-ab7d-489e-ae59-4ed5c6f42fb5",
+		RawParameters:    []byte(`{"domain": "www.*.domain.gov"}`),
+	}
+	_, err := s.Broker.Provision(s.ctx, "123", details, true)
+	s.NotNil(err)
+	s.Contains(err.Error(), "wildcards are only supported as the first label")
+	s.cfclient.AssertNotCalled(s.T(), "GetDomainByName", "www.*.domain.gov")
+}
+
+func (s *ProvisionSuite) TestSuccessCacheBehaviors() {
+	s.Manager.On("Get", "123").Return(&models.Route{}, errors.New("not found"))
+	route := &models.Route{State: models.Provisioning}
+	s.cfclient.On("GetDomainByName", "domain.gov").Return(cfclient.Domain{}, nil)
+	s.Manager.On("Create", "123", "domain.gov", "origin.cloud.gov", "", false, utils.Headers{"Host": true}, utils.Headers{}, true, []string(nil), []string(nil), []utils.CacheBehavior{
+		{
+			PathPattern:    "/api/*",
+			MinTTL:         0,
+			DefaultTTL:     0,
+			MaxTTL:         0,
+			AllowedMethods: utils.AllMethods,
+			Headers:        []string{"Authorization", "Host"},
+			Cookies:        true,
+			QueryString:    true,
+		},
+		{
+			PathPattern:    "/static/*",
+			MinTTL:         0,
+			DefaultTTL:     31536000,
+			MaxTTL:         31536000,
+			AllowedMethods: utils.ReadMethods,
+			Headers:        []string{"Host"},
+			Cookies:        false,
+			QueryString:    false,
+		},
+	}, (*utils.ResponseHeaders)(nil), []utils.ErrorResponse{}, "letsencrypt", "", "", (*certificate.Resource)(nil), map[string]string{"Organization": "", "Space": "", "Service": "", "Plan": ""}).Return(route, nil)
+
+	details := brokerapi.ProvisionDetails{
+		RawParameters: []byte(`{"domain": "domain.gov", "cache_behaviors": [
+			{"path_pattern": "/api/*", "max_ttl": 0, "headers": ["authorization"]},
+			{"path_pattern": "/static/*", "default_ttl": 31536000, "allowed_methods": ["get", "head"], "cookies": false, "query_string": false}
+		]}`),
+	}
+	_, err := s.Broker.Provision(s.ctx, "123", details, true)
+	s.Nil(err)
+}
+
+func (s *ProvisionSuite) TestCacheBehaviorsInvalid() {
+	s.cfclient.On("GetDomainByName", "domain.gov").Return(cfclient.Domain{}, nil)
+	s.Manager.On("Get", "123").Return(&models.Route{}, errors.New("not found"))
+
+	for behaviors, message := range map[string]string{
+		`[{"path_pattern": ""}]`:                                           "invalid cache behavior `path_pattern`",
+		`[{"path_pattern": "/a b"}]`:                                       "invalid cache behavior `path_pattern`",
+		`[{"path_pattern": "/.well-known/acme-challenge/*"}]`:              "reserved for certificate validation",
+		`[{"path_pattern": "/api/*"}, {"path_pattern": "/api/*"}]`:         "duplicated",
+		`[{"path_pattern": "/api/*", "min_ttl": 60, "max_ttl": 30}]`:       "`min_ttl` <= `default_ttl` <= `max_ttl`",
+		`[{"path_pattern": "/api/*", "default_ttl": -1}]`:                  "negative",
+		`[{"path_pattern": "/api/*", "allowed_methods": ["GET", "POST"]}]`: "`allowed_methods`",
+		`[{"path_pattern": "/api/*", "headers": ["*", "Accept"]}]`:         "wildcard",
+	} {
+		details := brokerapi.ProvisionDetails{
+			RawParameters: []byte(`{"domain": "domain.gov", "cache_behaviors": ` + behaviors + `}`),
+		}
+		_, err := s.Broker.Provision(s.ctx, "123", details, true)
+		if s.Error(err, behaviors) {
+			s.Contains(err.Error(), message, behaviors)
+		}
+	}
+}
+
+func (s *ProvisionSuite) TestCacheBehaviorsMoreThanLimit() {
+	s.cfclient.On("GetDomainByName", "domain.gov").Return(cfclient.Domain{}, nil)
+	s.Manager.On("Get", "123").Return(&models.Route{}, errors.New("not found"))
+
+	behaviors := []string{}
+	for i := 0; i <= broker.MAX_CACHE_BEHAVIORS; i++ {
+		behaviors = append(behaviors, fmt.Sprintf(`{"path_pattern": "/%d/*"}`, i))
+	}
+	details := brokerapi.ProvisionDetails{
+		RawParameters: []byte(`{"domain": "domain.gov", "cache_behaviors": [` + strings.Join(behaviors, ",") + `]}`),
+	}
+	_, err := s.Broker.Provision(s.ctx, "123", details, true)
+	s.Error(err)
+	s.Contains(err.Error(), "must not pass more than 23 `cache_behaviors`")
+}
+
 func (s *ProvisionSuite) setupTestOfHeaderForwarding() {
 	s.Manager.On("Get", "123").Return(&models.Route{}, errors.New("not found"))
 	s.cfclient.On("GetDomainByName", "domain.gov").Return(cfclient.Domain{}, nil)
@@ -161,13 +482,13 @@ func (s *ProvisionSuite) setupTestOfHeaderForwarding() {
 
 func (s *ProvisionSuite) allowCreateWithExpectedHeaders(expectedHeaders utils.Headers) {
 	route := &models.Route{State: models.Provisioning}
-	s.Manager.On("Create", "123", "domain.gov", "origin.cloud.gov", "", false, expectedHeaders, true,
-		map[string]string{"Organization": "", "Space": "", "Service": "", "Plan": ""}).Return(route, nil)
+	s.Manager.On("Create", "123", "domain.gov", "origin.cloud.gov", "", false, expectedHeaders, utils.Headers{}, true, []string(nil), []string(nil), []utils.CacheBehavior(nil), (*utils.ResponseHeaders)(nil), []utils.ErrorResponse{}, "letsencrypt", "", "",
+		(*certificate.Resource)(nil), map[string]string{"Organization": "", "Space": "", "Service": "", "Plan": ""}).Return(route, nil)
 }
 
 func (s *ProvisionSuite) failCreateWithExpectedHeaders(expectedHeaders utils.Headers) {
-	s.Manager.On("Create", "123", "domain.gov", "origin.cloud.gov", "", false, expectedHeaders, true,
-		map[string]string{"Organization": "", "Space": "", "Service": "", "Plan": ""}).Return(nil, errors.New("fail"))
+	s.Manager.On("Create", "123", "domain.gov", "origin.cloud.gov", "", false, expectedHeaders, utils.Headers{}, true, []string(nil), []string(nil), []utils.CacheBehavior(nil), (*utils.ResponseHeaders)(nil), []utils.ErrorResponse{}, "letsencrypt", "", "",
+		(*certificate.Resource)(nil), map[string]string{"Organization": "", "Space": "", "Service": "", "Plan": ""}).Return(nil, errors.New("fail"))
 }
 
 func (s *ProvisionSuite) TestSuccessForwardingDuplicatedHostHeader() {
@@ -249,3 +570,214 @@ func (s *ProvisionSuite) TestForwardedHeadersMoreThanTen() {
 	s.NotNil(err)
 	s.Contains(err.Error(), "must not set more than 10 headers; got 11")
 }
+
+func (s *ProvisionSuite) TestSuccessOriginHeaders() {
+	s.settings.CloudFrontPolicies = true
+	s.Broker = broker.New(&s.Manager, &s.cfclient, s.settings, s.logger)
+	s.setupTestOfHeaderForwarding()
+	route := &models.Route{State: models.Provisioning}
+	s.Manager.On("Create", "123", "domain.gov", "origin.cloud.gov", "", false, utils.Headers{"Host": true}, utils.Headers{"*": true}, true, []string(nil), []string(nil), []utils.CacheBehavior{{
+		PathPattern:    "/api/*",
+		DefaultTTL:     86400,
+		MaxTTL:         31536000,
+		AllowedMethods: utils.AllMethods,
+		Headers:        []string{"Host"},
+		OriginHeaders:  []string{"Authorization", "User-Agent"},
+		Cookies:        true,
+		QueryString:    true,
+	}}, (*utils.ResponseHeaders)(nil), []utils.ErrorResponse{}, "letsencrypt", "", "", (*certificate.Resource)(nil), map[string]string{"Organization": "", "Space": "", "Service": "", "Plan": ""}).Return(route, nil)
+
+	details := brokerapi.ProvisionDetails{
+		RawParameters: []byte(`{"domain": "domain.gov", "origin_headers": ["*"], "cache_behaviors": [
+			{"path_pattern": "/api/*", "origin_headers": ["user-agent", "authorization"]}
+		]}`),
+	}
+	_, err := s.Broker.Provision(s.ctx, "123", details, true)
+	s.Nil(err)
+}
+
+func (s *ProvisionSuite) TestOriginHeadersInvalid() {
+	s.settings.CloudFrontPolicies = true
+	s.Broker = broker.New(&s.Manager, &s.cfclient, s.settings, s.logger)
+	s.setupTestOfHeaderForwarding()
+
+	for headers, message := range map[string]string{
+		`["Accept", "accept"]`: "must not pass duplicated origin header 'accept'",
+		`["*", "Accept"]`:      "must not pass origin headers alongside wildcard",
+		`["1", "2", "3", "4", "5", "6", "7", "8", "9", "10", "11"]`: "must not set more than 10 origin headers; got 11",
+	} {
+		details := brokerapi.ProvisionDetails{
+			RawParameters: []byte(`{"domain": "domain.gov", "origin_headers": ` + headers + `}`),
+		}
+		_, err := s.Broker.Provision(s.ctx, "123", details, true)
+		if s.Error(err, headers) {
+			s.Contains(err.Error(), message, headers)
+		}
+	}
+}
+
+func (s *ProvisionSuite) TestOriginHeadersWithoutPolicies() {
+	s.setupTestOfHeaderForwarding()
+
+	details := brokerapi.ProvisionDetails{
+		RawParameters: []byte(`{"domain": "domain.gov", "origin_headers": ["Accept"]}`),
+	}
+	_, err := s.Broker.Provision(s.ctx, "123", details, true)
+	s.Error(err)
+	s.Contains(err.Error(), "`origin_headers` need CloudFront cache policies")
+}
+
+func (s *ProvisionSuite) TestSuccessDefaultResponseHeaders() {
+	s.settings.ResponseHeaders = `{"strict_transport_security": {"max_age": 31536000}, "content_type_options": true}`
+	s.Broker = broker.New(&s.Manager, &s.cfclient, s.settings, s.logger)
+	s.setupTestOfHeaderForwarding()
+	route := &models.Route{State: models.Provisioning}
+	s.Manager.On("Create", "123", "domain.gov", "origin.cloud.gov", "", false, utils.Headers{"Host": true}, utils.Headers{}, true, []string(nil), []string(nil), []utils.CacheBehavior(nil), &utils.ResponseHeaders{
+		StrictTransportSecurity: &utils.StrictTransportSecurity{MaxAge: 31536000},
+		ContentTypeOptions:      true,
+	}, []utils.ErrorResponse{}, "letsencrypt", "", "", (*certificate.Resource)(nil), map[string]string{"Organization": "", "Space": "", "Service": "", "Plan": ""}).Return(route, nil)
+
+	details := brokerapi.ProvisionDetails{
+		RawParameters: []byte(`{"domain": "domain.gov"}`),
+	}
+	_, err := s.Broker.Provision(s.ctx, "123", details, true)
+	s.Nil(err)
+}
+
+func (s *ProvisionSuite) TestSuccessResponseHeaders() {
+	s.settings.ResponseHeaders = `{"content_type_options": true}`
+	s.Broker = broker.New(&s.Manager, &s.cfclient, s.settings, s.logger)
+	s.setupTestOfHeaderForwarding()
+	route := &models.Route{State: models.Provisioning}
+	s.Manager.On("Create", "123", "domain.gov", "origin.cloud.gov", "", false, utils.Headers{"Host": true}, utils.Headers{}, true, []string(nil), []string(nil), []utils.CacheBehavior(nil), &utils.ResponseHeaders{
+		FrameOptions:  "DENY",
+		CustomHeaders: map[string]string{"X-Agency": "gsa"},
+		Cors: &utils.Cors{
+			AllowOrigins: []string{"https://www.agency.gov"},
+			AllowMethods: []string{"GET", "HEAD", "OPTIONS"},
+			AllowHeaders: []string{"*"},
+		},
+	}, []utils.ErrorResponse{}, "letsencrypt", "", "", (*certificate.Resource)(nil), map[string]string{"Organization": "", "Space": "", "Service": "", "Plan": ""}).Return(route, nil)
+
+	details := brokerapi.ProvisionDetails{
+		RawParameters: []byte(`{"domain": "domain.gov", "response_headers": {
+			"frame_options": "deny",
+			"custom_headers": {"X-Agency": "gsa"},
+			"cors": {"allow_origins": ["https://www.agency.gov"]}
+		}}`),
+	}
+	_, err := s.Broker.Provision(s.ctx, "123", details, true)
+	s.Nil(err)
+}
+
+func (s *ProvisionSuite) TestResponseHeadersInvalid() {
+	s.setupTestOfHeaderForwarding()
+
+	details := brokerapi.ProvisionDetails{
+		RawParameters: []byte(`{"domain": "domain.gov", "response_headers": {"frame_options": "ALLOW-FROM"}}`),
+	}
+	_, err := s.Broker.Provision(s.ctx, "123", details, true)
+	s.Error(err)
+	s.Contains(err.Error(), "invalid `response_headers`: `frame_options` must be one of DENY, SAMEORIGIN")
+}
+
+func (s *ProvisionSuite) TestSuccessMaintenancePage() {
+	s.settings.MaintenancePage = "./maintenance.html"
+	s.Broker = broker.New(&s.Manager, &s.cfclient, s.settings, s.logger)
+	s.setupTestOfHeaderForwarding()
+	route := &models.Route{State: models.Provisioning}
+	s.Manager.On("Create", "123", "domain.gov", "origin.cloud.gov", "", false, utils.Headers{"Host": true}, utils.Headers{}, true, []string(nil), []string(nil), []utils.CacheBehavior(nil), (*utils.ResponseHeaders)(nil), []utils.ErrorResponse{
+		{ErrorCode: 404, ResponseCode: 404, ResponsePagePath: "/404.html", CachingMinTTL: aws.Int64(60)},
+		{ErrorCode: 503, CachingMinTTL: aws.Int64(0)},
+		{ErrorCode: 502, ResponseCode: 503, ResponsePagePath: utils.MaintenancePagePath, CachingMinTTL: aws.Int64(10)},
+		{ErrorCode: 504, ResponseCode: 503, ResponsePagePath: utils.MaintenancePagePath, CachingMinTTL: aws.Int64(10)},
+	}, "letsencrypt", "", "", (*certificate.Resource)(nil), map[string]string{"Organization": "", "Space": "", "Service": "", "Plan": ""}).Return(route, nil)
+
+	details := brokerapi.ProvisionDetails{
+		RawParameters: []byte(`{"domain": "domain.gov", "error_responses": [
+			{"error_code": 404, "response_page_path": "/404.html", "caching_min_ttl": 60},
+			{"error_code": 503, "caching_min_ttl": 0}
+		]}`),
+	}
+	_, err := s.Broker.Provision(s.ctx, "123", details, true)
+	s.Nil(err)
+}
+
+func (s *ProvisionSuite) TestErrorResponsesInvalid() {
+	s.setupTestOfHeaderForwarding()
+
+	for responses, message := range map[string]string{
+		`"error_responses": [{"error_code": 418}]`:                                                          "`error_code` must be one of 400, 403",
+		`"error_responses": [{"error_code": 404}, {"error_code": 404}]`:                                     "must not pass duplicated `error_code` 404",
+		`"error_responses": [{"error_code": 404, "caching_min_ttl": -1}]`:                                   "error response 404: `caching_min_ttl` must not be negative",
+		`"error_responses": [{"error_code": 404, "response_code": 200}]`:                                    "error response 404: must not pass `response_code` without `response_page_path`",
+		`"error_responses": [{"error_code": 404, "response_page_path": "404.html"}]`:                        "error response 404: `response_page_path` must be a path starting with /",
+		`"error_responses": [{"error_code": 404, "response_page_path": "/404.html", "response_code": 302}]`: "error response 404: `response_code` must be one of 200, 400",
+		`"maintenance_page": true`:                                                                          "`maintenance_page` isn't offered by this broker",
+	} {
+		details := brokerapi.ProvisionDetails{
+			RawParameters: []byte(`{"domain": "domain.gov", ` + responses + `}`),
+		}
+		_, err := s.Broker.Provision(s.ctx, "123", details, true)
+		if s.Error(err, responses) {
+			s.Contains(err.Error(), message, responses)
+		}
+	}
+}
+
+func (s *ProvisionSuite) TestSuccessCookieAndQueryStringWhitelists() {
+	s.setupTestOfHeaderForwarding()
+	route := &models.Route{State: models.Provisioning}
+	s.Manager.On("Create", "123", "domain.gov", "origin.cloud.gov", "", false, utils.Headers{"Host": true}, utils.Headers{}, true,
+		[]string{"session"}, []string{"page", "q"}, []utils.CacheBehavior{
+			{
+				PathPattern:     "/search/*",
+				DefaultTTL:      86400,
+				MaxTTL:          31536000,
+				AllowedMethods:  utils.AllMethods,
+				Headers:         []string{"Host"},
+				Cookies:         true,
+				CookieNames:     []string{"session"},
+				QueryString:     true,
+				QueryStringKeys: []string{"page", "q"},
+			},
+			{
+				PathPattern:     "/static/*",
+				DefaultTTL:      86400,
+				MaxTTL:          31536000,
+				AllowedMethods:  utils.AllMethods,
+				Headers:         []string{"Host"},
+				Cookies:         false,
+				QueryString:     true,
+				QueryStringKeys: []string{"v"},
+			},
+		}, (*utils.ResponseHeaders)(nil), []utils.ErrorResponse{}, "letsencrypt", "", "", (*certificate.Resource)(nil), map[string]string{"Organization": "", "Space": "", "Service": "", "Plan": ""}).Return(route, nil)
+
+	details := brokerapi.ProvisionDetails{
+		RawParameters: []byte(`{"domain": "domain.gov", "cookie_names": ["session"], "query_string_keys": ["page", "q"], "cache_behaviors": [
+			{"path_pattern": "/search/*"},
+			{"path_pattern": "/static/*", "cookies": false, "query_string_keys": ["v"]}
+		]}`),
+	}
+	_, err := s.Broker.Provision(s.ctx, "123", details, true)
+	s.Nil(err)
+}
+
+func (s *ProvisionSuite) TestCookieAndQueryStringWhitelistsInvalid() {
+	s.setupTestOfHeaderForwarding()
+
+	for params, message := range map[string]string{
+		`"cookie_names": ["session", "session"]`: "must not pass duplicated `cookie_names` 'session'",
+		`"query_string_keys": [""]`:              "must not pass empty `query_string_keys`",
+		`"query_string_keys": ["1", "2", "3", "4", "5", "6", "7", "8", "9", "10", "11"]`:                 "must not set more than 10 `query_string_keys`; got 11",
+		`"cache_behaviors": [{"path_pattern": "/*", "query_string": false, "query_string_keys": ["v"]}]`: "without forwarding query strings",
+	} {
+		details := brokerapi.ProvisionDetails{
+			RawParameters: []byte(`{"domain": "domain.gov", ` + params + `}`),
+		}
+		_, err := s.Broker.Provision(s.ctx, "123", details, true)
+		if s.Error(err, params) {
+			s.Contains(err.Error(), message, params)
+		}
+	}
+}
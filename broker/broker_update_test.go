@@ -4,17 +4,20 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"testing"
 
 	"github.com/stretchr/testify/suite"
 
 	"code.cloudfoundry.org/lager"
 	"github.com/cloudfoundry-community/go-cfclient"
+	"github.com/go-acme/lego/v4/certificate"
 	"github.com/pivotal-cf/brokerapi"
 
 	"github.com/cloud-gov/cf-cdn-service-broker/broker"
 	cfmock "github.com/cloud-gov/cf-cdn-service-broker/cf/mocks"
 	"github.com/cloud-gov/cf-cdn-service-broker/config"
+	"github.com/cloud-gov/cf-cdn-service-broker/models"
 	"github.com/cloud-gov/cf-cdn-service-broker/models/mocks"
 	"github.com/cloud-gov/cf-cdn-service-broker/utils"
 )
@@ -47,6 +50,8 @@ func (s *UpdateSuite) SetupTest() {
 		s.logger,
 	)
 	s.ctx = context.Background()
+
+	s.Manager.On("Get", "").Return(&models.Route{CertificateProvider: models.CertificateProviderLetsEncrypt}, nil)
 }
 
 func (s *UpdateSuite) TestUpdateWithoutOptions() {
@@ -55,14 +60,14 @@ func (s *UpdateSuite) TestUpdateWithoutOptions() {
 	}
 	_, err := s.Broker.Update(s.ctx, "", details, true)
 	s.NotNil(err)
-	s.Equal(err.Error(), "must pass non-empty `domain` or `origin`")
+	s.Equal(err.Error(), "must pass non-empty `domain`, `origin` or `certificate`")
 }
 
 func (s *UpdateSuite) TestUpdateSuccessOnlyDomain() {
 	details := brokerapi.UpdateDetails{
 		RawParameters: json.RawMessage(`{"domain": "domain.gov"}`),
 	}
-	s.Manager.On("Update", "", "domain.gov", "origin.cloud.gov", "", false, utils.Headers{"Host": true}, true).Return(nil)
+	s.Manager.On("Update", "", "domain.gov", "origin.cloud.gov", "", false, utils.Headers{"Host": true}, utils.Headers{}, true, []string(nil), []string(nil), []utils.CacheBehavior(nil), (*utils.ResponseHeaders)(nil), []utils.ErrorResponse(nil), "").Return(nil)
 	s.cfclient.On("GetDomainByName", "domain.gov").Return(cfclient.Domain{}, nil)
 	_, err := s.Broker.Update(s.ctx, "", details, true)
 	s.Nil(err)
@@ -72,7 +77,7 @@ func (s *UpdateSuite) TestUpdateSuccessOnlyOrigin() {
 	details := brokerapi.UpdateDetails{
 		RawParameters: json.RawMessage(`{"origin": "origin.gov"}`),
 	}
-	s.Manager.On("Update", "", "", "origin.gov", "", false, utils.Headers{}, true).Return(nil)
+	s.Manager.On("Update", "", "", "origin.gov", "", false, utils.Headers{}, utils.Headers{}, true, []string(nil), []string(nil), []utils.CacheBehavior(nil), (*utils.ResponseHeaders)(nil), []utils.ErrorResponse(nil), "").Return(nil)
 	s.cfclient.On("GetDomainByName", "domain.gov").Return(cfclient.Domain{}, nil)
 	_, err := s.Broker.Update(s.ctx, "", details, true)
 	s.Nil(err)
@@ -86,12 +91,44 @@ func (s *UpdateSuite) TestUpdateSuccess() {
 			"path": "."
 		}`),
 	}
-	s.Manager.On("Update", "", "domain.gov", "origin.cloud.gov", ".", true, utils.Headers{"Host": true}, true).Return(nil)
+	s.Manager.On("Update", "", "domain.gov", "origin.cloud.gov", ".", true, utils.Headers{"Host": true}, utils.Headers{}, true, []string(nil), []string(nil), []utils.CacheBehavior(nil), (*utils.ResponseHeaders)(nil), []utils.ErrorResponse(nil), "").Return(nil)
 	s.cfclient.On("GetDomainByName", "domain.gov").Return(cfclient.Domain{}, nil)
 	_, err := s.Broker.Update(s.ctx, "", details, true)
 	s.Nil(err)
 }
 
+func (s *UpdateSuite) TestUpdateSuccessKeyType() {
+	details := brokerapi.UpdateDetails{
+		RawParameters: json.RawMessage(`{"origin": "origin.gov", "key_type": "EC_secp384r1"}`),
+	}
+	s.Manager.On("Update", "", "", "origin.gov", "", false, utils.Headers{}, utils.Headers{}, true, []string(nil), []string(nil), []utils.CacheBehavior(nil), (*utils.ResponseHeaders)(nil), []utils.ErrorResponse(nil), "EC_secp384r1").Return(nil)
+	_, err := s.Broker.Update(s.ctx, "", details, true)
+	s.Nil(err)
+}
+
+func (s *UpdateSuite) TestUpdateUnknownKeyType() {
+	details := brokerapi.UpdateDetails{
+		RawParameters: json.RawMessage(`{"origin": "origin.gov", "key_type": "RSA_1024"}`),
+	}
+	_, err := s.Broker.Update(s.ctx, "", details, true)
+	s.NotNil(err)
+	s.Contains(err.Error(), "key_type")
+}
+
+func (s *UpdateSuite) TestUpdateKeyTypeOfOtherProviders() {
+	s.Manager.On("Get", "acm").Return(&models.Route{CertificateProvider: models.CertificateProviderAcm}, nil)
+	s.Manager.On("Get", "custom").Return(&models.Route{CertificateProvider: models.CertificateProviderCustom}, nil)
+	details := brokerapi.UpdateDetails{
+		RawParameters: json.RawMessage(`{"origin": "origin.gov", "key_type": "EC_secp384r1"}`),
+	}
+	for _, instanceID := range []string{"acm", "custom"} {
+		_, err := s.Broker.Update(s.ctx, instanceID, details, true)
+		s.NotNil(err)
+		s.Contains(err.Error(), fmt.Sprintf("`key_type` can't be used with the %q certificate provider", instanceID))
+	}
+	s.Manager.AssertNotCalled(s.T(), "Update")
+}
+
 func (s *UpdateSuite) TestDomainNotExists() {
 	details := brokerapi.UpdateDetails{
 		PreviousValues: brokerapi.PreviousValues{
@@ -99,7 +136,7 @@ func (s *UpdateSuite) TestDomainNotExists() {
 		},
 		RawParameters: json.RawMessage(`{"domain": "domain.gov"}`),
 	}
-	s.Manager.On("Update", "", "domain.gov", "origin.cloud.gov", ".", true, utils.Headers{"Host": true}, true).Return(nil)
+	s.Manager.On("Update", "", "domain.gov", "origin.cloud.gov", ".", true, utils.Headers{"Host": true}, utils.Headers{}, true, []string(nil), []string(nil), []utils.CacheBehavior(nil), (*utils.ResponseHeaders)(nil), []utils.ErrorResponse(nil), "").Return(nil)
 	s.cfclient.On("GetOrgByGuid", "dfb39134-ab7d-489e-ae59-4ed5c6f42fb5").Return(cfclient.Org{Name: "my-org"}, nil)
 	s.cfclient.On("GetDomainByName", "domain.gov").Return(cfclient.Domain{}, errors.New("bad"))
 	_, err := s.Broker.Update(s.ctx, "", details, true)
@@ -107,16 +144,134 @@ func (s *UpdateSuite) TestDomainNotExists() {
 	s.Contains(err.Error(), "cf create-domain")
 }
 
+func (s *UpdateSuite) TestWildcardDomainNotOwnedCustomOrigin() {
+	details := brokerapi.UpdateDetails{
+		PreviousValues: brokerapi.PreviousValues{
+			OrgID: "dfb39134-ab7d-489e-ae59-4ed5c6f42fb5",
+		},
+		RawParameters: json.RawMessage(`{"domain": "*.domain.gov", "origin": "custom.cloud.gov"}`),
+	}
+	s.cfclient.On("GetOrgByGuid", "dfb39134-ab7d-489e-ae59-4ed5c6f42fb5").Return(cfclient.Org{Name: "my-org"}, nil)
+	s.cfclient.On("GetDomainByName", "domain.gov").Return(cfclient.Domain{
+		OwningOrganizationGuid: "another-org",
+	}, nil)
+	_, err := s.Broker.Update(s.ctx, "", details, true)
+	s.NotNil(err)
+	s.Contains(err.Error(), "requires domain.gov to be a private domain of organization my-org")
+	s.Manager.AssertNotCalled(s.T(), "Update")
+}
+
+func (s *UpdateSuite) TestUpdateOnlyCertificate() {
+	route := &models.Route{CertificateProvider: models.CertificateProviderCustom, DomainExternal: "domain.gov,www.domain.gov"}
+	cert := certificate.Resource{Domain: "domain.gov"}
+	s.Manager.On("Get", "123").Return(route, nil)
+	s.Manager.On("ResolveCertificate", utils.CustomCertificate{Certificate: "cert", PrivateKey: "key"}, []string{"domain.gov", "www.domain.gov"}).Return(cert, nil)
+	s.Manager.On("ImportCertificate", route, cert).Return(nil)
+
+	details := brokerapi.UpdateDetails{
+		RawParameters: json.RawMessage(`{"certificate": "cert", "private_key": "key"}`),
+	}
+	_, err := s.Broker.Update(s.ctx, "123", details, true)
+	s.Nil(err)
+	s.Manager.AssertCalled(s.T(), "ImportCertificate", route, cert)
+	s.Manager.AssertNotCalled(s.T(), "Update")
+}
+
+func (s *UpdateSuite) TestUpdateDomainWithCertificate() {
+	route := &models.Route{CertificateProvider: models.CertificateProviderCustom, DomainExternal: "domain.gov"}
+	cert := certificate.Resource{Domain: "new.domain.gov"}
+	s.Manager.On("Get", "123").Return(route, nil)
+	s.cfclient.On("GetDomainByName", "new.domain.gov").Return(cfclient.Domain{}, nil)
+	s.Manager.On("ResolveCertificate", utils.CustomCertificate{CredhubRef: "/agency/cert"}, []string{"new.domain.gov"}).Return(cert, nil)
+	s.Manager.On("Update", "123", "new.domain.gov", "origin.cloud.gov", "", false, utils.Headers{"Host": true}, utils.Headers{}, true, []string(nil), []string(nil), []utils.CacheBehavior(nil), (*utils.ResponseHeaders)(nil), []utils.ErrorResponse(nil), "").Return(nil)
+	s.Manager.On("ImportCertificate", route, cert).Return(nil)
+
+	details := brokerapi.UpdateDetails{
+		RawParameters: json.RawMessage(`{"domain": "new.domain.gov", "credhub_ref": "/agency/cert"}`),
+	}
+	_, err := s.Broker.Update(s.ctx, "123", details, true)
+	s.Nil(err)
+	s.Manager.AssertCalled(s.T(), "Update", "123", "new.domain.gov", "origin.cloud.gov", "", false, utils.Headers{"Host": true}, utils.Headers{}, true, []string(nil), []string(nil), []utils.CacheBehavior(nil), (*utils.ResponseHeaders)(nil), []utils.ErrorResponse(nil), "")
+	s.Manager.AssertCalled(s.T(), "ImportCertificate", route, cert)
+}
+
+func (s *UpdateSuite) TestUpdateDomainWithoutCertificate() {
+	route := &models.Route{CertificateProvider: models.CertificateProviderCustom, DomainExternal: "domain.gov"}
+	s.Manager.On("Get", "123").Return(route, nil)
+	s.cfclient.On("GetDomainByName", "new.domain.gov").Return(cfclient.Domain{}, nil)
+
+	details := brokerapi.UpdateDetails{
+		RawParameters: json.RawMessage(`{"domain": "new.domain.gov"}`),
+	}
+	_, err := s.Broker.Update(s.ctx, "123", details, true)
+	s.NotNil(err)
+	s.Contains(err.Error(), "must pass a `certificate`")
+	s.Manager.AssertNotCalled(s.T(), "Update")
+}
+
+func (s *UpdateSuite) TestUpdateCertificateOfLetsEncryptInstance() {
+	details := brokerapi.UpdateDetails{
+		RawParameters: json.RawMessage(`{"certificate": "cert", "private_key": "key"}`),
+	}
+	_, err := s.Broker.Update(s.ctx, "", details, true)
+	s.NotNil(err)
+	s.Contains(err.Error(), "`certificate` can't be used")
+}
+
+func (s *UpdateSuite) TestUpdateCacheBehaviors() {
+	details := brokerapi.UpdateDetails{
+		RawParameters: json.RawMessage(`{"origin": "origin.gov", "cache_behaviors": [{"path_pattern": "/api/*", "max_ttl": 0}]}`),
+	}
+	s.Manager.On("Update", "", "", "origin.gov", "", false, utils.Headers{}, utils.Headers{}, true, []string(nil), []string(nil), []utils.CacheBehavior{{
+		PathPattern:    "/api/*",
+		AllowedMethods: utils.AllMethods,
+		Headers:        []string{},
+		Cookies:        true,
+		QueryString:    true,
+	}}, (*utils.ResponseHeaders)(nil), []utils.ErrorResponse(nil), "").Return(nil)
+	_, err := s.Broker.Update(s.ctx, "", details, true)
+	s.Nil(err)
+}
+
+func (s *UpdateSuite) TestUpdateClearCacheBehaviors() {
+	details := brokerapi.UpdateDetails{
+		RawParameters: json.RawMessage(`{"origin": "origin.gov", "cache_behaviors": []}`),
+	}
+	s.Manager.On("Update", "", "", "origin.gov", "", false, utils.Headers{}, utils.Headers{}, true, []string(nil), []string(nil), []utils.CacheBehavior{}, (*utils.ResponseHeaders)(nil), []utils.ErrorResponse(nil), "").Return(nil)
+	_, err := s.Broker.Update(s.ctx, "", details, true)
+	s.Nil(err)
+}
+
+func (s *UpdateSuite) TestUpdateRemoveResponseHeaders() {
+	details := brokerapi.UpdateDetails{
+		RawParameters: json.RawMessage(`{"origin": "origin.gov", "response_headers": {}}`),
+	}
+	s.Manager.On("Update", "", "", "origin.gov", "", false, utils.Headers{}, utils.Headers{}, true, []string(nil), []string(nil), []utils.CacheBehavior(nil), &utils.ResponseHeaders{}, []utils.ErrorResponse(nil), "").Return(nil)
+	_, err := s.Broker.Update(s.ctx, "", details, true)
+	s.Nil(err)
+}
+
+func (s *UpdateSuite) TestUpdateRemoveMaintenancePage() {
+	s.settings.MaintenancePage = "./maintenance.html"
+	s.Broker = broker.New(&s.Manager, &s.cfclient, s.settings, s.logger)
+	details := brokerapi.UpdateDetails{
+		RawParameters: json.RawMessage(`{"origin": "origin.gov", "maintenance_page": false}`),
+	}
+	s.Manager.On("Update", "", "", "origin.gov", "", false, utils.Headers{}, utils.Headers{}, true, []string(nil), []string(nil), []utils.CacheBehavior(nil), (*utils.ResponseHeaders)(nil), []utils.ErrorResponse{}, "").Return(nil)
+	_, err := s.Broker.Update(s.ctx, "", details, true)
+	s.Nil(err)
+}
+
 func (s *UpdateSuite) setupTestOfHeaderForwarding() {
 	s.cfclient.On("GetDomainByName", "domain.gov").Return(cfclient.Domain{}, nil)
 }
 
 func (s *UpdateSuite) allowUpdateWithExpectedHeaders(expectedHeaders utils.Headers) {
-	s.Manager.On("Update", "", "domain.gov", "origin.cloud.gov", ".", true, expectedHeaders, true).Return(nil)
+	s.Manager.On("Update", "", "domain.gov", "origin.cloud.gov", ".", true, expectedHeaders, utils.Headers{}, true, []string(nil), []string(nil), []utils.CacheBehavior(nil), (*utils.ResponseHeaders)(nil), []utils.ErrorResponse(nil), "").Return(nil)
 }
 
 func (s *UpdateSuite) failOnUpdateWithExpectedHeaders(expectedHeaders utils.Headers) {
-	s.Manager.On("Update", "", "domain.gov", "origin.cloud.gov", ".", true, expectedHeaders, true).Return(errors.New("fail"))
+	s.Manager.On("Update", "", "domain.gov", "origin.cloud.gov", ".", true, expectedHeaders, utils.Headers{}, true, []string(nil), []string(nil), []utils.CacheBehavior(nil), (*utils.ResponseHeaders)(nil), []utils.ErrorResponse(nil), "").Return(errors.New("fail"))
 }
 
 func (s *UpdateSuite) TestSuccessForwardingDuplicatedHostHeader() {
@@ -14,7 +14,8 @@ import (
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/cloudfront"
-	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/route53"
+	"github.com/aws/aws-sdk-go/service/s3"
 
 	"github.com/cloud-gov/cf-cdn-service-broker/broker"
 	"github.com/cloud-gov/cf-cdn-service-broker/config"
@@ -33,6 +34,9 @@ func main() {
 	if err != nil {
 		logger.Fatal("new-settings", err)
 	}
+	if _, err := utils.ParseResponseHeaders(settings.ResponseHeaders); err != nil {
+		logger.Fatal("parse-response-headers", err)
+	}
 
 	db, err := config.Connect(settings)
 	if err != nil {
@@ -50,14 +54,38 @@ func main() {
 
 	session := session.New(aws.NewConfig().WithRegion(settings.AwsDefaultRegion))
 
-	if err := db.AutoMigrate(&models.Route{}, &models.Certificate{}, &models.UserData{}).Error; err != nil {
+	if settings.MaintenancePage != "" {
+		if err := utils.UploadMaintenancePage(settings, s3.New(session)); err != nil {
+			logger.Fatal("upload-maintenance-page", err)
+		}
+	}
+
+	if err := db.AutoMigrate(&models.Route{}, &models.Certificate{}, &models.UserData{}, &models.RenewalAttempt{}, &models.RenewalWindow{}, &models.ExpiryNotification{}, &models.DomainAuthorization{}, &models.HealthFinding{}).Error; err != nil {
 		logger.Fatal("migrate", err)
 	}
 
+	certs, err := utils.NewCertificateStore(settings, session)
+	if err != nil {
+		logger.Fatal("new-certificate-store", err)
+	}
+
+	keys, err := utils.NewEncryptorFromSettings(settings, session)
+	if err != nil {
+		logger.Fatal("new-encryptor", err)
+	}
+
+	dns, err := utils.NewDNSProviders(settings, &utils.Route53{Settings: settings, Service: route53.New(session)})
+	if err != nil {
+		logger.Fatal("new-dns-providers", err)
+	}
+
 	manager := models.NewManager(
 		logger,
-		&utils.Iam{settings, iam.New(session)},
-		&utils.Distribution{settings, cloudfront.New(session)},
+		certs,
+		&utils.Distribution{Settings: settings, Service: cloudfront.New(session)},
+		utils.NewAcmIssuer(settings, session),
+		dns,
+		keys,
 		settings,
 		db,
 	)
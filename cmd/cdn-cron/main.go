@@ -12,7 +12,7 @@ import (
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/cloudfront"
-	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/route53"
 
 	"github.com/cloud-gov/cf-cdn-service-broker/config"
 	"github.com/cloud-gov/cf-cdn-service-broker/models"
@@ -37,18 +37,40 @@ func main() {
 		logger.Fatal("connect", err)
 	}
 
-	if err := db.AutoMigrate(&models.Route{}, &models.Certificate{}, &models.UserData{}).Error; err != nil {
+	if err := db.AutoMigrate(&models.Route{}, &models.Certificate{}, &models.UserData{}, &models.RenewalAttempt{}, &models.RenewalWindow{}, &models.ExpiryNotification{}, &models.DomainAuthorization{}, &models.HealthFinding{}).Error; err != nil {
 		logger.Fatal("migrate", err)
 	}
 
+	certs, err := utils.NewCertificateStore(settings, session)
+	if err != nil {
+		logger.Fatal("new-certificate-store", err)
+	}
+
+	keys, err := utils.NewEncryptorFromSettings(settings, session)
+	if err != nil {
+		logger.Fatal("new-encryptor", err)
+	}
+
+	dns, err := utils.NewDNSProviders(settings, &utils.Route53{Settings: settings, Service: route53.New(session)})
+	if err != nil {
+		logger.Fatal("new-dns-providers", err)
+	}
+
 	manager := models.NewManager(
 		logger,
-		&utils.Iam{settings, iam.New(session)},
-		&utils.Distribution{settings, cloudfront.New(session)},
+		certs,
+		&utils.Distribution{Settings: settings, Service: cloudfront.New(session)},
+		utils.NewAcmIssuer(settings, session),
+		dns,
+		keys,
 		settings,
 		db,
 	)
 
+	alerter := models.NewExpiryAlerter(logger, settings, db, utils.NewNotifiers(settings))
+
+	monitor := models.NewTLSMonitor(logger, db, utils.NewTLSProber(settings))
+
 	c := cron.New()
 
 	c.AddFunc(settings.Schedule, func() {
@@ -61,6 +83,18 @@ func main() {
 		manager.DeleteOrphanedCerts()
 	})
 
+	c.AddFunc(settings.Schedule, func() {
+		logger.Info("Running expiry alerts")
+		alerter.AlertAll()
+	})
+
+	if settings.TlsMonitor {
+		c.AddFunc(settings.Schedule, func() {
+			logger.Info("Running TLS monitor")
+			monitor.CheckAll()
+		})
+	}
+
 	logger.Info("Starting cron")
 	c.Start()
 
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"flag"
+	"os"
+
+	"code.cloudfoundry.org/lager"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudfront"
+	"github.com/aws/aws-sdk-go/service/route53"
+
+	"github.com/cloud-gov/cf-cdn-service-broker/config"
+	"github.com/cloud-gov/cf-cdn-service-broker/models"
+	"github.com/cloud-gov/cf-cdn-service-broker/utils"
+)
+
+// cdn-migrate-certificates moves distributions to certificates in the
+// configured CERTIFICATE_STORE, e.g. from IAM server certificates to ACM.
+func main() {
+	limit := flag.Int("limit", 0, "maximum number of routes to migrate; 0 migrates all routes")
+	dryRun := flag.Bool("dry-run", false, "log the routes that would be migrated without migrating them")
+	deleteOrphaned := flag.String("delete-orphaned", "", "after migrating, delete unused certificates from this store (iam or acm)")
+	flag.Parse()
+
+	logger := lager.NewLogger("cdn-migrate-certificates")
+	logger.RegisterSink(lager.NewWriterSink(os.Stderr, lager.INFO))
+
+	settings, err := config.NewSettings()
+	if err != nil {
+		logger.Fatal("new-settings", err)
+	}
+
+	session := session.New(aws.NewConfig().WithRegion(settings.AwsDefaultRegion))
+
+	db, err := config.Connect(settings)
+	if err != nil {
+		logger.Fatal("connect", err)
+	}
+
+	if err := db.AutoMigrate(&models.Route{}, &models.Certificate{}, &models.UserData{}, &models.RenewalAttempt{}, &models.RenewalWindow{}, &models.ExpiryNotification{}, &models.DomainAuthorization{}, &models.HealthFinding{}).Error; err != nil {
+		logger.Fatal("migrate", err)
+	}
+
+	certs, err := utils.NewCertificateStore(settings, session)
+	if err != nil {
+		logger.Fatal("new-certificate-store", err)
+	}
+
+	distribution := &utils.Distribution{Settings: settings, Service: cloudfront.New(session)}
+	acmIssuer := utils.NewAcmIssuer(settings, session)
+
+	keys, err := utils.NewEncryptorFromSettings(settings, session)
+	if err != nil {
+		logger.Fatal("new-encryptor", err)
+	}
+
+	dns, err := utils.NewDNSProviders(settings, &utils.Route53{Settings: settings, Service: route53.New(session)})
+	if err != nil {
+		logger.Fatal("new-dns-providers", err)
+	}
+
+	manager := models.NewManager(logger, certs, distribution, acmIssuer, dns, keys, settings, db)
+	if err := manager.MigrateCertificates(*limit, *dryRun); err != nil {
+		logger.Fatal("migrate-certificates", err)
+	}
+
+	if *deleteOrphaned != "" && !*dryRun {
+		oldSettings := settings
+		oldSettings.CertificateStore = *deleteOrphaned
+		oldCerts, err := utils.NewCertificateStore(oldSettings, session)
+		if err != nil {
+			logger.Fatal("new-certificate-store", err)
+		}
+
+		oldManager := models.NewManager(logger, oldCerts, distribution, acmIssuer, dns, keys, oldSettings, db)
+		oldManager.DeleteOrphanedCerts()
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"os"
+
+	"code.cloudfoundry.org/lager"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudfront"
+	"github.com/aws/aws-sdk-go/service/route53"
+
+	"github.com/cloud-gov/cf-cdn-service-broker/config"
+	"github.com/cloud-gov/cf-cdn-service-broker/models"
+	"github.com/cloud-gov/cf-cdn-service-broker/utils"
+)
+
+// cdn-revoke-certificate revokes a route's certificate with the CA, e.g. after
+// its private key was compromised, and then issues it a new certificate.
+func main() {
+	instanceId := flag.String("instance-id", "", "service instance whose certificate to revoke")
+	reason := flag.String("reason", "unspecified", "RFC 5280 revocation reason: unspecified, keyCompromise, affiliationChanged, superseded or cessationOfOperation")
+	renew := flag.Bool("renew", true, "issue the route a new certificate after revoking the old one")
+	flag.Parse()
+
+	logger := lager.NewLogger("cdn-revoke-certificate")
+	logger.RegisterSink(lager.NewWriterSink(os.Stderr, lager.INFO))
+
+	if *instanceId == "" {
+		logger.Fatal("parse-flags", errors.New("-instance-id is required"))
+	}
+
+	reasonCode, err := utils.ParseRevocationReason(*reason)
+	if err != nil {
+		logger.Fatal("parse-flags", err)
+	}
+
+	settings, err := config.NewSettings()
+	if err != nil {
+		logger.Fatal("new-settings", err)
+	}
+
+	session := session.New(aws.NewConfig().WithRegion(settings.AwsDefaultRegion))
+
+	db, err := config.Connect(settings)
+	if err != nil {
+		logger.Fatal("connect", err)
+	}
+
+	if err := db.AutoMigrate(&models.Route{}, &models.Certificate{}, &models.UserData{}, &models.RenewalAttempt{}, &models.RenewalWindow{}, &models.ExpiryNotification{}, &models.DomainAuthorization{}, &models.HealthFinding{}).Error; err != nil {
+		logger.Fatal("migrate", err)
+	}
+
+	certs, err := utils.NewCertificateStore(settings, session)
+	if err != nil {
+		logger.Fatal("new-certificate-store", err)
+	}
+
+	keys, err := utils.NewEncryptorFromSettings(settings, session)
+	if err != nil {
+		logger.Fatal("new-encryptor", err)
+	}
+
+	dns, err := utils.NewDNSProviders(settings, &utils.Route53{Settings: settings, Service: route53.New(session)})
+	if err != nil {
+		logger.Fatal("new-dns-providers", err)
+	}
+
+	manager := models.NewManager(
+		logger,
+		certs,
+		&utils.Distribution{Settings: settings, Service: cloudfront.New(session)},
+		utils.NewAcmIssuer(settings, session),
+		dns,
+		keys,
+		settings,
+		db,
+	)
+
+	route, err := manager.Get(*instanceId)
+	if err != nil {
+		logger.Fatal("get-route", err)
+	}
+
+	if err := manager.Revoke(route, reasonCode); err != nil {
+		logger.Fatal("revoke", err)
+	}
+
+	if *renew {
+		if err := manager.Renew(route); err != nil {
+			logger.Fatal("renew", err)
+		}
+	}
+}
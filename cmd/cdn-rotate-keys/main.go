@@ -0,0 +1,50 @@
+package main
+
+import (
+	"flag"
+	"os"
+
+	"code.cloudfoundry.org/lager"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+
+	"github.com/cloud-gov/cf-cdn-service-broker/config"
+	"github.com/cloud-gov/cf-cdn-service-broker/models"
+	"github.com/cloud-gov/cf-cdn-service-broker/utils"
+)
+
+// cdn-rotate-keys re-encrypts stored account and certificate keys with the
+// current key encryption key, i.e. KMS_KEY_ID or KEY_ENCRYPTION_KEY_VERSION.
+func main() {
+	dryRun := flag.Bool("dry-run", false, "log the keys that would be re-encrypted without re-encrypting them")
+	flag.Parse()
+
+	logger := lager.NewLogger("cdn-rotate-keys")
+	logger.RegisterSink(lager.NewWriterSink(os.Stderr, lager.INFO))
+
+	settings, err := config.NewSettings()
+	if err != nil {
+		logger.Fatal("new-settings", err)
+	}
+
+	session := session.New(aws.NewConfig().WithRegion(settings.AwsDefaultRegion))
+
+	db, err := config.Connect(settings)
+	if err != nil {
+		logger.Fatal("connect", err)
+	}
+
+	if err := db.AutoMigrate(&models.Route{}, &models.Certificate{}, &models.UserData{}, &models.RenewalAttempt{}, &models.RenewalWindow{}, &models.ExpiryNotification{}, &models.DomainAuthorization{}, &models.HealthFinding{}).Error; err != nil {
+		logger.Fatal("migrate", err)
+	}
+
+	keys, err := utils.NewEncryptorFromSettings(settings, session)
+	if err != nil {
+		logger.Fatal("new-encryptor", err)
+	}
+
+	if err := models.RotateKeys(logger, db, keys, *dryRun); err != nil {
+		logger.Fatal("rotate-keys", err)
+	}
+}
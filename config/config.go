@@ -1,6 +1,8 @@
 package config
 
 import (
+	"time"
+
 	"github.com/kelseyhightower/envconfig"
 
 	"github.com/jinzhu/gorm"
@@ -9,25 +11,150 @@ import (
 )
 
 type Settings struct {
-	Port                 string   `envconfig:"port" default:"3000"`
-	BrokerUsername       string   `envconfig:"broker_username" required:"true"`
-	BrokerPassword       string   `envconfig:"broker_password" required:"true"`
-	DatabaseUrl          string   `envconfig:"database_url" required:"true"`
-	Email                string   `envconfig:"email" required:"true"`
-	AcmeUrl              string   `envconfig:"acme_url" required:"true"`
-	Bucket               string   `envconfig:"bucket" required:"true"`
-	IamPathPrefix        string   `envconfig:"iam_path_prefix" default:"letsencrypt"`
-	CloudFrontPrefix     string   `envconfig:"cloudfront_prefix" default:""`
-	AwsAccessKeyId       string   `envconfig:"aws_access_key_id" required:"true"`
-	AwsSecretAccessKey   string   `envconfig:"aws_secret_access_key" required:"true"`
-	AwsDefaultRegion     string   `envconfig:"aws_default_region" required:"true"`
-	ServerSideEncryption string   `envconfig:"server_side_encryption"`
-	APIAddress           string   `envconfig:"api_address" required:"true"`
-	ClientID             string   `envconfig:"client_id" required:"true"`
-	ClientSecret         string   `envconfig:"client_secret" required:"true"`
-	DefaultOrigin        string   `envconfig:"default_origin" required:"true"`
-	Schedule             string   `envconfig:"schedule" default:"0 0 * * * *"`
-	UserIdPool           []string `envconfig:"user_id_pool" required:"true"`
+	Port                 string `envconfig:"port" default:"3000"`
+	BrokerUsername       string `envconfig:"broker_username" required:"true"`
+	BrokerPassword       string `envconfig:"broker_password" required:"true"`
+	DatabaseUrl          string `envconfig:"database_url" required:"true"`
+	Email                string `envconfig:"email" required:"true"`
+	AcmeUrl              string `envconfig:"acme_url" required:"true"`
+	Bucket               string `envconfig:"bucket" required:"true"`
+	IamPathPrefix        string `envconfig:"iam_path_prefix" default:"letsencrypt"`
+	CertificateStore     string `envconfig:"certificate_store" default:"iam"`
+	CertificateProvider  string `envconfig:"certificate_provider" default:"letsencrypt"`
+	CloudFrontPrefix     string `envconfig:"cloudfront_prefix" default:""`
+	AwsAccessKeyId       string `envconfig:"aws_access_key_id" required:"true"`
+	AwsSecretAccessKey   string `envconfig:"aws_secret_access_key" required:"true"`
+	AwsDefaultRegion     string `envconfig:"aws_default_region" required:"true"`
+	ServerSideEncryption string `envconfig:"server_side_encryption"`
+	APIAddress           string `envconfig:"api_address" required:"true"`
+	ClientID             string `envconfig:"client_id" required:"true"`
+	ClientSecret         string `envconfig:"client_secret" required:"true"`
+	DefaultOrigin        string `envconfig:"default_origin" required:"true"`
+	Schedule             string `envconfig:"schedule" default:"0 0 * * * *"`
+
+	// CertificateKeyType is the key type of certificates for routes that
+	// don't choose one, named as ACM names key algorithms, e.g. RSA_2048 or
+	// EC_prime256v1.
+	CertificateKeyType string `envconfig:"certificate_key_type" default:"RSA_2048"`
+
+	// ACME account keys and certificate private keys are stored in the
+	// database encrypted with a data key each, which is in turn encrypted with
+	// the KMS key KmsKeyId, or if that is unset, the local key
+	// KeyEncryptionKeys[KeyEncryptionKeyVersion]. Local keys are given as
+	// version:base64 pairs, and old versions are kept to decrypt secrets
+	// until they have been re-encrypted. Without either, account keys are
+	// stored in plain text and certificate keys aren't stored.
+	KeyEncryptionKeys       map[string]string `envconfig:"key_encryption_keys"`
+	KeyEncryptionKeyVersion string            `envconfig:"key_encryption_key_version"`
+	KmsKeyId                string            `envconfig:"kms_key_id"`
+
+	// External Account Binding credentials, which CAs such as ZeroSSL, Google
+	// Trust Services or smallstep require to link the ACME accounts the broker
+	// registers to an account with the CA. AcmeEabHmacKey is base64-encoded,
+	// as the CA gives it.
+	AcmeEabKid     string `envconfig:"acme_eab_kid"`
+	AcmeEabHmacKey string `envconfig:"acme_eab_hmac_key"`
+
+	// Limits applied by the ACME account manager. Let's Encrypt allows 300
+	// pending authorizations per account and 5 failed validations per
+	// account, hostname and hour; the defaults leave some headroom.
+	AcmeMaxAccounts              int `envconfig:"acme_max_accounts" default:"10"`
+	AcmeMaxPendingAuthorizations int `envconfig:"acme_max_pending_authorizations" default:"250"`
+	AcmeMaxFailedValidations     int `envconfig:"acme_max_failed_validations" default:"4"`
+
+	// Certificates are renewed RenewBefore ahead of expiry, or if RenewBefore
+	// is zero, once RenewBeforeFraction of their lifetime remains. Each route's
+	// renewal is brought forward by up to RenewJitter. If AcmeRenewalInfo is
+	// set and the CA supports ACME Renewal Information, its suggested renewal
+	// windows are used instead.
+	RenewBefore         time.Duration `envconfig:"renew_before" default:"0"`
+	RenewBeforeFraction float64       `envconfig:"renew_before_fraction" default:"0.33"`
+	RenewJitter         time.Duration `envconfig:"renew_jitter" default:"72h"`
+	AcmeRenewalInfo     bool          `envconfig:"acme_renewal_info" default:"true"`
+
+	// Failed renewals are retried after RenewBackoffBase, doubling with each
+	// consecutive failure up to RenewBackoffMax, and at most
+	// RenewMaxAttemptsPerHour renewals, retries included, are attempted each
+	// hour. Routes whose certificates fail to renew within RenewAtRiskBefore
+	// of expiry are marked renewal-at-risk.
+	RenewBackoffBase        time.Duration `envconfig:"renew_backoff_base" default:"1h"`
+	RenewBackoffMax         time.Duration `envconfig:"renew_backoff_max" default:"24h"`
+	RenewMaxAttemptsPerHour int           `envconfig:"renew_max_attempts_per_hour" default:"20"`
+	RenewAtRiskBefore       time.Duration `envconfig:"renew_at_risk_before" default:"336h"`
+
+	// If RevokeCertificates is set, certificates are revoked with the CA when
+	// their routes are deprovisioned or their domains change.
+	RevokeCertificates bool `envconfig:"revoke_certificates" default:"true"`
+
+	// Operators are alerted once for each of ExpiryAlertThresholds, in days,
+	// that a certificate comes within of expiry without being renewed.
+	// Alerts are emailed through the SMTP server and posted to the generic
+	// and Slack-compatible webhooks that are configured.
+	ExpiryAlertThresholds []int    `envconfig:"expiry_alert_thresholds" default:"21,14,7,3"`
+	ExpiryAlertEmails     []string `envconfig:"expiry_alert_emails"`
+	ExpiryAlertWebhookUrl string   `envconfig:"expiry_alert_webhook_url"`
+	ExpiryAlertSlackUrl   string   `envconfig:"expiry_alert_slack_url"`
+	SmtpHost              string   `envconfig:"smtp_host"`
+	SmtpPort              int      `envconfig:"smtp_port" default:"587"`
+	SmtpUser              string   `envconfig:"smtp_user"`
+	SmtpPassword          string   `envconfig:"smtp_password"`
+	SmtpFrom              string   `envconfig:"smtp_from"`
+
+	// Recursive nameservers that the broker resolves customers' domains with,
+	// e.g. to check their CAA records. If unset, those in /etc/resolv.conf
+	// are used.
+	DNSResolvers []string `envconfig:"dns_resolvers"`
+
+	// If CaaCheck is set, domains whose CAA records don't permit the CA to
+	// issue certificates are refused, and provisioning stops before their
+	// challenges are attempted. CaaIdentities are the CAA identities of the
+	// ACME CA.
+	CaaCheck      bool     `envconfig:"caa_check" default:"true"`
+	CaaIdentities []string `envconfig:"caa_identities" default:"letsencrypt.org"`
+
+	// If TlsMonitor is set, cdn-cron connects to every provisioned domain to
+	// check that it serves the route's certificate, with a chain that
+	// verifies and a good OCSP status, waiting up to TlsMonitorTimeout for
+	// each handshake and OCSP response.
+	TlsMonitor        bool          `envconfig:"tls_monitor" default:"true"`
+	TlsMonitorTimeout time.Duration `envconfig:"tls_monitor_timeout" default:"10s"`
+
+	// If CloudFrontPolicies is set, distributions' cache behaviors use cache
+	// policies and origin request policies rather than the legacy forwarded
+	// values. The broker shares the policies it creates between
+	// distributions with the same settings, naming them with
+	// CloudFrontPolicyPrefix. Off by default, as it changes existing
+	// distributions when their instances are next updated.
+	CloudFrontPolicies     bool   `envconfig:"cloudfront_policies" default:"false"`
+	CloudFrontPolicyPrefix string `envconfig:"cloudfront_policy_prefix" default:"cdn-broker"`
+
+	// ResponseHeaders are the headers, as JSON in the format of the
+	// response_headers parameter, that distributions add to their responses
+	// unless instances choose their own. If empty, distributions add none.
+	ResponseHeaders string `envconfig:"response_headers" default:"{\"strict_transport_security\":{\"max_age\":31536000},\"content_type_options\":true,\"referrer_policy\":\"strict-origin-when-cross-origin\"}"`
+
+	// MaintenancePage is an HTML file, e.g. ./maintenance.html, that the
+	// broker uploads to the bucket at startup, and that new distributions
+	// serve when their origins are down. The broker needs s3:PutObject on the
+	// page's key. If empty, distributions show CloudFront's own errors unless
+	// instances configure error responses.
+	MaintenancePage string `envconfig:"maintenance_page"`
+
+	// Route 53 hosted zones that the broker writes DNS-01 challenge records
+	// to, and optionally alias records pointing domains at their distributions.
+	Route53ZoneIds       []string `envconfig:"route53_zone_ids"`
+	Route53ZoneDiscovery bool     `envconfig:"route53_zone_discovery" default:"false"`
+	Route53CreateAlias   bool     `envconfig:"route53_create_alias" default:"false"`
+
+	// CredHub, from which instances may load their own certificates by
+	// reference. The client needs read access to the credentials.
+	CredhubURL          string `envconfig:"credhub_url"`
+	CredhubClientID     string `envconfig:"credhub_client_id"`
+	CredhubClientSecret string `envconfig:"credhub_client_secret"`
+
+	// Additional DNS-01 providers that instances can choose with the
+	// dns_provider parameter, as a JSON object keyed by provider name.
+	DNSProviders string `envconfig:"dns_providers"`
 }
 
 func NewSettings() (Settings, error) {
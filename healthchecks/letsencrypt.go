@@ -2,14 +2,15 @@ package healthchecks
 
 import (
 	"crypto"
-	"github.com/xenolf/lego/acme"
+	"github.com/go-acme/lego/v4/lego"
+	"github.com/go-acme/lego/v4/registration"
 
 	"github.com/cloud-gov/cf-cdn-service-broker/config"
 )
 
 type User struct {
 	Email        string
-	Registration *acme.RegistrationResource
+	Registration *registration.Resource
 	key          crypto.PrivateKey
 }
 
@@ -17,7 +18,7 @@ func (u *User) GetEmail() string {
 	return u.Email
 }
 
-func (u *User) GetRegistration() *acme.RegistrationResource {
+func (u *User) GetRegistration() *registration.Resource {
 	return u.Registration
 }
 
@@ -27,6 +28,8 @@ func (u *User) GetPrivateKey() crypto.PrivateKey {
 
 func LetsEncrypt(settings config.Settings) error {
 	user := &User{key: "cheese"}
-	_, err := acme.NewClient("https://acme-v01.api.letsencrypt.org/directory", user, acme.RSA2048)
+	legoConfig := lego.NewConfig(user)
+	legoConfig.CADirURL = settings.AcmeUrl
+	_, err := lego.NewClient(legoConfig)
 	return err
 }
@@ -0,0 +1,210 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/jinzhu/gorm"
+
+	"github.com/go-acme/lego/v4/acme"
+	"github.com/go-acme/lego/v4/certcrypto"
+
+	"github.com/cloud-gov/cf-cdn-service-broker/config"
+	"github.com/cloud-gov/cf-cdn-service-broker/utils"
+)
+
+// failedValidationWindow matches the window of Let's Encrypt's failed
+// validation rate limit.
+const failedValidationWindow = time.Hour
+
+var ErrNoAccountAvailable = errors.New("all ACME accounts are at their rate limits")
+
+// AccountManager hands out ACME accounts to routes. Accounts are registered on
+// demand, and each new route gets the least-loaded account that is still
+// within the CA's rate limits.
+type AccountManager struct {
+	logger   lager.Logger
+	settings config.Settings
+	db       *gorm.DB
+	keys     *utils.Encryptor
+}
+
+func NewAccountManager(logger lager.Logger, settings config.Settings, db *gorm.DB, keys *utils.Encryptor) *AccountManager {
+	return &AccountManager{
+		logger:   logger,
+		settings: settings,
+		db:       db,
+		keys:     keys,
+	}
+}
+
+// failedValidations returns the number of failed validations in the current window.
+func (u *UserData) failedValidations() int {
+	if time.Since(u.FailedValidationsSince) > failedValidationWindow {
+		return 0
+	}
+	return u.FailedValidations
+}
+
+func (a *AccountManager) available(account UserData, authorizations int) bool {
+	return account.PendingAuthorizations+authorizations <= a.settings.AcmeMaxPendingAuthorizations &&
+		account.failedValidations() < a.settings.AcmeMaxFailedValidations
+}
+
+// Acquire returns the least-loaded account with room for the given number of
+// pending authorizations, registering a new account if every existing one is
+// at its limits.
+func (a *AccountManager) Acquire(authorizations int) (UserData, error) {
+	lsession := a.logger.Session("account-manager-acquire")
+
+	var accounts []UserData
+	if err := a.db.Where(
+		"managed = ? AND deactivated = ?", true, false,
+	).Order(
+		"pending_authorizations asc, failed_validations asc, id asc",
+	).Find(&accounts).Error; err != nil {
+		lsession.Error("db-find-accounts", err)
+		return UserData{}, err
+	}
+
+	for _, account := range accounts {
+		if a.available(account, authorizations) {
+			return account, nil
+		}
+	}
+
+	if len(accounts) >= a.settings.AcmeMaxAccounts {
+		lsession.Error("max-accounts", ErrNoAccountAvailable, lager.Data{
+			"accounts": len(accounts),
+		})
+		return UserData{}, ErrNoAccountAvailable
+	}
+
+	return a.create()
+}
+
+// create registers a new account with the CA and saves it.
+func (a *AccountManager) create() (UserData, error) {
+	lsession := a.logger.Session("account-manager-create")
+
+	key, err := certcrypto.GeneratePrivateKey(certcrypto.EC256)
+	if err != nil {
+		lsession.Error("generate-private-key", err)
+		return UserData{}, err
+	}
+
+	user := utils.User{Email: a.settings.Email}
+	user.SetPrivateKey(key)
+
+	if _, err := utils.NewClient(a.settings, &user, nil, nil); err != nil {
+		lsession.Error("register", err)
+		return UserData{}, err
+	}
+
+	userData, err := saveUser(a.db, UserData{Email: user.GetEmail(), Managed: true}, user, a.keys)
+	if err != nil {
+		lsession.Error("save-user", err)
+		return UserData{}, err
+	}
+
+	lsession.Info("registered", lager.Data{
+		"user-data-id": userData.ID,
+		"uri":          user.GetRegistration().URI,
+	})
+
+	return userData, nil
+}
+
+// RefreshPendingAuthorizations recounts the pending authorizations held by the
+// orders of the account's provisioning routes.
+func (a *AccountManager) RefreshPendingAuthorizations(userDataID uint) error {
+	lsession := a.logger.Session("account-manager-refresh-pending-authorizations", lager.Data{
+		"user-data-id": userDataID,
+	})
+
+	// Routes with certificates issued by ACM have no account.
+	if userDataID == 0 {
+		return nil
+	}
+
+	var routes []Route
+	if err := a.db.Where(
+		"user_data_id = ? AND state = ?", userDataID, string(Provisioning),
+	).Find(&routes).Error; err != nil {
+		lsession.Error("db-find-routes", err)
+		return err
+	}
+
+	pending := 0
+	for _, route := range routes {
+		pending += route.pendingAuthorizations()
+	}
+
+	if err := a.db.Model(&UserData{}).Where("id = ?", userDataID).Update("pending_authorizations", pending).Error; err != nil {
+		lsession.Error("db-update-account", err)
+		return err
+	}
+	return nil
+}
+
+// RecordFailedValidation counts a failed validation against the account.
+func (a *AccountManager) RecordFailedValidation(userDataID uint) error {
+	lsession := a.logger.Session("account-manager-record-failed-validation", lager.Data{
+		"user-data-id": userDataID,
+	})
+
+	var account UserData
+	if err := a.db.First(&account, userDataID).Error; err != nil {
+		lsession.Error("db-find-account", err)
+		return err
+	}
+
+	if account.failedValidations() == 0 {
+		account.FailedValidations = 0
+		account.FailedValidationsSince = time.Now()
+	}
+	account.FailedValidations++
+
+	if err := a.db.Save(&account).Error; err != nil {
+		lsession.Error("db-save-account", err)
+		return err
+	}
+	return nil
+}
+
+// Deactivate takes an account that the CA no longer accepts out of rotation.
+func (a *AccountManager) Deactivate(userDataID uint) error {
+	lsession := a.logger.Session("account-manager-deactivate", lager.Data{
+		"user-data-id": userDataID,
+	})
+
+	if err := a.db.Model(&UserData{}).Where("id = ?", userDataID).Update("deactivated", true).Error; err != nil {
+		lsession.Error("db-update-account", err)
+		return err
+	}
+	lsession.Info("deactivated")
+	return nil
+}
+
+// pendingAuthorizations counts the authorizations on the route's order that
+// the CA still holds open.
+func (r *Route) pendingAuthorizations() int {
+	if len(r.ChallengeJSON) == 0 || isLegacyChallengeJSON(r.ChallengeJSON) {
+		return 0
+	}
+
+	var order utils.Order
+	if err := json.Unmarshal(r.ChallengeJSON, &order); err != nil {
+		return 0
+	}
+
+	pending := 0
+	for _, authz := range order.Authorizations {
+		if authz.Status == acme.StatusPending {
+			pending++
+		}
+	}
+	return pending
+}
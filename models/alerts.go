@@ -0,0 +1,157 @@
+package models
+
+import (
+	"sort"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/jinzhu/gorm"
+
+	"github.com/cloud-gov/cf-cdn-service-broker/config"
+	"github.com/cloud-gov/cf-cdn-service-broker/utils"
+)
+
+// ExpiryNotification records that operators were alerted about a certificate
+// crossing an expiry threshold. Renewing the certificate changes its expiry,
+// so the thresholds fire again for the new certificate.
+type ExpiryNotification struct {
+	gorm.Model
+	CertificateId uint      `gorm:"not null;unique_index:idx_expiry_notification"`
+	Expires       time.Time `gorm:"not null;unique_index:idx_expiry_notification"`
+	Threshold     int       `gorm:"not null;unique_index:idx_expiry_notification"`
+}
+
+// ExpiryAlerter alerts operators about certificates that are close to expiry
+// and haven't been renewed.
+type ExpiryAlerter struct {
+	logger    lager.Logger
+	settings  config.Settings
+	db        *gorm.DB
+	notifiers []utils.NotifierIface
+}
+
+func NewExpiryAlerter(logger lager.Logger, settings config.Settings, db *gorm.DB, notifiers []utils.NotifierIface) *ExpiryAlerter {
+	return &ExpiryAlerter{
+		logger:    logger,
+		settings:  settings,
+		db:        db,
+		notifiers: notifiers,
+	}
+}
+
+// CrossedThreshold returns the smallest threshold, in days, that a
+// certificate expiring at expires is within, or 0 if it isn't within any.
+func CrossedThreshold(thresholds []int, expires, now time.Time) int {
+	crossed := 0
+	for _, threshold := range thresholds {
+		if threshold <= 0 || expires.Sub(now) > time.Duration(threshold)*24*time.Hour {
+			continue
+		}
+		if crossed == 0 || threshold < crossed {
+			crossed = threshold
+		}
+	}
+	return crossed
+}
+
+// AlertAll sends an alert for every certificate that has crossed a threshold
+// it hasn't been alerted for. When a certificate has crossed several, e.g.
+// after the alerter was down, only the most urgent is sent. Alerts that no
+// notifier could deliver are retried on the next run.
+func (a *ExpiryAlerter) AlertAll() {
+	lsession := a.logger.Session("expiry-alerter-alert-all")
+
+	if len(a.notifiers) == 0 || len(a.settings.ExpiryAlertThresholds) == 0 {
+		lsession.Info("no-notifiers")
+		return
+	}
+
+	thresholds := append([]int{}, a.settings.ExpiryAlertThresholds...)
+	sort.Sort(sort.Reverse(sort.IntSlice(thresholds)))
+
+	routes := []Route{}
+	if err := a.db.Preload("Certificate").Where(
+		"state IN (?) AND certificate_provider <> ?", []string{Provisioned, RenewalAtRisk}, CertificateProviderAcm,
+	).Find(&routes).Error; err != nil {
+		lsession.Error("db-find-routes", err)
+		return
+	}
+
+	now := time.Now()
+	for _, route := range routes {
+		cert := route.Certificate
+		if cert.ID == 0 {
+			continue
+		}
+
+		threshold := CrossedThreshold(thresholds, cert.Expires, now)
+		if threshold == 0 {
+			continue
+		}
+
+		var sent int
+		if err := a.db.Model(&ExpiryNotification{}).Where(
+			"certificate_id = ? AND expires = ? AND threshold <= ?", cert.ID, cert.Expires, threshold,
+		).Count(&sent).Error; err != nil {
+			lsession.Error("db-count-expiry-notifications", err)
+			continue
+		}
+		if sent > 0 {
+			continue
+		}
+
+		alert := utils.ExpiryAlert{
+			InstanceId: route.InstanceId,
+			Domains:    route.GetDomains(),
+			Expires:    cert.Expires,
+			Threshold:  threshold,
+			State:      string(route.State),
+		}
+
+		var attempt RenewalAttempt
+		if err := a.db.Where(RenewalAttempt{RouteId: route.ID}).First(&attempt).Error; err == nil {
+			alert.LastError = attempt.LastError
+		}
+
+		if !a.notify(lsession, alert) {
+			continue
+		}
+
+		// Mark the less urgent thresholds as sent too, so that they don't fire
+		// if the certificate's expiry is ever compared against them again.
+		for _, t := range thresholds {
+			if t < threshold {
+				continue
+			}
+			notification := ExpiryNotification{CertificateId: cert.ID, Expires: cert.Expires, Threshold: t}
+			if err := a.db.Where(notification).FirstOrCreate(&notification).Error; err != nil {
+				lsession.Error("db-create-expiry-notification", err, lager.Data{
+					"instance-id": route.InstanceId,
+				})
+			}
+		}
+	}
+}
+
+// notify sends the alert through every notifier, and reports whether any of
+// them delivered it.
+func (a *ExpiryAlerter) notify(lsession lager.Logger, alert utils.ExpiryAlert) bool {
+	delivered := false
+	for _, notifier := range a.notifiers {
+		if err := notifier.Notify(alert); err != nil {
+			lsession.Error("notify", err, lager.Data{
+				"instance-id": alert.InstanceId,
+				"notifier":    notifier.Name(),
+			})
+			continue
+		}
+		delivered = true
+	}
+
+	lsession.Info("expiry-alert", lager.Data{
+		"instance-id": alert.InstanceId,
+		"threshold":   alert.Threshold,
+		"delivered":   delivered,
+	})
+	return delivered
+}
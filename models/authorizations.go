@@ -0,0 +1,109 @@
+package models
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+
+	"github.com/cloud-gov/cf-cdn-service-broker/utils"
+)
+
+// DomainAuthorization tracks the authorization of one of a route's domains on
+// the route's current order, so that each domain is attempted on its own.
+// ChallengeType is the challenge the CA last validated, e.g. http-01, and
+// Attempts counts the attempts at the authorization at URL.
+type DomainAuthorization struct {
+	gorm.Model
+	RouteId       uint   `gorm:"not null;index"`
+	Domain        string `gorm:"not null"`
+	URL           string
+	Status        string
+	ChallengeType string
+	Attempts      int
+	LastError     string
+	LastAttemptAt *time.Time
+}
+
+// Attempted records an attempt at the authorization.
+func (a *DomainAuthorization) Attempted(authz utils.Authorization, err error, now time.Time) {
+	a.Attempts++
+	a.LastAttemptAt = &now
+	a.Status = string(authz.Status)
+	a.ChallengeType = authz.ChallengeType()
+	a.LastError = ""
+	if err != nil {
+		a.LastError = err.Error()
+	}
+}
+
+// loadAuthorizations returns the route's domain authorizations, keyed by
+// domain, updated to match the authorizations on order. Authorizations for
+// domains that are no longer on the order are deleted.
+func (m *RouteManager) loadAuthorizations(r *Route, order utils.Order) (map[string]*DomainAuthorization, error) {
+	rows := []DomainAuthorization{}
+	if err := m.db.Where("route_id = ?", r.ID).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	stale := map[string]*DomainAuthorization{}
+	for i := range rows {
+		stale[rows[i].Domain] = &rows[i]
+	}
+
+	auths := map[string]*DomainAuthorization{}
+	for _, authz := range order.Authorizations {
+		domain := authz.Domain()
+
+		auth, ok := stale[domain]
+		if !ok {
+			auth = &DomainAuthorization{RouteId: r.ID, Domain: domain}
+		}
+		delete(stale, domain)
+
+		// A new order brings new authorizations, which start afresh.
+		if auth.URL != authz.URL {
+			auth.URL = authz.URL
+			auth.Attempts = 0
+		}
+		auth.Status = string(authz.Status)
+		auths[domain] = auth
+	}
+
+	for _, auth := range stale {
+		if err := m.db.Delete(auth).Error; err != nil {
+			return nil, err
+		}
+	}
+	return auths, nil
+}
+
+// saveAuthorizations records the outcome of attempting the authorizations on
+// order. failures holds the errors of the attempted domains, and of domains
+// that weren't attempted because they can't be issued for yet.
+func (m *RouteManager) saveAuthorizations(auths map[string]*DomainAuthorization, order utils.Order, attempted []string, failures map[string]error) error {
+	now := time.Now()
+
+	tried := map[string]bool{}
+	for _, domain := range attempted {
+		tried[domain] = true
+	}
+
+	for _, authz := range order.Authorizations {
+		domain := authz.Domain()
+		auth, ok := auths[domain]
+		if !ok {
+			continue
+		}
+
+		if tried[domain] {
+			auth.Attempted(authz, failures[domain], now)
+		} else if err, ok := failures[domain]; ok {
+			auth.LastError = err.Error()
+		}
+
+		if err := m.db.Save(auth).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package models
+
+import (
+	"code.cloudfoundry.org/lager"
+
+	"github.com/cloud-gov/cf-cdn-service-broker/utils"
+)
+
+// CheckCAA checks that the CAA records of each domain permit the certificate
+// provider's CA to issue certificates for it. It returns utils.CAAErrors
+// listing every domain that doesn't. Domains whose CAA records can't be
+// looked up are let through, since the CA will check them again.
+func (m *RouteManager) CheckCAA(domains []string, certificateProvider string) error {
+	lsession := m.logger.Session("route-manager-check-caa", lager.Data{
+		"domains":              domains,
+		"certificate-provider": certificateProvider,
+	})
+
+	identities := m.settings.CaaIdentities
+	switch certificateProvider {
+	case CertificateProviderAcm:
+		identities = utils.AcmCAAIdentities
+	case CertificateProviderCustom:
+		// Customers' own CAs check their CAA records themselves.
+		return nil
+	}
+	if len(identities) == 0 {
+		return nil
+	}
+
+	caaErrs := utils.CAAErrors{}
+	for _, domain := range domains {
+		err := m.caa.Check(domain, identities)
+		switch err := err.(type) {
+		case nil:
+		case *utils.CAAError:
+			lsession.Info("caa-forbids-issuance", lager.Data{
+				"domain":        domain,
+				"record-domain": err.RecordDomain,
+				"records":       err.Records,
+			})
+			caaErrs = append(caaErrs, err)
+		default:
+			lsession.Error("caa-lookup", err, lager.Data{"domain": domain})
+		}
+	}
+
+	if len(caaErrs) > 0 {
+		return caaErrs
+	}
+	return nil
+}
+
+// preflightCAA checks the CAA records of a provisioning route's domains, if
+// the broker is configured to.
+func (m *RouteManager) preflightCAA(r *Route, domains []string) error {
+	if !m.settings.CaaCheck || len(domains) == 0 {
+		return nil
+	}
+
+	if err := m.CheckCAA(domains, r.CertificateProvider); err != nil {
+		m.logger.Session("route-manager-preflight-caa", lager.Data{
+			"instance-id": r.InstanceId,
+		}).Error("check-caa", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/go-acme/lego/v4/certificate"
+
+	"github.com/cloud-gov/cf-cdn-service-broker/utils"
+)
+
+// ResolveCertificate loads a customer's own certificate from CredHub, if it
+// is given by reference, and checks that it can serve domains.
+func (m *RouteManager) ResolveCertificate(cert utils.CustomCertificate, domains []string) (certificate.Resource, error) {
+	lsession := m.logger.Session("route-manager-resolve-certificate", lager.Data{
+		"domains":     domains,
+		"credhub-ref": cert.CredhubRef,
+	})
+
+	if cert.CredhubRef != "" {
+		var err error
+		cert, err = m.credhub.GetCertificate(cert.CredhubRef)
+		if err != nil {
+			lsession.Error("credhub-get-certificate", err)
+			return certificate.Resource{}, err
+		}
+	}
+
+	resource, err := utils.ParseCustomCertificate(cert, domains, time.Now())
+	if err != nil {
+		lsession.Error("parse-custom-certificate", err)
+		return certificate.Resource{}, err
+	}
+	return resource, nil
+}
+
+// ImportCertificate uploads a customer's own certificate, as returned by
+// ResolveCertificate, and deploys it to the route's distribution along with
+// the route's domains.
+func (m *RouteManager) ImportCertificate(r *Route, cert certificate.Resource) error {
+	lsession := m.logger.Session("route-manager-import-certificate", lager.Data{
+		"instance-id": r.InstanceId,
+	})
+
+	if r.CertificateProvider != CertificateProviderCustom {
+		err := errors.New("only instances created with their own certificate can import one")
+		lsession.Error("certificate-provider", err)
+		return err
+	}
+
+	certRow := Certificate{}
+	if err := certRow.setResource(cert, m.keys); err != nil {
+		lsession.Error("set-certificate-resource", err)
+		return err
+	}
+
+	if err := m.deployCertificate(r, cert); err != nil {
+		lsession.Error("deploy-certificate", err)
+		return err
+	}
+
+	if err := m.db.Create(&certRow).Error; err != nil {
+		lsession.Error("db-create-cert", err)
+		return err
+	}
+
+	r.Certificate = certRow
+	if r.State == RenewalAtRisk {
+		r.State = Provisioned
+	}
+	if err := m.db.Save(r).Error; err != nil {
+		lsession.Error("db-save-cert", err)
+		return err
+	}
+	return nil
+}
+
+// updateCustomProvisioning finishes provisioning a route with its own
+// certificate once the distribution has deployed.
+func (m *RouteManager) updateCustomProvisioning(r *Route) error {
+	lsession := m.logger.Session("route-manager-update-custom-provisioning", lager.Data{
+		"instance-id": r.InstanceId,
+	})
+
+	var certRow Certificate
+	if err := m.db.Model(r).Related(&certRow, "Certificate").Error; err != nil {
+		lsession.Error("db-find-related-cert", err)
+		return err
+	}
+
+	if !m.checkDistribution(r) {
+		lsession.Info("distribution-provisioning")
+		return nil
+	}
+
+	r.State = Provisioned
+	if err := m.db.Save(r).Error; err != nil {
+		lsession.Error("db-save-route", err)
+		return err
+	}
+	return nil
+}
+
+// warnCustomCertificates marks routes whose own certificates expire within
+// RenewAtRiskBefore as renewal-at-risk, so that customers are asked for a
+// replacement.
+func (m *RouteManager) warnCustomCertificates() {
+	lsession := m.logger.Session("route-manager-warn-custom-certificates")
+
+	routes := []Route{}
+	if err := m.db.Preload("Certificate").Where(
+		"state = ? AND certificate_provider = ?", Provisioned, CertificateProviderCustom,
+	).Find(&routes).Error; err != nil {
+		lsession.Error("db-find-routes", err)
+		return
+	}
+
+	now := time.Now()
+	for _, route := range routes {
+		if route.Certificate.ID == 0 || route.Certificate.Expires.Sub(now) > m.settings.RenewAtRiskBefore {
+			continue
+		}
+
+		lsession.Info("custom-certificate-expiring", lager.Data{
+			"instance-id": route.InstanceId,
+			"domain":      route.DomainExternal,
+			"expires":     route.Certificate.Expires,
+		})
+		if err := m.db.Model(&route).UpdateColumn("state", RenewalAtRisk).Error; err != nil {
+			lsession.Error("db-update-state", err, lager.Data{
+				"instance-id": route.InstanceId,
+			})
+		}
+	}
+}
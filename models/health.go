@@ -0,0 +1,176 @@
+package models
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/jinzhu/gorm"
+
+	"github.com/cloud-gov/cf-cdn-service-broker/utils"
+)
+
+// Problems that a route's health findings record.
+const (
+	FindingHandshakeFailed     = "handshake-failed"
+	FindingCertificateMismatch = "certificate-mismatch"
+	FindingChainInvalid        = "chain-invalid"
+	FindingOCSPStatus          = "ocsp-status"
+)
+
+// HealthFinding records a problem with what one of a route's domains serves.
+// A finding stays open, with LastSeenAt updated, for as long as the problem
+// is found, and is resolved once it isn't.
+type HealthFinding struct {
+	gorm.Model
+	RouteId     uint   `gorm:"not null;index"`
+	Domain      string `gorm:"not null"`
+	Kind        string `gorm:"not null"`
+	Detail      string
+	FirstSeenAt time.Time
+	LastSeenAt  time.Time
+	ResolvedAt  *time.Time `gorm:"index"`
+}
+
+// TLSMonitor checks that each provisioned domain serves the route's current
+// certificate, with a chain that browsers trust and that hasn't been revoked.
+type TLSMonitor struct {
+	logger lager.Logger
+	db     *gorm.DB
+	prober utils.TLSProberIface
+}
+
+func NewTLSMonitor(logger lager.Logger, db *gorm.DB, prober utils.TLSProberIface) *TLSMonitor {
+	return &TLSMonitor{
+		logger: logger,
+		db:     db,
+		prober: prober,
+	}
+}
+
+// Findings returns the problems that a probe of one of route's domains found,
+// with their details. The served certificate is compared with the route's, if
+// the broker keeps one; ACM renews its certificates without the broker's copy
+// changing, so those aren't compared.
+func Findings(probe utils.TLSProbe, probeErr error, route Route) map[string]string {
+	findings := map[string]string{}
+	if probeErr != nil {
+		findings[FindingHandshakeFailed] = probeErr.Error()
+		return findings
+	}
+
+	stored := route.Certificate
+	if stored.ID != 0 && route.CertificateProvider != CertificateProviderAcm && !sameSerialNumber(probe.SerialNumber(), stored.SerialNumber) {
+		findings[FindingCertificateMismatch] = fmt.Sprintf(
+			"served certificate %s, expected %s", probe.SerialNumber(), stored.SerialNumber,
+		)
+	}
+	if probe.ChainError != nil {
+		findings[FindingChainInvalid] = probe.ChainError.Error()
+	}
+	switch probe.OCSPStatus {
+	case utils.OCSPRevoked, utils.OCSPUnknown:
+		findings[FindingOCSPStatus] = "OCSP status " + probe.OCSPStatus
+	}
+	return findings
+}
+
+// sameSerialNumber compares hex serial numbers by value, as stores differ on
+// whether they keep leading zeros.
+func sameSerialNumber(a, b string) bool {
+	x, okX := new(big.Int).SetString(a, 16)
+	y, okY := new(big.Int).SetString(b, 16)
+	if !okX || !okY {
+		return strings.EqualFold(a, b)
+	}
+	return x.Cmp(y) == 0
+}
+
+// CheckAll probes every domain of each provisioned route and records what it
+// finds. Wildcard domains are skipped, as there's no single name to connect
+// to.
+func (t *TLSMonitor) CheckAll() {
+	lsession := t.logger.Session("tls-monitor-check-all")
+
+	routes := []Route{}
+	if err := t.db.Preload("Certificate").Where(
+		"state IN (?)", []string{Provisioned, RenewalAtRisk},
+	).Find(&routes).Error; err != nil {
+		lsession.Error("db-find-routes", err)
+		return
+	}
+
+	for _, route := range routes {
+		for _, domain := range route.GetDomains() {
+			if strings.HasPrefix(domain, "*.") {
+				continue
+			}
+
+			probe, err := t.prober.Probe(domain)
+			if err == nil && probe.OCSPError != nil {
+				// Responders are often briefly unavailable; that alone isn't
+				// a problem with the route.
+				lsession.Info("ocsp-failed", lager.Data{
+					"instance-id": route.InstanceId,
+					"domain":      domain,
+					"error":       probe.OCSPError.Error(),
+				})
+			}
+
+			t.record(lsession, route, domain, Findings(probe, err, route))
+		}
+	}
+}
+
+// record opens or refreshes a finding for each problem found with domain, and
+// resolves its open findings that weren't found again.
+func (t *TLSMonitor) record(lsession lager.Logger, route Route, domain string, findings map[string]string) {
+	now := time.Now()
+
+	open := []HealthFinding{}
+	if err := t.db.Where(
+		"route_id = ? AND domain = ? AND resolved_at IS NULL", route.ID, domain,
+	).Find(&open).Error; err != nil {
+		lsession.Error("db-find-findings", err)
+		return
+	}
+
+	for _, finding := range open {
+		detail, failing := findings[finding.Kind]
+		if failing {
+			finding.Detail = detail
+			finding.LastSeenAt = now
+			delete(findings, finding.Kind)
+		} else {
+			finding.ResolvedAt = &now
+			lsession.Info("health-finding-resolved", lager.Data{
+				"instance-id": route.InstanceId,
+				"domain":      domain,
+				"kind":        finding.Kind,
+			})
+		}
+		if err := t.db.Save(&finding).Error; err != nil {
+			lsession.Error("db-save-finding", err)
+		}
+	}
+
+	for kind, detail := range findings {
+		finding := HealthFinding{
+			RouteId:     route.ID,
+			Domain:      domain,
+			Kind:        kind,
+			Detail:      detail,
+			FirstSeenAt: now,
+			LastSeenAt:  now,
+		}
+		lsession.Error("health-finding", fmt.Errorf("%s: %s", kind, detail), lager.Data{
+			"instance-id": route.InstanceId,
+			"domain":      domain,
+		})
+		if err := t.db.Create(&finding).Error; err != nil {
+			lsession.Error("db-create-finding", err)
+		}
+	}
+}
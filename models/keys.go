@@ -0,0 +1,109 @@
+package models
+
+import (
+	"errors"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/jinzhu/gorm"
+
+	"github.com/cloud-gov/cf-cdn-service-broker/utils"
+)
+
+// RotateKeys re-encrypts every stored account and certificate key that isn't
+// encrypted with the current key version, including account keys stored in
+// plain text, so that retired key encryption keys can be removed. Deleted
+// rows are re-encrypted too.
+func RotateKeys(logger lager.Logger, db *gorm.DB, keys *utils.Encryptor, dryRun bool) error {
+	lsession := logger.Session("rotate-keys", lager.Data{
+		"key-version": keys.Version(),
+		"dry-run":     dryRun,
+	})
+
+	if keys.Version() == "" {
+		err := errors.New("no key encryption key is configured")
+		lsession.Error("no-key-version", err)
+		return err
+	}
+
+	users := []UserData{}
+	if err := db.Unscoped().Where("key_version IS NULL OR key_version <> ?", keys.Version()).Find(&users).Error; err != nil {
+		lsession.Error("db-find-user-data", err)
+		return err
+	}
+
+	rotated, failed := 0, 0
+	for _, userData := range users {
+		data := lager.Data{"user-data-id": userData.ID, "from-version": userData.KeyVersion}
+		if dryRun {
+			lsession.Info("rotate-user-data", data)
+			rotated++
+			continue
+		}
+
+		key, version, err := rotateKey(keys, userData.Key, userData.KeyVersion)
+		if err != nil {
+			lsession.Error("rotate-user-data", err, data)
+			failed++
+			continue
+		}
+		if err := db.Unscoped().Model(&userData).Updates(map[string]interface{}{"key": key, "key_version": version}).Error; err != nil {
+			lsession.Error("db-save-user-data", err, data)
+			failed++
+			continue
+		}
+		lsession.Info("rotated-user-data", data)
+		rotated++
+	}
+
+	certs := []Certificate{}
+	if err := db.Unscoped().Where(
+		"private_key IS NOT NULL AND (private_key_version IS NULL OR private_key_version <> ?)", keys.Version(),
+	).Find(&certs).Error; err != nil {
+		lsession.Error("db-find-certificates", err)
+		return err
+	}
+
+	for _, cert := range certs {
+		if len(cert.PrivateKey) == 0 {
+			continue
+		}
+
+		data := lager.Data{"certificate-id": cert.ID, "from-version": cert.PrivateKeyVersion}
+		if dryRun {
+			lsession.Info("rotate-certificate", data)
+			rotated++
+			continue
+		}
+
+		key, version, err := rotateKey(keys, cert.PrivateKey, cert.PrivateKeyVersion)
+		if err != nil {
+			lsession.Error("rotate-certificate", err, data)
+			failed++
+			continue
+		}
+		if err := db.Unscoped().Model(&cert).Updates(map[string]interface{}{"private_key": key, "private_key_version": version}).Error; err != nil {
+			lsession.Error("db-save-certificate", err, data)
+			failed++
+			continue
+		}
+		lsession.Info("rotated-certificate", data)
+		rotated++
+	}
+
+	lsession.Info("keys-rotated", lager.Data{
+		"num-rotated": rotated,
+		"num-failed":  failed,
+	})
+	if failed > 0 {
+		return errors.New("some keys couldn't be re-encrypted")
+	}
+	return nil
+}
+
+func rotateKey(keys *utils.Encryptor, ciphertext []byte, version string) ([]byte, string, error) {
+	plaintext, err := keys.Decrypt(ciphertext, version)
+	if err != nil {
+		return nil, "", err
+	}
+	return keys.Encrypt(plaintext)
+}
@@ -3,19 +3,34 @@ package mocks
 import mock "github.com/stretchr/testify/mock"
 import models "github.com/cloud-gov/cf-cdn-service-broker/models"
 import utils "github.com/cloud-gov/cf-cdn-service-broker/utils"
+import certificate "github.com/go-acme/lego/v4/certificate"
 
 // RouteManagerIface is an autogenerated mock type for the RouteManagerIface type
 type RouteManagerIface struct {
 	mock.Mock
 }
 
-// Create provides a mock function with given fields: instanceId, domain, origin, path, insecureOrigin, forwardedHeaders, forwardCookies, tags
-func (_m *RouteManagerIface) Create(instanceId string, domain string, origin string, path string, insecureOrigin bool, forwardedHeaders utils.Headers, forwardCookies bool, tags map[string]string) (*models.Route, error) {
-	ret := _m.Called(instanceId, domain, origin, path, insecureOrigin, forwardedHeaders, forwardCookies, tags)
+// CheckCAA provides a mock function with given fields: domains, certificateProvider
+func (_m *RouteManagerIface) CheckCAA(domains []string, certificateProvider string) error {
+	ret := _m.Called(domains, certificateProvider)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func([]string, string) error); ok {
+		r0 = rf(domains, certificateProvider)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// Create provides a mock function with given fields: instanceId, domain, origin, path, insecureOrigin, forwardedHeaders, originHeaders, forwardCookies, cookieNames, queryStringKeys, cacheBehaviors, responseHeaders, errorResponses, certificateProvider, dnsProvider, keyType, cert, tags
+func (_m *RouteManagerIface) Create(instanceId string, domain string, origin string, path string, insecureOrigin bool, forwardedHeaders utils.Headers, originHeaders utils.Headers, forwardCookies bool, cookieNames []string, queryStringKeys []string, cacheBehaviors []utils.CacheBehavior, responseHeaders *utils.ResponseHeaders, errorResponses []utils.ErrorResponse, certificateProvider string, dnsProvider string, keyType string, cert *certificate.Resource, tags map[string]string) (*models.Route, error) {
+	ret := _m.Called(instanceId, domain, origin, path, insecureOrigin, forwardedHeaders, originHeaders, forwardCookies, cookieNames, queryStringKeys, cacheBehaviors, responseHeaders, errorResponses, certificateProvider, dnsProvider, keyType, cert, tags)
 
 	var r0 *models.Route
-	if rf, ok := ret.Get(0).(func(string, string, string, string, bool, utils.Headers, bool, map[string]string) *models.Route); ok {
-		r0 = rf(instanceId, domain, origin, path, insecureOrigin, forwardedHeaders, forwardCookies, tags)
+	if rf, ok := ret.Get(0).(func(string, string, string, string, bool, utils.Headers, utils.Headers, bool, []string, []string, []utils.CacheBehavior, *utils.ResponseHeaders, []utils.ErrorResponse, string, string, string, *certificate.Resource, map[string]string) *models.Route); ok {
+		r0 = rf(instanceId, domain, origin, path, insecureOrigin, forwardedHeaders, originHeaders, forwardCookies, cookieNames, queryStringKeys, cacheBehaviors, responseHeaders, errorResponses, certificateProvider, dnsProvider, keyType, cert, tags)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).(*models.Route)
@@ -23,8 +38,8 @@ func (_m *RouteManagerIface) Create(instanceId string, domain string, origin str
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(string, string, string, string, bool, utils.Headers, bool, map[string]string) error); ok {
-		r1 = rf(instanceId, domain, origin, path, insecureOrigin, forwardedHeaders, forwardCookies, tags)
+	if rf, ok := ret.Get(1).(func(string, string, string, string, bool, utils.Headers, utils.Headers, bool, []string, []string, []utils.CacheBehavior, *utils.ResponseHeaders, []utils.ErrorResponse, string, string, string, *certificate.Resource, map[string]string) error); ok {
+		r1 = rf(instanceId, domain, origin, path, insecureOrigin, forwardedHeaders, originHeaders, forwardCookies, cookieNames, queryStringKeys, cacheBehaviors, responseHeaders, errorResponses, certificateProvider, dnsProvider, keyType, cert, tags)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -74,16 +89,16 @@ func (_m *RouteManagerIface) Get(instanceId string) (*models.Route, error) {
 	return r0, r1
 }
 
-// GetDNSInstructions provides a mock function with given fields: route
-func (_m *RouteManagerIface) GetDNSInstructions(route *models.Route) ([]string, error) {
+// GetDomainStatus provides a mock function with given fields: route
+func (_m *RouteManagerIface) GetDomainStatus(route *models.Route) ([]utils.DomainStatus, error) {
 	ret := _m.Called(route)
 
-	var r0 []string
-	if rf, ok := ret.Get(0).(func(*models.Route) []string); ok {
+	var r0 []utils.DomainStatus
+	if rf, ok := ret.Get(0).(func(*models.Route) []utils.DomainStatus); ok {
 		r0 = rf(route)
 	} else {
 		if ret.Get(0) != nil {
-			r0 = ret.Get(0).([]string)
+			r0 = ret.Get(0).([]utils.DomainStatus)
 		}
 	}
 
@@ -97,6 +112,20 @@ func (_m *RouteManagerIface) GetDNSInstructions(route *models.Route) ([]string,
 	return r0, r1
 }
 
+// ImportCertificate provides a mock function with given fields: route, cert
+func (_m *RouteManagerIface) ImportCertificate(route *models.Route, cert certificate.Resource) error {
+	ret := _m.Called(route, cert)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(*models.Route, certificate.Resource) error); ok {
+		r0 = rf(route, cert)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // Poll provides a mock function with given fields: route
 func (_m *RouteManagerIface) Poll(route *models.Route) error {
 	ret := _m.Called(route)
@@ -130,13 +159,48 @@ func (_m *RouteManagerIface) RenewAll() {
 	_m.Called()
 }
 
-// Update provides a mock function with given fields: instanceId, domain, origin, path, insecureOrigin, forwardedHeaders, forwardCookies
-func (_m *RouteManagerIface) Update(instanceId string, domain string, origin string, path string, insecureOrigin bool, forwardedHeaders utils.Headers, forwardCookies bool) error {
-	ret := _m.Called(instanceId, domain, origin, path, insecureOrigin, forwardedHeaders, forwardCookies)
+// ResolveCertificate provides a mock function with given fields: cert, domains
+func (_m *RouteManagerIface) ResolveCertificate(cert utils.CustomCertificate, domains []string) (certificate.Resource, error) {
+	ret := _m.Called(cert, domains)
+
+	var r0 certificate.Resource
+	if rf, ok := ret.Get(0).(func(utils.CustomCertificate, []string) certificate.Resource); ok {
+		r0 = rf(cert, domains)
+	} else {
+		r0 = ret.Get(0).(certificate.Resource)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(utils.CustomCertificate, []string) error); ok {
+		r1 = rf(cert, domains)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Revoke provides a mock function with given fields: route, reason
+func (_m *RouteManagerIface) Revoke(route *models.Route, reason uint) error {
+	ret := _m.Called(route, reason)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(*models.Route, uint) error); ok {
+		r0 = rf(route, reason)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// Update provides a mock function with given fields: instanceId, domain, origin, path, insecureOrigin, forwardedHeaders, originHeaders, forwardCookies, cookieNames, queryStringKeys, cacheBehaviors, responseHeaders, errorResponses, keyType
+func (_m *RouteManagerIface) Update(instanceId string, domain string, origin string, path string, insecureOrigin bool, forwardedHeaders utils.Headers, originHeaders utils.Headers, forwardCookies bool, cookieNames []string, queryStringKeys []string, cacheBehaviors []utils.CacheBehavior, responseHeaders *utils.ResponseHeaders, errorResponses []utils.ErrorResponse, keyType string) error {
+	ret := _m.Called(instanceId, domain, origin, path, insecureOrigin, forwardedHeaders, originHeaders, forwardCookies, cookieNames, queryStringKeys, cacheBehaviors, responseHeaders, errorResponses, keyType)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(string, string, string, string, bool, utils.Headers, bool) error); ok {
-		r0 = rf(instanceId, domain, origin, path, insecureOrigin, forwardedHeaders, forwardCookies)
+	if rf, ok := ret.Get(0).(func(string, string, string, string, bool, utils.Headers, utils.Headers, bool, []string, []string, []utils.CacheBehavior, *utils.ResponseHeaders, []utils.ErrorResponse, string) error); ok {
+		r0 = rf(instanceId, domain, origin, path, insecureOrigin, forwardedHeaders, originHeaders, forwardCookies, cookieNames, queryStringKeys, cacheBehaviors, responseHeaders, errorResponses, keyType)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -1,32 +1,31 @@
 package models
 
 import (
+	"bytes"
 	"crypto"
 	"crypto/ecdsa"
 	"crypto/rsa"
-	"crypto/tls"
 	"crypto/x509"
 	"database/sql/driver"
 	"encoding/json"
 	"encoding/pem"
 	"errors"
 	"fmt"
-	"io/ioutil"
-	"math/rand"
-	"net/http"
-	"path"
 	"strings"
 	"time"
 
 	"code.cloudfoundry.org/lager"
 	"github.com/jinzhu/gorm"
 	"github.com/pivotal-cf/brokerapi"
-	"github.com/xenolf/lego/acme"
+
+	"github.com/go-acme/lego/v4/acme"
+	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/go-acme/lego/v4/certificate"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/acm"
 	"github.com/aws/aws-sdk-go/service/cloudfront"
-	"github.com/aws/aws-sdk-go/service/iam"
 	"github.com/aws/aws-sdk-go/service/s3"
 
 	"github.com/cloud-gov/cf-cdn-service-broker/config"
@@ -41,6 +40,21 @@ const (
 	Deprovisioning       = "deprovisioning"
 	Deprovisioned        = "deprovisioned"
 	Failed               = "failed"
+
+	// RenewalAtRisk routes are provisioned, but failed to renew a certificate
+	// that is close to expiry, or for customers' own certificates, haven't
+	// been given a replacement.
+	RenewalAtRisk = "renewal-at-risk"
+)
+
+// Certificate providers
+const (
+	CertificateProviderLetsEncrypt = "letsencrypt"
+	CertificateProviderAcm         = "acm"
+
+	// Routes with the custom provider use certificates from customers' own
+	// CAs, which the broker doesn't renew.
+	CertificateProviderCustom = "custom"
 )
 
 var (
@@ -67,61 +81,41 @@ func (s *State) Scan(value interface{}) error {
 	return nil
 }
 
+// UserData is an ACME account. Managed accounts are created by the
+// AccountManager and shared between routes; accounts created before it
+// existed each belong to a single route.
 type UserData struct {
 	gorm.Model
-	Email string `gorm:"not null"`
-	Reg   []byte
-	Key   []byte
-}
-
-/*
- * LoadRandomUser The Let's Encrypt v1 acme API has shut down user creation to force users to adopt v2.
- * In an attempt to contiune using v1 while we develop a v2 compliant broker, we are replacing
- * calls to create a new user for each new domain registration with a method that fetches an existing user
- * from a pool of ids. The random selection of users from a pool aims to minimize the impact of the following rate limits:
- *	- 300 Pending Authorizations per account
- *	- Failed Validation limit of 5 failures per account, per hostname, per hour.
- */
-func LoadRandomUser(db *gorm.DB, userIDPool []string) (utils.User, error) {
-	var user utils.User
-	defer func() {
-		if r := recover(); r != nil {
-			return
-		}
-	}()
-	userID := userIDPool[rand.Intn(len(userIDPool))]
-
-	helperLogger.Session("load-random-user").Info("random-user-id", lager.Data{
-		"userID": userID,
-	})
-
-	var userData UserData
-
-	if err := db.Where("id = ?", userID).First(&userData).Error; err != nil {
-		helperLogger.Session("load-random-user").Error("load-user-data", err)
-		return user, err
-	}
-
-	user, err := LoadUser(userData)
-	if err != nil {
-		helperLogger.Session("load-random-user").Error("load-user", err)
-		return user, err
-	}
-
-	return user, nil
+	Email                  string `gorm:"not null"`
+	Reg                    []byte
+	Key                    []byte
+	KeyVersion             string
+	Managed                bool `gorm:"not null;default:false;index"`
+	Deactivated            bool `gorm:"not null;default:false"`
+	PendingAuthorizations  int  `gorm:"not null;default:0"`
+	FailedValidations      int  `gorm:"not null;default:0"`
+	FailedValidationsSince time.Time
 }
 
-func SaveUser(db *gorm.DB, user utils.User) (UserData, error) {
-	var err error
-	userData := UserData{Email: user.GetEmail()}
+func SaveUser(db *gorm.DB, user utils.User, keys *utils.Encryptor) (UserData, error) {
+	return saveUser(db, UserData{Email: user.GetEmail()}, user, keys)
+}
 
+// saveUser stores the user's encrypted key and registration on userData and
+// saves it.
+func saveUser(db *gorm.DB, userData UserData, user utils.User, keys *utils.Encryptor) (UserData, error) {
 	lsession := helperLogger.Session("save-user")
 
-	userData.Key, err = savePrivateKey(user.GetPrivateKey())
+	key, err := savePrivateKey(user.GetPrivateKey())
 	if err != nil {
 		lsession.Error("save-private-key", err)
 		return userData, err
 	}
+	userData.Key, userData.KeyVersion, err = keys.Encrypt(key)
+	if err != nil {
+		lsession.Error("encrypt-private-key", err)
+		return userData, err
+	}
 	userData.Reg, err = json.Marshal(user)
 	if err != nil {
 		lsession.Error("json-marshal-user", err)
@@ -136,7 +130,7 @@ func SaveUser(db *gorm.DB, user utils.User) (UserData, error) {
 	return userData, nil
 }
 
-func LoadUser(userData UserData) (utils.User, error) {
+func LoadUser(userData UserData, keys *utils.Encryptor) (utils.User, error) {
 	var user utils.User
 
 	lsession := helperLogger.Session("load-user")
@@ -145,7 +139,18 @@ func LoadUser(userData UserData) (utils.User, error) {
 		lsession.Error("json-unmarshal-user-data", err)
 		return user, err
 	}
-	key, err := loadPrivateKey(userData.Key)
+	if isLegacyRegistration(userData.Reg) {
+		// ACME v1 registration URIs can't be used as v2 account URLs; drop the
+		// registration so that the account is resolved again by its key.
+		lsession.Info("legacy-registration", lager.Data{"user-data-id": userData.ID})
+		user.Registration = nil
+	}
+	keyBytes, err := keys.Decrypt(userData.Key, userData.KeyVersion)
+	if err != nil {
+		lsession.Error("decrypt-private-key", err, lager.Data{"user-data-id": userData.ID})
+		return user, err
+	}
+	key, err := loadPrivateKey(keyBytes)
 	if err != nil {
 		lsession.Error("load-private-key", err)
 		return user, err
@@ -154,6 +159,27 @@ func LoadUser(userData UserData) (utils.User, error) {
 	return user, nil
 }
 
+// isLegacyRegistration reports whether a stored user was registered through the
+// ACME v1 API, whose registration resources carry a new-authz URL.
+func isLegacyRegistration(reg []byte) bool {
+	var legacy struct {
+		Registration *struct {
+			NewAuthzURL string `json:"new_authzr_uri"`
+		}
+	}
+	if err := json.Unmarshal(reg, &legacy); err != nil {
+		return false
+	}
+	return legacy.Registration != nil && legacy.Registration.NewAuthzURL != ""
+}
+
+// isLegacyChallengeJSON reports whether a route's challenges were stored as a
+// list of ACME v1 authorizations rather than an ACME v2 order.
+func isLegacyChallengeJSON(challengeJSON []byte) bool {
+	trimmed := bytes.TrimSpace(challengeJSON)
+	return len(trimmed) > 0 && trimmed[0] == '['
+}
+
 // loadPrivateKey loads a PEM-encoded ECC/RSA private key from an array of bytes.
 func loadPrivateKey(keyBytes []byte) (crypto.PrivateKey, error) {
 	keyBlock, _ := pem.Decode(keyBytes)
@@ -190,134 +216,414 @@ func savePrivateKey(key crypto.PrivateKey) ([]byte, error) {
 	return pem.EncodeToMemory(&pemKey), nil
 }
 
+// Route is a CDN service instance. CertificateArn is the certificate the
+// distribution uses, or for routes with certificates issued by ACM, the
+// certificate most recently requested for the route's domains. DNSProvider
+// names the provider that writes the route's DNS-01 records; if it is empty,
+// the broker writes records for domains in its Route 53 hosted zones. KeyType
+// is the key type of the route's certificates, e.g. EC_prime256v1; if it is
+// empty, the broker's default is used. ResponseHeadersJSON holds the headers
+// the distribution adds to responses, and ErrorResponsesJSON what it serves
+// in place of origin errors; routes created before them have neither.
 type Route struct {
 	gorm.Model
-	InstanceId     string `gorm:"not null;unique_index"`
-	State          State  `gorm:"not null;index"`
-	ChallengeJSON  []byte
-	DomainExternal string
-	DomainInternal string
-	DistId         string
-	Origin         string
-	Path           string
-	InsecureOrigin bool
-	Certificate    Certificate
-	UserData       UserData
-	UserDataID     int
+	InstanceId          string `gorm:"not null;unique_index"`
+	State               State  `gorm:"not null;index"`
+	ChallengeJSON       []byte
+	DomainExternal      string
+	DomainInternal      string
+	DistId              string
+	Origin              string
+	Path                string
+	InsecureOrigin      bool
+	Certificate         Certificate
+	CertificateArn      string
+	CertificateProvider string `gorm:"not null;default:'letsencrypt'"`
+	DNSProvider         string
+	KeyType             string
+	CacheBehaviorsJSON  []byte
+	ResponseHeadersJSON []byte
+	ErrorResponsesJSON  []byte
+	UserData            UserData
+	UserDataID          int
 }
 
 func (r *Route) GetDomains() []string {
 	return strings.Split(r.DomainExternal, ",")
 }
 
-func (r *Route) loadUser(db *gorm.DB) (utils.User, error) {
+// GetCacheBehaviors returns the route's own cache behaviors, which follow the
+// distribution's ACME challenge behavior.
+func (r *Route) GetCacheBehaviors() ([]utils.CacheBehavior, error) {
+	behaviors := []utils.CacheBehavior{}
+	if len(r.CacheBehaviorsJSON) == 0 {
+		return behaviors, nil
+	}
+	err := json.Unmarshal(r.CacheBehaviorsJSON, &behaviors)
+	return behaviors, err
+}
+
+func (r *Route) setCacheBehaviors(behaviors []utils.CacheBehavior) error {
+	data, err := json.Marshal(behaviors)
+	if err != nil {
+		return err
+	}
+	r.CacheBehaviorsJSON = data
+	return nil
+}
+
+// GetResponseHeaders returns the headers the route's distribution adds to
+// responses, or nil if it adds none.
+func (r *Route) GetResponseHeaders() (*utils.ResponseHeaders, error) {
+	if len(r.ResponseHeadersJSON) == 0 {
+		return nil, nil
+	}
+	headers := &utils.ResponseHeaders{}
+	err := json.Unmarshal(r.ResponseHeadersJSON, headers)
+	return headers, err
+}
+
+func (r *Route) setResponseHeaders(headers *utils.ResponseHeaders) error {
+	if headers == nil {
+		r.ResponseHeadersJSON = nil
+		return nil
+	}
+	data, err := json.Marshal(headers)
+	if err != nil {
+		return err
+	}
+	r.ResponseHeadersJSON = data
+	return nil
+}
+
+// GetErrorResponses returns the responses the route's distribution serves in
+// place of origin errors.
+func (r *Route) GetErrorResponses() ([]utils.ErrorResponse, error) {
+	responses := []utils.ErrorResponse{}
+	if len(r.ErrorResponsesJSON) == 0 {
+		return responses, nil
+	}
+	err := json.Unmarshal(r.ErrorResponsesJSON, &responses)
+	return responses, err
+}
+
+func (r *Route) setErrorResponses(responses []utils.ErrorResponse) error {
+	data, err := json.Marshal(responses)
+	if err != nil {
+		return err
+	}
+	r.ErrorResponsesJSON = data
+	return nil
+}
+
+func (r *Route) loadUserData(db *gorm.DB) (UserData, error) {
 	var userData UserData
 	if err := db.Model(r).Related(&userData).Error; err != nil {
 		helperLogger.Session("route-load-user").Error("load-user-data", err)
+		return userData, err
+	}
+
+	return userData, nil
+}
+
+func (r *Route) loadUser(db *gorm.DB, keys *utils.Encryptor) (utils.User, error) {
+	userData, err := r.loadUserData(db)
+	if err != nil {
 		return utils.User{}, err
 	}
 
-	return LoadUser(userData)
+	return LoadUser(userData, keys)
 }
 
+// Certificate is a route's current certificate. SubjectAlternativeNames is
+// comma-separated, and PrivateKey is encrypted with the key version
+// PrivateKeyVersion, or empty if no key encryption key is configured or the
+// key is held by ACM.
+// RenewAt is when the certificate is due for renewal, and RenewalInfoRetryAt
+// is when the renewal window suggested by the CA should next be fetched.
+// RevokedAt is set once the certificate has been revoked with the RFC 5280
+// reason code RevocationReason.
 type Certificate struct {
 	gorm.Model
-	RouteId     uint
-	Domain      string
-	CertURL     string
-	Certificate []byte
-	Expires     time.Time `gorm:"index"`
+	RouteId                 uint
+	Domain                  string
+	CertURL                 string
+	Certificate             []byte
+	IssuerCertificate       []byte
+	PrivateKey              []byte
+	PrivateKeyVersion       string
+	SerialNumber            string `gorm:"index"`
+	SubjectAlternativeNames string
+	KeyAlgorithm            string
+	NotBefore               time.Time
+	Expires                 time.Time `gorm:"index"`
+	RenewAt                 *time.Time
+	RenewalInfoRetryAt      *time.Time
+	RevokedAt               *time.Time
+	RevocationReason        uint
+}
+
+// setResource records an issued certificate, its metadata and its encrypted
+// private key.
+func (c *Certificate) setResource(cert certificate.Resource, keys *utils.Encryptor) error {
+	info, err := utils.GetPEMCertInfo(cert.Certificate)
+	if err != nil {
+		return err
+	}
+
+	// Unlike account keys, certificate keys are never stored in plain text.
+	var privateKey []byte
+	var privateKeyVersion string
+	if keys.Version() != "" && len(cert.PrivateKey) > 0 {
+		privateKey, privateKeyVersion, err = keys.Encrypt(cert.PrivateKey)
+		if err != nil {
+			return err
+		}
+	}
+
+	c.Domain = cert.Domain
+	c.CertURL = cert.CertURL
+	c.Certificate = cert.Certificate
+	c.IssuerCertificate = cert.IssuerCertificate
+	c.PrivateKey = privateKey
+	c.PrivateKeyVersion = privateKeyVersion
+	c.SerialNumber = info.SerialNumber
+	c.SubjectAlternativeNames = strings.Join(info.DNSNames, ",")
+	c.KeyAlgorithm = info.KeyAlgorithm
+	c.NotBefore = info.NotBefore
+	c.Expires = info.NotAfter
+	return nil
+}
+
+// Resource returns the stored certificate with its decrypted private key, e.g.
+// to upload it again.
+func (c *Certificate) Resource(keys *utils.Encryptor) (certificate.Resource, error) {
+	if len(c.PrivateKey) == 0 {
+		return certificate.Resource{}, errors.New("the certificate's private key isn't stored")
+	}
+
+	privateKey, err := keys.Decrypt(c.PrivateKey, c.PrivateKeyVersion)
+	if err != nil {
+		return certificate.Resource{}, err
+	}
+
+	return certificate.Resource{
+		Domain:            c.Domain,
+		CertURL:           c.CertURL,
+		PrivateKey:        privateKey,
+		Certificate:       c.Certificate,
+		IssuerCertificate: c.IssuerCertificate,
+	}, nil
+}
+
+// scheduleRenewal sets when the certificate is due for renewal according to
+// the broker's settings, until the CA suggests a renewal window for it.
+func (c *Certificate) scheduleRenewal(settings config.Settings, instanceId string) {
+	renewAt := utils.RenewalTime(settings, instanceId, c.Certificate, c.Expires)
+	c.RenewAt = &renewAt
+	c.RenewalInfoRetryAt = nil
 }
 
 type RouteManagerIface interface {
-	Create(instanceId, domain, origin, path string, insecureOrigin bool, forwardedHeaders utils.Headers, forwardCookies bool, tags map[string]string) (*Route, error)
-	Update(instanceId string, domain, origin string, path string, insecureOrigin bool, forwardedHeaders utils.Headers, forwardCookies bool) error
+	Create(instanceId, domain, origin, path string, insecureOrigin bool, forwardedHeaders, originHeaders utils.Headers, forwardCookies bool, cookieNames, queryStringKeys []string, cacheBehaviors []utils.CacheBehavior, responseHeaders *utils.ResponseHeaders, errorResponses []utils.ErrorResponse, certificateProvider, dnsProvider, keyType string, cert *certificate.Resource, tags map[string]string) (*Route, error)
+	Update(instanceId string, domain, origin string, path string, insecureOrigin bool, forwardedHeaders, originHeaders utils.Headers, forwardCookies bool, cookieNames, queryStringKeys []string, cacheBehaviors []utils.CacheBehavior, responseHeaders *utils.ResponseHeaders, errorResponses []utils.ErrorResponse, keyType string) error
 	Get(instanceId string) (*Route, error)
 	Poll(route *Route) error
 	Disable(route *Route) error
 	Renew(route *Route) error
+	Revoke(route *Route, reason uint) error
 	RenewAll()
 	DeleteOrphanedCerts()
-	GetDNSInstructions(route *Route) ([]string, error)
+	GetDomainStatus(route *Route) ([]utils.DomainStatus, error)
+	CheckCAA(domains []string, certificateProvider string) error
+	ResolveCertificate(cert utils.CustomCertificate, domains []string) (certificate.Resource, error)
+	ImportCertificate(route *Route, cert certificate.Resource) error
 }
 
 type RouteManager struct {
 	logger     lager.Logger
-	iam        utils.IamIface
+	certs      utils.CertificateStoreIface
 	cloudFront utils.DistributionIface
+	acm        utils.AcmIssuerIface
+	dns        *utils.DNSProviders
+	keys       *utils.Encryptor
+	caa        utils.CAACheckerIface
+	readiness  utils.DomainCheckerIface
+	credhub    utils.CredhubIface
+	canary     utils.CanaryIface
 	settings   config.Settings
 	db         *gorm.DB
+	accounts   *AccountManager
 }
 
 func NewManager(
 	logger lager.Logger,
-	iam utils.IamIface,
+	certs utils.CertificateStoreIface,
 	cloudFront utils.DistributionIface,
+	acm utils.AcmIssuerIface,
+	dns *utils.DNSProviders,
+	keys *utils.Encryptor,
 	settings config.Settings,
 	db *gorm.DB,
 ) RouteManager {
+	resolver := utils.NewResolver(settings)
 	return RouteManager{
 		logger:     logger,
-		iam:        iam,
+		certs:      certs,
 		cloudFront: cloudFront,
+		acm:        acm,
+		dns:        dns,
+		keys:       keys,
+		caa:        &utils.CAAChecker{Resolver: resolver},
+		readiness:  &utils.DomainChecker{Resolver: resolver},
+		credhub:    utils.NewCredhub(settings),
+		canary:     utils.NewCanary(settings, s3.New(session.New(aws.NewConfig().WithRegion(settings.AwsDefaultRegion)))),
 		settings:   settings,
 		db:         db,
+		accounts:   NewAccountManager(logger, settings, db, keys),
 	}
 }
 
-func (m *RouteManager) Create(instanceId, domain, origin, path string, insecureOrigin bool, forwardedHeaders utils.Headers, forwardCookies bool, tags map[string]string) (*Route, error) {
+func (m *RouteManager) Create(instanceId, domain, origin, path string, insecureOrigin bool, forwardedHeaders, originHeaders utils.Headers, forwardCookies bool, cookieNames, queryStringKeys []string, cacheBehaviors []utils.CacheBehavior, responseHeaders *utils.ResponseHeaders, errorResponses []utils.ErrorResponse, certificateProvider, dnsProvider, keyType string, cert *certificate.Resource, tags map[string]string) (*Route, error) {
 	route := &Route{
-		InstanceId:     instanceId,
-		State:          Provisioning,
-		DomainExternal: domain,
-		Origin:         origin,
-		Path:           path,
-		InsecureOrigin: insecureOrigin,
+		InstanceId:          instanceId,
+		State:               Provisioning,
+		DomainExternal:      domain,
+		Origin:              origin,
+		Path:                path,
+		InsecureOrigin:      insecureOrigin,
+		CertificateProvider: certificateProvider,
+		DNSProvider:         dnsProvider,
+		KeyType:             keyType,
 	}
 
 	lsession := m.logger.Session("route-manager-create-route", lager.Data{
-		"instance-id": instanceId,
+		"instance-id":          instanceId,
+		"certificate-provider": certificateProvider,
+		"dns-provider":         dnsProvider,
+		"key-type":             keyType,
 	})
 
-	user, err := LoadRandomUser(m.db, m.settings.UserIdPool)
-	if err != nil {
-		lsession.Error("load-random-user", err)
+	if _, err := m.keyType(route); err != nil {
+		lsession.Error("key-type", err)
 		return nil, err
 	}
 
-	clients, err := m.getClients(&user, m.settings)
-	if err != nil {
-		lsession.Error("get-clients", err)
+	if err := route.setCacheBehaviors(cacheBehaviors); err != nil {
+		lsession.Error("set-cache-behaviors", err)
 		return nil, err
 	}
 
-	userData, err := SaveUser(m.db, user)
-	if err != nil {
-		lsession.Error("save-user", err)
+	if err := route.setResponseHeaders(responseHeaders); err != nil {
+		lsession.Error("set-response-headers", err)
 		return nil, err
 	}
 
-	route.UserData = userData
-
-	if err := m.ensureChallenges(route, clients[acme.HTTP01], false); err != nil {
-		lsession.Error("ensure-challenges-http-01", err)
+	if err := route.setErrorResponses(errorResponses); err != nil {
+		lsession.Error("set-error-responses", err)
 		return nil, err
 	}
 
-	dist, err := m.cloudFront.Create(instanceId, make([]string, 0), origin, path, insecureOrigin, forwardedHeaders, forwardCookies, tags)
+	var stored utils.StoredCertificate
+	switch certificateProvider {
+	case CertificateProviderAcm:
+		arn, err := m.acm.RequestCertificate(instanceId, route.GetDomains())
+		if err != nil {
+			lsession.Error("acm-request-certificate", err)
+			return nil, err
+		}
+		route.CertificateArn = arn
+	case CertificateProviderCustom:
+		// Store the certificate before anything is created, so that a
+		// certificate the store rejects leaves nothing behind.
+		if cert == nil {
+			err := errors.New("instances with their own certificate must provide one")
+			lsession.Error("custom-certificate", err)
+			return nil, err
+		}
+		if err := route.Certificate.setResource(*cert, m.keys); err != nil {
+			lsession.Error("set-certificate-resource", err)
+			return nil, err
+		}
+		var err error
+		if stored, err = m.storeCertificate(route, *cert); err != nil {
+			lsession.Error("store-certificate", err)
+			return nil, err
+		}
+	default:
+		provider, err := m.dns.Get(dnsProvider)
+		if err != nil {
+			lsession.Error("get-dns-provider", err)
+			return nil, err
+		}
+
+		userData, err := m.accounts.Acquire(len(route.GetDomains()))
+		if err != nil {
+			lsession.Error("acquire-account", err)
+			return nil, err
+		}
+
+		client, err := m.getAccountClient(userData, provider)
+		if err != nil {
+			lsession.Error("get-account-client", err)
+			return nil, err
+		}
+
+		route.UserData = userData
+
+		if err := m.ensureChallenges(route, client, false); err != nil {
+			lsession.Error("ensure-challenges", err)
+			if utils.IsAccountError(err) {
+				m.accounts.Deactivate(userData.ID)
+			}
+			return nil, err
+		}
+	}
+
+	// ACM would otherwise hold the requested certificate until its
+	// validation times out, against the account's quota.
+	releaseAcmCertificate := func() {
+		if certificateProvider != CertificateProviderAcm {
+			return
+		}
+		if err := m.acm.DeleteCertificate(route.CertificateArn); err != nil {
+			lsession.Error("acm-delete-certificate", err)
+		}
+	}
+
+	dist, err := m.cloudFront.Create(instanceId, make([]string, 0), origin, path, insecureOrigin, forwardedHeaders, originHeaders, forwardCookies, cookieNames, queryStringKeys, cacheBehaviors, responseHeaders, errorResponses, tags)
 	if err != nil {
 		lsession.Error("create-cloudfront-instance", err)
+		releaseAcmCertificate()
 		return nil, err
 	}
 
 	route.DomainInternal = *dist.DomainName
 	route.DistId = *dist.Id
 
+	if certificateProvider == CertificateProviderCustom {
+		if err := m.cloudFront.SetCertificateAndCname(route.DistId, stored.Id, m.certs.Source(), route.GetDomains()); err != nil {
+			lsession.Error("set-certificate-and-cname", err)
+			if err := m.cloudFront.Disable(route.DistId); err != nil {
+				lsession.Error("cloudfront-disable", err)
+			}
+			return nil, err
+		}
+		route.CertificateArn = stored.Arn
+	}
+
 	if err := m.db.Create(route).Error; err != nil {
 		lsession.Error("db-create-route", err)
+		releaseAcmCertificate()
 		return nil, err
 	}
 
+	m.upsertAliases(route, route.GetDomains())
+
+	if err := m.accounts.RefreshPendingAuthorizations(route.UserData.ID); err != nil {
+		lsession.Error("refresh-pending-authorizations", err)
+	}
+
 	return route, nil
 }
 
@@ -339,7 +645,10 @@ func (m *RouteManager) Get(instanceId string) (*Route, error) {
 	}
 }
 
-func (m *RouteManager) Update(instanceId, domain, origin string, path string, insecureOrigin bool, forwardedHeaders utils.Headers, forwardCookies bool) error {
+// Update changes a route's settings. cacheBehaviors replace the route's own
+// cache behaviors, responseHeaders its response headers and errorResponses
+// its error responses, unless they're nil.
+func (m *RouteManager) Update(instanceId, domain, origin string, path string, insecureOrigin bool, forwardedHeaders, originHeaders utils.Headers, forwardCookies bool, cookieNames, queryStringKeys []string, cacheBehaviors []utils.CacheBehavior, responseHeaders *utils.ResponseHeaders, errorResponses []utils.ErrorResponse, keyType string) error {
 	lsession := m.logger.Session("route-manager-update", lager.Data{
 		"instance-id": instanceId,
 	})
@@ -352,7 +661,7 @@ func (m *RouteManager) Update(instanceId, domain, origin string, path string, in
 	}
 
 	// When we update the CloudFront distribution we should use the old domains
-	// until we have a valid certificate in IAM.
+	// until we have a valid certificate in the certificate store.
 	// CloudFront gets updated when we receive new certificates during Poll
 	oldDomainsForCloudFront := route.GetDomains()
 
@@ -370,34 +679,101 @@ func (m *RouteManager) Update(instanceId, domain, origin string, path string, in
 		route.InsecureOrigin = insecureOrigin
 	}
 
+	// A new key type needs a new certificate, as do new domains.
+	keyTypeChanged := keyType != "" && keyType != route.KeyType
+	if keyTypeChanged {
+		route.KeyType = keyType
+		if _, err := m.keyType(route); err != nil {
+			lsession.Error("key-type", err)
+			return err
+		}
+	}
+
+	if cacheBehaviors != nil {
+		if err := route.setCacheBehaviors(cacheBehaviors); err != nil {
+			lsession.Error("set-cache-behaviors", err)
+			return err
+		}
+	}
+	cacheBehaviors, err = route.GetCacheBehaviors()
+	if err != nil {
+		lsession.Error("get-cache-behaviors", err)
+		return err
+	}
+
+	if responseHeaders != nil {
+		if err := route.setResponseHeaders(responseHeaders); err != nil {
+			lsession.Error("set-response-headers", err)
+			return err
+		}
+	}
+	responseHeaders, err = route.GetResponseHeaders()
+	if err != nil {
+		lsession.Error("get-response-headers", err)
+		return err
+	}
+
+	if errorResponses != nil {
+		if err := route.setErrorResponses(errorResponses); err != nil {
+			lsession.Error("set-error-responses", err)
+			return err
+		}
+	}
+	errorResponses, err = route.GetErrorResponses()
+	if err != nil {
+		lsession.Error("get-error-responses", err)
+		return err
+	}
+
 	// Update the distribution
 	dist, err := m.cloudFront.Update(route.DistId, oldDomainsForCloudFront,
-		route.Origin, route.Path, route.InsecureOrigin, forwardedHeaders, forwardCookies)
+		route.Origin, route.Path, route.InsecureOrigin, forwardedHeaders, originHeaders, forwardCookies, cookieNames, queryStringKeys, cacheBehaviors, responseHeaders, errorResponses)
 	if err != nil {
 		lsession.Error("cloudfront-update", err)
 		return err
 	}
-	route.State = Provisioning
+
+	// Only new domains or a new key type need a new certificate. Routes that
+	// keep theirs stay as they are while the distribution deploys, rather
+	// than finalizing their old order again.
+	reissue := domain != "" || keyTypeChanged
+	if reissue || (route.State != Provisioned && route.State != RenewalAtRisk) {
+		route.State = Provisioning
+	}
+	if !reissue && route.State == Provisioning && route.CertificateProvider != CertificateProviderAcm && route.CertificateProvider != CertificateProviderCustom {
+		// An order whose authorizations are all valid may have been finalized
+		// already, so start a new one. The CA reuses the authorizations.
+		var order utils.Order
+		if err := json.Unmarshal(route.ChallengeJSON, &order); err == nil && len(order.PendingDomains()) == 0 {
+			reissue = true
+		}
+	}
 
 	// Get the updated domain name and dist id.
 	route.DomainInternal = *dist.DomainName
 	route.DistId = *dist.Id
 
 	if domain != "" {
-		user, err := route.loadUser(m.db)
+		m.upsertAliases(route, route.GetDomains())
+		m.deleteAliases(route, removedDomains(oldDomainsForCloudFront, route.GetDomains()))
+	}
+
+	if domain != "" && route.CertificateProvider == CertificateProviderAcm {
+		arn, err := m.acm.RequestCertificate(route.InstanceId, route.GetDomains())
 		if err != nil {
-			lsession.Error("load-user", err)
+			lsession.Error("acm-request-certificate", err)
 			return err
 		}
-
-		clients, err := m.getClients(&user, m.settings)
+		route.CertificateArn = arn
+	} else if reissue && route.CertificateProvider != CertificateProviderCustom {
+		client, err := m.getRouteClient(route)
 		if err != nil {
-			lsession.Error("get-clients", err)
+			lsession.Error("get-route-client", err)
 			return err
 		}
 
 		route.ChallengeJSON = []byte("")
-		if err := m.ensureChallenges(route, clients[acme.HTTP01], false); err != nil {
+		if err := m.ensureChallenges(route, client, false); err != nil {
 			lsession.Error("ensure-challenges", err)
 			return err
 		}
@@ -409,6 +785,10 @@ func (m *RouteManager) Update(instanceId, domain, origin string, path string, in
 		lsession.Error("db-save-route", err)
 		return result.Error
 	}
+
+	if err := m.accounts.RefreshPendingAuthorizations(uint(route.UserDataID)); err != nil {
+		lsession.Error("refresh-pending-authorizations", err)
+	}
 	return nil
 }
 
@@ -441,6 +821,10 @@ func (m *RouteManager) Disable(r *Route) error {
 		lsession.Error("db-save-error", err)
 	}
 
+	if err := m.accounts.RefreshPendingAuthorizations(uint(r.UserDataID)); err != nil {
+		lsession.Error("refresh-pending-authorizations", err)
+	}
+
 	return nil
 }
 
@@ -455,58 +839,33 @@ func (m *RouteManager) stillActive(r *Route) error {
 		"instance-id": r.InstanceId,
 	})
 
-	session := session.New(aws.NewConfig().WithRegion(m.settings.AwsDefaultRegion))
-
-	s3client := s3.New(session)
-
-	target := path.Join(".well-known", "acme-challenge", "canary", r.InstanceId)
-
-	input := s3.PutObjectInput{
-		Bucket: aws.String(m.settings.Bucket),
-		Key:    aws.String(target),
-		Body:   strings.NewReader(r.InstanceId),
-	}
-
-	if m.settings.ServerSideEncryption != "" {
-		input.ServerSideEncryption = aws.String(m.settings.ServerSideEncryption)
-	}
-
-	if _, err := s3client.PutObject(&input); err != nil {
-		lsession.Error("s3-put-object", err)
+	if err := m.canary.Check(r.InstanceId, r.GetDomains()); err != nil {
+		lsession.Error("canary-check", err)
 		return err
 	}
 
-	insecureClient := &http.Client{
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-		},
-	}
-
-	for _, domain := range r.GetDomains() {
-		resp, err := insecureClient.Get("https://" + path.Join(domain, target))
-		if err != nil {
-			lsession.Error("insecure-client-get", err)
-			return err
-		}
-
-		defer resp.Body.Close()
-		body, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			lsession.Error("read-response-body", err)
-			return err
-		}
+	return nil
+}
 
-		if string(body) != r.InstanceId {
-			err := fmt.Errorf("Canary check failed for %s; expected %s, got %s", domain, r.InstanceId, string(body))
-			lsession.Error("", err)
-			return err
-		}
+// Renew replaces the route's certificate. The outcome is recorded, so that
+// RenewAll backs off after failed renewals.
+func (m *RouteManager) Renew(r *Route) error {
+	if r.CertificateProvider == CertificateProviderAcm {
+		m.logger.Session("route-manager-renew", lager.Data{
+			"instance-id": r.InstanceId,
+		}).Info("renewed-by-acm")
+		return nil
+	}
+	if r.CertificateProvider == CertificateProviderCustom {
+		return errors.New("the route's own certificate can't be renewed by the broker")
 	}
 
-	return nil
+	err := m.renew(r)
+	m.recordRenewalAttempt(r, err)
+	return err
 }
 
-func (m *RouteManager) Renew(r *Route) error {
+func (m *RouteManager) renew(r *Route) error {
 	lsession := m.logger.Session("route-manager-renew", lager.Data{
 		"instance-id": r.InstanceId,
 	})
@@ -525,40 +884,37 @@ func (m *RouteManager) Renew(r *Route) error {
 		return err
 	}
 
-	user, err := r.loadUser(m.db)
+	client, err := m.getRouteClient(r)
 	if err != nil {
-		lsession.Error("db-load-user", err)
+		lsession.Error("get-route-client", err)
 		return err
 	}
 
-	clients, err := m.getClients(&user, m.settings)
+	keyType, err := m.keyType(r)
 	if err != nil {
-		lsession.Error("get-clients", err)
+		lsession.Error("key-type", err)
 		return err
 	}
 
-	certResource, errs := clients[acme.HTTP01].ObtainCertificate(r.GetDomains(), true, nil, false)
-	if len(errs) > 0 {
-		err := fmt.Errorf("Error(s) obtaining certificate: %v", errs)
+	certResource, err := client.ObtainCertificate(r.GetDomains(), keyType)
+	if err != nil {
+		m.recordAccountFailure(r, err)
+		err := fmt.Errorf("Error(s) obtaining certificate: %v", err)
 		lsession.Error("obtain-certificate", err)
 		return err
 	}
 
-	expires, err := acme.GetPEMCertExpiration(certResource.Certificate)
-	if err != nil {
-		lsession.Error("get-pem-cert-expiry", err)
+	if err := certRow.setResource(*certResource, m.keys); err != nil {
+		lsession.Error("set-certificate-resource", err)
 		return err
 	}
 
-	if err := m.deployCertificate(*r, certResource); err != nil {
+	if err := m.deployCertificate(r, *certResource); err != nil {
 		lsession.Error("deploy-certificate", err)
 		return err
 	}
 
-	certRow.Domain = certResource.Domain
-	certRow.CertURL = certResource.CertURL
-	certRow.Certificate = certResource.Certificate
-	certRow.Expires = expires
+	certRow.scheduleRenewal(m.settings, r.InstanceId)
 	if err := m.db.Save(&certRow).Error; err != nil {
 		lsession.Error("db-save-cert", err)
 		return err
@@ -574,22 +930,69 @@ func (m *RouteManager) DeleteOrphanedCerts() {
 		if distro.ViewerCertificate.IAMCertificateId != nil {
 			activeCerts[*distro.ViewerCertificate.IAMCertificateId] = *distro.ARN
 		}
+		if distro.ViewerCertificate.ACMCertificateArn != nil {
+			activeCerts[*distro.ViewerCertificate.ACMCertificateArn] = *distro.ARN
+		}
+		return true
+	})
+
+	// record the ACM certificates that routes are waiting on, which aren't
+	// attached to their distributions until they're issued
+	requestedCerts := make(map[string]bool)
+	routes := []Route{}
+	requestedKnown := true
+	if err := m.db.Where(
+		"certificate_provider = ? AND state != ?", CertificateProviderAcm, Deprovisioned,
+	).Find(&routes).Error; err != nil {
+		m.logger.Error("db-find-acm-routes", err)
+		requestedKnown = false
+	}
+	for _, route := range routes {
+		requestedCerts[route.CertificateArn] = true
+	}
+
+	// iterate over all certificates requested from ACM
+	m.acm.ListCertificates(func(cert acm.CertificateDetail) bool {
+
+		// delete any certs not in use that were issued more than 24 hours ago,
+		// e.g. certs replaced when a route's domains changed, and any that no
+		// route is waiting on and that haven't validated in 72 hours, e.g.
+		// certs requested by provisions that failed
+		_, active := activeCerts[*cert.CertificateArn]
+		status := aws.StringValue(cert.Status)
+		orphaned := status == acm.CertificateStatusIssued && time.Since(aws.TimeValue(cert.IssuedAt)).Hours() > 24
+		if status == acm.CertificateStatusPendingValidation || status == acm.CertificateStatusValidationTimedOut {
+			orphaned = requestedKnown && !requestedCerts[*cert.CertificateArn] && time.Since(aws.TimeValue(cert.CreatedAt)).Hours() > 72
+		}
+		if !active && orphaned && len(cert.InUseBy) == 0 {
+			m.logger.Info("cleaning-orphaned-acm-certificate", lager.Data{
+				"arn": *cert.CertificateArn,
+			})
+
+			err := m.acm.DeleteCertificate(*cert.CertificateArn)
+			if err != nil {
+				m.logger.Error("acm-delete-certificate", err, lager.Data{
+					"arn": *cert.CertificateArn,
+				})
+			}
+		}
+
 		return true
 	})
 
 	// iterate over all certificates
-	m.iam.ListCertificates(func(cert iam.ServerCertificateMetadata) bool {
+	m.certs.ListCertificates(func(cert utils.StoredCertificate) bool {
 
 		// delete any certs not attached to a distribution that are older than 24 hours
-		_, active := activeCerts[*cert.ServerCertificateId]
-		if !active && time.Since(*cert.UploadDate).Hours() > 24 {
+		_, active := activeCerts[cert.Id]
+		if !active && time.Since(cert.Uploaded).Hours() > 24 {
 			m.logger.Info("cleaning-orphaned-certificate", lager.Data{
 				"cert": cert,
 			})
 
-			err := m.iam.DeleteCertificate(*cert.ServerCertificateName)
+			err := m.certs.DeleteCertificate(cert)
 			if err != nil {
-				m.logger.Error("iam-delete-certificate", err, lager.Data{
+				m.logger.Error("delete-certificate", err, lager.Data{
 					"cert": cert,
 				})
 			}
@@ -606,21 +1009,42 @@ func (m *RouteManager) RenewAll() {
 
 	m.logger.Info("Looking for routes that are expiring soon")
 
-	m.db.Having(
-		"max(expires) < now() + interval '70 days'",
-	).Group(
-		"routes.id",
-	).Where(
-		"state = ?", string(Provisioned),
-	).Joins(
-		"join certificates on routes.id = certificates.route_id",
-	).Find(&routes)
+	if err := m.db.Preload("Certificate").Where(
+		"state IN (?) AND certificate_provider NOT IN (?)", []string{Provisioned, RenewalAtRisk},
+		[]string{CertificateProviderAcm, CertificateProviderCustom},
+	).Find(&routes).Error; err != nil {
+		lsession.Error("db-find-routes", err)
+		return
+	}
+
+	// Customers replace their own certificates, so only warn them.
+	m.warnCustomCertificates()
+
+	var renewalInfo utils.RenewalInfoIface
+	if m.settings.AcmeRenewalInfo {
+		client, err := utils.NewRenewalInfoClient(m.settings)
+		if err == utils.ErrNoRenewalInfo {
+			lsession.Info("renewal-info-unsupported")
+		} else if err != nil {
+			lsession.Error("new-renewal-info-client", err)
+		} else {
+			renewalInfo = client
+		}
+	}
+
+	due := []Route{}
+	for _, route := range routes {
+		if m.renewalDue(&route, renewalInfo) {
+			due = append(due, route)
+		}
+	}
+	due = m.throttleRenewals(due)
 
 	m.logger.Info("routes-needing-renewal", lager.Data{
-		"num-routes": len(routes),
+		"num-routes": len(due),
 	})
 
-	for _, route := range routes {
+	for _, route := range due {
 		err := m.Renew(&route)
 		if err != nil {
 			lsession.Error("renew-error", err, lager.Data{
@@ -636,75 +1060,460 @@ func (m *RouteManager) RenewAll() {
 	}
 }
 
-func (m *RouteManager) getClients(user *utils.User, settings config.Settings) (map[acme.Challenge]*acme.Client, error) {
-	session := session.New(aws.NewConfig().WithRegion(settings.AwsDefaultRegion))
+// renewalDue reports whether the route's certificate is due for renewal. The
+// renewal time of certificates issued before renewals were scheduled is
+// filled in, and if renewalInfo is set, the CA's suggested renewal window is
+// fetched whenever the previous one has gone stale.
+func (m *RouteManager) renewalDue(r *Route, renewalInfo utils.RenewalInfoIface) bool {
+	lsession := m.logger.Session("route-manager-renewal-due", lager.Data{
+		"instance-id": r.InstanceId,
+	})
+
+	cert := &r.Certificate
+	if cert.ID == 0 {
+		return false
+	}
 
-	lsession := m.logger.Session("route-manager-get-clients")
+	now := time.Now()
+	updates := map[string]interface{}{}
 
-	var err error
+	if cert.RenewAt == nil {
+		cert.scheduleRenewal(m.settings, r.InstanceId)
+		updates["renew_at"] = *cert.RenewAt
+	}
 
-	clients := map[acme.Challenge]*acme.Client{}
-	clients[acme.HTTP01], err = utils.NewClient(settings, user, s3.New(session), []acme.Challenge{acme.TLSSNI01, acme.DNS01})
-	if err != nil {
-		lsession.Error("new-client-http-builder", err)
-		return clients, err
+	if renewalInfo != nil && (cert.RenewalInfoRetryAt == nil || !cert.RenewalInfoRetryAt.After(now)) {
+		info, err := renewalInfo.GetRenewalInfo(cert.Certificate)
+		if err != nil {
+			lsession.Error("get-renewal-info", err)
+		} else {
+			renewAt := info.RenewAt(r.InstanceId)
+			cert.RenewAt = &renewAt
+			cert.RenewalInfoRetryAt = &info.RetryAt
+			updates["renew_at"] = renewAt
+			updates["renewal_info_retry_at"] = info.RetryAt
+		}
 	}
-	clients[acme.DNS01], err = utils.NewClient(settings, user, s3.New(session), []acme.Challenge{acme.TLSSNI01, acme.HTTP01})
-	if err != nil {
-		lsession.Error("new-client-dns-builder", err)
-		return clients, err
+
+	if len(updates) > 0 {
+		if err := m.db.Model(cert).Updates(updates).Error; err != nil {
+			lsession.Error("db-update-renewal", err)
+		}
 	}
 
-	return clients, nil
+	return !cert.RenewAt.After(now)
 }
 
-func (m *RouteManager) updateProvisioning(r *Route) error {
-	lsession := m.logger.Session("route-manager-update-provisioning", lager.Data{
-		"instance-id": r.InstanceId,
+// MigrateCertificates moves provisioned routes whose distributions use a
+// certificate from another store to the configured certificate store. The
+// route's certificate is imported again from the database; routes whose
+// private keys weren't stored, or whose certificates have expired, get a new
+// certificate instead. If limit is positive, at most limit routes are
+// migrated.
+func (m *RouteManager) MigrateCertificates(limit int, dryRun bool) error {
+	lsession := m.logger.Session("route-manager-migrate-certificates", lager.Data{
+		"store":   m.certs.Source(),
+		"dry-run": dryRun,
 	})
 
-	user, err := r.loadUser(m.db)
-	if err != nil {
-		lsession.Error("load-user", err)
+	routes := []Route{}
+	if err := m.db.Preload("Certificate").Where(
+		"state IN (?) AND certificate_provider NOT IN (?)", []string{Provisioned, RenewalAtRisk},
+		[]string{CertificateProviderAcm, CertificateProviderCustom},
+	).Order("id asc").Find(&routes).Error; err != nil {
+		lsession.Error("db-find-routes", err)
 		return err
 	}
 
-	clients, err := m.getClients(&user, m.settings)
+	migrated := 0
+	for _, route := range routes {
+		if limit > 0 && migrated >= limit {
+			break
+		}
+
+		dist, err := m.cloudFront.Get(route.DistId)
+		if err != nil {
+			lsession.Error("cloudfront-get", err, lager.Data{
+				"instance-id": route.InstanceId,
+			})
+			continue
+		}
+
+		source := aws.StringValue(dist.DistributionConfig.ViewerCertificate.CertificateSource)
+		if source != utils.CertificateSourceIam && source != utils.CertificateSourceAcm || source == m.certs.Source() {
+			continue
+		}
+
+		lsession.Info("migrate-route", lager.Data{
+			"instance-id": route.InstanceId,
+			"domain":      route.DomainExternal,
+			"source":      source,
+		})
+		migrated++
+
+		if dryRun {
+			continue
+		}
+
+		if len(route.Certificate.PrivateKey) == 0 || !route.Certificate.Expires.After(time.Now()) {
+			if err := m.Renew(&route); err != nil {
+				lsession.Error("renew", err, lager.Data{
+					"instance-id": route.InstanceId,
+				})
+			}
+			continue
+		}
+
+		cert, err := route.Certificate.Resource(m.keys)
+		if err != nil {
+			lsession.Error("certificate-resource", err, lager.Data{
+				"instance-id": route.InstanceId,
+			})
+			continue
+		}
+		if err := m.deployCertificate(&route, cert); err != nil {
+			lsession.Error("deploy-certificate", err, lager.Data{
+				"instance-id": route.InstanceId,
+			})
+		}
+	}
+
+	lsession.Info("routes-migrated", lager.Data{
+		"num-routes": migrated,
+	})
+	return nil
+}
+
+// MigratePolicies converts the distributions of up to limit routes, or of
+// all routes if limit is 0, from forwarded values to cache policies and
+// origin request policies. With dryRun, it only logs the routes it would
+// convert.
+func (m *RouteManager) MigratePolicies(limit int, dryRun bool) error {
+	lsession := m.logger.Session("route-manager-migrate-policies", lager.Data{
+		"dry-run": dryRun,
+	})
+
+	if !m.settings.CloudFrontPolicies {
+		err := errors.New("CLOUDFRONT_POLICIES must be set to migrate distributions to policies")
+		lsession.Error("settings", err)
+		return err
+	}
+
+	routes := []Route{}
+	if err := m.db.Where(
+		"state IN (?)", []string{Provisioned, RenewalAtRisk},
+	).Order("id asc").Find(&routes).Error; err != nil {
+		lsession.Error("db-find-routes", err)
+		return err
+	}
+
+	migrated := 0
+	for _, route := range routes {
+		if limit > 0 && migrated >= limit {
+			break
+		}
+
+		dist, err := m.cloudFront.Get(route.DistId)
+		if err != nil {
+			lsession.Error("cloudfront-get", err, lager.Data{
+				"instance-id": route.InstanceId,
+			})
+			continue
+		}
+		if utils.UsesPolicies(dist.DistributionConfig) {
+			continue
+		}
+
+		lsession.Info("migrate-route", lager.Data{
+			"instance-id": route.InstanceId,
+			"dist-id":     route.DistId,
+		})
+		migrated++
+
+		if dryRun {
+			continue
+		}
+		if err := m.cloudFront.ConvertToPolicies(route.DistId); err != nil {
+			lsession.Error("cloudfront-convert-to-policies", err, lager.Data{
+				"instance-id": route.InstanceId,
+			})
+		}
+	}
+
+	lsession.Info("routes-migrated", lager.Data{
+		"num-routes": migrated,
+	})
+	return nil
+}
+
+// keyType returns the key type of the route's certificates. Routes with
+// certificates issued by ACM can't choose one.
+func (m *RouteManager) keyType(r *Route) (certcrypto.KeyType, error) {
+	if r.KeyType == "" {
+		return utils.ParseKeyType(m.settings.CertificateKeyType)
+	}
+	if r.CertificateProvider == CertificateProviderAcm {
+		return "", fmt.Errorf("certificates issued by ACM can't use the key type %s", r.KeyType)
+	}
+	return utils.ParseKeyType(r.KeyType)
+}
+
+func (m *RouteManager) getClient(user *utils.User, settings config.Settings, dnsProvider utils.DNSProviderIface) (*utils.AcmeClient, error) {
+	session := session.New(aws.NewConfig().WithRegion(settings.AwsDefaultRegion))
+
+	client, err := utils.NewClient(settings, user, s3.New(session), dnsProvider)
+	if err != nil {
+		m.logger.Session("route-manager-get-client").Error("new-client", err)
+		return nil, err
+	}
+
+	return client, nil
+}
+
+// getAccountClient builds an ACME client for an account. If the account had
+// to be resolved again, e.g. because it was registered through ACME v1, the
+// new registration is saved back to the account.
+func (m *RouteManager) getAccountClient(userData UserData, dnsProvider utils.DNSProviderIface) (*utils.AcmeClient, error) {
+	lsession := m.logger.Session("route-manager-get-account-client", lager.Data{
+		"user-data-id": userData.ID,
+	})
+
+	user, err := LoadUser(userData, m.keys)
+	if err != nil {
+		lsession.Error("load-user", err)
+		return nil, err
+	}
+
+	registered := user.GetRegistration() != nil
+
+	client, err := m.getClient(&user, m.settings, dnsProvider)
+	if err != nil {
+		lsession.Error("get-client", err)
+		return nil, err
+	}
+
+	if !registered {
+		if _, err := saveUser(m.db, userData, user, m.keys); err != nil {
+			lsession.Error("save-user", err)
+			return nil, err
+		}
+	}
+
+	return client, nil
+}
+
+func (m *RouteManager) getRouteClient(r *Route) (*utils.AcmeClient, error) {
+	lsession := m.logger.Session("route-manager-get-route-client", lager.Data{
+		"instance-id": r.InstanceId,
+	})
+
+	userData, err := r.loadUserData(m.db)
+	if err != nil {
+		lsession.Error("load-user-data", err)
+		return nil, err
+	}
+
+	dnsProvider, err := m.dns.Get(r.DNSProvider)
 	if err != nil {
-		lsession.Error("get-clients", err)
+		lsession.Error("get-dns-provider", err)
+		return nil, err
+	}
+
+	return m.getAccountClient(userData, dnsProvider)
+}
+
+// recordAccountFailure updates the route's account after the CA refused a
+// request: validation failures count towards the account's rate limit, and
+// an account the CA no longer accepts is replaced.
+func (m *RouteManager) recordAccountFailure(r *Route, err error) {
+	lsession := m.logger.Session("route-manager-record-account-failure", lager.Data{
+		"instance-id":  r.InstanceId,
+		"user-data-id": r.UserDataID,
+	})
+
+	switch {
+	case utils.IsAccountError(err):
+		if err := m.rotateAccount(r); err != nil {
+			lsession.Error("rotate-account", err)
+		}
+	case utils.IsValidationFailure(err):
+		if err := m.accounts.RecordFailedValidation(uint(r.UserDataID)); err != nil {
+			lsession.Error("record-failed-validation", err)
+		}
+	}
+}
+
+// rotateAccount moves a route off an account the CA no longer accepts. Orders
+// belong to the account that created them, so any pending order is dropped
+// and a new one is created on the next poll.
+func (m *RouteManager) rotateAccount(r *Route) error {
+	lsession := m.logger.Session("route-manager-rotate-account", lager.Data{
+		"instance-id":  r.InstanceId,
+		"user-data-id": r.UserDataID,
+	})
+
+	if err := m.accounts.Deactivate(uint(r.UserDataID)); err != nil {
+		lsession.Error("deactivate-account", err)
 		return err
 	}
 
-	// Handle provisioning instances created before DNS challenge
-	if err := m.ensureChallenges(r, clients[acme.HTTP01], true); err != nil {
+	userData, err := m.accounts.Acquire(len(r.GetDomains()))
+	if err != nil {
+		lsession.Error("acquire-account", err)
+		return err
+	}
+
+	r.UserData = userData
+	r.UserDataID = int(userData.ID)
+	r.ChallengeJSON = []byte("")
+	if err := m.db.Save(r).Error; err != nil {
+		lsession.Error("db-save-route", err)
+		return err
+	}
+	return nil
+}
+
+func (m *RouteManager) updateProvisioning(r *Route) error {
+	switch r.CertificateProvider {
+	case CertificateProviderAcm:
+		return m.updateAcmProvisioning(r)
+	case CertificateProviderCustom:
+		return m.updateCustomProvisioning(r)
+	}
+
+	lsession := m.logger.Session("route-manager-update-provisioning", lager.Data{
+		"instance-id": r.InstanceId,
+	})
+
+	defer func() {
+		if err := m.accounts.RefreshPendingAuthorizations(uint(r.UserDataID)); err != nil {
+			lsession.Error("refresh-pending-authorizations", err)
+		}
+	}()
+
+	client, err := m.getRouteClient(r)
+	if err != nil {
+		lsession.Error("get-route-client", err)
+		return err
+	}
+
+	// Handle provisioning instances created before ACME v2
+	if err := m.ensureChallenges(r, client, true); err != nil {
 		lsession.Error("ensure-challenges", err)
+		m.recordAccountFailure(r, err)
 		return err
 	}
 
 	if m.checkDistribution(r) {
-		var challenges []acme.AuthorizationResource
-		if err := json.Unmarshal(r.ChallengeJSON, &challenges); err != nil {
+		var order utils.Order
+		if err := json.Unmarshal(r.ChallengeJSON, &order); err != nil {
 			lsession.Error("challenge-unmarshall", err)
 			return err
 		}
-		if errs := m.solveChallenges(clients, challenges); len(errs) > 0 {
+		auths, err := m.loadAuthorizations(r, order)
+		if err != nil {
+			lsession.Error("load-authorizations", err)
+			return err
+		}
+
+		// Don't spend failed validations on domains the CA can't issue for,
+		// but carry on with the others.
+		pending := order.PendingDomains()
+		caaErr := m.preflightCAA(r, pending)
+		blocked := map[string]error{}
+		var caaErrs utils.CAAErrors
+		if errors.As(caaErr, &caaErrs) {
+			for _, err := range caaErrs {
+				blocked[err.Domain] = err
+			}
+		}
+		attempt := []string{}
+		for _, domain := range pending {
+			if _, ok := blocked[domain]; !ok {
+				attempt = append(attempt, domain)
+			}
+		}
+
+		errs := client.SolveChallenges(&order, attempt)
+
+		failures := map[string]error{}
+		for domain, err := range blocked {
+			failures[domain] = err
+		}
+		for domain, err := range errs {
+			failures[domain] = err
+		}
+		if err := m.saveAuthorizations(auths, order, attempt, failures); err != nil {
+			lsession.Error("db-save-authorizations", err)
+			return err
+		}
+
+		// Keep the authorization statuses current for the account manager.
+		if r.ChallengeJSON, err = json.Marshal(order); err != nil {
+			lsession.Error("challenge-marshal", err)
+			return err
+		}
+		if err := m.db.Save(r).Error; err != nil {
+			lsession.Error("db-save-route-challenge", err)
+			return err
+		}
+
+		if len(errs) > 0 {
 			errstr := fmt.Errorf("Error(s) solving challenges: %v", errs)
 			lsession.Error("solve-challenges", errstr)
+
+			for _, err := range errs {
+				m.recordAccountFailure(r, err)
+				if utils.IsAccountError(err) {
+					return errstr
+				}
+			}
+
+			// A failed authorization invalidates the whole order, so start
+			// over with a new one. The CA reuses the authorizations that are
+			// already valid.
+			if order.Invalid() {
+				lsession.Info("order-invalid")
+				m.restartOrder(r, client)
+			}
+			if caaErr != nil {
+				return caaErr
+			}
 			return errstr
 		}
+		if caaErr != nil {
+			return caaErr
+		}
+
+		// Issue the certificate as soon as every domain is validated.
+		if domains := order.PendingDomains(); len(domains) > 0 {
+			lsession.Info("authorizations-pending", lager.Data{"domains": domains})
+			return nil
+		}
 
-		cert, err := clients[acme.HTTP01].RequestCertificate(challenges, true, nil, false)
+		keyType, err := m.keyType(r)
 		if err != nil {
-			lsession.Error("request-certificate-http-01", err)
+			lsession.Error("key-type", err)
 			return err
 		}
 
-		expires, err := acme.GetPEMCertExpiration(cert.Certificate)
+		cert, err := client.Finalize(order, keyType)
 		if err != nil {
-			lsession.Error("get-cert-expiry", err)
+			lsession.Error("finalize-order", err)
+			// An invalid order can't be finalized again, so start over.
+			if errors.Is(err, utils.ErrOrderInvalid) {
+				m.restartOrder(r, client)
+			}
 			return err
 		}
-		if err := m.deployCertificate(*r, cert); err != nil {
+
+		certRow := Certificate{}
+		if err := certRow.setResource(*cert, m.keys); err != nil {
+			lsession.Error("set-certificate-resource", err)
+			return err
+		}
+
+		if err := m.deployCertificate(r, *cert); err != nil {
 			lsession.Error("deploy-certificate", err)
 			r.State = Failed
 			if dbErr := m.db.Save(r).Error; dbErr != nil {
@@ -714,12 +1523,7 @@ func (m *RouteManager) updateProvisioning(r *Route) error {
 			return err
 		}
 
-		certRow := Certificate{
-			Domain:      cert.Domain,
-			CertURL:     cert.CertURL,
-			Certificate: cert.Certificate,
-			Expires:     expires,
-		}
+		certRow.scheduleRenewal(m.settings, r.InstanceId)
 		if err := m.db.Create(&certRow).Error; err != nil {
 			lsession.Error("db-create-cert", err)
 			return err
@@ -731,6 +1535,10 @@ func (m *RouteManager) updateProvisioning(r *Route) error {
 			lsession.Error("db-save-cert", err)
 			return err
 		}
+
+		// The route's domains changed, so its previous certificate is no
+		// longer needed.
+		m.revokeReplacedCertificates(r, certRow.ID, acme.CRLReasonSuperseded)
 		return nil
 	}
 
@@ -753,6 +1561,15 @@ func (m *RouteManager) updateDeprovisioning(r *Route) error {
 			if err := m.db.Save(r).Error; err != nil {
 				lsession.Error("db-save-delete-state", err)
 			}
+
+			m.deleteAliases(r, r.GetDomains())
+			m.revokeReplacedCertificates(r, 0, acme.CRLReasonCessationOfOperation)
+
+			if r.CertificateProvider == CertificateProviderAcm && r.CertificateArn != "" {
+				if err := m.acm.DeleteCertificate(r.CertificateArn); err != nil {
+					lsession.Error("acm-delete-certificate", err)
+				}
+			}
 		}
 	}
 
@@ -764,6 +1581,123 @@ func (m *RouteManager) updateDeprovisioning(r *Route) error {
 	return nil
 }
 
+// updateAcmProvisioning attaches a route's ACM certificate to its
+// distribution once ACM has issued it and the distribution has deployed.
+func (m *RouteManager) updateAcmProvisioning(r *Route) error {
+	lsession := m.logger.Session("route-manager-update-acm-provisioning", lager.Data{
+		"instance-id": r.InstanceId,
+		"arn":         r.CertificateArn,
+	})
+
+	cert, err := m.acm.DescribeCertificate(r.CertificateArn)
+	if err != nil {
+		lsession.Error("acm-describe-certificate", err)
+		return err
+	}
+
+	switch status := aws.StringValue(cert.Status); status {
+	case acm.CertificateStatusIssued:
+	case acm.CertificateStatusPendingValidation:
+		lsession.Info("certificate-pending-validation")
+		return m.preflightCAA(r, r.GetDomains())
+	default:
+		err := fmt.Errorf("ACM certificate is %s: %s", status, aws.StringValue(cert.FailureReason))
+		lsession.Error("certificate-status", err)
+		r.State = Failed
+		if dbErr := m.db.Save(r).Error; dbErr != nil {
+			return fmt.Errorf("error saving state to db: %s while processing ACM certificate status: %s", dbErr, err)
+		}
+		return err
+	}
+
+	if !m.checkDistribution(r) {
+		lsession.Info("distribution-provisioning")
+		return nil
+	}
+
+	if err := m.cloudFront.SetCertificateAndCname(r.DistId, r.CertificateArn, utils.CertificateSourceAcm, r.GetDomains()); err != nil {
+		lsession.Error("set-certificate-and-cname", err)
+		return err
+	}
+
+	certRow := Certificate{
+		Domain:                  aws.StringValue(cert.DomainName),
+		SerialNumber:            strings.Replace(aws.StringValue(cert.Serial), ":", "", -1),
+		SubjectAlternativeNames: strings.Join(aws.StringValueSlice(cert.SubjectAlternativeNames), ","),
+		KeyAlgorithm:            aws.StringValue(cert.KeyAlgorithm),
+		NotBefore:               aws.TimeValue(cert.NotBefore),
+		Expires:                 aws.TimeValue(cert.NotAfter),
+	}
+	if err := m.db.Create(&certRow).Error; err != nil {
+		lsession.Error("db-create-cert", err)
+		return err
+	}
+
+	r.State = Provisioned
+	r.Certificate = certRow
+	if err := m.db.Save(r).Error; err != nil {
+		lsession.Error("db-save-cert", err)
+		return err
+	}
+	return nil
+}
+
+// upsertAliases points the route's domains in the broker's hosted zones at
+// its distribution, if the broker is configured to manage alias records.
+func (m *RouteManager) upsertAliases(r *Route, domains []string) {
+	m.changeAliases(r, domains, "upsert", m.dns.Route53.UpsertAlias)
+}
+
+// deleteAliases removes the alias records created by upsertAliases.
+func (m *RouteManager) deleteAliases(r *Route, domains []string) {
+	m.changeAliases(r, domains, "delete", m.dns.Route53.DeleteAlias)
+}
+
+func (m *RouteManager) changeAliases(r *Route, domains []string, action string, change func(zoneId, domain, target string) error) {
+	if !m.settings.Route53CreateAlias || r.DomainInternal == "" {
+		return
+	}
+
+	lsession := m.logger.Session("route-manager-"+action+"-aliases", lager.Data{
+		"instance-id": r.InstanceId,
+	})
+
+	for _, domain := range domains {
+		zoneId, err := m.dns.Route53.HostedZone(domain)
+		if err != nil {
+			lsession.Error("hosted-zone", err, lager.Data{"domain": domain})
+			continue
+		}
+		if zoneId == "" {
+			continue
+		}
+		if err := change(zoneId, domain, r.DomainInternal); err != nil {
+			lsession.Error("change-alias", err, lager.Data{
+				"domain":  domain,
+				"zone-id": zoneId,
+			})
+		}
+	}
+}
+
+// removedDomains returns the domains in before that are not in after.
+func removedDomains(before, after []string) []string {
+	var removed []string
+	for _, domain := range before {
+		found := false
+		for _, d := range after {
+			if d == domain {
+				found = true
+				break
+			}
+		}
+		if !found {
+			removed = append(removed, domain)
+		}
+	}
+	return removed
+}
+
 func (m *RouteManager) checkDistribution(r *Route) bool {
 	dist, err := m.cloudFront.Get(r.DistId)
 	if err != nil {
@@ -774,69 +1708,80 @@ func (m *RouteManager) checkDistribution(r *Route) bool {
 	return *dist.Status == "Deployed" && *dist.DistributionConfig.Enabled
 }
 
-func (m *RouteManager) solveChallenges(clients map[acme.Challenge]*acme.Client, challenges []acme.AuthorizationResource) map[string]error {
-	errs := make(chan map[string]error)
+// storeCertificate uploads a route's certificate to the certificate store,
+// replacing the route's current certificate if the store can.
+func (m *RouteManager) storeCertificate(route *Route, cert certificate.Resource) (utils.StoredCertificate, error) {
+	lsession := m.logger.Session("store-certificate", lager.Data{
+		"instance-id": route.InstanceId,
+	})
 
-	for _, client := range clients {
-		go func(client *acme.Client) {
-			errs <- client.SolveChallenges(challenges)
-		}(client)
+	expires, err := utils.GetPEMCertExpiration(cert.Certificate)
+	if err != nil {
+		lsession.Error("get-cert-expiry", err)
+		return utils.StoredCertificate{}, err
 	}
 
-	var failures map[string]error
-	for challenge := range clients {
-		failures = <-errs
-		m.logger.Info("solve-challenges", lager.Data{
-			"challenge": challenge,
-			"failures":  failures,
-		})
-		if len(failures) == 0 {
-			return failures
-		}
-	}
+	name := fmt.Sprintf("cdn-route-%s-%s", route.InstanceId, expires.Format("2006-01-02_15-04-05"))
 
-	return failures
+	m.logger.Info("Uploading certificate", lager.Data{
+		"name":  name,
+		"store": m.certs.Source(),
+	})
+
+	stored, err := m.certs.UploadCertificate(name, cert, route.CertificateArn)
+	if err != nil {
+		lsession.Error("upload-certificate", err)
+		return utils.StoredCertificate{}, err
+	}
+	return stored, nil
 }
 
-func (m *RouteManager) deployCertificate(route Route, cert acme.CertificateResource) error {
+func (m *RouteManager) deployCertificate(route *Route, cert certificate.Resource) error {
 	lsession := m.logger.Session("deploy-certificate", lager.Data{
 		"instance-id": route.InstanceId,
 	})
 
-	expires, err := acme.GetPEMCertExpiration(cert.Certificate)
+	stored, err := m.storeCertificate(route, cert)
 	if err != nil {
-		lsession.Error("get-cert-expiry", err)
+		lsession.Error("store-certificate", err)
 		return err
 	}
 
-	name := fmt.Sprintf("cdn-route-%s-%s", route.InstanceId, expires.Format("2006-01-02_15-04-05"))
-
-	m.logger.Info("Uploading certificate to IAM", lager.Data{"name": name})
+	if err := m.cloudFront.SetCertificateAndCname(route.DistId, stored.Id, m.certs.Source(), route.GetDomains()); err != nil {
+		lsession.Error("set-certificate-and-cname", err)
+		return err
+	}
 
-	certId, err := m.iam.UploadCertificate(name, cert)
-	if err != nil {
-		lsession.Error("iam-upload-certificate", err)
+	route.CertificateArn = stored.Arn
+	if err := m.db.Model(route).UpdateColumn("certificate_arn", route.CertificateArn).Error; err != nil {
+		lsession.Error("db-update-certificate-arn", err)
 		return err
 	}
+	return nil
+}
 
-	return m.cloudFront.SetCertificateAndCname(route.DistId, certId, route.GetDomains())
+// restartOrder replaces the route's invalid order with a new one.
+func (m *RouteManager) restartOrder(r *Route, client *utils.AcmeClient) {
+	r.ChallengeJSON = []byte("")
+	if err := m.ensureChallenges(r, client, true); err != nil {
+		m.logger.Error("restart-order", err, lager.Data{"instance-id": r.InstanceId})
+	}
 }
 
-func (m *RouteManager) ensureChallenges(route *Route, client *acme.Client, update bool) error {
+func (m *RouteManager) ensureChallenges(route *Route, client *utils.AcmeClient, update bool) error {
 	lsession := m.logger.Session("ensure-challenges", lager.Data{
 		"instance-id": route.InstanceId,
 	})
 
-	if len(route.ChallengeJSON) == 0 {
-		challenges, errs := client.GetChallenges(route.GetDomains())
-		if len(errs) > 0 {
-			err := fmt.Errorf("Error(s) getting challenges: %v", errs)
-			lsession.Error("get-challenges", err)
+	if len(route.ChallengeJSON) == 0 || isLegacyChallengeJSON(route.ChallengeJSON) {
+		order, err := client.NewOrder(route.GetDomains())
+		if err != nil {
+			err := fmt.Errorf("Error(s) getting challenges: %w", err)
+			lsession.Error("new-order", err)
 			return err
 		}
 
-		var err error
-		route.ChallengeJSON, err = json.Marshal(challenges)
+		route.ChallengeJSON, err = json.Marshal(order)
 		if err != nil {
 			lsession.Error("json-marshal-challenge", err)
 			return err
@@ -852,37 +1797,3 @@ func (m *RouteManager) ensureChallenges(route *Route, client *acme.Client, updat
 
 	return nil
 }
-
-func (m *RouteManager) GetDNSInstructions(route *Route) ([]string, error) {
-	var instructions []string
-	var challenges []acme.AuthorizationResource
-
-	lsession := m.logger.Session("get-dns-instructions", lager.Data{
-		"instance-id": route.InstanceId,
-	})
-
-	user, err := route.loadUser(m.db)
-	if err != nil {
-		lsession.Error("load-user", err)
-		return instructions, err
-	}
-
-	if err := json.Unmarshal(route.ChallengeJSON, &challenges); err != nil {
-		lsession.Error("json-unmarshal-challenge", err)
-		return instructions, err
-	}
-	for _, auth := range challenges {
-		for _, challenge := range auth.Body.Challenges {
-			if challenge.Type == acme.DNS01 {
-				keyAuth, err := acme.GetKeyAuthorization(challenge.Token, user.GetPrivateKey())
-				if err != nil {
-					lsession.Error("get-key-authorization", err)
-					return instructions, err
-				}
-				fqdn, value, ttl := acme.DNS01Record(auth.Domain, keyAuth)
-				instructions = append(instructions, fmt.Sprintf("name: %s, value: %s, ttl: %d", fqdn, value, ttl))
-			}
-		}
-	}
-	return instructions, nil
-}
@@ -1,23 +1,32 @@
 package models_test
 
 import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net/http"
 	"os"
 	"testing"
 	"time"
 
 	"code.cloudfoundry.org/lager"
+	"github.com/go-acme/lego/v4/acme"
+	"github.com/go-acme/lego/v4/certificate"
 	"github.com/jinzhu/gorm"
-	"github.com/xenolf/lego/acme"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/acm"
 	"github.com/aws/aws-sdk-go/service/cloudfront"
 	"github.com/aws/aws-sdk-go/service/iam"
 
 	"github.com/cloud-gov/cf-cdn-service-broker/config"
 	"github.com/cloud-gov/cf-cdn-service-broker/models"
 	"github.com/cloud-gov/cf-cdn-service-broker/utils"
+	"github.com/cloud-gov/cf-cdn-service-broker/utils/acmetest"
 
 	"github.com/stretchr/testify/mock"
 )
@@ -29,19 +38,53 @@ type MockUtilsIam struct {
 	Service  *iam.IAM
 }
 
-// test doesn't execute this method
-func (_f MockUtilsIam) UploadCertificate(name string, cert acme.CertificateResource) (string, error) {
-	return "", nil
+func (_f *MockUtilsIam) Source() string {
+	return utils.CertificateSourceIam
+}
+
+func (_f *MockUtilsIam) UploadCertificate(name string, cert certificate.Resource, arn string) (utils.StoredCertificate, error) {
+	args := _f.Called(name, cert, arn)
+	return args.Get(0).(utils.StoredCertificate), args.Error(1)
 }
 
 // don't mock this method
-func (_f MockUtilsIam) ListCertificates(callback func(iam.ServerCertificateMetadata) bool) error {
+func (_f *MockUtilsIam) ListCertificates(callback func(utils.StoredCertificate) bool) error {
 	orig := &utils.Iam{Settings: _f.Settings, Service: _f.Service}
 	return orig.ListCertificates(callback)
 }
 
-func (_f MockUtilsIam) DeleteCertificate(certName string) error {
-	args := _f.Called(certName)
+func (_f *MockUtilsIam) DeleteCertificate(cert utils.StoredCertificate) error {
+	args := _f.Called(cert.Name)
+	return args.Error(0)
+}
+
+type MockUtilsAcmIssuer struct {
+	mock.Mock
+
+	Certificates []acm.CertificateDetail
+}
+
+func (_f *MockUtilsAcmIssuer) RequestCertificate(instanceId string, domains []string) (string, error) {
+	args := _f.Called(instanceId, domains)
+	return args.String(0), args.Error(1)
+}
+
+// test doesn't execute this method
+func (_f *MockUtilsAcmIssuer) DescribeCertificate(arn string) (*acm.CertificateDetail, error) {
+	return nil, nil
+}
+
+func (_f *MockUtilsAcmIssuer) ListCertificates(callback func(acm.CertificateDetail) bool) error {
+	for _, cert := range _f.Certificates {
+		if !callback(cert) {
+			break
+		}
+	}
+	return nil
+}
+
+func (_f *MockUtilsAcmIssuer) DeleteCertificate(arn string) error {
+	args := _f.Called(arn)
 	return args.Error(0)
 }
 
@@ -119,6 +162,12 @@ func TestDeleteOrphanedCerts(t *testing.T) {
 						IAMCertificateId: aws.String("some-other-active-certificate"),
 					},
 				},
+				&cloudfront.DistributionSummary{
+					ARN: aws.String("some-acm-distribution"),
+					ViewerCertificate: &cloudfront.ViewerCertificate{
+						ACMCertificateArn: aws.String("an-active-acm-certificate"),
+					},
+				},
 			}
 
 			data := r.Data.(*cloudfront.ListDistributionsOutput)
@@ -137,12 +186,68 @@ func TestDeleteOrphanedCerts(t *testing.T) {
 	mui.On("DeleteCertificate", "some-orphaned-cert").Return(nil)
 	mui.On("DeleteCertificate", "some-other-orphaned-cert").Return(nil)
 
+	old := time.Now().AddDate(0, 0, -2)
+	stale := time.Now().AddDate(0, 0, -4)
+	recent := time.Now().Add(-time.Hour)
+	mua := new(MockUtilsAcmIssuer)
+	mua.Certificates = []acm.CertificateDetail{
+		{
+			CertificateArn: aws.String("an-active-acm-certificate"),
+			Status:         aws.String(acm.CertificateStatusIssued),
+			IssuedAt:       &old,
+		},
+		{
+			CertificateArn: aws.String("a-pending-acm-certificate"),
+			Status:         aws.String(acm.CertificateStatusPendingValidation),
+			CreatedAt:      &recent,
+		},
+		{
+			CertificateArn: aws.String("a-requested-acm-certificate"),
+			Status:         aws.String(acm.CertificateStatusPendingValidation),
+			CreatedAt:      &stale,
+		},
+		{
+			CertificateArn: aws.String("an-abandoned-acm-certificate"),
+			Status:         aws.String(acm.CertificateStatusPendingValidation),
+			CreatedAt:      &stale,
+		},
+		{
+			CertificateArn: aws.String("a-timed-out-acm-certificate"),
+			Status:         aws.String(acm.CertificateStatusValidationTimedOut),
+			CreatedAt:      &stale,
+		},
+		{
+			CertificateArn: aws.String("an-orphaned-acm-certificate"),
+			Status:         aws.String(acm.CertificateStatusIssued),
+			IssuedAt:       &old,
+		},
+	}
+
+	// expect the orphaned ACM cert, and the stale ones no route is waiting
+	// on, to be deleted
+	mua.On("DeleteCertificate", "an-orphaned-acm-certificate").Return(nil)
+	mua.On("DeleteCertificate", "an-abandoned-acm-certificate").Return(nil)
+	mua.On("DeleteCertificate", "a-timed-out-acm-certificate").Return(nil)
+
+	db := newTestDB(t)
+	if err := db.Create(&models.Route{
+		InstanceId:          "123",
+		State:               models.Provisioning,
+		CertificateProvider: models.CertificateProviderAcm,
+		CertificateArn:      "a-requested-acm-certificate",
+	}).Error; err != nil {
+		t.Fatal(err)
+	}
+
 	m := models.NewManager(
 		logger,
 		mui,
-		&utils.Distribution{settings, fakecf},
+		&utils.Distribution{Settings: settings, Service: fakecf},
+		mua,
+		&utils.DNSProviders{Route53: &utils.Route53{Settings: settings}},
+		&utils.Encryptor{},
 		settings,
-		&gorm.DB{},
+		db,
 	)
 
 	//run the test
@@ -150,5 +255,556 @@ func TestDeleteOrphanedCerts(t *testing.T) {
 
 	//check our expectations
 	mui.AssertExpectations(t)
+	mua.AssertExpectations(t)
+
+}
+
+// newTestDB returns an in-memory database with the broker's tables.
+func newTestDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := db.AutoMigrate(&models.Route{}, &models.Certificate{}, &models.UserData{}, &models.RenewalAttempt{}, &models.RenewalWindow{}, &models.DomainAuthorization{}).Error; err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// newTestManager returns a route manager backed by an in-memory database and a
+// fake CloudFront whose distributions deploy at once. The distribution configs
+// sent to CloudFront are appended to configs.
+func newTestManager(t *testing.T, settings config.Settings, certs utils.CertificateStoreIface, acm utils.AcmIssuerIface, configs *[]*cloudfront.DistributionConfig) (models.RouteManager, *gorm.DB) {
+	db := newTestDB(t)
+
+	fakecf := cloudfront.New(session.New(aws.NewConfig().WithRegion("us-east-1")))
+	fakecf.Handlers.Clear()
+	fakecf.Handlers.Send.PushBack(func(r *request.Request) {
+		switch input := r.Params.(type) {
+		case *cloudfront.CreateDistributionWithTagsInput:
+			*configs = append(*configs, input.DistributionConfigWithTags.DistributionConfig)
+			*r.Data.(*cloudfront.CreateDistributionWithTagsOutput) = cloudfront.CreateDistributionWithTagsOutput{
+				Distribution: &cloudfront.Distribution{Id: aws.String("dist-1"), DomainName: aws.String("abc.cloudfront.net")},
+			}
+		case *cloudfront.GetDistributionConfigInput:
+			current := &cloudfront.DistributionConfig{CallerReference: aws.String("123")}
+			if len(*configs) > 0 {
+				current = (*configs)[len(*configs)-1]
+			}
+			if current.ViewerCertificate == nil {
+				current.ViewerCertificate = &cloudfront.ViewerCertificate{}
+			}
+			*r.Data.(*cloudfront.GetDistributionConfigOutput) = cloudfront.GetDistributionConfigOutput{
+				DistributionConfig: current,
+				ETag:               aws.String("etag"),
+			}
+		case *cloudfront.UpdateDistributionInput:
+			*configs = append(*configs, input.DistributionConfig)
+			*r.Data.(*cloudfront.UpdateDistributionOutput) = cloudfront.UpdateDistributionOutput{
+				Distribution: &cloudfront.Distribution{Id: input.Id, DomainName: aws.String("abc.cloudfront.net")},
+			}
+		case *cloudfront.GetDistributionInput:
+			current := &cloudfront.DistributionConfig{Enabled: aws.Bool(true)}
+			if len(*configs) > 0 {
+				current = (*configs)[len(*configs)-1]
+			}
+			*r.Data.(*cloudfront.GetDistributionOutput) = cloudfront.GetDistributionOutput{
+				Distribution: &cloudfront.Distribution{
+					Id:                 input.Id,
+					Status:             aws.String("Deployed"),
+					DistributionConfig: current,
+				},
+			}
+		}
+	})
+
+	dns, err := utils.NewDNSProviders(settings, &utils.Route53{Settings: settings})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger := lager.NewLogger("models-test")
+	m := models.NewManager(
+		logger,
+		certs,
+		&utils.Distribution{Settings: settings, Service: fakecf},
+		acm,
+		dns,
+		&utils.Encryptor{},
+		settings,
+		db,
+	)
+	return m, db
+}
+
+func TestUpdateOriginKeepsCertificate(t *testing.T) {
+	updates := []*cloudfront.DistributionConfig{}
+	m, db := newTestManager(t, config.Settings{Bucket: "acme-bucket"}, new(MockUtilsIam), new(MockUtilsAcmIssuer), &updates)
+
+	// The route's order was finalized when its certificate was issued.
+	order := []byte(`{"status": "valid", "domains": ["agency.gov"], "authorizations": [{"status": "valid", "identifier": {"type": "dns", "value": "agency.gov"}}]}`)
+	route := models.Route{
+		InstanceId:          "123",
+		State:               models.Provisioned,
+		DomainExternal:      "agency.gov",
+		DistId:              "dist-1",
+		Origin:              "origin.cloud.gov",
+		CertificateProvider: models.CertificateProviderLetsEncrypt,
+		ChallengeJSON:       order,
+	}
+	if err := db.Create(&route).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Update("123", "", "new-origin.cloud.gov", "", false, utils.Headers{}, utils.Headers{}, true, nil, nil, nil, nil, nil, ""); err != nil {
+		t.Fatal(err)
+	}
+	if len(updates) != 1 || *updates[0].Origins.Items[0].DomainName != "new-origin.cloud.gov" {
+		t.Fatalf("expected the distribution to move to the new origin, got %v", updates)
+	}
+
+	updated, err := m.Get("123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.State != models.Provisioned || updated.Origin != "new-origin.cloud.gov" {
+		t.Errorf("expected the route to stay provisioned with its new origin, got %s, %s", updated.State, updated.Origin)
+	}
+
+	// Polling doesn't finalize the old order again.
+	if err := m.Poll(updated); err != nil {
+		t.Errorf("expected polling to succeed, got %v", err)
+	}
+	if updated.State != models.Provisioned || string(updated.ChallengeJSON) != string(order) {
+		t.Errorf("expected polling to leave the route as it was, got %s", updated.State)
+	}
+}
+
+// selfSignedCertificate returns a customer's certificate for domain.
+func selfSignedCertificate(t *testing.T, domain string) certificate.Resource {
+	cert, _, err := acmetest.SelfSigned(&x509.Certificate{
+		SerialNumber: big.NewInt(0xabc),
+		Subject:      pkix.Name{CommonName: domain},
+		DNSNames:     []string{domain},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certificate.Resource{Domain: domain, Certificate: cert}
+}
+
+func TestCreateCustomCertificate(t *testing.T) {
+	cert := selfSignedCertificate(t, "agency.gov")
+	certs := new(MockUtilsIam)
+	certs.On("UploadCertificate", mock.Anything, cert, "").
+		Return(utils.StoredCertificate{Id: "cert-1", Arn: "arn:cert-1"}, nil).Once()
+	configs := []*cloudfront.DistributionConfig{}
+	m, db := newTestManager(t, config.Settings{Bucket: "acme-bucket", CertificateKeyType: "RSA_2048"}, certs, new(MockUtilsAcmIssuer), &configs)
+
+	route, err := m.Create("123", "agency.gov", "origin.cloud.gov", "", false, utils.Headers{}, utils.Headers{}, true, nil, nil, nil, nil, nil, models.CertificateProviderCustom, "", "", &cert, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(configs) != 2 || *configs[1].ViewerCertificate.IAMCertificateId != "cert-1" {
+		t.Fatalf("expected the distribution to serve the certificate, got %v", configs)
+	}
+
+	created, err := m.Get("123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.CertificateArn != "arn:cert-1" {
+		t.Errorf("expected the route to record its certificate, got %q", created.CertificateArn)
+	}
+	var certRow models.Certificate
+	if err := db.Model(created).Related(&certRow, "Certificate").Error; err != nil || certRow.SerialNumber != "abc" {
+		t.Errorf("expected the certificate to be saved with the route, got %v", err)
+	}
+	if route.State != models.Provisioning {
+		t.Errorf("expected the route to provision, got %s", route.State)
+	}
+}
+
+func TestCreateCustomCertificateRejected(t *testing.T) {
+	cert := selfSignedCertificate(t, "agency.gov")
+	certs := new(MockUtilsIam)
+	certs.On("UploadCertificate", mock.Anything, cert, "").
+		Return(utils.StoredCertificate{}, errors.New("MalformedCertificate"))
+	configs := []*cloudfront.DistributionConfig{}
+	m, _ := newTestManager(t, config.Settings{Bucket: "acme-bucket", CertificateKeyType: "RSA_2048"}, certs, new(MockUtilsAcmIssuer), &configs)
+
+	if _, err := m.Create("123", "agency.gov", "origin.cloud.gov", "", false, utils.Headers{}, utils.Headers{}, true, nil, nil, nil, nil, nil, models.CertificateProviderCustom, "", "", &cert, nil); err == nil {
+		t.Fatal("expected the rejected certificate to fail the create")
+	}
+	if len(configs) != 0 {
+		t.Errorf("expected no distribution to be created, got %v", configs)
+	}
+
+	// Nothing is left behind, so the instance can be provisioned again.
+	if _, err := m.Get("123"); err == nil {
+		t.Error("expected no route to be saved")
+	}
+}
+
+func TestCreateAcmReleasesCertificate(t *testing.T) {
+	acmIssuer := new(MockUtilsAcmIssuer)
+	acmIssuer.On("RequestCertificate", "123", []string{"agency.gov"}).Return("arn:acm:123", nil)
+	acmIssuer.On("DeleteCertificate", "arn:acm:123").Return(nil)
+	configs := []*cloudfront.DistributionConfig{}
+	m, db := newTestManager(t, config.Settings{Bucket: "acme-bucket", CertificateKeyType: "RSA_2048"}, new(MockUtilsIam), acmIssuer, &configs)
+
+	// A route left over with the same instance id fails the save.
+	if err := db.Create(&models.Route{InstanceId: "123", State: models.Deprovisioned}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := m.Create("123", "agency.gov", "origin.cloud.gov", "", false, utils.Headers{}, utils.Headers{}, true, nil, nil, nil, nil, nil, models.CertificateProviderAcm, "", "", nil, nil); err == nil {
+		t.Fatal("expected the create to fail")
+	}
+	acmIssuer.AssertExpectations(t)
+}
+
+// newTestCA returns a fake CA that registers accounts, but answers every new
+// order with problem.
+func newTestCA(t *testing.T, problem acme.ProblemDetails) *acmetest.CA {
+	ca := acmetest.NewCA()
+	t.Cleanup(ca.Close)
+	ca.NewOrder = func(w http.ResponseWriter, r *http.Request) {
+		acmetest.WriteProblem(w, problem)
+	}
+	return ca
+}
+
+func TestCreateAccountError(t *testing.T) {
+	ca := newTestCA(t, acme.ProblemDetails{
+		Type:       "urn:ietf:params:acme:error:accountDoesNotExist",
+		HTTPStatus: http.StatusBadRequest,
+	})
+	configs := []*cloudfront.DistributionConfig{}
+	m, db := newTestManager(t, config.Settings{
+		Bucket:             "acme-bucket",
+		AcmeUrl:            ca.DirectoryURL(),
+		AcmeMaxAccounts:    10,
+		CertificateKeyType: "RSA_2048",
+	}, new(MockUtilsIam), new(MockUtilsAcmIssuer), &configs)
+
+	_, err := m.Create("123", "agency.gov", "origin.cloud.gov", "", false, utils.Headers{}, utils.Headers{}, true, nil, nil, nil, nil, nil, models.CertificateProviderLetsEncrypt, "", "", nil, nil)
+	if !utils.IsAccountError(err) {
+		t.Fatalf("expected an account error, got %v", err)
+	}
+
+	// The account is retired, so that the next instance registers another.
+	var account models.UserData
+	if err := db.First(&account).Error; err != nil {
+		t.Fatal(err)
+	}
+	if !account.Deactivated {
+		t.Error("expected the account to be deactivated")
+	}
+	if len(configs) != 0 {
+		t.Errorf("expected no distribution to be created, got %v", configs)
+	}
+}
+
+func TestRenewalAttemptBackoff(t *testing.T) {
+	settings := config.Settings{RenewBackoffBase: time.Hour, RenewBackoffMax: 24 * time.Hour}
+	now := time.Now()
+	attempt := models.RenewalAttempt{}
+
+	if !attempt.Due(now) {
+		t.Error("expected a route without failed renewals to be due")
+	}
+
+	for _, expected := range []time.Duration{1, 2, 4, 8, 16, 24, 24} {
+		attempt.Fail(settings, errors.New("canary check failed"), now)
+		if attempt.NextAttemptAt != now.Add(expected*time.Hour) {
+			t.Errorf("expected attempt %d after %dh, got %s", attempt.Attempts, expected, attempt.NextAttemptAt.Sub(now))
+		}
+		if attempt.Due(now.Add(expected*time.Hour - time.Minute)) {
+			t.Errorf("expected attempt %d to back off", attempt.Attempts)
+		}
+	}
+	if attempt.LastError != "canary check failed" {
+		t.Errorf("unexpected last error %q", attempt.LastError)
+	}
+
+	attempt.Succeed(now)
+	if attempt.Attempts != 0 || attempt.LastError != "" || !attempt.Due(now) {
+		t.Errorf("expected a successful renewal to reset backoff, got %+v", attempt)
+	}
+}
+
+func TestMigrateCertificatesReimportsStoredKey(t *testing.T) {
+	// The distributions use ACM certificates, to be moved to IAM.
+	configs := []*cloudfront.DistributionConfig{{
+		CallerReference:   aws.String("123"),
+		Enabled:           aws.Bool(true),
+		ViewerCertificate: &cloudfront.ViewerCertificate{CertificateSource: aws.String(utils.CertificateSourceAcm)},
+	}}
+
+	cert, key, err := acmetest.SelfSigned(&x509.Certificate{DNSNames: []string{"agency.gov"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := certificate.Resource{Domain: "agency.gov", PrivateKey: key, Certificate: cert}
+	certs := new(MockUtilsIam)
+	certs.On("UploadCertificate", mock.Anything, expected, "arn:acm:123").
+		Return(utils.StoredCertificate{Id: "cert-1", Arn: "arn:cert-1"}, nil).Once()
+
+	m, db := newTestManager(t, config.Settings{Bucket: "acme-bucket"}, certs, new(MockUtilsAcmIssuer), &configs)
+
+	// Only the second route's private key is stored. The first route's
+	// certificate is renewed instead, which fails its canary check without
+	// an S3 region. Both share the fake distribution, so the route that
+	// moves it to IAM goes last.
+	routes := []models.Route{
+		{
+			InstanceId:     "456",
+			DomainExternal: "www.agency.gov",
+			Certificate:    models.Certificate{Domain: "www.agency.gov", Expires: time.Now().Add(24 * time.Hour)},
+		},
+		{
+			InstanceId:     "123",
+			DomainExternal: "agency.gov",
+			CertificateArn: "arn:acm:123",
+			Certificate: models.Certificate{
+				Domain:      "agency.gov",
+				Certificate: cert,
+				PrivateKey:  key,
+				Expires:     time.Now().Add(24 * time.Hour),
+			},
+		},
+	}
+	for _, route := range routes {
+		route.State = models.Provisioned
+		route.DistId = "dist-1"
+		route.CertificateProvider = models.CertificateProviderLetsEncrypt
+		if err := db.Create(&route).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := m.MigrateCertificates(0, false); err != nil {
+		t.Fatal(err)
+	}
+	certs.AssertExpectations(t)
+
+	migrated, err := m.Get("123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if migrated.CertificateArn != "arn:cert-1" {
+		t.Errorf("expected the route to use the imported certificate, got %s", migrated.CertificateArn)
+	}
+	if *configs[len(configs)-1].ViewerCertificate.IAMCertificateId != "cert-1" {
+		t.Errorf("expected the distribution to use the imported certificate, got %v", configs[len(configs)-1].ViewerCertificate)
+	}
+
+	attempt := models.RenewalAttempt{}
+	if err := db.Joins("JOIN routes ON routes.id = renewal_attempts.route_id").Where("routes.instance_id = ?", "456").First(&attempt).Error; err != nil {
+		t.Errorf("expected the route without a stored key to be renewed, got %v", err)
+	}
+}
+
+func TestRenewalsThrottledPerAttempt(t *testing.T) {
+	configs := []*cloudfront.DistributionConfig{}
+	m, db := newTestManager(t, config.Settings{
+		Bucket:                  "acme-bucket",
+		RenewBackoffBase:        time.Nanosecond,
+		RenewBackoffMax:         time.Nanosecond,
+		RenewMaxAttemptsPerHour: 2,
+	}, new(MockUtilsIam), new(MockUtilsAcmIssuer), &configs)
+
+	// The route backs off for so little time that it's due again on every
+	// run, but the canary check fails without an S3 region.
+	renewAt := time.Now().Add(-time.Hour)
+	route := models.Route{
+		InstanceId:          "123",
+		State:               models.Provisioned,
+		DomainExternal:      "agency.gov",
+		CertificateProvider: models.CertificateProviderLetsEncrypt,
+		Certificate:         models.Certificate{Expires: time.Now().Add(24 * time.Hour), RenewAt: &renewAt},
+	}
+	if err := db.Create(&route).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		m.RenewAll()
+		time.Sleep(time.Millisecond)
+	}
+
+	attempt := models.RenewalAttempt{}
+	if err := db.Where(models.RenewalAttempt{RouteId: route.ID}).First(&attempt).Error; err != nil {
+		t.Fatal(err)
+	}
+	if attempt.Attempts != 2 {
+		t.Errorf("expected 2 attempts this hour, got %d", attempt.Attempts)
+	}
+}
+
+func TestDomainAuthorizationAttempted(t *testing.T) {
+	now := time.Now()
+	auth := models.DomainAuthorization{Domain: "agency.gov", Status: "pending"}
+	authz := utils.Authorization{Authorization: acme.Authorization{
+		Status: acme.StatusPending,
+		Challenges: []acme.Challenge{
+			{Type: "http-01", Status: acme.StatusPending},
+			{Type: "dns-01", Status: acme.StatusPending},
+		},
+	}}
+
+	auth.Attempted(authz, errors.New("dns-01: NXDOMAIN"), now)
+	if auth.Attempts != 1 || auth.LastError != "dns-01: NXDOMAIN" || auth.ChallengeType != "" {
+		t.Errorf("expected a failed attempt to be recorded, got %+v", auth)
+	}
+
+	authz.Status = acme.StatusValid
+	authz.Challenges[0].Status = acme.StatusValid
+	auth.Attempted(authz, nil, now)
+	if auth.Attempts != 2 || auth.LastError != "" || auth.Status != "valid" || auth.ChallengeType != "http-01" {
+		t.Errorf("expected a successful attempt to be recorded, got %+v", auth)
+	}
+}
+
+func TestCrossedThreshold(t *testing.T) {
+	now := time.Now()
+	thresholds := []int{21, 14, 7, 3}
+
+	for days, expected := range map[int]int{30: 0, 21: 21, 20: 21, 10: 14, 7: 7, 5: 7, 1: 3, -1: 3} {
+		expires := now.Add(time.Duration(days) * 24 * time.Hour)
+		if threshold := models.CrossedThreshold(thresholds, expires, now); threshold != expected {
+			t.Errorf("expected a certificate expiring in %d days to cross %d, got %d", days, expected, threshold)
+		}
+	}
+}
+
+func TestFindings(t *testing.T) {
+	probe := utils.TLSProbe{
+		Domain:     "cdn.cloud.gov",
+		Leaf:       &x509.Certificate{SerialNumber: big.NewInt(0xabc)},
+		OCSPStatus: utils.OCSPGood,
+	}
+	route := models.Route{
+		CertificateProvider: models.CertificateProviderLetsEncrypt,
+		Certificate:         models.Certificate{Model: gorm.Model{ID: 1}, SerialNumber: "abc"},
+	}
+
+	if findings := models.Findings(probe, nil, route); len(findings) != 0 {
+		t.Errorf("expected no findings for the stored certificate, got %v", findings)
+	}
+
+	// Serials from ACM keep their leading zeros.
+	route.Certificate.SerialNumber = "0abc"
+	if findings := models.Findings(probe, nil, route); len(findings) != 0 {
+		t.Errorf("expected leading zeros not to matter, got %v", findings)
+	}
+
+	// ACM renews its certificates without the stored one changing.
+	route.CertificateProvider = models.CertificateProviderAcm
+	route.Certificate.SerialNumber = "def"
+	if findings := models.Findings(probe, nil, route); len(findings) != 0 {
+		t.Errorf("expected no findings for a renewed ACM certificate, got %v", findings)
+	}
+
+	route.CertificateProvider = models.CertificateProviderLetsEncrypt
+	probe.ChainError = errors.New("x509: certificate signed by unknown authority")
+	probe.OCSPStatus = utils.OCSPRevoked
+	findings := models.Findings(probe, nil, route)
+	for _, finding := range []string{models.FindingCertificateMismatch, models.FindingChainInvalid, models.FindingOCSPStatus} {
+		if _, ok := findings[finding]; !ok {
+			t.Errorf("expected a %s finding, got %v", finding, findings)
+		}
+	}
+
+	findings = models.Findings(utils.TLSProbe{}, errors.New("connection refused"), route)
+	if len(findings) != 1 || findings[models.FindingHandshakeFailed] != "connection refused" {
+		t.Errorf("expected only a failed handshake, got %v", findings)
+	}
+}
+
+func TestRouteCacheBehaviors(t *testing.T) {
+	route := models.Route{}
+	if behaviors, err := route.GetCacheBehaviors(); err != nil || len(behaviors) != 0 {
+		t.Errorf("expected no cache behaviors, got %v, %v", behaviors, err)
+	}
+
+	route.CacheBehaviorsJSON = []byte(`[{"path_pattern": "/api/*", "max_ttl": 60, "allowed_methods": ["HEAD", "GET"]}]`)
+	behaviors, err := route.GetCacheBehaviors()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(behaviors) != 1 || behaviors[0].PathPattern != "/api/*" || behaviors[0].MaxTTL != 60 || len(behaviors[0].AllowedMethods) != 2 {
+		t.Errorf("expected the stored cache behavior, got %+v", behaviors)
+	}
+}
+
+func TestRouteResponseHeaders(t *testing.T) {
+	route := models.Route{}
+	if headers, err := route.GetResponseHeaders(); err != nil || headers != nil {
+		t.Errorf("expected no response headers, got %v, %v", headers, err)
+	}
+
+	route.ResponseHeadersJSON = []byte(`{"frame_options": "DENY", "custom_headers": {"X-Agency": "gsa"}}`)
+	headers, err := route.GetResponseHeaders()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if headers.FrameOptions != "DENY" || headers.CustomHeaders["X-Agency"] != "gsa" {
+		t.Errorf("expected the stored response headers, got %+v", headers)
+	}
+}
+
+func TestRouteErrorResponses(t *testing.T) {
+	route := models.Route{}
+	if responses, err := route.GetErrorResponses(); err != nil || len(responses) != 0 {
+		t.Errorf("expected no error responses, got %v, %v", responses, err)
+	}
+
+	route.ErrorResponsesJSON = []byte(`[{"error_code": 404, "response_code": 200, "response_page_path": "/index.html", "caching_min_ttl": 0}]`)
+	responses, err := route.GetErrorResponses()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(responses) != 1 || responses[0].ErrorCode != 404 || responses[0].ResponsePagePath != "/index.html" || *responses[0].CachingMinTTL != 0 {
+		t.Errorf("expected the stored error response, got %+v", responses)
+	}
+}
+
+func TestCertificateResource(t *testing.T) {
+	provider, err := utils.NewLocalKeyProvider("1", []byte("0123456789abcdef0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := utils.NewEncryptor(provider)
+
+	encrypted, version, err := keys.Encrypt([]byte("private key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := models.Certificate{
+		Domain:            "agency.gov",
+		Certificate:       []byte("certificate"),
+		IssuerCertificate: []byte("issuer"),
+		PrivateKey:        encrypted,
+		PrivateKeyVersion: version,
+	}
+
+	resource, err := cert.Resource(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(resource.PrivateKey) != "private key" || string(resource.IssuerCertificate) != "issuer" {
+		t.Errorf("unexpected resource %+v", resource)
+	}
+
+	if _, err := cert.Resource(nil); err == nil {
+		t.Error("expected an error without the key encryption key")
+	}
 
+	cert.PrivateKey = nil
+	if _, err := cert.Resource(keys); err == nil {
+		t.Error("expected an error without a stored private key")
+	}
 }
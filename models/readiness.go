@@ -0,0 +1,152 @@
+package models
+
+import (
+	"encoding/json"
+	"strings"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/go-acme/lego/v4/acme"
+	"github.com/go-acme/lego/v4/challenge"
+	"github.com/go-acme/lego/v4/challenge/dns01"
+
+	"github.com/cloud-gov/cf-cdn-service-broker/utils"
+)
+
+// domainValidation is how the CA validates one of a route's domains: by a
+// record the customer creates, by a record the broker writes itself, or not
+// at all if the domain has already been validated.
+type domainValidation struct {
+	record    *utils.ValidationRecord
+	automated bool
+	valid     bool
+}
+
+// GetDomainStatus reports, for each of a provisioning route's domains,
+// whether it is CNAME'd or ALIAS'd to the route's distribution, and whether
+// the record validating its certificate exists.
+func (m *RouteManager) GetDomainStatus(route *Route) ([]utils.DomainStatus, error) {
+	lsession := m.logger.Session("get-domain-status", lager.Data{
+		"instance-id": route.InstanceId,
+	})
+
+	validations, err := m.domainValidations(route)
+	if err != nil {
+		lsession.Error("domain-validations", err)
+		return nil, err
+	}
+
+	statuses := []utils.DomainStatus{}
+	for _, domain := range route.GetDomains() {
+		validation := validations[domain]
+		if validation.valid {
+			statuses = append(statuses, utils.DomainStatus{
+				Domain: domain,
+				Status: utils.DomainReady,
+				Found:  "validated",
+			})
+			continue
+		}
+
+		// ACM only validates over DNS, as does Let's Encrypt for wildcards, so
+		// their records are always needed. Otherwise Let's Encrypt validates
+		// over HTTP once the domain points at the distribution.
+		recordRequired := route.CertificateProvider == CertificateProviderAcm || strings.HasPrefix(domain, "*.")
+
+		status := m.readiness.Check(domain, route.DomainInternal, validation.record, recordRequired)
+		if validation.automated && status.Status != utils.DomainReady {
+			status.Status = utils.DomainReady
+			status.Found = "validation record managed by the broker"
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// domainValidations returns the validation of each of a route's domains
+// that the CA is waiting on, keyed by domain.
+func (m *RouteManager) domainValidations(route *Route) (map[string]domainValidation, error) {
+	validations := map[string]domainValidation{}
+
+	switch route.CertificateProvider {
+	case CertificateProviderAcm:
+		return m.acmDomainValidations(route)
+	case CertificateProviderCustom:
+		// Routes with their own certificates only wait on their distributions.
+		return validations, nil
+	}
+
+	// Routes still holding ACME v1 challenges get a new order on the next poll.
+	if len(route.ChallengeJSON) == 0 || isLegacyChallengeJSON(route.ChallengeJSON) {
+		return validations, nil
+	}
+
+	var order utils.Order
+	if err := json.Unmarshal(route.ChallengeJSON, &order); err != nil {
+		return nil, err
+	}
+
+	user, err := route.loadUser(m.db, m.keys)
+	if err != nil {
+		return nil, err
+	}
+	dnsProvider, err := m.dns.Get(route.DNSProvider)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, auth := range order.Authorizations {
+		domain := auth.Domain()
+		if auth.Status == acme.StatusValid {
+			validations[domain] = domainValidation{valid: true}
+			continue
+		}
+		if dnsProvider.Automated(auth.Identifier.Value) {
+			validations[domain] = domainValidation{automated: true}
+			continue
+		}
+		for _, chlg := range auth.Challenges {
+			if chlg.Type != string(challenge.DNS01) {
+				continue
+			}
+			keyAuth, err := utils.GetKeyAuthorization(chlg.Token, user.GetPrivateKey())
+			if err != nil {
+				return nil, err
+			}
+			fqdn, value := dns01.GetRecord(auth.Identifier.Value, keyAuth)
+			validations[domain] = domainValidation{record: &utils.ValidationRecord{
+				Name:  fqdn,
+				Type:  "TXT",
+				Value: value,
+			}}
+		}
+	}
+	return validations, nil
+}
+
+// acmDomainValidations returns the CNAME records that ACM validates a route's
+// certificate with. ACM adds the records to the certificate shortly after it
+// is requested.
+func (m *RouteManager) acmDomainValidations(route *Route) (map[string]domainValidation, error) {
+	cert, err := m.acm.DescribeCertificate(route.CertificateArn)
+	if err != nil {
+		return nil, err
+	}
+
+	validations := map[string]domainValidation{}
+	for _, validation := range cert.DomainValidationOptions {
+		record := validation.ResourceRecord
+		if record == nil {
+			continue
+		}
+		validations[aws.StringValue(validation.DomainName)] = domainValidation{
+			valid: aws.StringValue(validation.ValidationStatus) == "SUCCESS",
+			record: &utils.ValidationRecord{
+				Name:  aws.StringValue(record.Name),
+				Type:  aws.StringValue(record.Type),
+				Value: aws.StringValue(record.Value),
+			},
+		}
+	}
+	return validations, nil
+}
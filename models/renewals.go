@@ -0,0 +1,190 @@
+package models
+
+import (
+	"sort"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/jinzhu/gorm"
+
+	"github.com/cloud-gov/cf-cdn-service-broker/config"
+)
+
+// renewalAttemptWindow is the window that RenewMaxAttemptsPerHour applies to.
+const renewalAttemptWindow = time.Hour
+
+// RenewalWindow counts the renewals attempted in the hour from StartsAt,
+// across all routes, so that RenewMaxAttemptsPerHour limits attempts rather
+// than the routes attempted.
+type RenewalWindow struct {
+	gorm.Model
+	StartsAt time.Time `gorm:"not null;unique_index"`
+	Attempts int
+}
+
+// RenewalAttempt records the renewals of a route's certificate, so that a
+// failing renewal backs off instead of being retried on every run. Attempts
+// counts the failures since the last successful renewal.
+type RenewalAttempt struct {
+	gorm.Model
+	RouteId       uint `gorm:"not null;unique_index"`
+	Attempts      int
+	LastError     string
+	LastAttemptAt time.Time `gorm:"index"`
+	NextAttemptAt time.Time
+}
+
+// Due reports whether the route's certificate may be renewed again.
+func (a *RenewalAttempt) Due(now time.Time) bool {
+	return !a.NextAttemptAt.After(now)
+}
+
+// Succeed records a successful renewal.
+func (a *RenewalAttempt) Succeed(now time.Time) {
+	a.Attempts = 0
+	a.LastError = ""
+	a.LastAttemptAt = now
+	a.NextAttemptAt = time.Time{}
+}
+
+// Fail records a failed renewal. The next attempt waits RenewBackoffBase,
+// doubled for each consecutive failure, up to RenewBackoffMax.
+func (a *RenewalAttempt) Fail(settings config.Settings, err error, now time.Time) {
+	a.Attempts++
+	a.LastError = err.Error()
+	a.LastAttemptAt = now
+
+	backoff := settings.RenewBackoffBase
+	for i := 1; i < a.Attempts && backoff < settings.RenewBackoffMax; i++ {
+		backoff *= 2
+	}
+	if backoff > settings.RenewBackoffMax {
+		backoff = settings.RenewBackoffMax
+	}
+	a.NextAttemptAt = now.Add(backoff)
+}
+
+// recordRenewalAttempt saves the outcome of renewing the route's certificate.
+// If the renewal failed and the certificate is close to expiry, the route is
+// marked renewal-at-risk until a renewal succeeds.
+func (m *RouteManager) recordRenewalAttempt(r *Route, renewErr error) {
+	lsession := m.logger.Session("route-manager-record-renewal-attempt", lager.Data{
+		"instance-id": r.InstanceId,
+	})
+
+	now := time.Now()
+
+	var attempt RenewalAttempt
+	if err := m.db.Where(RenewalAttempt{RouteId: r.ID}).FirstOrInit(&attempt).Error; err != nil {
+		lsession.Error("db-find-renewal-attempt", err)
+		return
+	}
+
+	state := r.State
+	if renewErr == nil {
+		attempt.Succeed(now)
+		if r.State == RenewalAtRisk {
+			state = Provisioned
+		}
+	} else {
+		attempt.Fail(m.settings, renewErr, now)
+
+		var certRow Certificate
+		if err := m.db.Model(r).Related(&certRow, "Certificate").Error; err != nil {
+			lsession.Error("db-find-related-cert", err)
+		} else if certRow.Expires.Sub(now) < m.settings.RenewAtRiskBefore {
+			state = RenewalAtRisk
+			lsession.Error("renewal-at-risk", renewErr, lager.Data{
+				"domain":   r.DomainExternal,
+				"expires":  certRow.Expires,
+				"attempts": attempt.Attempts,
+			})
+		}
+	}
+
+	if err := m.db.Save(&attempt).Error; err != nil {
+		lsession.Error("db-save-renewal-attempt", err)
+	}
+
+	window := RenewalWindow{StartsAt: now.UTC().Truncate(renewalAttemptWindow)}
+	if err := m.db.Where(RenewalWindow{StartsAt: window.StartsAt}).FirstOrCreate(&window).Error; err != nil {
+		lsession.Error("db-find-renewal-window", err)
+	} else if err := m.db.Model(&window).UpdateColumn("attempts", gorm.Expr("attempts + 1")).Error; err != nil {
+		lsession.Error("db-update-renewal-window", err)
+	}
+
+	if state != r.State {
+		r.State = state
+		if err := m.db.Model(r).UpdateColumn("state", state).Error; err != nil {
+			lsession.Error("db-update-state", err)
+		}
+	}
+}
+
+// throttleRenewals drops the routes that are backing off after failed
+// renewals, and limits the rest to the attempts left this hour, keeping the
+// routes whose certificates expire soonest.
+func (m *RouteManager) throttleRenewals(routes []Route) []Route {
+	lsession := m.logger.Session("route-manager-throttle-renewals")
+
+	if len(routes) == 0 {
+		return routes
+	}
+
+	now := time.Now()
+
+	ids := []uint{}
+	for _, route := range routes {
+		ids = append(ids, route.ID)
+	}
+
+	attempts := []RenewalAttempt{}
+	if err := m.db.Where("route_id IN (?)", ids).Find(&attempts).Error; err != nil {
+		lsession.Error("db-find-renewal-attempts", err)
+		return nil
+	}
+	nextAttempts := map[uint]RenewalAttempt{}
+	for _, attempt := range attempts {
+		nextAttempts[attempt.RouteId] = attempt
+	}
+
+	due := []Route{}
+	for _, route := range routes {
+		if attempt, ok := nextAttempts[route.ID]; ok && !attempt.Due(now) {
+			continue
+		}
+		due = append(due, route)
+	}
+
+	sort.SliceStable(due, func(i, j int) bool {
+		return due[i].Certificate.Expires.Before(due[j].Certificate.Expires)
+	})
+
+	if m.settings.RenewMaxAttemptsPerHour <= 0 {
+		return due
+	}
+
+	startsAt := now.UTC().Truncate(renewalAttemptWindow)
+	if err := m.db.Unscoped().Where("starts_at < ?", startsAt).Delete(RenewalWindow{}).Error; err != nil {
+		lsession.Error("db-delete-renewal-windows", err)
+	}
+
+	var window RenewalWindow
+	if err := m.db.Where(RenewalWindow{StartsAt: startsAt}).First(&window).Error; err != nil && !gorm.IsRecordNotFoundError(err) {
+		lsession.Error("db-find-renewal-window", err)
+		return nil
+	}
+
+	remaining := m.settings.RenewMaxAttemptsPerHour - window.Attempts
+	if remaining < 0 {
+		remaining = 0
+	}
+	if len(due) > remaining {
+		lsession.Info("renewals-deferred", lager.Data{
+			"num-routes": len(due) - remaining,
+		})
+		due = due[:remaining]
+	}
+
+	return due
+}
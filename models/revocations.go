@@ -0,0 +1,121 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+
+	"github.com/cloud-gov/cf-cdn-service-broker/utils"
+)
+
+// Revoke revokes the route's unexpired certificates with the CA, e.g. after
+// their private key was compromised, giving the RFC 5280 reason code reason.
+// The distribution serves the revoked certificate until the route is renewed.
+func (m *RouteManager) Revoke(r *Route, reason uint) error {
+	lsession := m.logger.Session("route-manager-revoke", lager.Data{
+		"instance-id": r.InstanceId,
+		"reason":      utils.RevocationReasonName(reason),
+	})
+
+	switch r.CertificateProvider {
+	case CertificateProviderAcm:
+		err := errors.New("certificates issued by ACM can't be revoked by the broker")
+		lsession.Error("acm-certificate", err)
+		return err
+	case CertificateProviderCustom:
+		err := errors.New("the route's own certificate must be revoked with the CA that issued it")
+		lsession.Error("custom-certificate", err)
+		return err
+	}
+
+	certs, err := m.unrevokedCertificates(r, 0)
+	if err != nil {
+		lsession.Error("db-find-certificates", err)
+		return err
+	}
+	if len(certs) == 0 {
+		err := errors.New("the route has no unexpired certificates to revoke")
+		lsession.Error("no-certificates", err)
+		return err
+	}
+
+	return m.revokeCertificates(r, certs, reason)
+}
+
+// revokeReplacedCertificates revokes the route's certificates other than
+// keepId, once they are no longer needed because the route's domains changed
+// or it was deprovisioned. Failures are logged, since the certificates will
+// expire anyway.
+func (m *RouteManager) revokeReplacedCertificates(r *Route, keepId uint, reason uint) {
+	if !m.settings.RevokeCertificates || r.CertificateProvider == CertificateProviderAcm || r.CertificateProvider == CertificateProviderCustom {
+		return
+	}
+
+	lsession := m.logger.Session("route-manager-revoke-replaced-certificates", lager.Data{
+		"instance-id": r.InstanceId,
+		"reason":      utils.RevocationReasonName(reason),
+	})
+
+	certs, err := m.unrevokedCertificates(r, keepId)
+	if err != nil {
+		lsession.Error("db-find-certificates", err)
+		return
+	}
+	if len(certs) == 0 {
+		return
+	}
+
+	if err := m.revokeCertificates(r, certs, reason); err != nil {
+		lsession.Error("revoke-certificates", err)
+	}
+}
+
+func (m *RouteManager) unrevokedCertificates(r *Route, exceptId uint) ([]Certificate, error) {
+	certs := []Certificate{}
+	err := m.db.Where(
+		"route_id = ? AND id <> ? AND revoked_at IS NULL AND expires > ?", r.ID, exceptId, time.Now(),
+	).Find(&certs).Error
+	return certs, err
+}
+
+// revokeCertificates revokes each certificate through the route's ACME
+// account, and records the revocation. It returns the last error, if any.
+func (m *RouteManager) revokeCertificates(r *Route, certs []Certificate, reason uint) error {
+	lsession := m.logger.Session("route-manager-revoke-certificates", lager.Data{
+		"instance-id": r.InstanceId,
+		"reason":      utils.RevocationReasonName(reason),
+	})
+
+	client, err := m.getRouteClient(r)
+	if err != nil {
+		lsession.Error("get-route-client", err)
+		return err
+	}
+
+	var lastErr error
+	for _, cert := range certs {
+		data := lager.Data{
+			"certificate-id": cert.ID,
+			"serial-number":  cert.SerialNumber,
+		}
+
+		if err := client.RevokeCertificate(cert.Certificate, reason); err != nil && !utils.IsAlreadyRevoked(err) {
+			lsession.Error("revoke-certificate", err, data)
+			lastErr = err
+			continue
+		}
+
+		now := time.Now()
+		if err := m.db.Model(&cert).Updates(map[string]interface{}{
+			"revoked_at":        &now,
+			"revocation_reason": reason,
+		}).Error; err != nil {
+			lsession.Error("db-save-certificate", err, data)
+			lastErr = err
+			continue
+		}
+		lsession.Info("revoked-certificate", data)
+	}
+	return lastErr
+}
@@ -0,0 +1,195 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/pem"
+	"errors"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/service/acm"
+
+	"github.com/go-acme/lego/v4/certificate"
+
+	"github.com/cloud-gov/cf-cdn-service-broker/config"
+)
+
+// acmRegion is the region of the ACM certificates that CloudFront can use.
+const acmRegion = "us-east-1"
+
+// acmPrefixTag marks the certificates imported by this broker, so that brokers
+// sharing an AWS account only manage their own certificates.
+const acmPrefixTag = "cdn-broker-prefix"
+
+type Acm struct {
+	Settings config.Settings
+	Service  *acm.ACM
+}
+
+func (a *Acm) Source() string {
+	return CertificateSourceAcm
+}
+
+// UploadCertificate imports a certificate into ACM. If arn is an ACM
+// certificate, the certificate is re-imported in place, so distributions
+// using it don't need to be updated.
+func (a *Acm) UploadCertificate(name string, cert certificate.Resource, certArn string) (StoredCertificate, error) {
+	leaf, chain := splitCertificateChain(cert.Certificate)
+	if leaf == nil {
+		return StoredCertificate{}, errors.New("no certificate found in bundle")
+	}
+	if len(chain) == 0 {
+		chain = cert.IssuerCertificate
+	}
+
+	input := &acm.ImportCertificateInput{
+		Certificate: leaf,
+		PrivateKey:  cert.PrivateKey,
+	}
+	if len(chain) > 0 {
+		input.CertificateChain = chain
+	}
+
+	if parsed, err := arn.Parse(certArn); err == nil && parsed.Service == "acm" {
+		// Tags can't be set when re-importing.
+		input.CertificateArn = aws.String(certArn)
+	} else {
+		input.Tags = acmTags(name, a.Settings.IamPathPrefix)
+	}
+
+	resp, err := a.Service.ImportCertificate(input)
+	if err != nil {
+		return StoredCertificate{}, err
+	}
+
+	described, err := a.Service.DescribeCertificate(&acm.DescribeCertificateInput{
+		CertificateArn: resp.CertificateArn,
+	})
+	if err != nil {
+		return StoredCertificate{}, err
+	}
+
+	return StoredCertificate{
+		Id:       *resp.CertificateArn,
+		Arn:      *resp.CertificateArn,
+		Name:     name,
+		Uploaded: aws.TimeValue(described.Certificate.ImportedAt),
+	}, nil
+}
+
+func (a *Acm) ListCertificates(callback func(StoredCertificate) bool) error {
+	return listTaggedCertificates(a.Service, a.Settings.IamPathPrefix, acm.CertificateTypeImported,
+		func(name string, cert acm.CertificateDetail) bool {
+			return callback(StoredCertificate{
+				Id:       aws.StringValue(cert.CertificateArn),
+				Arn:      aws.StringValue(cert.CertificateArn),
+				Name:     name,
+				Uploaded: aws.TimeValue(cert.ImportedAt),
+			})
+		},
+	)
+}
+
+func (a *Acm) DeleteCertificate(cert StoredCertificate) error {
+	_, err := a.Service.DeleteCertificate(&acm.DeleteCertificateInput{
+		CertificateArn: aws.String(cert.Arn),
+	})
+
+	return err
+}
+
+// listTaggedCertificates lists the certificates of the given type that this
+// broker created, along with their names.
+func listTaggedCertificates(service *acm.ACM, prefix, certType string, callback func(string, acm.CertificateDetail) bool) error {
+	var innerErr error
+	err := service.ListCertificatesPages(
+		&acm.ListCertificatesInput{
+			Includes: &acm.Filters{
+				KeyTypes: aws.StringSlice([]string{
+					acm.KeyAlgorithmRsa2048,
+					acm.KeyAlgorithmRsa4096,
+					acm.KeyAlgorithmEcPrime256v1,
+					acm.KeyAlgorithmEcSecp384r1,
+				}),
+			},
+		},
+		func(page *acm.ListCertificatesOutput, lastPage bool) bool {
+			for _, v := range page.CertificateSummaryList {
+				name, ours, err := certificateName(service, prefix, *v.CertificateArn)
+				if err != nil {
+					innerErr = err
+					return false
+				}
+				if !ours {
+					continue
+				}
+
+				resp, err := service.DescribeCertificate(&acm.DescribeCertificateInput{
+					CertificateArn: v.CertificateArn,
+				})
+				if err != nil {
+					innerErr = err
+					return false
+				}
+				if aws.StringValue(resp.Certificate.Type) != certType {
+					continue
+				}
+
+				// stop iteration if the callback tells us to
+				if callback(name, *resp.Certificate) == false {
+					return false
+				}
+			}
+
+			return true
+		},
+	)
+	if err != nil {
+		return err
+	}
+	return innerErr
+}
+
+// certificateName returns the name of a certificate, and whether it was
+// created by the broker with the given prefix.
+func certificateName(service *acm.ACM, prefix, certArn string) (string, bool, error) {
+	resp, err := service.ListTagsForCertificate(&acm.ListTagsForCertificateInput{
+		CertificateArn: aws.String(certArn),
+	})
+	if err != nil {
+		return "", false, err
+	}
+
+	var name string
+	var ours bool
+	for _, tag := range resp.Tags {
+		switch aws.StringValue(tag.Key) {
+		case "Name":
+			name = aws.StringValue(tag.Value)
+		case acmPrefixTag:
+			ours = aws.StringValue(tag.Value) == prefix
+		}
+	}
+	return name, ours, nil
+}
+
+// acmTags returns the tags of a certificate created by this broker.
+func acmTags(name, prefix string) []*acm.Tag {
+	return []*acm.Tag{
+		{Key: aws.String("Name"), Value: aws.String(name)},
+		{Key: aws.String(acmPrefixTag), Value: aws.String(prefix)},
+	}
+}
+
+// splitCertificateChain splits a PEM bundle into its first certificate and
+// the rest of the chain.
+func splitCertificateChain(bundle []byte) ([]byte, []byte) {
+	block, rest := pem.Decode(bundle)
+	if block == nil {
+		return nil, nil
+	}
+	if len(bytes.TrimSpace(rest)) == 0 {
+		return pem.EncodeToMemory(block), nil
+	}
+	return pem.EncodeToMemory(block), rest
+}
@@ -0,0 +1,100 @@
+package utils_test
+
+import (
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/acm"
+	"github.com/go-acme/lego/v4/certificate"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/cloud-gov/cf-cdn-service-broker/config"
+	. "github.com/cloud-gov/cf-cdn-service-broker/utils"
+)
+
+func TestAcm(t *testing.T) {
+	suite.Run(t, new(AcmSuite))
+}
+
+type AcmSuite struct {
+	suite.Suite
+
+	acm    *Acm
+	input  *acm.ImportCertificateInput
+	leaf   []byte
+	issuer []byte
+}
+
+func (s *AcmSuite) SetupTest() {
+	s.leaf = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte("leaf")})
+	s.issuer = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte("issuer")})
+
+	service := acm.New(session.New(aws.NewConfig().WithRegion("us-east-1")))
+	service.Handlers.Clear()
+	service.Handlers.Send.PushBack(func(r *request.Request) {
+		switch r.Operation.Name {
+		case "ImportCertificate":
+			s.input = r.Params.(*acm.ImportCertificateInput)
+			arn := aws.StringValue(s.input.CertificateArn)
+			if arn == "" {
+				arn = "arn:aws:acm:us-east-1:123456789012:certificate/new"
+			}
+			r.Data.(*acm.ImportCertificateOutput).CertificateArn = aws.String(arn)
+		case "DescribeCertificate":
+			r.Data.(*acm.DescribeCertificateOutput).Certificate = &acm.CertificateDetail{
+				ImportedAt: aws.Time(time.Now()),
+			}
+		}
+	})
+
+	s.acm = &Acm{Settings: config.Settings{IamPathPrefix: "letsencrypt"}, Service: service}
+}
+
+func (s *AcmSuite) TestUploadCertificateSplitsBundle() {
+	cert := certificate.Resource{
+		Certificate: append(append([]byte{}, s.leaf...), s.issuer...),
+		PrivateKey:  []byte("key"),
+	}
+
+	stored, err := s.acm.UploadCertificate("cdn-route-abc", cert, "")
+	s.Require().NoError(err)
+
+	s.Equal("arn:aws:acm:us-east-1:123456789012:certificate/new", stored.Id)
+	s.Equal(stored.Id, stored.Arn)
+	s.Equal(s.leaf, s.input.Certificate)
+	s.Equal(s.issuer, s.input.CertificateChain)
+	s.Nil(s.input.CertificateArn)
+	s.Len(s.input.Tags, 2)
+}
+
+func (s *AcmSuite) TestUploadCertificateReimports() {
+	cert := certificate.Resource{
+		Certificate:       s.leaf,
+		IssuerCertificate: s.issuer,
+		PrivateKey:        []byte("key"),
+	}
+	arn := "arn:aws:acm:us-east-1:123456789012:certificate/existing"
+
+	stored, err := s.acm.UploadCertificate("cdn-route-abc", cert, arn)
+	s.Require().NoError(err)
+
+	s.Equal(arn, stored.Arn)
+	s.Equal(arn, aws.StringValue(s.input.CertificateArn))
+	s.Equal(s.issuer, s.input.CertificateChain)
+	s.Empty(s.input.Tags)
+}
+
+func (s *AcmSuite) TestUploadCertificateIgnoresIamArn() {
+	cert := certificate.Resource{Certificate: s.leaf, PrivateKey: []byte("key")}
+
+	stored, err := s.acm.UploadCertificate("cdn-route-abc", cert, "arn:aws:iam::123456789012:server-certificate/cloudfront/letsencrypt/cdn-route-abc")
+	s.Require().NoError(err)
+
+	s.Equal("arn:aws:acm:us-east-1:123456789012:certificate/new", stored.Arn)
+	s.Nil(s.input.CertificateArn)
+	s.Nil(s.input.CertificateChain)
+}
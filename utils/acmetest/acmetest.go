@@ -0,0 +1,152 @@
+// Package acmetest provides a fake ACME CA and certificates for tests.
+package acmetest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/go-acme/lego/v4/acme"
+)
+
+// CA is a fake ACME CA. It serves its directory and nonces itself, and hands
+// the other requests to its handlers, which can be replaced before the CA is
+// used. Paths without a handler are not found.
+type CA struct {
+	*httptest.Server
+
+	// ExternalAccountRequired is advertised in the directory's metadata.
+	ExternalAccountRequired bool
+
+	// Account registers accounts, by default as valid accounts at
+	// AccountURL.
+	Account http.HandlerFunc
+	// NewOrder creates orders.
+	NewOrder http.HandlerFunc
+	// Order serves the order at OrderURL.
+	Order http.HandlerFunc
+	// Finalize finalizes the order at OrderURL.
+	Finalize http.HandlerFunc
+}
+
+// NewCA starts a fake CA, which the caller should close.
+func NewCA() *CA {
+	ca := &CA{}
+	ca.Account = ca.Register
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/directory", ca.serveDirectory)
+	mux.HandleFunc("/nonce", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("/account", func(w http.ResponseWriter, r *http.Request) { serve(ca.Account, w, r) })
+	mux.HandleFunc("/order", func(w http.ResponseWriter, r *http.Request) { serve(ca.NewOrder, w, r) })
+	mux.HandleFunc("/order/1", func(w http.ResponseWriter, r *http.Request) { serve(ca.Order, w, r) })
+	mux.HandleFunc("/finalize", func(w http.ResponseWriter, r *http.Request) { serve(ca.Finalize, w, r) })
+
+	ca.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Replay-Nonce", "nonce")
+		mux.ServeHTTP(w, r)
+	}))
+	return ca
+}
+
+func serve(handler http.HandlerFunc, w http.ResponseWriter, r *http.Request) {
+	if handler == nil {
+		http.NotFound(w, r)
+		return
+	}
+	handler(w, r)
+}
+
+// DirectoryURL is the URL to configure clients with.
+func (ca *CA) DirectoryURL() string {
+	return ca.URL + "/directory"
+}
+
+// AccountURL is the URL of the accounts that Register creates.
+func (ca *CA) AccountURL() string {
+	return ca.URL + "/account/1"
+}
+
+// OrderURL is the URL of the order that Order serves.
+func (ca *CA) OrderURL() string {
+	return ca.URL + "/order/1"
+}
+
+// FinalizeURL is the URL that Finalize serves.
+func (ca *CA) FinalizeURL() string {
+	return ca.URL + "/finalize"
+}
+
+func (ca *CA) serveDirectory(w http.ResponseWriter, r *http.Request) {
+	directory := map[string]interface{}{
+		"newNonce":   ca.URL + "/nonce",
+		"newAccount": ca.URL + "/account",
+		"newOrder":   ca.URL + "/order",
+	}
+	if ca.ExternalAccountRequired {
+		directory["meta"] = map[string]interface{}{"externalAccountRequired": true}
+	}
+	json.NewEncoder(w).Encode(directory)
+}
+
+// Register answers a new account request with a valid account at
+// AccountURL.
+func (ca *CA) Register(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Location", ca.AccountURL())
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]string{"status": "valid"})
+}
+
+// WriteProblem answers with problem, as a bad request if it has no status.
+func WriteProblem(w http.ResponseWriter, problem acme.ProblemDetails) {
+	status := problem.HTTPStatus
+	if status == 0 {
+		status = http.StatusBadRequest
+	}
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(problem)
+}
+
+// WriteOrder answers with order.
+func WriteOrder(w http.ResponseWriter, order acme.Order) {
+	json.NewEncoder(w).Encode(order)
+}
+
+// SelfSigned signs template with a new P-256 key and returns the certificate
+// and key in PEM. A template without a serial number or validity gets serial
+// 1 and a validity of 90 days from an hour ago.
+func SelfSigned(template *x509.Certificate) ([]byte, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if template.SerialNumber == nil {
+		template.SerialNumber = big.NewInt(1)
+	}
+	if template.NotBefore.IsZero() {
+		template.NotBefore = time.Now().Add(-time.Hour)
+	}
+	if template.NotAfter.IsZero() {
+		template.NotAfter = template.NotBefore.Add(90 * 24 * time.Hour)
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), nil
+}
@@ -0,0 +1,88 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/acm"
+
+	"github.com/cloud-gov/cf-cdn-service-broker/config"
+)
+
+// AcmIssuerIface requests DNS-validated certificates from AWS Certificate
+// Manager, which validates and renews them itself.
+type AcmIssuerIface interface {
+	RequestCertificate(instanceId string, domains []string) (string, error)
+	DescribeCertificate(arn string) (*acm.CertificateDetail, error)
+	DeleteCertificate(arn string) error
+	ListCertificates(callback func(acm.CertificateDetail) bool) error
+}
+
+type AcmIssuer struct {
+	Settings config.Settings
+	Service  *acm.ACM
+}
+
+func NewAcmIssuer(settings config.Settings, session *session.Session) *AcmIssuer {
+	return &AcmIssuer{Settings: settings, Service: acm.New(session, aws.NewConfig().WithRegion(acmRegion))}
+}
+
+// RequestCertificate requests a certificate for domains and returns its ARN.
+// Repeated requests for the same instance and domains return the same
+// certificate.
+func (a *AcmIssuer) RequestCertificate(instanceId string, domains []string) (string, error) {
+	input := &acm.RequestCertificateInput{
+		DomainName:       aws.String(domains[0]),
+		ValidationMethod: aws.String(acm.ValidationMethodDns),
+		IdempotencyToken: aws.String(idempotencyToken(instanceId, domains)),
+		Tags:             acmTags("cdn-route-"+instanceId, a.Settings.IamPathPrefix),
+	}
+	if len(domains) > 1 {
+		input.SubjectAlternativeNames = aws.StringSlice(domains[1:])
+	}
+
+	resp, err := a.Service.RequestCertificate(input)
+	if err != nil {
+		return "", err
+	}
+
+	return *resp.CertificateArn, nil
+}
+
+func (a *AcmIssuer) DescribeCertificate(arn string) (*acm.CertificateDetail, error) {
+	resp, err := a.Service.DescribeCertificate(&acm.DescribeCertificateInput{
+		CertificateArn: aws.String(arn),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Certificate, nil
+}
+
+func (a *AcmIssuer) DeleteCertificate(arn string) error {
+	_, err := a.Service.DeleteCertificate(&acm.DeleteCertificateInput{
+		CertificateArn: aws.String(arn),
+	})
+
+	return err
+}
+
+// ListCertificates lists the certificates requested by this broker.
+func (a *AcmIssuer) ListCertificates(callback func(acm.CertificateDetail) bool) error {
+	return listTaggedCertificates(a.Service, a.Settings.IamPathPrefix, acm.CertificateTypeAmazonIssued,
+		func(name string, cert acm.CertificateDetail) bool {
+			return callback(cert)
+		},
+	)
+}
+
+// idempotencyToken derives an ACM idempotency token, which is limited to 32
+// word characters, from an instance and its domains.
+func idempotencyToken(instanceId string, domains []string) string {
+	sum := sha256.Sum256([]byte(instanceId + ":" + strings.Join(domains, ",")))
+	return hex.EncodeToString(sum[:])[:32]
+}
@@ -0,0 +1,144 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// AcmCAAIdentities are the CAA identities that AWS Certificate Manager issues
+// certificates under.
+var AcmCAAIdentities = []string{"amazon.com", "amazontrust.com", "awstrust.com", "amazonaws.com"}
+
+// CAAError reports a domain whose CAA records don't permit any of the CA's
+// identities to issue certificates for it. RecordDomain is where the
+// relevant records were found, which may be a parent of Domain.
+type CAAError struct {
+	Domain       string
+	RecordDomain string
+	Tag          string
+	Records      []string
+	Identities   []string
+}
+
+func (e *CAAError) Error() string {
+	return fmt.Sprintf("CAA records for %s at %s (%s) don't permit %s to issue certificates",
+		e.Domain, e.RecordDomain, strings.Join(e.Records, ", "), e.Identities[0])
+}
+
+// Fix describes the record to add, or remove, so that the CA may issue for
+// the domain.
+func (e *CAAError) Fix() string {
+	if e.Tag != "issue" && e.Tag != "issuewild" {
+		return fmt.Sprintf("remove the critical CAA property `%s` at %s, which %s doesn't support", e.Tag, e.RecordDomain, e.Identities[0])
+	}
+	return fmt.Sprintf("add the record `%s CAA 0 %s \"%s\"` for %s", e.RecordDomain, e.Tag, e.Identities[0], e.Domain)
+}
+
+// CAAErrors reports every domain whose CAA records forbid issuance.
+type CAAErrors []*CAAError
+
+func (e CAAErrors) Error() string {
+	messages := []string{}
+	for _, err := range e {
+		messages = append(messages, err.Error())
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Fix describes the records to add so that the CA may issue for every domain.
+func (e CAAErrors) Fix() string {
+	fixes := []string{}
+	for _, err := range e {
+		fixes = append(fixes, err.Fix())
+	}
+	return strings.Join(fixes, "\n")
+}
+
+type CAACheckerIface interface {
+	Check(domain string, identities []string) error
+}
+
+// CAAChecker checks whether a domain's CAA records (RFC 8659) permit a CA
+// to issue certificates for it.
+type CAAChecker struct {
+	Resolver *Resolver
+}
+
+// Check returns a *CAAError if the CAA records relevant to domain don't
+// permit any of identities to issue for it, or another error if they
+// couldn't be looked up. The relevant records are the first non-empty CAA
+// set found at the domain or one of its parents.
+func (c *CAAChecker) Check(domain string, identities []string) error {
+	wildcard := strings.HasPrefix(domain, "*.")
+	name := dns.Fqdn(strings.TrimPrefix(domain, "*."))
+
+	for labels := dns.SplitDomainName(name); len(labels) > 0; labels = labels[1:] {
+		recordDomain := dns.Fqdn(strings.Join(labels, "."))
+
+		in, err := c.Resolver.Lookup(recordDomain, dns.TypeCAA)
+		if err != nil {
+			return err
+		}
+
+		records := []*dns.CAA{}
+		for _, rr := range in.Answer {
+			if caa, ok := rr.(*dns.CAA); ok {
+				records = append(records, caa)
+			}
+		}
+		if len(records) > 0 {
+			return checkCAARecords(domain, recordDomain, wildcard, records, identities)
+		}
+	}
+
+	return nil
+}
+
+func checkCAARecords(domain, recordDomain string, wildcard bool, records []*dns.CAA, identities []string) error {
+	caaErr := &CAAError{
+		Domain:       domain,
+		RecordDomain: recordDomain,
+		Tag:          "issue",
+		Identities:   identities,
+	}
+	for _, record := range records {
+		caaErr.Records = append(caaErr.Records, fmt.Sprintf("%d %s %q", record.Flag, record.Tag, record.Value))
+	}
+
+	tags := map[string][]string{}
+	for _, record := range records {
+		tag := strings.ToLower(record.Tag)
+		switch tag {
+		case "issue", "issuewild", "iodef":
+		default:
+			// CAs must refuse to issue if they don't understand a critical
+			// property.
+			if record.Flag&128 != 0 {
+				caaErr.Tag = record.Tag
+				return caaErr
+			}
+		}
+		tags[tag] = append(tags[tag], record.Value)
+	}
+
+	if _, ok := tags["issuewild"]; ok && wildcard {
+		caaErr.Tag = "issuewild"
+	}
+
+	values, ok := tags[caaErr.Tag]
+	if !ok {
+		return nil
+	}
+
+	for _, value := range values {
+		issuer := strings.TrimSpace(strings.SplitN(value, ";", 2)[0])
+		for _, identity := range identities {
+			if strings.EqualFold(issuer, identity) {
+				return nil
+			}
+		}
+	}
+	return caaErr
+}
@@ -0,0 +1,148 @@
+package utils_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/suite"
+
+	. "github.com/cloud-gov/cf-cdn-service-broker/utils"
+)
+
+func TestCAA(t *testing.T) {
+	suite.Run(t, new(CAASuite))
+}
+
+// CAASuite checks domains against a local nameserver serving CAA records.
+type CAASuite struct {
+	suite.Suite
+
+	server  *dns.Server
+	checker *CAAChecker
+	records map[string][]dns.RR
+}
+
+func (s *CAASuite) SetupTest() {
+	s.records = map[string][]dns.RR{}
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	s.Require().NoError(err)
+
+	started := make(chan struct{})
+	s.server = &dns.Server{
+		PacketConn:        conn,
+		Handler:           dns.HandlerFunc(s.serveDNS),
+		NotifyStartedFunc: func() { close(started) },
+	}
+	go s.server.ActivateAndServe()
+	<-started
+
+	s.checker = &CAAChecker{Resolver: &Resolver{
+		Nameservers: []string{conn.LocalAddr().String()},
+		Timeout:     time.Second,
+	}}
+}
+
+func (s *CAASuite) TearDownTest() {
+	s.server.Shutdown()
+}
+
+func (s *CAASuite) serveDNS(w dns.ResponseWriter, r *dns.Msg) {
+	m := new(dns.Msg)
+	m.SetReply(r)
+
+	q := r.Question[0]
+	if q.Name == "servfail.gov." {
+		m.Rcode = dns.RcodeServerFailure
+	} else if q.Qtype == dns.TypeCAA {
+		m.Answer = s.records[q.Name]
+	}
+
+	w.WriteMsg(m)
+}
+
+func (s *CAASuite) addCAA(name string, flag uint8, tag, value string) {
+	s.records[name] = append(s.records[name], &dns.CAA{
+		Hdr:   dns.RR_Header{Name: name, Rrtype: dns.TypeCAA, Class: dns.ClassINET, Ttl: 300},
+		Flag:  flag,
+		Tag:   tag,
+		Value: value,
+	})
+}
+
+func (s *CAASuite) TestNoRecords() {
+	s.NoError(s.checker.Check("www.agency.gov", []string{"letsencrypt.org"}))
+}
+
+func (s *CAASuite) TestPermitted() {
+	s.addCAA("agency.gov.", 0, "issue", "digicert.com")
+	s.addCAA("agency.gov.", 0, "issue", "letsencrypt.org; accounturi=https://acme-v02.api.letsencrypt.org/acme/acct/1")
+
+	s.NoError(s.checker.Check("agency.gov", []string{"letsencrypt.org"}))
+}
+
+func (s *CAASuite) TestForbiddenAtParent() {
+	s.addCAA("agency.gov.", 0, "issue", "digicert.com")
+	s.addCAA("agency.gov.", 0, "iodef", "mailto:security@agency.gov")
+
+	err := s.checker.Check("www.agency.gov", []string{"letsencrypt.org"})
+	caaErr, ok := err.(*CAAError)
+	s.Require().True(ok, "expected a CAA error, got %v", err)
+	s.Equal("www.agency.gov", caaErr.Domain)
+	s.Equal("agency.gov.", caaErr.RecordDomain)
+	s.Len(caaErr.Records, 2)
+	s.Equal("add the record `agency.gov. CAA 0 issue \"letsencrypt.org\"` for www.agency.gov", caaErr.Fix())
+}
+
+func (s *CAASuite) TestClosestRecordsApply() {
+	s.addCAA("agency.gov.", 0, "issue", "digicert.com")
+	s.addCAA("www.agency.gov.", 0, "issue", "letsencrypt.org")
+
+	s.NoError(s.checker.Check("www.agency.gov", []string{"letsencrypt.org"}))
+}
+
+func (s *CAASuite) TestNoIssuance() {
+	s.addCAA("agency.gov.", 0, "issue", ";")
+
+	s.IsType(&CAAError{}, s.checker.Check("agency.gov", []string{"letsencrypt.org"}))
+}
+
+func (s *CAASuite) TestOnlyIodef() {
+	s.addCAA("agency.gov.", 0, "iodef", "mailto:security@agency.gov")
+
+	s.NoError(s.checker.Check("agency.gov", []string{"letsencrypt.org"}))
+}
+
+func (s *CAASuite) TestWildcard() {
+	s.addCAA("agency.gov.", 0, "issue", "letsencrypt.org")
+	s.addCAA("agency.gov.", 0, "issuewild", "digicert.com")
+
+	s.NoError(s.checker.Check("agency.gov", []string{"letsencrypt.org"}))
+
+	err := s.checker.Check("*.agency.gov", []string{"letsencrypt.org"})
+	s.Require().IsType(&CAAError{}, err)
+	s.Equal("issuewild", err.(*CAAError).Tag)
+}
+
+func (s *CAASuite) TestUnknownCriticalProperty() {
+	s.addCAA("agency.gov.", 0, "issue", "letsencrypt.org")
+	s.addCAA("agency.gov.", 128, "tbs", "unknown")
+
+	err := s.checker.Check("agency.gov", []string{"letsencrypt.org"})
+	s.Require().IsType(&CAAError{}, err)
+	s.Contains(err.(*CAAError).Fix(), "remove the critical CAA property `tbs`")
+
+	delete(s.records, "agency.gov.")
+	s.addCAA("agency.gov.", 0, "issue", "letsencrypt.org")
+	s.addCAA("agency.gov.", 0, "tbs", "unknown")
+	s.NoError(s.checker.Check("agency.gov", []string{"letsencrypt.org"}))
+}
+
+func (s *CAASuite) TestLookupFailure() {
+	err := s.checker.Check("servfail.gov", []string{"letsencrypt.org"})
+	s.Error(err)
+	_, ok := err.(*CAAError)
+	s.False(ok)
+}
@@ -0,0 +1,80 @@
+package utils
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+
+	"github.com/cloud-gov/cf-cdn-service-broker/config"
+)
+
+type CanaryIface interface {
+	Check(instanceId string, domains []string) error
+}
+
+// Canary checks that domains still point at their distribution before their
+// certificate is renewed, by putting an object in the bucket and fetching it
+// through each domain.
+type Canary struct {
+	Settings config.Settings
+	Service  *s3.S3
+	Client   *http.Client
+}
+
+func NewCanary(settings config.Settings, service *s3.S3) *Canary {
+	return &Canary{
+		Settings: settings,
+		Service:  service,
+		Client: &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+			},
+		},
+	}
+}
+
+// Check serves instanceId from each of domains. Wildcard domains are skipped,
+// as there's no single name to fetch from.
+func (c *Canary) Check(instanceId string, domains []string) error {
+	target := path.Join(".well-known", "acme-challenge", "canary", instanceId)
+
+	input := s3.PutObjectInput{
+		Bucket: aws.String(c.Settings.Bucket),
+		Key:    aws.String(target),
+		Body:   strings.NewReader(instanceId),
+	}
+	if c.Settings.ServerSideEncryption != "" {
+		input.ServerSideEncryption = aws.String(c.Settings.ServerSideEncryption)
+	}
+	if _, err := c.Service.PutObject(&input); err != nil {
+		return err
+	}
+
+	for _, domain := range domains {
+		if strings.HasPrefix(domain, "*.") {
+			continue
+		}
+
+		resp, err := c.Client.Get("https://" + path.Join(domain, target))
+		if err != nil {
+			return err
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+
+		if string(body) != instanceId {
+			return fmt.Errorf("Canary check failed for %s; expected %s, got %s", domain, instanceId, string(body))
+		}
+	}
+
+	return nil
+}
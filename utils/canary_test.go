@@ -0,0 +1,84 @@
+package utils_test
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/cloud-gov/cf-cdn-service-broker/config"
+	. "github.com/cloud-gov/cf-cdn-service-broker/utils"
+)
+
+func TestCanary(t *testing.T) {
+	suite.Run(t, new(CanarySuite))
+}
+
+// CanarySuite serves the canary object from a local TLS server that every
+// domain resolves to, recording the hosts it was fetched through.
+type CanarySuite struct {
+	suite.Suite
+
+	server *httptest.Server
+	hosts  []string
+	body   string
+	canary *Canary
+}
+
+func (s *CanarySuite) SetupTest() {
+	s.hosts = []string{}
+	s.body = "123"
+	s.server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.hosts = append(s.hosts, r.Host)
+		w.Write([]byte(s.body))
+	}))
+
+	fakes3 := s3.New(session.New(aws.NewConfig().WithRegion("us-east-1")))
+	fakes3.Handlers.Clear()
+	fakes3.Handlers.Send.PushBack(func(r *request.Request) {
+		input := r.Params.(*s3.PutObjectInput)
+		s.Equal("acme-bucket", aws.StringValue(input.Bucket))
+		s.Equal(".well-known/acme-challenge/canary/123", aws.StringValue(input.Key))
+	})
+
+	s.canary = NewCanary(config.Settings{Bucket: "acme-bucket"}, fakes3)
+	s.canary.Client = &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, network, s.server.Listener.Addr().String())
+			},
+		},
+	}
+}
+
+func (s *CanarySuite) TearDownTest() {
+	s.server.Close()
+}
+
+func (s *CanarySuite) TestCheck() {
+	s.NoError(s.canary.Check("123", []string{"agency.gov", "www.agency.gov"}))
+	s.Equal([]string{"agency.gov", "www.agency.gov"}, s.hosts)
+}
+
+func (s *CanarySuite) TestCheckMismatch() {
+	s.body = "456"
+	s.Error(s.canary.Check("123", []string{"agency.gov"}))
+}
+
+func (s *CanarySuite) TestCheckSkipsWildcards() {
+	s.NoError(s.canary.Check("123", []string{"*.agency.gov", "agency.gov"}))
+	s.Equal([]string{"agency.gov"}, s.hosts)
+
+	s.hosts = []string{}
+	s.NoError(s.canary.Check("123", []string{"*.agency.gov"}))
+	s.Empty(s.hosts)
+}
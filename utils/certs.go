@@ -2,40 +2,57 @@ package utils
 
 import (
 	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
 	"crypto/tls"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net"
 	"net/http"
 	"path"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/s3"
-	"github.com/xenolf/lego/acme"
+	"github.com/go-acme/lego/v4/acme"
+	"github.com/go-acme/lego/v4/acme/api"
+	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/go-acme/lego/v4/certificate"
+	"github.com/go-acme/lego/v4/challenge"
+	"github.com/go-acme/lego/v4/challenge/dns01"
+	"github.com/go-acme/lego/v4/challenge/resolver"
+	"github.com/go-acme/lego/v4/platform/wait"
+	"github.com/go-acme/lego/v4/registration"
+	jose "github.com/go-jose/go-jose/v4"
 
 	"github.com/cloud-gov/cf-cdn-service-broker/config"
 )
 
+const userAgent = "cf-cdn-service-broker"
+
+// preCheckDNS looks for value among the TXT records at fqdn. A domain and
+// its wildcard are validated with records of the same name, so customers
+// may have created both.
 func preCheckDNS(fqdn, value string) (bool, error) {
-	record, err := net.LookupTXT(fqdn)
+	records, err := net.LookupTXT(fqdn)
 	if err != nil {
 		return false, err
 	}
-	if len(record) == 1 && record[0] == value {
-		return true, nil
+	for _, record := range records {
+		if record == value {
+			return true, nil
+		}
 	}
 	return false, fmt.Errorf("DNS precheck failed on name %s, value %s", fqdn, value)
 }
 
-func init() {
-	acme.PreCheckDNS = preCheckDNS
-}
-
 type User struct {
 	Email        string
-	Registration *acme.RegistrationResource
+	Registration *registration.Resource
 	key          crypto.PrivateKey
 }
 
@@ -43,7 +60,7 @@ func (u *User) GetEmail() string {
 	return u.Email
 }
 
-func (u *User) GetRegistration() *acme.RegistrationResource {
+func (u *User) GetRegistration() *registration.Resource {
 	return u.Registration
 }
 
@@ -55,6 +72,28 @@ func (u *User) SetPrivateKey(key crypto.PrivateKey) {
 	u.key = key
 }
 
+// GetKeyAuthorization computes the key authorization for a challenge token
+// without contacting the CA, so that DNS instructions can be rendered offline.
+func GetKeyAuthorization(token string, key crypto.PrivateKey) (string, error) {
+	var publicKey crypto.PublicKey
+	switch k := key.(type) {
+	case *ecdsa.PrivateKey:
+		publicKey = k.Public()
+	case *rsa.PrivateKey:
+		publicKey = k.Public()
+	default:
+		return "", errors.New("unknown private key type")
+	}
+
+	jwk := &jose.JSONWebKey{Key: publicKey}
+	thumbprint, err := jwk.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return "", err
+	}
+
+	return token + "." + base64.RawURLEncoding.EncodeToString(thumbprint), nil
+}
+
 type HTTPProvider struct {
 	Settings config.Settings
 	Service  *s3.S3
@@ -79,7 +118,7 @@ func (p *HTTPProvider) Present(domain, token, keyAuth string) error {
 		},
 	}
 
-	return acme.WaitFor(10*time.Second, 2*time.Second, func() (bool, error) {
+	return wait.For("http-01 token", 10*time.Second, 2*time.Second, func() (bool, error) {
 		resp, err := insecureClient.Get("https://" + path.Join(domain, ".well-known", "acme-challenge", token))
 		if err != nil {
 			return false, err
@@ -104,44 +143,571 @@ func (p *HTTPProvider) CleanUp(domain, token, keyAuth string) error {
 	return err
 }
 
-type DNSProvider struct{}
+// Authorization is an ACME authorization along with the URL it is polled from.
+type Authorization struct {
+	acme.Authorization
+	URL string `json:"url"`
+}
+
+// Domain returns the domain name the authorization is for, including the
+// wildcard label if there is one.
+func (a Authorization) Domain() string {
+	return challenge.GetTargetedDomain(a.Authorization)
+}
+
+// ChallengeType returns the type of the challenge the CA is validating or has
+// ruled on, or an empty string if none has been attempted.
+func (a Authorization) ChallengeType() string {
+	for _, chlg := range a.Challenges {
+		if chlg.Status != acme.StatusPending {
+			return chlg.Type
+		}
+	}
+	return ""
+}
+
+// Order is the broker's record of an ACME order. It is persisted between
+// polls so that the same authorizations are reused until the order is finalized.
+type Order struct {
+	URL            string          `json:"url"`
+	Finalize       string          `json:"finalize"`
+	Domains        []string        `json:"domains"`
+	Authorizations []Authorization `json:"authorizations"`
+}
 
-func (p *DNSProvider) Present(domain, token, keyAuth string) error {
-	return nil
+// PendingDomains returns the domains whose authorizations haven't been
+// validated yet.
+func (o *Order) PendingDomains() []string {
+	domains := []string{}
+	for _, authz := range o.Authorizations {
+		if authz.Status != acme.StatusValid {
+			domains = append(domains, authz.Domain())
+		}
+	}
+	return domains
 }
 
-func (p *DNSProvider) CleanUp(domain, token, keyAuth string) error {
-	return nil
+// Invalid reports whether any authorization on the order can no longer be
+// used, in which case the whole order has to be replaced.
+func (o *Order) Invalid() bool {
+	for _, authz := range o.Authorizations {
+		switch authz.Status {
+		case acme.StatusInvalid, acme.StatusExpired, acme.StatusDeactivated, acme.StatusRevoked:
+			return true
+		}
+	}
+	return false
 }
 
-func (p *DNSProvider) Timeout() (time.Duration, time.Duration) {
-	return 10 * time.Second, 2 * time.Second
+// AcmeClient drives RFC 8555 orders on behalf of a User. Registration and
+// renewals go through lego, while the order methods let the broker spread
+// issuance of a new certificate across several polls.
+type AcmeClient struct {
+	Certificate  *certificate.Certifier
+	Registration *registration.Registrar
+
+	core         *api.Core
+	user         *User
+	httpProvider *HTTPProvider
+	dnsProvider  DNSProviderIface
 }
 
-func NewClient(settings config.Settings, user *User, s3Service *s3.S3, excludes []acme.Challenge) (*acme.Client, error) {
-	client, err := acme.NewClient(settings.AcmeUrl, user, acme.RSA2048)
+// NewClient builds an ACME client for user. DNS-01 records are written by
+// dnsProvider, or left to customers if it is nil.
+func NewClient(settings config.Settings, user *User, s3Service *s3.S3, dnsProvider DNSProviderIface) (*AcmeClient, error) {
+	if dnsProvider == nil {
+		dnsProvider = &ManualDNSProvider{}
+	}
+
+	var kid string
+	if user.GetRegistration() != nil {
+		kid = user.GetRegistration().URI
+	}
+
+	core, err := api.New(&http.Client{Timeout: 30 * time.Second}, userAgent, settings.AcmeUrl, kid, user.GetPrivateKey())
 	if err != nil {
-		return &acme.Client{}, err
+		return nil, err
+	}
+
+	httpProvider := &HTTPProvider{
+		Settings: settings,
+		Service:  s3Service,
+	}
+
+	solvers := resolver.NewSolversManager(core)
+	if err := solvers.SetHTTP01Provider(httpProvider); err != nil {
+		return nil, err
+	}
+	// lego prefers HTTP-01 where it's offered, so renewals only fall back on
+	// DNS-01 for wildcard domains.
+	if err := solvers.SetDNS01Provider(dnsProvider); err != nil {
+		return nil, err
+	}
+
+	client := &AcmeClient{
+		Certificate: certificate.NewCertifier(core, resolver.NewProber(solvers), certificate.CertifierOptions{
+			KeyType: certcrypto.RSA2048,
+			Timeout: 30 * time.Second,
+		}),
+		Registration: registration.NewRegistrar(core, user),
+		core:         core,
+		user:         user,
+		httpProvider: httpProvider,
+		dnsProvider:  dnsProvider,
 	}
 
 	if user.GetRegistration() == nil {
-		reg, err := client.Register()
+		// Accounts created through the ACME v1 API share their key with v2, so
+		// look the account up before falling back to registering a new one.
+		reg, err := client.Registration.ResolveAccountByKey()
 		if err != nil {
-			return client, err
+			reg, err = client.register(settings)
+			if err != nil {
+				return client, err
+			}
 		}
 		user.Registration = reg
 	}
 
-	if err := client.AgreeToTOS(); err != nil {
-		return client, err
+	return client, nil
+}
+
+// register creates the user's account with the CA, bound to the external
+// account given by ACME_EAB_KID and ACME_EAB_HMAC_KEY if they're set.
+func (c *AcmeClient) register(settings config.Settings) (*registration.Resource, error) {
+	if settings.AcmeEabKid == "" && settings.AcmeEabHmacKey == "" {
+		if c.core.GetDirectory().Meta.ExternalAccountRequired {
+			return nil, errors.New("the CA requires external account binding; set ACME_EAB_KID and ACME_EAB_HMAC_KEY")
+		}
+		return c.Registration.Register(registration.RegisterOptions{TermsOfServiceAgreed: true})
+	}
+	if settings.AcmeEabKid == "" || settings.AcmeEabHmacKey == "" {
+		return nil, errors.New("ACME_EAB_KID and ACME_EAB_HMAC_KEY must be set together")
 	}
 
-	client.SetChallengeProvider(acme.HTTP01, &HTTPProvider{
-		Settings: settings,
-		Service:  s3Service,
+	return c.Registration.RegisterWithExternalAccountBinding(registration.RegisterEABOptions{
+		TermsOfServiceAgreed: true,
+		Kid:                  settings.AcmeEabKid,
+		HmacEncoded:          eabHmacKey(settings.AcmeEabHmacKey),
 	})
-	client.SetChallengeProvider(acme.DNS01, &DNSProvider{})
-	client.ExcludeChallenges(excludes)
+}
 
-	return client, nil
+// eabHmacKey converts an HMAC key from standard or padded base64, as some CAs
+// give it, to the unpadded base64url that lego expects.
+func eabHmacKey(key string) string {
+	key = strings.NewReplacer("+", "-", "/", "_").Replace(strings.TrimSpace(key))
+	return strings.TrimRight(key, "=")
+}
+
+// NewOrder creates an order for domains and fetches its authorizations.
+func (c *AcmeClient) NewOrder(domains []string) (Order, error) {
+	order, err := c.core.Orders.New(domains)
+	if err != nil {
+		return Order{}, err
+	}
+
+	result := Order{
+		URL:      order.Location,
+		Finalize: order.Finalize,
+		Domains:  domains,
+	}
+	for _, url := range order.Authorizations {
+		authz, err := c.core.Authorizations.Get(url)
+		if err != nil {
+			return Order{}, err
+		}
+		result.Authorizations = append(result.Authorizations, Authorization{Authorization: authz, URL: url})
+	}
+
+	return result, nil
+}
+
+// SolveChallenges attempts the authorizations on the order for domains,
+// trying HTTP-01 and then DNS-01, or DNS-01 first for domains whose records
+// the broker writes itself. A challenge is only submitted to the CA once its
+// pre-check passes, so that an unready domain doesn't invalidate the order.
+// The returned map is keyed by domain.
+func (c *AcmeClient) SolveChallenges(order *Order, domains []string) map[string]error {
+	failures := map[string]error{}
+
+	attempt := map[string]bool{}
+	for _, domain := range domains {
+		attempt[domain] = true
+	}
+
+	for idx := range order.Authorizations {
+		authz := &order.Authorizations[idx]
+		if !attempt[authz.Domain()] {
+			continue
+		}
+
+		current, err := c.core.Authorizations.Get(authz.URL)
+		if err != nil {
+			failures[authz.Domain()] = err
+			continue
+		}
+		authz.Authorization = current
+
+		if authz.Status != acme.StatusPending {
+			if authz.Status != acme.StatusValid {
+				failures[authz.Domain()] = fmt.Errorf("authorization is %s", authz.Status)
+			}
+			continue
+		}
+
+		if err := c.solveAuthorization(authz); err != nil {
+			failures[authz.Domain()] = err
+		}
+	}
+
+	return failures
+}
+
+func (c *AcmeClient) solveAuthorization(authz *Authorization) error {
+	var errs []string
+
+	automated := c.dnsProvider.Automated(authz.Identifier.Value)
+	chlgTypes := []challenge.Type{challenge.HTTP01, challenge.DNS01}
+	switch {
+	case authz.Wildcard:
+		// Wildcards can only be validated over DNS.
+		chlgTypes = []challenge.Type{challenge.DNS01}
+	case automated:
+		chlgTypes = []challenge.Type{challenge.DNS01, challenge.HTTP01}
+	}
+
+	for _, chlgType := range chlgTypes {
+		chlg, err := challenge.FindChallenge(chlgType, authz.Authorization)
+		if err != nil {
+			continue
+		}
+		if chlg.Status == acme.StatusProcessing {
+			return fmt.Errorf("%s validation in progress", chlgType)
+		}
+
+		keyAuth, err := c.core.GetKeyAuthorization(chlg.Token)
+		if err != nil {
+			return err
+		}
+
+		var provider challenge.Provider
+		switch chlgType {
+		case challenge.HTTP01:
+			provider = c.httpProvider
+		case challenge.DNS01:
+			provider = c.dnsProvider
+		}
+
+		if err := provider.Present(authz.Identifier.Value, chlg.Token, keyAuth); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", chlgType, err))
+			continue
+		}
+
+		// Records written by the broker are served by the zone's name servers
+		// once Present returns, so only check records created by customers.
+		if chlgType == challenge.DNS01 && !automated {
+			fqdn, value := dns01.GetRecord(authz.Identifier.Value, keyAuth)
+			if _, err := preCheckDNS(fqdn, value); err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %s", chlgType, err))
+				continue
+			}
+		}
+
+		err = c.validate(authz, chlg)
+		provider.CleanUp(authz.Identifier.Value, chlg.Token, keyAuth)
+		return err
+	}
+
+	if len(errs) == 0 {
+		return errors.New("no supported challenge types offered")
+	}
+	return errors.New(strings.Join(errs, "; "))
+}
+
+// validate submits the challenge and waits for the CA to rule on the authorization.
+func (c *AcmeClient) validate(authz *Authorization, chlg acme.Challenge) error {
+	if _, err := c.core.Challenges.New(chlg.URL); err != nil {
+		return err
+	}
+
+	return poll("authorization", 30*time.Second, 2*time.Second, func() (bool, error) {
+		current, err := c.core.Authorizations.Get(authz.URL)
+		if err != nil {
+			return false, err
+		}
+		authz.Authorization = current
+
+		switch current.Status {
+		case acme.StatusValid:
+			return true, nil
+		case acme.StatusPending, acme.StatusProcessing:
+			return false, nil
+		default:
+			for _, chlg := range current.Challenges {
+				if chlg.Error != nil {
+					return false, chlg.Error
+				}
+			}
+			return false, fmt.Errorf("authorization is %s", current.Status)
+		}
+	})
+}
+
+// poll calls f every interval until it reports done, returns an error or the
+// timeout passes. Unlike wait.For it stops at the first error, since the CA
+// reporting an invalid authorization or order is final.
+func poll(msg string, timeout, interval time.Duration, f func() (bool, error)) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		done, err := f()
+		if done || err != nil {
+			return err
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%s: time limit exceeded", msg)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// ErrOrderInvalid is returned by Finalize if the CA marked the order invalid.
+// The CA's problem document, if it gave one, is wrapped with it.
+var ErrOrderInvalid = errors.New("order is invalid")
+
+// Finalize submits a CSR for a fresh private key of type keyType once every
+// authorization on the order is valid, and downloads the issued certificate.
+func (c *AcmeClient) Finalize(order Order, keyType certcrypto.KeyType) (*certificate.Resource, error) {
+	privateKey, err := certcrypto.GeneratePrivateKey(keyType)
+	if err != nil {
+		return nil, err
+	}
+
+	commonName := ""
+	if len(order.Domains[0]) <= 64 {
+		commonName = order.Domains[0]
+	}
+	csr, err := certcrypto.GenerateCSR(privateKey, commonName, order.Domains, false)
+	if err != nil {
+		return nil, err
+	}
+
+	current, err := c.core.Orders.UpdateForCSR(order.Finalize, csr)
+	if problem, ok := err.(*acme.ProblemDetails); ok && problem == nil {
+		// lego returns an invalid order's error as is, even if the CA gave none.
+		return nil, ErrOrderInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = poll("certificate", 30*time.Second, 2*time.Second, func() (bool, error) {
+		if current.Status == acme.StatusValid {
+			return true, nil
+		}
+		if current.Status == acme.StatusInvalid {
+			if current.Error == nil {
+				return false, ErrOrderInvalid
+			}
+			return false, fmt.Errorf("%w: %w", ErrOrderInvalid, current.Error)
+		}
+		current, err = c.core.Orders.Get(order.URL)
+		return false, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	cert, issuer, err := c.core.Certificates.Get(current.Certificate, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return &certificate.Resource{
+		Domain:            order.Domains[0],
+		CertURL:           current.Certificate,
+		CertStableURL:     current.Certificate,
+		PrivateKey:        certcrypto.PEMEncode(privateKey),
+		Certificate:       cert,
+		IssuerCertificate: issuer,
+	}, nil
+}
+
+// ObtainCertificate runs a complete order for domains, as used for renewals of
+// routes that are already serving traffic. Domains are validated with HTTP-01
+// where the CA offers it, and otherwise with DNS-01 through the client's DNS
+// provider.
+func (c *AcmeClient) ObtainCertificate(domains []string, keyType certcrypto.KeyType) (*certificate.Resource, error) {
+	privateKey, err := certcrypto.GeneratePrivateKey(keyType)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.Certificate.Obtain(certificate.ObtainRequest{
+		Domains:    domains,
+		Bundle:     true,
+		PrivateKey: privateKey,
+	})
+}
+
+// RevokeCertificate revokes the first certificate in a PEM bundle with the
+// given RFC 5280 reason code.
+func (c *AcmeClient) RevokeCertificate(cert []byte, reason uint) error {
+	return c.Certificate.RevokeWithReason(cert, &reason)
+}
+
+// revocationReasons are the RFC 5280 reason codes that subscribers may give
+// when revoking a certificate.
+var revocationReasons = map[string]uint{
+	"unspecified":          acme.CRLReasonUnspecified,
+	"keyCompromise":        acme.CRLReasonKeyCompromise,
+	"affiliationChanged":   acme.CRLReasonAffiliationChanged,
+	"superseded":           acme.CRLReasonSuperseded,
+	"cessationOfOperation": acme.CRLReasonCessationOfOperation,
+}
+
+// ParseRevocationReason parses a revocation reason given by name, e.g.
+// keyCompromise, or by code.
+func ParseRevocationReason(reason string) (uint, error) {
+	for name, code := range revocationReasons {
+		if strings.EqualFold(reason, name) || reason == strconv.Itoa(int(code)) {
+			return code, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown revocation reason %q", reason)
+}
+
+// RevocationReasonName names an RFC 5280 reason code.
+func RevocationReasonName(reason uint) string {
+	for name, code := range revocationReasons {
+		if code == reason {
+			return name
+		}
+	}
+	return strconv.Itoa(int(reason))
+}
+
+// problemDetails extracts the ACME problem document from an error, if any.
+func problemDetails(err error) (acme.ProblemDetails, bool) {
+	var ptr *acme.ProblemDetails
+	if errors.As(err, &ptr) && ptr != nil {
+		return *ptr, true
+	}
+	var problem acme.ProblemDetails
+	if errors.As(err, &problem) {
+		return problem, true
+	}
+	return acme.ProblemDetails{}, false
+}
+
+// IsValidationFailure reports whether err is the CA rejecting a challenge,
+// which counts against the account's failed validation limit.
+func IsValidationFailure(err error) bool {
+	problem, ok := problemDetails(err)
+	if !ok {
+		return false
+	}
+	switch strings.TrimPrefix(problem.Type, "urn:ietf:params:acme:error:") {
+	case "unauthorized", "connection", "dns", "incorrectResponse", "caa", "tls":
+		return true
+	}
+	return false
+}
+
+// IsAccountError reports whether err is the CA refusing to act for the
+// account, e.g. because it was deactivated.
+func IsAccountError(err error) bool {
+	problem, ok := problemDetails(err)
+	if !ok {
+		return false
+	}
+	switch strings.TrimPrefix(problem.Type, "urn:ietf:params:acme:error:") {
+	case "accountDoesNotExist":
+		return true
+	case "unauthorized":
+		return strings.Contains(strings.ToLower(problem.Detail), "account")
+	}
+	return false
+}
+
+// IsAlreadyRevoked reports whether err is the CA refusing to revoke a
+// certificate because it has already been revoked.
+func IsAlreadyRevoked(err error) bool {
+	problem, ok := problemDetails(err)
+	return ok && problem.Type == "urn:ietf:params:acme:error:alreadyRevoked"
+}
+
+// Certificate key types that routes can choose, named as ACM names key
+// algorithms.
+const (
+	KeyTypeRSA2048 = "RSA_2048"
+	KeyTypeRSA4096 = "RSA_4096"
+	KeyTypeEC256   = "EC_prime256v1"
+	KeyTypeEC384   = "EC_secp384r1"
+)
+
+var keyTypes = map[string]certcrypto.KeyType{
+	KeyTypeRSA2048: certcrypto.RSA2048,
+	KeyTypeRSA4096: certcrypto.RSA4096,
+	KeyTypeEC256:   certcrypto.EC256,
+	KeyTypeEC384:   certcrypto.EC384,
+}
+
+// ParseKeyType returns the key type with the given name, e.g. EC_prime256v1.
+func ParseKeyType(name string) (certcrypto.KeyType, error) {
+	for keyTypeName, keyType := range keyTypes {
+		if strings.EqualFold(name, keyTypeName) {
+			return keyType, nil
+		}
+	}
+	return "", fmt.Errorf("key type must be one of %s, %s, %s or %s", KeyTypeRSA2048, KeyTypeRSA4096, KeyTypeEC256, KeyTypeEC384)
+}
+
+// CertificateInfo describes a certificate.
+type CertificateInfo struct {
+	SerialNumber string
+	DNSNames     []string
+	KeyAlgorithm string
+	NotBefore    time.Time
+	NotAfter     time.Time
+}
+
+// GetPEMCertInfo describes the first certificate in a PEM bundle.
+func GetPEMCertInfo(cert []byte) (CertificateInfo, error) {
+	parsed, err := certcrypto.ParsePEMCertificate(cert)
+	if err != nil {
+		return CertificateInfo{}, err
+	}
+
+	// Key algorithms are named as in ACM, so that they compare across providers.
+	keyAlgorithm := parsed.PublicKeyAlgorithm.String()
+	switch key := parsed.PublicKey.(type) {
+	case *rsa.PublicKey:
+		keyAlgorithm = fmt.Sprintf("RSA_%d", key.N.BitLen())
+	case *ecdsa.PublicKey:
+		switch key.Curve.Params().Name {
+		case "P-256":
+			keyAlgorithm = "EC_prime256v1"
+		case "P-384":
+			keyAlgorithm = "EC_secp384r1"
+		case "P-521":
+			keyAlgorithm = "EC_secp521r1"
+		}
+	}
+
+	return CertificateInfo{
+		SerialNumber: fmt.Sprintf("%x", parsed.SerialNumber),
+		DNSNames:     parsed.DNSNames,
+		KeyAlgorithm: keyAlgorithm,
+		NotBefore:    parsed.NotBefore,
+		NotAfter:     parsed.NotAfter,
+	}, nil
+}
+
+// GetPEMCertExpiration returns the expiry of the first certificate in a PEM bundle.
+func GetPEMCertExpiration(cert []byte) (time.Time, error) {
+	parsed, err := certcrypto.ParsePEMCertificate(cert)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return parsed.NotAfter, nil
 }
@@ -0,0 +1,324 @@
+package utils_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-acme/lego/v4/acme"
+	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/cloud-gov/cf-cdn-service-broker/config"
+	. "github.com/cloud-gov/cf-cdn-service-broker/utils"
+	"github.com/cloud-gov/cf-cdn-service-broker/utils/acmetest"
+)
+
+func TestCerts(t *testing.T) {
+	suite.Run(t, new(CertsSuite))
+}
+
+type CertsSuite struct {
+	suite.Suite
+}
+
+func (s *CertsSuite) TestGetKeyAuthorization() {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	s.Require().NoError(err)
+
+	keyAuth, err := GetKeyAuthorization("token", key)
+	s.NoError(err)
+	s.True(strings.HasPrefix(keyAuth, "token."))
+
+	again, err := GetKeyAuthorization("token", key)
+	s.NoError(err)
+	s.Equal(keyAuth, again)
+}
+
+func (s *CertsSuite) TestGetKeyAuthorizationUnknownKey() {
+	_, err := GetKeyAuthorization("token", "cheese")
+	s.Error(err)
+}
+
+func (s *CertsSuite) TestOrderInvalid() {
+	order := Order{Authorizations: []Authorization{
+		{Authorization: acme.Authorization{Status: acme.StatusValid}},
+		{Authorization: acme.Authorization{Status: acme.StatusPending}},
+	}}
+	s.False(order.Invalid())
+
+	order.Authorizations[1].Status = acme.StatusInvalid
+	s.True(order.Invalid())
+}
+
+func (s *CertsSuite) TestAuthorizationDomain() {
+	authz := Authorization{Authorization: acme.Authorization{
+		Identifier: acme.Identifier{Type: "dns", Value: "agency.gov"},
+		Wildcard:   true,
+	}}
+	s.Equal("*.agency.gov", authz.Domain())
+}
+
+func (s *CertsSuite) TestAuthorizationChallengeType() {
+	authz := Authorization{Authorization: acme.Authorization{Challenges: []acme.Challenge{
+		{Type: "http-01", Status: acme.StatusPending},
+		{Type: "dns-01", Status: acme.StatusPending},
+	}}}
+	s.Equal("", authz.ChallengeType())
+
+	authz.Challenges[1].Status = acme.StatusValid
+	s.Equal("dns-01", authz.ChallengeType())
+}
+
+func (s *CertsSuite) TestParseRevocationReason() {
+	for reason, expected := range map[string]uint{
+		"keyCompromise":        1,
+		"keycompromise":        1,
+		"1":                    1,
+		"superseded":           4,
+		"cessationOfOperation": 5,
+		"0":                    0,
+	} {
+		code, err := ParseRevocationReason(reason)
+		s.NoError(err, reason)
+		s.Equal(expected, code, reason)
+	}
+
+	for _, reason := range []string{"", "cACompromise", "2", "cheese"} {
+		_, err := ParseRevocationReason(reason)
+		s.Error(err, reason)
+	}
+
+	s.Equal("keyCompromise", RevocationReasonName(1))
+	s.Equal("9", RevocationReasonName(9))
+}
+
+func (s *CertsSuite) TestParseKeyType() {
+	for name, expected := range map[string]certcrypto.KeyType{
+		"RSA_2048":      certcrypto.RSA2048,
+		"RSA_4096":      certcrypto.RSA4096,
+		"EC_prime256v1": certcrypto.EC256,
+		"ec_secp384r1":  certcrypto.EC384,
+	} {
+		keyType, err := ParseKeyType(name)
+		s.NoError(err, name)
+		s.Equal(expected, keyType, name)
+	}
+
+	for _, name := range []string{"", "RSA_1024", "EC_secp521r1", "P256"} {
+		_, err := ParseKeyType(name)
+		s.Error(err, name)
+	}
+}
+
+func (s *CertsSuite) TestIsAlreadyRevoked() {
+	s.True(IsAlreadyRevoked(&acme.ProblemDetails{Type: "urn:ietf:params:acme:error:alreadyRevoked"}))
+	s.False(IsAlreadyRevoked(&acme.ProblemDetails{Type: "urn:ietf:params:acme:error:unauthorized"}))
+	s.False(IsAlreadyRevoked(errors.New("certificate already revoked")))
+}
+
+func (s *CertsSuite) TestIsValidationFailure() {
+	s.True(IsValidationFailure(&acme.ProblemDetails{Type: "urn:ietf:params:acme:error:incorrectResponse"}))
+	s.True(IsValidationFailure(fmt.Errorf("domain.gov: %w", acme.ProblemDetails{Type: "urn:ietf:params:acme:error:dns"})))
+	s.False(IsValidationFailure(&acme.ProblemDetails{Type: "urn:ietf:params:acme:error:rateLimited"}))
+	s.False(IsValidationFailure(errors.New("HTTP-01 token mismatch")))
+}
+
+func (s *CertsSuite) TestIsAccountError() {
+	s.True(IsAccountError(&acme.ProblemDetails{Type: "urn:ietf:params:acme:error:accountDoesNotExist"}))
+	s.True(IsAccountError(&acme.ProblemDetails{
+		Type:   "urn:ietf:params:acme:error:unauthorized",
+		Detail: `Account is not valid, has status "deactivated"`,
+	}))
+	s.False(IsAccountError(&acme.ProblemDetails{
+		Type:   "urn:ietf:params:acme:error:unauthorized",
+		Detail: "Invalid response from http://domain.gov/.well-known/acme-challenge/token",
+	}))
+}
+
+func (s *CertsSuite) TestGetPEMCertInfo() {
+	notBefore := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	cert, _, err := acmetest.SelfSigned(&x509.Certificate{
+		SerialNumber: big.NewInt(0xabc123),
+		DNSNames:     []string{"www.agency.gov", "agency.gov"},
+		NotBefore:    notBefore,
+		NotAfter:     notBefore.Add(90 * 24 * time.Hour),
+	})
+	s.Require().NoError(err)
+
+	info, err := GetPEMCertInfo(cert)
+	s.Require().NoError(err)
+	s.Equal("abc123", info.SerialNumber)
+	s.Equal([]string{"www.agency.gov", "agency.gov"}, info.DNSNames)
+	s.Equal("EC_prime256v1", info.KeyAlgorithm)
+	s.Equal(notBefore, info.NotBefore)
+	s.Equal(notBefore.Add(90*24*time.Hour), info.NotAfter)
+}
+
+// finalizeInvalidOrder finalizes an order with a fake CA that marks it
+// invalid, with the given problem if it isn't nil. If processing is set, the
+// order is only invalid once the CA has processed it.
+func (s *CertsSuite) finalizeInvalidOrder(problem *acme.ProblemDetails, processing bool) error {
+	ca := acmetest.NewCA()
+	defer ca.Close()
+	ca.Finalize = func(w http.ResponseWriter, r *http.Request) {
+		if processing {
+			acmetest.WriteOrder(w, acme.Order{Status: acme.StatusProcessing})
+			return
+		}
+		acmetest.WriteOrder(w, acme.Order{Status: acme.StatusInvalid, Error: problem})
+	}
+	ca.Order = func(w http.ResponseWriter, r *http.Request) {
+		acmetest.WriteOrder(w, acme.Order{Status: acme.StatusInvalid, Error: problem})
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	s.Require().NoError(err)
+	user := &User{Email: "cdn@agency.gov"}
+	user.SetPrivateKey(key)
+	client, err := NewClient(config.Settings{AcmeUrl: ca.DirectoryURL()}, user, nil, nil)
+	s.Require().NoError(err)
+
+	_, err = client.Finalize(Order{
+		URL:      ca.OrderURL(),
+		Finalize: ca.FinalizeURL(),
+		Domains:  []string{"agency.gov"},
+	}, certcrypto.EC256)
+	return err
+}
+
+func (s *CertsSuite) TestFinalizeInvalidOrder() {
+	for _, processing := range []bool{false, true} {
+		err := s.finalizeInvalidOrder(nil, processing)
+		s.ErrorIs(err, ErrOrderInvalid)
+	}
+
+	problem := &acme.ProblemDetails{
+		Type:   "urn:ietf:params:acme:error:badCSR",
+		Detail: "key too small",
+	}
+	err := s.finalizeInvalidOrder(problem, false)
+	s.Require().Error(err)
+	s.Contains(err.Error(), "badCSR")
+
+	// The problem is wrapped once the CA has processed the order.
+	err = s.finalizeInvalidOrder(problem, true)
+	s.ErrorIs(err, ErrOrderInvalid)
+	s.Contains(err.Error(), "badCSR")
+}
+
+func TestExternalAccountBinding(t *testing.T) {
+	suite.Run(t, new(EABSuite))
+}
+
+// EABSuite registers accounts with a fake CA that requires external account
+// binding, and records the binding it was sent.
+type EABSuite struct {
+	suite.Suite
+
+	ca       *acmetest.CA
+	hmacKey  []byte
+	kid      string
+	verified bool
+}
+
+func (s *EABSuite) SetupTest() {
+	s.hmacKey = []byte("\xfb\xff\xfe0123456789abcdef0123456789abcdef")
+	s.kid = ""
+	s.verified = false
+
+	s.ca = acmetest.NewCA()
+	s.ca.ExternalAccountRequired = true
+	s.ca.Account = func(w http.ResponseWriter, r *http.Request) {
+		var jws struct {
+			Payload string `json:"payload"`
+		}
+		json.NewDecoder(r.Body).Decode(&jws)
+		payload, _ := base64.RawURLEncoding.DecodeString(jws.Payload)
+
+		var account struct {
+			OnlyReturnExisting     bool `json:"onlyReturnExisting"`
+			ExternalAccountBinding *struct {
+				Protected string `json:"protected"`
+				Payload   string `json:"payload"`
+				Signature string `json:"signature"`
+			} `json:"externalAccountBinding"`
+		}
+		json.Unmarshal(payload, &account)
+
+		if account.OnlyReturnExisting || account.ExternalAccountBinding == nil {
+			acmetest.WriteProblem(w, acme.ProblemDetails{
+				Type: "urn:ietf:params:acme:error:accountDoesNotExist",
+			})
+			return
+		}
+
+		binding := account.ExternalAccountBinding
+		protected, _ := base64.RawURLEncoding.DecodeString(binding.Protected)
+		var header struct {
+			Kid string `json:"kid"`
+		}
+		json.Unmarshal(protected, &header)
+		s.kid = header.Kid
+
+		mac := hmac.New(sha256.New, s.hmacKey)
+		mac.Write([]byte(binding.Protected + "." + binding.Payload))
+		s.verified = base64.RawURLEncoding.EncodeToString(mac.Sum(nil)) == binding.Signature
+
+		s.ca.Register(w, r)
+	}
+}
+
+func (s *EABSuite) TearDownTest() {
+	s.ca.Close()
+}
+
+func (s *EABSuite) user() *User {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	s.Require().NoError(err)
+	user := &User{Email: "cdn@agency.gov"}
+	user.SetPrivateKey(key)
+	return user
+}
+
+func (s *EABSuite) TestRegister() {
+	user := s.user()
+
+	// CAs often give the key in standard, padded base64.
+	_, err := NewClient(config.Settings{
+		AcmeUrl:        s.ca.DirectoryURL(),
+		AcmeEabKid:     "kid-1",
+		AcmeEabHmacKey: base64.StdEncoding.EncodeToString(s.hmacKey),
+	}, user, nil, nil)
+	s.Require().NoError(err)
+	s.Equal(s.ca.AccountURL(), user.GetRegistration().URI)
+	s.Equal("kid-1", s.kid)
+	s.True(s.verified)
+}
+
+func (s *EABSuite) TestRequiredButNotConfigured() {
+	_, err := NewClient(config.Settings{AcmeUrl: s.ca.DirectoryURL()}, s.user(), nil, nil)
+	s.Require().Error(err)
+	s.Contains(err.Error(), "ACME_EAB_KID")
+}
+
+func (s *EABSuite) TestIncomplete() {
+	_, err := NewClient(config.Settings{
+		AcmeUrl:    s.ca.DirectoryURL(),
+		AcmeEabKid: "kid-1",
+	}, s.user(), nil, nil)
+	s.Require().Error(err)
+	s.Contains(err.Error(), "must be set together")
+}
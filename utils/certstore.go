@@ -0,0 +1,54 @@
+package utils
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/acm"
+	"github.com/aws/aws-sdk-go/service/iam"
+
+	"github.com/go-acme/lego/v4/certificate"
+
+	"github.com/cloud-gov/cf-cdn-service-broker/config"
+)
+
+// CloudFront certificate sources
+const (
+	CertificateSourceIam = "iam"
+	CertificateSourceAcm = "acm"
+)
+
+// StoredCertificate is a certificate held by a certificate store.
+type StoredCertificate struct {
+	// Id is how CloudFront refers to the certificate: an IAM server
+	// certificate id, or an ACM certificate ARN.
+	Id       string
+	Arn      string
+	Name     string
+	Uploaded time.Time
+}
+
+// CertificateStoreIface stores certificates where CloudFront can use them.
+type CertificateStoreIface interface {
+	// Source is the CloudFront certificate source of the stored certificates.
+	Source() string
+	// UploadCertificate stores a certificate. If arn refers to a certificate
+	// that the store can replace in place, that certificate is replaced.
+	UploadCertificate(name string, cert certificate.Resource, arn string) (StoredCertificate, error)
+	DeleteCertificate(cert StoredCertificate) error
+	ListCertificates(callback func(StoredCertificate) bool) error
+}
+
+// NewCertificateStore returns the certificate store selected by settings.
+func NewCertificateStore(settings config.Settings, session *session.Session) (CertificateStoreIface, error) {
+	switch settings.CertificateStore {
+	case CertificateSourceIam:
+		return &Iam{Settings: settings, Service: iam.New(session)}, nil
+	case CertificateSourceAcm:
+		return &Acm{Settings: settings, Service: acm.New(session, aws.NewConfig().WithRegion(acmRegion))}, nil
+	default:
+		return nil, fmt.Errorf("unknown certificate store %q", settings.CertificateStore)
+	}
+}
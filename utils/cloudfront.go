@@ -9,17 +9,49 @@ import (
 	"github.com/cloud-gov/cf-cdn-service-broker/config"
 )
 
+// Methods that cache behaviors can allow, as CloudFront accepts them.
+var (
+	ReadMethods        = []string{"HEAD", "GET"}
+	ReadOptionsMethods = []string{"HEAD", "GET", "OPTIONS"}
+	AllMethods         = []string{"HEAD", "GET", "OPTIONS", "PUT", "POST", "PATCH", "DELETE"}
+)
+
+// CacheBehavior configures how requests for paths matching PathPattern, e.g.
+// /api/*, are cached and forwarded to the origin. TTLs are in seconds.
+// Headers are forwarded and keyed on, and OriginHeaders, which need cache
+// policies, are forwarded without being keyed on. If CookieNames are set,
+// only those cookies are forwarded and keyed on, and if QueryStringKeys are,
+// query strings are still forwarded but only those are keyed on.
+type CacheBehavior struct {
+	PathPattern     string   `json:"path_pattern"`
+	MinTTL          int64    `json:"min_ttl"`
+	DefaultTTL      int64    `json:"default_ttl"`
+	MaxTTL          int64    `json:"max_ttl"`
+	AllowedMethods  []string `json:"allowed_methods"`
+	Headers         []string `json:"headers"`
+	OriginHeaders   []string `json:"origin_headers"`
+	Cookies         bool     `json:"cookies"`
+	CookieNames     []string `json:"cookie_names"`
+	QueryString     bool     `json:"query_string"`
+	QueryStringKeys []string `json:"query_string_keys"`
+}
+
 type DistributionIface interface {
-	Create(callerReference string, domains []string, origin, path string, insecureOrigin bool, forwardedHeaders Headers, forwardCookies bool, tags map[string]string) (*cloudfront.Distribution, error)
-	Update(distId string, domains []string, origin, path string, insecureOrigin bool, forwardedHeaders Headers, forwardCookies bool) (*cloudfront.Distribution, error)
+	Create(callerReference string, domains []string, origin, path string, insecureOrigin bool, forwardedHeaders, originHeaders Headers, forwardCookies bool, cookieNames, queryStringKeys []string, cacheBehaviors []CacheBehavior, responseHeaders *ResponseHeaders, errorResponses []ErrorResponse, tags map[string]string) (*cloudfront.Distribution, error)
+	Update(distId string, domains []string, origin, path string, insecureOrigin bool, forwardedHeaders, originHeaders Headers, forwardCookies bool, cookieNames, queryStringKeys []string, cacheBehaviors []CacheBehavior, responseHeaders *ResponseHeaders, errorResponses []ErrorResponse) (*cloudfront.Distribution, error)
 	Get(distId string) (*cloudfront.Distribution, error)
-	SetCertificate(distId, certId string) error
-	SetCertificateAndCname(distId, certId string, domains []string) error
+	ConvertToPolicies(distId string) error
+	SetCertificate(distId, certId, certSource string) error
+	SetCertificateAndCname(distId, certId, certSource string, domains []string) error
 	Disable(distId string) error
 	Delete(distId string) (bool, error)
 	ListDistributions(callback func(cloudfront.DistributionSummary) bool) error
 }
 
+// AcmeChallengePathPattern is the path of the cache behavior that serves
+// HTTP-01 challenges from the bucket.
+const AcmeChallengePathPattern = "/.well-known/acme-challenge/*"
+
 type Distribution struct {
 	Settings config.Settings
 	Service  *cloudfront.CloudFront
@@ -58,41 +90,58 @@ func (d *Distribution) getHeaders(headers []string) *cloudfront.Headers {
 	}
 }
 
-// fillDistributionConfig is a wrapper function that will get all the common config settings for
-// "cloudfront.DistributionConfig". This function is shared between "Create" and "Update".
-// In order to maintain backwards compatibility with older versions of the code where the callerReference was derived
-// from the domain(s), the callerReference has to be explicitly passed in. This is necessary because whenever we do an
-// update, the domains could change but we need to treat the CallerReference like an ID because
-// it can't be changed like the domains and instead the callerReference which was composed of the original domains must
-// be passed in.
-func (d *Distribution) fillDistributionConfig(config *cloudfront.DistributionConfig, origin, path string,
-	insecureOrigin bool, callerReference *string, domains []string, forwardedHeaders []string, forwardCookies bool) {
-	config.CallerReference = callerReference
-	config.Comment = aws.String("cdn route service")
-	config.Enabled = aws.Bool(true)
-	config.IsIPV6Enabled = aws.Bool(true)
+func (d *Distribution) getForwardedValues(behavior CacheBehavior) *cloudfront.ForwardedValues {
+	cookies := &cloudfront.CookiePreference{
+		Forward: aws.String(allOrNone(behavior.Cookies)),
+	}
+	if len(behavior.CookieNames) > 0 {
+		cookies = &cloudfront.CookiePreference{
+			Forward: aws.String("whitelist"),
+			WhitelistedNames: &cloudfront.CookieNames{
+				Quantity: aws.Int64(int64(len(behavior.CookieNames))),
+				Items:    aws.StringSlice(behavior.CookieNames),
+			},
+		}
+	}
 
-	cookies := aws.String("all")
-	if forwardCookies == false {
-		cookies = aws.String("none")
+	return &cloudfront.ForwardedValues{
+		Headers:     d.getHeaders(behavior.Headers),
+		Cookies:     cookies,
+		QueryString: aws.Bool(behavior.QueryString),
+		QueryStringCacheKeys: &cloudfront.QueryStringCacheKeys{
+			Quantity: aws.Int64(int64(len(behavior.QueryStringKeys))),
+			Items:    aws.StringSlice(behavior.QueryStringKeys),
+		},
 	}
+}
 
-	config.DefaultCacheBehavior = &cloudfront.DefaultCacheBehavior{
-		TargetOriginId: aws.String(*callerReference),
-		ForwardedValues: &cloudfront.ForwardedValues{
-			Headers: d.getHeaders(forwardedHeaders),
-			Cookies: &cloudfront.CookiePreference{
-				Forward: cookies,
-			},
-			QueryString: aws.Bool(true),
-			QueryStringCacheKeys: &cloudfront.QueryStringCacheKeys{
-				Quantity: aws.Int64(0),
+func (d *Distribution) getAllowedMethods(methods []string) *cloudfront.AllowedMethods {
+	items := make([]*string, len(methods))
+	for idx, method := range methods {
+		items[idx] = aws.String(method)
+	}
+	return &cloudfront.AllowedMethods{
+		CachedMethods: &cloudfront.CachedMethods{
+			Quantity: aws.Int64(2),
+			Items: []*string{
+				aws.String("HEAD"),
+				aws.String("GET"),
 			},
 		},
+		Quantity: aws.Int64(int64(len(methods))),
+		Items:    items,
+	}
+}
+
+// getCacheBehavior renders an instance's cache behavior for the origin
+// originId, with policies if the broker uses them.
+func (d *Distribution) getCacheBehavior(behavior CacheBehavior, originId string) (*cloudfront.CacheBehavior, error) {
+	cacheBehavior := &cloudfront.CacheBehavior{
+		AllowedMethods:  d.getAllowedMethods(behavior.AllowedMethods),
+		Compress:        aws.Bool(false),
+		PathPattern:     aws.String(behavior.PathPattern),
+		TargetOriginId:  aws.String(originId),
 		SmoothStreaming: aws.Bool(false),
-		DefaultTTL:      aws.Int64(86400),
-		MinTTL:          aws.Int64(0),
-		MaxTTL:          aws.Int64(31536000),
 		LambdaFunctionAssociations: &cloudfront.LambdaFunctionAssociations{
 			Quantity: aws.Int64(0),
 		},
@@ -101,27 +150,87 @@ func (d *Distribution) fillDistributionConfig(config *cloudfront.DistributionCon
 			Quantity: aws.Int64(0),
 		},
 		ViewerProtocolPolicy: aws.String("redirect-to-https"),
-		AllowedMethods: &cloudfront.AllowedMethods{
-			CachedMethods: &cloudfront.CachedMethods{
-				Quantity: aws.Int64(2),
-				Items: []*string{
-					aws.String("HEAD"),
-					aws.String("GET"),
-				},
-			},
-			Quantity: aws.Int64(7),
-			Items: []*string{
-				aws.String("HEAD"),
-				aws.String("GET"),
-				aws.String("OPTIONS"),
-				aws.String("PUT"),
-				aws.String("POST"),
-				aws.String("PATCH"),
-				aws.String("DELETE"),
-			},
-		},
-		Compress: aws.Bool(false),
 	}
+
+	if !d.Settings.CloudFrontPolicies {
+		cacheBehavior.ForwardedValues = d.getForwardedValues(behavior)
+		cacheBehavior.DefaultTTL = aws.Int64(behavior.DefaultTTL)
+		cacheBehavior.MinTTL = aws.Int64(behavior.MinTTL)
+		cacheBehavior.MaxTTL = aws.Int64(behavior.MaxTTL)
+		return cacheBehavior, nil
+	}
+
+	var err error
+	cacheBehavior.CachePolicyId, cacheBehavior.OriginRequestPolicyId, err = d.getPolicyIds(behavior)
+	if err != nil {
+		return nil, err
+	}
+	return cacheBehavior, nil
+}
+
+// getDefaultCacheBehavior renders a distribution's default cache behavior,
+// which has the settings of a cache behavior without its path pattern.
+func (d *Distribution) getDefaultCacheBehavior(behavior CacheBehavior, originId string) (*cloudfront.DefaultCacheBehavior, error) {
+	cacheBehavior, err := d.getCacheBehavior(behavior, originId)
+	if err != nil {
+		return nil, err
+	}
+	return &cloudfront.DefaultCacheBehavior{
+		AllowedMethods:             cacheBehavior.AllowedMethods,
+		CachePolicyId:              cacheBehavior.CachePolicyId,
+		Compress:                   cacheBehavior.Compress,
+		DefaultTTL:                 cacheBehavior.DefaultTTL,
+		ForwardedValues:            cacheBehavior.ForwardedValues,
+		LambdaFunctionAssociations: cacheBehavior.LambdaFunctionAssociations,
+		MaxTTL:                     cacheBehavior.MaxTTL,
+		MinTTL:                     cacheBehavior.MinTTL,
+		OriginRequestPolicyId:      cacheBehavior.OriginRequestPolicyId,
+		ResponseHeadersPolicyId:    cacheBehavior.ResponseHeadersPolicyId,
+		SmoothStreaming:            cacheBehavior.SmoothStreaming,
+		TargetOriginId:             cacheBehavior.TargetOriginId,
+		TrustedSigners:             cacheBehavior.TrustedSigners,
+		ViewerProtocolPolicy:       cacheBehavior.ViewerProtocolPolicy,
+	}, nil
+}
+
+// fillDistributionConfig is a wrapper function that will get all the common config settings for
+// "cloudfront.DistributionConfig". This function is shared between "Create" and "Update".
+// In order to maintain backwards compatibility with older versions of the code where the callerReference was derived
+// from the domain(s), the callerReference has to be explicitly passed in. This is necessary because whenever we do an
+// update, the domains could change but we need to treat the CallerReference like an ID because
+// it can't be changed like the domains and instead the callerReference which was composed of the original domains must
+// be passed in.
+func (d *Distribution) fillDistributionConfig(config *cloudfront.DistributionConfig, origin, path string,
+	insecureOrigin bool, callerReference *string, domains []string, forwardedHeaders, originHeaders []string,
+	forwardCookies bool, cookieNames, queryStringKeys []string, cacheBehaviors []CacheBehavior, responseHeaders *ResponseHeaders, errorResponses []ErrorResponse) error {
+	config.CallerReference = callerReference
+	config.Comment = aws.String("cdn route service")
+	config.Enabled = aws.Bool(true)
+	config.IsIPV6Enabled = aws.Bool(true)
+
+	defaultBehavior, err := d.getDefaultCacheBehavior(CacheBehavior{
+		MinTTL:          0,
+		DefaultTTL:      86400,
+		MaxTTL:          31536000,
+		AllowedMethods:  AllMethods,
+		Headers:         forwardedHeaders,
+		OriginHeaders:   originHeaders,
+		Cookies:         forwardCookies,
+		CookieNames:     cookieNames,
+		QueryString:     true,
+		QueryStringKeys: queryStringKeys,
+	}, *callerReference)
+	if err != nil {
+		return err
+	}
+	// The instance's response headers are added to everything but ACME
+	// challenges.
+	responseHeadersPolicyId, err := d.getResponseHeadersPolicyId(responseHeaders)
+	if err != nil {
+		return err
+	}
+	defaultBehavior.ResponseHeadersPolicyId = responseHeadersPolicyId
+	config.DefaultCacheBehavior = defaultBehavior
 	config.Origins = &cloudfront.Origins{
 		Quantity: aws.Int64(2),
 		Items: []*cloudfront.Origin{
@@ -159,62 +268,60 @@ func (d *Distribution) fillDistributionConfig(config *cloudfront.DistributionCon
 			},
 		},
 	}
+	// The ACME challenge behavior must come first, so that no instance's
+	// behavior can take over its path.
+	acmeBehavior, err := d.getCacheBehavior(CacheBehavior{
+		PathPattern:    AcmeChallengePathPattern,
+		MinTTL:         0,
+		DefaultTTL:     86400,
+		MaxTTL:         31536000,
+		AllowedMethods: ReadMethods,
+	}, fmt.Sprintf("s3-%s-%s", d.Settings.Bucket, *callerReference))
+	if err != nil {
+		return err
+	}
+	acmeBehavior.ViewerProtocolPolicy = aws.String("allow-all")
+
+	behaviors := []*cloudfront.CacheBehavior{acmeBehavior}
+	if usesMaintenancePage(errorResponses) {
+		maintenanceBehavior, err := d.getCacheBehavior(CacheBehavior{
+			PathPattern:    MaintenancePathPattern,
+			MinTTL:         0,
+			DefaultTTL:     300,
+			MaxTTL:         300,
+			AllowedMethods: ReadMethods,
+		}, fmt.Sprintf("s3-%s-%s", d.Settings.Bucket, *callerReference))
+		if err != nil {
+			return err
+		}
+		maintenanceBehavior.ResponseHeadersPolicyId = responseHeadersPolicyId
+		behaviors = append(behaviors, maintenanceBehavior)
+	}
+	for _, behavior := range cacheBehaviors {
+		cacheBehavior, err := d.getCacheBehavior(behavior, *callerReference)
+		if err != nil {
+			return err
+		}
+		cacheBehavior.ResponseHeadersPolicyId = responseHeadersPolicyId
+		behaviors = append(behaviors, cacheBehavior)
+	}
 	config.CacheBehaviors = &cloudfront.CacheBehaviors{
-		Quantity: aws.Int64(1),
-		Items: []*cloudfront.CacheBehavior{
-			{
-				AllowedMethods: &cloudfront.AllowedMethods{
-					CachedMethods: &cloudfront.CachedMethods{
-						Quantity: aws.Int64(2),
-						Items: []*string{
-							aws.String("HEAD"),
-							aws.String("GET"),
-						},
-					},
-					Items: []*string{
-						aws.String("HEAD"),
-						aws.String("GET"),
-					},
-					Quantity: aws.Int64(2),
-				},
-				Compress:       aws.Bool(false),
-				PathPattern:    aws.String("/.well-known/acme-challenge/*"),
-				TargetOriginId: aws.String(fmt.Sprintf("s3-%s-%s", d.Settings.Bucket, *callerReference)),
-				ForwardedValues: &cloudfront.ForwardedValues{
-					Headers: &cloudfront.Headers{
-						Quantity: aws.Int64(0),
-					},
-					QueryString: aws.Bool(false),
-					Cookies: &cloudfront.CookiePreference{
-						Forward: aws.String("none"),
-					},
-					QueryStringCacheKeys: &cloudfront.QueryStringCacheKeys{
-						Quantity: aws.Int64(0),
-					},
-				},
-				SmoothStreaming: aws.Bool(false),
-				DefaultTTL:      aws.Int64(86400),
-				MinTTL:          aws.Int64(0),
-				MaxTTL:          aws.Int64(31536000),
-				LambdaFunctionAssociations: &cloudfront.LambdaFunctionAssociations{
-					Quantity: aws.Int64(0),
-				},
-				TrustedSigners: &cloudfront.TrustedSigners{
-					Enabled:  aws.Bool(false),
-					Quantity: aws.Int64(0),
-				},
-				ViewerProtocolPolicy: aws.String("allow-all"),
-			},
-		},
+		Quantity: aws.Int64(int64(len(behaviors))),
+		Items:    behaviors,
 	}
+	config.CustomErrorResponses = d.getCustomErrorResponses(errorResponses)
 	config.Aliases = d.getAliases(domains)
 	config.PriceClass = aws.String("PriceClass_100")
+	return nil
 }
 
-func (d *Distribution) Create(callerReference string, domains []string, origin, path string, insecureOrigin bool, forwardedHeaders Headers, forwardCookies bool, tags map[string]string) (*cloudfront.Distribution, error) {
+func (d *Distribution) Create(callerReference string, domains []string, origin, path string, insecureOrigin bool, forwardedHeaders, originHeaders Headers, forwardCookies bool, cookieNames, queryStringKeys []string, cacheBehaviors []CacheBehavior, responseHeaders *ResponseHeaders, errorResponses []ErrorResponse, tags map[string]string) (*cloudfront.Distribution, error) {
 	distConfig := new(cloudfront.DistributionConfig)
-	d.fillDistributionConfig(distConfig, origin, path, insecureOrigin,
-		aws.String(callerReference), domains, forwardedHeaders.Strings(), forwardCookies)
+	err := d.fillDistributionConfig(distConfig, origin, path, insecureOrigin,
+		aws.String(callerReference), domains, forwardedHeaders.Strings(), originHeaders.Strings(), forwardCookies, cookieNames, queryStringKeys, cacheBehaviors, responseHeaders, errorResponses)
+	if err != nil {
+		return &cloudfront.Distribution{}, err
+	}
 	resp, err := d.Service.CreateDistributionWithTags(&cloudfront.CreateDistributionWithTagsInput{
 		DistributionConfigWithTags: &cloudfront.DistributionConfigWithTags{
 			DistributionConfig: distConfig,
@@ -229,7 +336,7 @@ func (d *Distribution) Create(callerReference string, domains []string, origin,
 	return resp.Distribution, nil
 }
 
-func (d *Distribution) Update(distId string, domains []string, origin, path string, insecureOrigin bool, forwardedHeaders Headers, forwardCookies bool) (*cloudfront.Distribution, error) {
+func (d *Distribution) Update(distId string, domains []string, origin, path string, insecureOrigin bool, forwardedHeaders, originHeaders Headers, forwardCookies bool, cookieNames, queryStringKeys []string, cacheBehaviors []CacheBehavior, responseHeaders *ResponseHeaders, errorResponses []ErrorResponse) (*cloudfront.Distribution, error) {
 	// Get the current distribution
 	dist, err := d.Service.GetDistributionConfig(&cloudfront.GetDistributionConfigInput{
 		Id: aws.String(distId),
@@ -237,8 +344,11 @@ func (d *Distribution) Update(distId string, domains []string, origin, path stri
 	if err != nil {
 		return nil, err
 	}
-	d.fillDistributionConfig(dist.DistributionConfig, origin, path, insecureOrigin,
-		dist.DistributionConfig.CallerReference, domains, forwardedHeaders.Strings(), forwardCookies)
+	err = d.fillDistributionConfig(dist.DistributionConfig, origin, path, insecureOrigin,
+		dist.DistributionConfig.CallerReference, domains, forwardedHeaders.Strings(), originHeaders.Strings(), forwardCookies, cookieNames, queryStringKeys, cacheBehaviors, responseHeaders, errorResponses)
+	if err != nil {
+		return &cloudfront.Distribution{}, err
+	}
 
 	// Call the UpdateDistribution function
 	resp, err := d.Service.UpdateDistribution(&cloudfront.UpdateDistributionInput{
@@ -262,7 +372,62 @@ func (d *Distribution) Get(distId string) (*cloudfront.Distribution, error) {
 	return resp.Distribution, nil
 }
 
-func (d *Distribution) SetCertificateAndCname(distId, certId string, domains []string) error {
+// ConvertToPolicies moves a distribution's cache behaviors from forwarded
+// values to the equivalent policies, leaving behaviors that use policies
+// already as they are.
+func (d *Distribution) ConvertToPolicies(distId string) error {
+	resp, err := d.Service.GetDistributionConfig(&cloudfront.GetDistributionConfigInput{
+		Id: aws.String(distId),
+	})
+	if err != nil {
+		return err
+	}
+
+	DistributionConfig, ETag := resp.DistributionConfig, resp.ETag
+	if UsesPolicies(DistributionConfig) {
+		return nil
+	}
+
+	if defaultBehavior := DistributionConfig.DefaultCacheBehavior; defaultBehavior.ForwardedValues != nil {
+		behavior, err := legacyCacheBehavior(defaultBehavior.ForwardedValues,
+			defaultBehavior.MinTTL, defaultBehavior.DefaultTTL, defaultBehavior.MaxTTL)
+		if err != nil {
+			return err
+		}
+		defaultBehavior.CachePolicyId, defaultBehavior.OriginRequestPolicyId, err = d.getPolicyIds(behavior)
+		if err != nil {
+			return err
+		}
+		defaultBehavior.ForwardedValues = nil
+		defaultBehavior.MinTTL, defaultBehavior.DefaultTTL, defaultBehavior.MaxTTL = nil, nil, nil
+	}
+	for _, cacheBehavior := range DistributionConfig.CacheBehaviors.Items {
+		if cacheBehavior.ForwardedValues == nil {
+			continue
+		}
+		behavior, err := legacyCacheBehavior(cacheBehavior.ForwardedValues,
+			cacheBehavior.MinTTL, cacheBehavior.DefaultTTL, cacheBehavior.MaxTTL)
+		if err != nil {
+			return fmt.Errorf("cache behavior %s: %v", aws.StringValue(cacheBehavior.PathPattern), err)
+		}
+		cacheBehavior.CachePolicyId, cacheBehavior.OriginRequestPolicyId, err = d.getPolicyIds(behavior)
+		if err != nil {
+			return err
+		}
+		cacheBehavior.ForwardedValues = nil
+		cacheBehavior.MinTTL, cacheBehavior.DefaultTTL, cacheBehavior.MaxTTL = nil, nil, nil
+	}
+
+	_, err = d.Service.UpdateDistribution(&cloudfront.UpdateDistributionInput{
+		Id:                 aws.String(distId),
+		IfMatch:            ETag,
+		DistributionConfig: DistributionConfig,
+	})
+
+	return err
+}
+
+func (d *Distribution) SetCertificateAndCname(distId, certId, certSource string, domains []string) error {
 	resp, err := d.Service.GetDistributionConfig(&cloudfront.GetDistributionConfigInput{
 		Id: aws.String(distId),
 	})
@@ -274,12 +439,7 @@ func (d *Distribution) SetCertificateAndCname(distId, certId string, domains []s
 	DistributionConfig, ETag := resp.DistributionConfig, resp.ETag
 	DistributionConfig.Aliases = aliases
 
-	DistributionConfig.ViewerCertificate.Certificate = aws.String(certId)
-	DistributionConfig.ViewerCertificate.IAMCertificateId = aws.String(certId)
-	DistributionConfig.ViewerCertificate.CertificateSource = aws.String("iam")
-	DistributionConfig.ViewerCertificate.SSLSupportMethod = aws.String("sni-only")
-	DistributionConfig.ViewerCertificate.MinimumProtocolVersion = aws.String("TLSv1.2_2018")
-	DistributionConfig.ViewerCertificate.CloudFrontDefaultCertificate = aws.Bool(false)
+	d.setViewerCertificate(DistributionConfig.ViewerCertificate, certId, certSource)
 
 	_, err = d.Service.UpdateDistribution(&cloudfront.UpdateDistributionInput{
 		Id:                 aws.String(distId),
@@ -289,7 +449,8 @@ func (d *Distribution) SetCertificateAndCname(distId, certId string, domains []s
 
 	return err
 }
-func (d *Distribution) SetCertificate(distId, certId string) error {
+
+func (d *Distribution) SetCertificate(distId, certId, certSource string) error {
 	resp, err := d.Service.GetDistributionConfig(&cloudfront.GetDistributionConfigInput{
 		Id: aws.String(distId),
 	})
@@ -299,12 +460,7 @@ func (d *Distribution) SetCertificate(distId, certId string) error {
 
 	DistributionConfig, ETag := resp.DistributionConfig, resp.ETag
 
-	DistributionConfig.ViewerCertificate.Certificate = aws.String(certId)
-	DistributionConfig.ViewerCertificate.IAMCertificateId = aws.String(certId)
-	DistributionConfig.ViewerCertificate.CertificateSource = aws.String("iam")
-	DistributionConfig.ViewerCertificate.SSLSupportMethod = aws.String("sni-only")
-	DistributionConfig.ViewerCertificate.MinimumProtocolVersion = aws.String("TLSv1.2_2018")
-	DistributionConfig.ViewerCertificate.CloudFrontDefaultCertificate = aws.Bool(false)
+	d.setViewerCertificate(DistributionConfig.ViewerCertificate, certId, certSource)
 
 	_, err = d.Service.UpdateDistribution(&cloudfront.UpdateDistributionInput{
 		Id:                 aws.String(distId),
@@ -315,6 +471,24 @@ func (d *Distribution) SetCertificate(distId, certId string) error {
 	return err
 }
 
+// setViewerCertificate points a viewer certificate at an IAM server
+// certificate id or an ACM certificate ARN, depending on certSource.
+func (d *Distribution) setViewerCertificate(viewerCertificate *cloudfront.ViewerCertificate, certId, certSource string) {
+	viewerCertificate.Certificate = aws.String(certId)
+	viewerCertificate.CertificateSource = aws.String(certSource)
+	switch certSource {
+	case CertificateSourceAcm:
+		viewerCertificate.ACMCertificateArn = aws.String(certId)
+		viewerCertificate.IAMCertificateId = nil
+	default:
+		viewerCertificate.IAMCertificateId = aws.String(certId)
+		viewerCertificate.ACMCertificateArn = nil
+	}
+	viewerCertificate.SSLSupportMethod = aws.String("sni-only")
+	viewerCertificate.MinimumProtocolVersion = aws.String("TLSv1.2_2018")
+	viewerCertificate.CloudFrontDefaultCertificate = aws.Bool(false)
+}
+
 func (d *Distribution) Disable(distId string) error {
 	resp, err := d.Service.GetDistributionConfig(&cloudfront.GetDistributionConfigInput{
 		Id: aws.String(distId),
@@ -0,0 +1,440 @@
+package utils_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudfront"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/cloud-gov/cf-cdn-service-broker/config"
+	. "github.com/cloud-gov/cf-cdn-service-broker/utils"
+)
+
+func TestCloudFront(t *testing.T) {
+	suite.Run(t, new(CloudFrontSuite))
+}
+
+// CloudFrontSuite captures the distribution configs sent to a fake
+// CloudFront, which also keeps the policies created with it.
+type CloudFrontSuite struct {
+	suite.Suite
+
+	distribution            *Distribution
+	created                 *cloudfront.DistributionConfig
+	existing                *cloudfront.DistributionConfig
+	updated                 *cloudfront.DistributionConfig
+	cachePolicies           []*cloudfront.CachePolicyConfig
+	originRequestPolicies   []*cloudfront.OriginRequestPolicyConfig
+	responseHeadersPolicies []*cloudfront.ResponseHeadersPolicyConfig
+}
+
+func (s *CloudFrontSuite) SetupTest() {
+	s.created, s.existing, s.updated = nil, nil, nil
+	s.cachePolicies, s.originRequestPolicies, s.responseHeadersPolicies = nil, nil, nil
+
+	service := cloudfront.New(session.New(aws.NewConfig().WithRegion("us-east-1")))
+	service.Handlers.Clear()
+	service.Handlers.Send.PushBack(func(r *request.Request) {
+		switch input := r.Params.(type) {
+		case *cloudfront.CreateDistributionWithTagsInput:
+			s.created = input.DistributionConfigWithTags.DistributionConfig
+			*r.Data.(*cloudfront.CreateDistributionWithTagsOutput) = cloudfront.CreateDistributionWithTagsOutput{
+				Distribution: &cloudfront.Distribution{
+					Id:         aws.String("dist-1"),
+					DomainName: aws.String("abc.cloudfront.net"),
+				},
+			}
+		case *cloudfront.GetDistributionConfigInput:
+			*r.Data.(*cloudfront.GetDistributionConfigOutput) = cloudfront.GetDistributionConfigOutput{
+				DistributionConfig: s.existing,
+				ETag:               aws.String("etag-1"),
+			}
+		case *cloudfront.UpdateDistributionInput:
+			s.updated = input.DistributionConfig
+			*r.Data.(*cloudfront.UpdateDistributionOutput) = cloudfront.UpdateDistributionOutput{
+				Distribution: &cloudfront.Distribution{
+					Id:         input.Id,
+					DomainName: aws.String("abc.cloudfront.net"),
+				},
+			}
+		case *cloudfront.ListCachePoliciesInput:
+			items := []*cloudfront.CachePolicySummary{}
+			for idx, config := range s.cachePolicies {
+				items = append(items, &cloudfront.CachePolicySummary{
+					CachePolicy: &cloudfront.CachePolicy{
+						Id:                aws.String(fmt.Sprintf("cache-%d", idx)),
+						CachePolicyConfig: config,
+					},
+					Type: aws.String("custom"),
+				})
+			}
+			*r.Data.(*cloudfront.ListCachePoliciesOutput) = cloudfront.ListCachePoliciesOutput{
+				CachePolicyList: &cloudfront.CachePolicyList{Items: items},
+			}
+		case *cloudfront.CreateCachePolicyInput:
+			s.cachePolicies = append(s.cachePolicies, input.CachePolicyConfig)
+			*r.Data.(*cloudfront.CreateCachePolicyOutput) = cloudfront.CreateCachePolicyOutput{
+				CachePolicy: &cloudfront.CachePolicy{
+					Id: aws.String(fmt.Sprintf("cache-%d", len(s.cachePolicies)-1)),
+				},
+			}
+		case *cloudfront.ListOriginRequestPoliciesInput:
+			items := []*cloudfront.OriginRequestPolicySummary{}
+			for idx, config := range s.originRequestPolicies {
+				items = append(items, &cloudfront.OriginRequestPolicySummary{
+					OriginRequestPolicy: &cloudfront.OriginRequestPolicy{
+						Id:                        aws.String(fmt.Sprintf("origin-%d", idx)),
+						OriginRequestPolicyConfig: config,
+					},
+					Type: aws.String("custom"),
+				})
+			}
+			*r.Data.(*cloudfront.ListOriginRequestPoliciesOutput) = cloudfront.ListOriginRequestPoliciesOutput{
+				OriginRequestPolicyList: &cloudfront.OriginRequestPolicyList{Items: items},
+			}
+		case *cloudfront.CreateOriginRequestPolicyInput:
+			s.originRequestPolicies = append(s.originRequestPolicies, input.OriginRequestPolicyConfig)
+			*r.Data.(*cloudfront.CreateOriginRequestPolicyOutput) = cloudfront.CreateOriginRequestPolicyOutput{
+				OriginRequestPolicy: &cloudfront.OriginRequestPolicy{
+					Id: aws.String(fmt.Sprintf("origin-%d", len(s.originRequestPolicies)-1)),
+				},
+			}
+		case *cloudfront.ListResponseHeadersPoliciesInput:
+			items := []*cloudfront.ResponseHeadersPolicySummary{}
+			for idx, config := range s.responseHeadersPolicies {
+				items = append(items, &cloudfront.ResponseHeadersPolicySummary{
+					ResponseHeadersPolicy: &cloudfront.ResponseHeadersPolicy{
+						Id:                          aws.String(fmt.Sprintf("headers-%d", idx)),
+						ResponseHeadersPolicyConfig: config,
+					},
+					Type: aws.String("custom"),
+				})
+			}
+			*r.Data.(*cloudfront.ListResponseHeadersPoliciesOutput) = cloudfront.ListResponseHeadersPoliciesOutput{
+				ResponseHeadersPolicyList: &cloudfront.ResponseHeadersPolicyList{Items: items},
+			}
+		case *cloudfront.CreateResponseHeadersPolicyInput:
+			s.responseHeadersPolicies = append(s.responseHeadersPolicies, input.ResponseHeadersPolicyConfig)
+			*r.Data.(*cloudfront.CreateResponseHeadersPolicyOutput) = cloudfront.CreateResponseHeadersPolicyOutput{
+				ResponseHeadersPolicy: &cloudfront.ResponseHeadersPolicy{
+					Id: aws.String(fmt.Sprintf("headers-%d", len(s.responseHeadersPolicies)-1)),
+				},
+			}
+		}
+	})
+
+	s.distribution = &Distribution{
+		Settings: config.Settings{Bucket: "acme-bucket"},
+		Service:  service,
+	}
+}
+
+func (s *CloudFrontSuite) TestCreateCacheBehaviors() {
+	_, err := s.distribution.Create("instance-1", []string{}, "origin.cloud.gov", "", false,
+		Headers{"Host": true}, Headers{}, true, nil, nil, []CacheBehavior{
+			{
+				PathPattern:    "/api/*",
+				AllowedMethods: AllMethods,
+				Headers:        []string{"Authorization", "Host"},
+				Cookies:        true,
+				QueryString:    true,
+			},
+			{
+				PathPattern:    "/static/*",
+				DefaultTTL:     31536000,
+				MinTTL:         31536000,
+				MaxTTL:         31536000,
+				AllowedMethods: ReadMethods,
+			},
+		}, nil, nil, map[string]string{})
+	s.Require().NoError(err)
+	s.Require().NotNil(s.created)
+
+	behaviors := s.created.CacheBehaviors
+	s.Equal(int64(3), *behaviors.Quantity)
+	s.Require().Len(behaviors.Items, 3)
+
+	acme := behaviors.Items[0]
+	s.Equal(AcmeChallengePathPattern, *acme.PathPattern)
+	s.Equal("s3-acme-bucket-instance-1", *acme.TargetOriginId)
+	s.Equal("allow-all", *acme.ViewerProtocolPolicy)
+
+	api := behaviors.Items[1]
+	s.Equal("/api/*", *api.PathPattern)
+	s.Equal("instance-1", *api.TargetOriginId)
+	s.Equal(int64(0), *api.DefaultTTL)
+	s.Equal(int64(7), *api.AllowedMethods.Quantity)
+	s.Equal(int64(2), *api.ForwardedValues.Headers.Quantity)
+	s.Equal("all", *api.ForwardedValues.Cookies.Forward)
+	s.True(*api.ForwardedValues.QueryString)
+	s.Equal("redirect-to-https", *api.ViewerProtocolPolicy)
+
+	static := behaviors.Items[2]
+	s.Equal("/static/*", *static.PathPattern)
+	s.Equal(int64(31536000), *static.DefaultTTL)
+	s.Equal(int64(2), *static.AllowedMethods.Quantity)
+	s.Equal("none", *static.ForwardedValues.Cookies.Forward)
+	s.False(*static.ForwardedValues.QueryString)
+}
+
+func (s *CloudFrontSuite) TestCreateWithoutCacheBehaviors() {
+	_, err := s.distribution.Create("instance-1", []string{}, "origin.cloud.gov", "", false,
+		Headers{"Host": true}, Headers{}, false, nil, nil, nil, nil, nil, map[string]string{})
+	s.Require().NoError(err)
+
+	s.Equal(int64(1), *s.created.CacheBehaviors.Quantity)
+	s.Equal(AcmeChallengePathPattern, *s.created.CacheBehaviors.Items[0].PathPattern)
+	s.Equal(int64(86400), *s.created.DefaultCacheBehavior.DefaultTTL)
+	s.Equal("none", *s.created.DefaultCacheBehavior.ForwardedValues.Cookies.Forward)
+	s.Equal(int64(7), *s.created.DefaultCacheBehavior.AllowedMethods.Quantity)
+}
+
+func (s *CloudFrontSuite) TestCreateWithPolicies() {
+	s.distribution.Settings.CloudFrontPolicies = true
+	s.distribution.Settings.CloudFrontPolicyPrefix = "cdn-broker"
+
+	_, err := s.distribution.Create("instance-1", []string{}, "origin.cloud.gov", "", false,
+		Headers{"Host": true}, Headers{"*": true}, true, nil, nil, []CacheBehavior{
+			{
+				PathPattern:    "/api/*",
+				AllowedMethods: AllMethods,
+				Headers:        []string{"Authorization"},
+				Cookies:        true,
+				QueryString:    true,
+			},
+			{
+				PathPattern:    "/static/*",
+				DefaultTTL:     86400,
+				MaxTTL:         31536000,
+				AllowedMethods: ReadMethods,
+				Headers:        []string{"Host"},
+				OriginHeaders:  []string{"Accept", "Host"},
+			},
+		}, nil, nil, map[string]string{})
+	s.Require().NoError(err)
+
+	s.Require().Len(s.cachePolicies, 3)
+	s.Require().Len(s.originRequestPolicies, 3)
+
+	defaultBehavior := s.created.DefaultCacheBehavior
+	s.Nil(defaultBehavior.ForwardedValues)
+	s.Nil(defaultBehavior.DefaultTTL)
+	s.Equal("cache-0", *defaultBehavior.CachePolicyId)
+	s.Equal("origin-0", *defaultBehavior.OriginRequestPolicyId)
+
+	keys := s.cachePolicies[0].ParametersInCacheKeyAndForwardedToOrigin
+	s.Regexp("^cdn-broker-[0-9a-f]{32}$", *s.cachePolicies[0].Name)
+	s.Equal(int64(86400), *s.cachePolicies[0].DefaultTTL)
+	s.Equal("whitelist", *keys.HeadersConfig.HeaderBehavior)
+	s.Equal([]string{"Host"}, aws.StringValueSlice(keys.HeadersConfig.Headers.Items))
+	s.Equal("all", *keys.CookiesConfig.CookieBehavior)
+	s.Equal("all", *keys.QueryStringsConfig.QueryStringBehavior)
+
+	// Cookies and query strings reach the origin through the cache key.
+	forwarded := s.originRequestPolicies[0]
+	s.Equal("allViewer", *forwarded.HeadersConfig.HeaderBehavior)
+	s.Equal("none", *forwarded.CookiesConfig.CookieBehavior)
+	s.Equal("none", *forwarded.QueryStringsConfig.QueryStringBehavior)
+
+	behaviors := s.created.CacheBehaviors.Items
+	s.Require().Len(behaviors, 3)
+
+	acme := behaviors[0]
+	s.Equal("cache-1", *acme.CachePolicyId)
+	s.Nil(acme.OriginRequestPolicyId)
+
+	api := behaviors[1]
+	s.Nil(api.ForwardedValues)
+	s.Equal(CachingDisabledPolicyId, *api.CachePolicyId)
+	s.Equal("origin-1", *api.OriginRequestPolicyId)
+	forwarded = s.originRequestPolicies[1]
+	s.Equal([]string{"Authorization"}, aws.StringValueSlice(forwarded.HeadersConfig.Headers.Items))
+	s.Equal("all", *forwarded.CookiesConfig.CookieBehavior)
+	s.Equal("all", *forwarded.QueryStringsConfig.QueryStringBehavior)
+
+	static := behaviors[2]
+	s.Equal("cache-2", *static.CachePolicyId)
+	s.Equal("origin-2", *static.OriginRequestPolicyId)
+	forwarded = s.originRequestPolicies[2]
+	s.Equal([]string{"Accept"}, aws.StringValueSlice(forwarded.HeadersConfig.Headers.Items))
+}
+
+func (s *CloudFrontSuite) TestCreateSharesPolicies() {
+	s.distribution.Settings.CloudFrontPolicies = true
+
+	for i := 0; i < 2; i++ {
+		_, err := s.distribution.Create(fmt.Sprintf("instance-%d", i), []string{}, "origin.cloud.gov", "", false,
+			Headers{"Host": true}, Headers{"Accept": true}, false, nil, nil, nil, nil, nil, map[string]string{})
+		s.Require().NoError(err)
+	}
+
+	s.Len(s.cachePolicies, 2)
+	s.Len(s.originRequestPolicies, 1)
+	s.Equal("origin-0", *s.created.DefaultCacheBehavior.OriginRequestPolicyId)
+}
+
+func (s *CloudFrontSuite) TestConvertToPolicies() {
+	s.distribution.Settings.CloudFrontPolicies = true
+
+	_, err := s.distribution.Create("instance-1", []string{}, "origin.cloud.gov", "", false,
+		Headers{"Host": true}, Headers{}, true, nil, nil, []CacheBehavior{{
+			PathPattern:    "/api/*",
+			AllowedMethods: AllMethods,
+			Headers:        []string{"*"},
+			Cookies:        true,
+			QueryString:    true,
+		}}, nil, nil, map[string]string{})
+	s.Require().NoError(err)
+	expected := s.created
+
+	s.distribution.Settings.CloudFrontPolicies = false
+	_, err = s.distribution.Create("instance-1", []string{}, "origin.cloud.gov", "", false,
+		Headers{"Host": true}, Headers{}, true, nil, nil, []CacheBehavior{{
+			PathPattern:    "/api/*",
+			AllowedMethods: AllMethods,
+			Headers:        []string{"*"},
+			Cookies:        true,
+			QueryString:    true,
+		}}, nil, nil, map[string]string{})
+	s.Require().NoError(err)
+	s.False(UsesPolicies(s.created))
+
+	s.distribution.Settings.CloudFrontPolicies = true
+	s.existing = s.created
+	s.Require().NoError(s.distribution.ConvertToPolicies("dist-1"))
+	s.Require().NotNil(s.updated)
+	s.True(UsesPolicies(s.updated))
+	s.Equal(expected.DefaultCacheBehavior, s.updated.DefaultCacheBehavior)
+	s.Equal(expected.CacheBehaviors, s.updated.CacheBehaviors)
+	s.Equal(AllViewerPolicyId, *s.updated.CacheBehaviors.Items[1].OriginRequestPolicyId)
+
+	// Converted distributions are left as they are.
+	s.updated = nil
+	s.existing = expected
+	s.Require().NoError(s.distribution.ConvertToPolicies("dist-1"))
+	s.Nil(s.updated)
+}
+
+func (s *CloudFrontSuite) TestCreateWithWhitelists() {
+	_, err := s.distribution.Create("instance-1", []string{}, "origin.cloud.gov", "", false,
+		Headers{"Host": true}, Headers{}, true, []string{"session"}, []string{"q", "page"}, nil, nil, nil, map[string]string{})
+	s.Require().NoError(err)
+
+	forwarded := s.created.DefaultCacheBehavior.ForwardedValues
+	s.Equal("whitelist", *forwarded.Cookies.Forward)
+	s.Equal([]string{"session"}, aws.StringValueSlice(forwarded.Cookies.WhitelistedNames.Items))
+	s.True(*forwarded.QueryString)
+	s.Equal(int64(2), *forwarded.QueryStringCacheKeys.Quantity)
+	s.Equal([]string{"q", "page"}, aws.StringValueSlice(forwarded.QueryStringCacheKeys.Items))
+
+	// Converting the distribution keeps the whitelists.
+	s.distribution.Settings.CloudFrontPolicies = true
+	s.existing = s.created
+	s.Require().NoError(s.distribution.ConvertToPolicies("dist-1"))
+	s.Require().NotNil(s.updated)
+
+	s.Require().Len(s.cachePolicies, 2)
+	keys := s.cachePolicies[0].ParametersInCacheKeyAndForwardedToOrigin
+	s.Equal("whitelist", *keys.CookiesConfig.CookieBehavior)
+	s.Equal([]string{"session"}, aws.StringValueSlice(keys.CookiesConfig.Cookies.Items))
+	s.Equal("whitelist", *keys.QueryStringsConfig.QueryStringBehavior)
+	s.Equal([]string{"page", "q"}, aws.StringValueSlice(keys.QueryStringsConfig.QueryStrings.Items))
+
+	// Query strings that aren't keyed on still reach the origin.
+	s.Require().Len(s.originRequestPolicies, 1)
+	forwardedToOrigin := s.originRequestPolicies[0]
+	s.Equal("none", *forwardedToOrigin.HeadersConfig.HeaderBehavior)
+	s.Equal("none", *forwardedToOrigin.CookiesConfig.CookieBehavior)
+	s.Equal("all", *forwardedToOrigin.QueryStringsConfig.QueryStringBehavior)
+	s.Equal("origin-0", *s.updated.DefaultCacheBehavior.OriginRequestPolicyId)
+}
+
+func (s *CloudFrontSuite) TestCreateWithResponseHeaders() {
+	headers, err := ParseResponseHeaders(`{
+		"strict_transport_security": {"max_age": 31536000, "include_subdomains": true},
+		"content_type_options": true,
+		"frame_options": "deny",
+		"custom_headers": {"X-Agency": "gsa"},
+		"cors": {"allow_origins": ["https://www.agency.gov"]}
+	}`)
+	s.Require().NoError(err)
+
+	for i := 0; i < 2; i++ {
+		_, err = s.distribution.Create(fmt.Sprintf("instance-%d", i), []string{}, "origin.cloud.gov", "", false,
+			Headers{"Host": true}, Headers{}, true, nil, nil, []CacheBehavior{{
+				PathPattern:    "/api/*",
+				AllowedMethods: AllMethods,
+			}}, headers, nil, map[string]string{})
+		s.Require().NoError(err)
+	}
+
+	// Distributions with the same headers share a policy.
+	s.Require().Len(s.responseHeadersPolicies, 1)
+	policy := s.responseHeadersPolicies[0]
+	s.Equal(int64(31536000), *policy.SecurityHeadersConfig.StrictTransportSecurity.AccessControlMaxAgeSec)
+	s.True(*policy.SecurityHeadersConfig.StrictTransportSecurity.IncludeSubdomains)
+	s.NotNil(policy.SecurityHeadersConfig.ContentTypeOptions)
+	s.Equal("DENY", *policy.SecurityHeadersConfig.FrameOptions.FrameOption)
+	s.Nil(policy.SecurityHeadersConfig.ReferrerPolicy)
+	s.Equal("X-Agency", *policy.CustomHeadersConfig.Items[0].Header)
+	s.Equal([]string{"GET", "HEAD", "OPTIONS"}, aws.StringValueSlice(policy.CorsConfig.AccessControlAllowMethods.Items))
+	s.Equal([]string{"*"}, aws.StringValueSlice(policy.CorsConfig.AccessControlAllowHeaders.Items))
+
+	// ACME challenges are served without the headers.
+	s.Equal("headers-0", *s.created.DefaultCacheBehavior.ResponseHeadersPolicyId)
+	s.Nil(s.created.CacheBehaviors.Items[0].ResponseHeadersPolicyId)
+	s.Equal("headers-0", *s.created.CacheBehaviors.Items[1].ResponseHeadersPolicyId)
+
+	// Empty headers detach the policy.
+	s.existing = s.created
+	_, err = s.distribution.Update("dist-1", []string{}, "origin.cloud.gov", "", false,
+		Headers{"Host": true}, Headers{}, true, nil, nil, nil, &ResponseHeaders{}, nil)
+	s.Require().NoError(err)
+	s.Nil(s.updated.DefaultCacheBehavior.ResponseHeadersPolicyId)
+	s.Len(s.responseHeadersPolicies, 1)
+}
+
+func (s *CloudFrontSuite) TestCreateWithErrorResponses() {
+	responses := append([]ErrorResponse{
+		{ErrorCode: 404, ResponseCode: 404, ResponsePagePath: "/404.html"},
+		{ErrorCode: 500, CachingMinTTL: aws.Int64(0)},
+	}, MaintenanceErrorResponses()...)
+	_, err := s.distribution.Create("instance-1", []string{}, "origin.cloud.gov", "", false,
+		Headers{"Host": true}, Headers{}, true, nil, nil, []CacheBehavior{{
+			PathPattern:    "/api/*",
+			AllowedMethods: AllMethods,
+		}}, nil, responses, map[string]string{})
+	s.Require().NoError(err)
+
+	errorResponses := s.created.CustomErrorResponses
+	s.Equal(int64(5), *errorResponses.Quantity)
+	s.Equal("/404.html", *errorResponses.Items[0].ResponsePagePath)
+	s.Equal("404", *errorResponses.Items[0].ResponseCode)
+	s.Nil(errorResponses.Items[0].ErrorCachingMinTTL)
+	s.Nil(errorResponses.Items[1].ResponsePagePath)
+	s.Nil(errorResponses.Items[1].ResponseCode)
+	s.Equal(int64(0), *errorResponses.Items[1].ErrorCachingMinTTL)
+	s.Equal(int64(502), *errorResponses.Items[2].ErrorCode)
+	s.Equal("503", *errorResponses.Items[2].ResponseCode)
+	s.Equal(MaintenancePagePath, *errorResponses.Items[2].ResponsePagePath)
+
+	// The maintenance page is served from the bucket, after ACME challenges.
+	behaviors := s.created.CacheBehaviors
+	s.Equal(int64(3), *behaviors.Quantity)
+	s.Equal(AcmeChallengePathPattern, *behaviors.Items[0].PathPattern)
+	s.Equal(MaintenancePathPattern, *behaviors.Items[1].PathPattern)
+	s.Equal("s3-acme-bucket-instance-1", *behaviors.Items[1].TargetOriginId)
+	s.Equal("/api/*", *behaviors.Items[2].PathPattern)
+
+	// Without the maintenance page, the behavior and error responses go.
+	s.existing = s.created
+	_, err = s.distribution.Update("dist-1", []string{}, "origin.cloud.gov", "", false,
+		Headers{"Host": true}, Headers{}, true, nil, nil, nil, nil, []ErrorResponse{})
+	s.Require().NoError(err)
+	s.Equal(int64(0), *s.updated.CustomErrorResponses.Quantity)
+	s.Equal(int64(1), *s.updated.CacheBehaviors.Quantity)
+}
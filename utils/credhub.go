@@ -0,0 +1,142 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+
+	"github.com/cloud-gov/cf-cdn-service-broker/config"
+)
+
+type CredhubIface interface {
+	GetCertificate(name string) (CustomCertificate, error)
+}
+
+// Credhub reads certificate credentials from CredHub, authenticating with
+// the UAA client CREDHUB_CLIENT_ID.
+type Credhub struct {
+	URL          string
+	ClientID     string
+	ClientSecret string
+
+	mu     sync.Mutex
+	client *http.Client
+}
+
+// NewCredhub returns a CredHub client, or nil if CREDHUB_URL isn't set.
+func NewCredhub(settings config.Settings) *Credhub {
+	if settings.CredhubURL == "" {
+		return nil
+	}
+	return &Credhub{
+		URL:          strings.TrimSuffix(settings.CredhubURL, "/"),
+		ClientID:     settings.CredhubClientID,
+		ClientSecret: settings.CredhubClientSecret,
+	}
+}
+
+// GetCertificate returns the current value of the certificate credential
+// name. The credential's CA is used as the certificate's chain.
+func (c *Credhub) GetCertificate(name string) (CustomCertificate, error) {
+	if c == nil {
+		return CustomCertificate{}, errors.New("CredHub isn't configured")
+	}
+
+	client, err := c.httpClient()
+	if err != nil {
+		return CustomCertificate{}, err
+	}
+
+	query := url.Values{"name": {name}, "current": {"true"}}
+	resp, err := client.Get(c.URL + "/api/v1/data?" + query.Encode())
+	if err != nil {
+		return CustomCertificate{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return CustomCertificate{}, fmt.Errorf("CredHub credential %s not found", name)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return CustomCertificate{}, fmt.Errorf("CredHub answered %s for %s", resp.Status, name)
+	}
+
+	var body struct {
+		Data []struct {
+			Type  string          `json:"type"`
+			Value json.RawMessage `json:"value"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return CustomCertificate{}, err
+	}
+	if len(body.Data) == 0 {
+		return CustomCertificate{}, fmt.Errorf("CredHub credential %s not found", name)
+	}
+	credential := body.Data[0]
+	if credential.Type != "certificate" {
+		return CustomCertificate{}, fmt.Errorf("CredHub credential %s is a %s, not a certificate", name, credential.Type)
+	}
+
+	var value struct {
+		CA          string `json:"ca"`
+		Certificate string `json:"certificate"`
+		PrivateKey  string `json:"private_key"`
+	}
+	if err := json.Unmarshal(credential.Value, &value); err != nil {
+		return CustomCertificate{}, err
+	}
+
+	return CustomCertificate{
+		Certificate: value.Certificate,
+		Chain:       value.CA,
+		PrivateKey:  value.PrivateKey,
+		CredhubRef:  name,
+	}, nil
+}
+
+// httpClient authenticates against the UAA that CredHub names in its info.
+func (c *Credhub) httpClient() (*http.Client, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.client != nil {
+		return c.client, nil
+	}
+
+	plain := &http.Client{Timeout: 30 * time.Second}
+	resp, err := plain.Get(c.URL + "/info")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var info struct {
+		AuthServer struct {
+			URL string `json:"url"`
+		} `json:"auth-server"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return nil, fmt.Errorf("reading CredHub info: %v", err)
+	}
+	if info.AuthServer.URL == "" {
+		return nil, errors.New("CredHub info names no auth server")
+	}
+
+	credentials := clientcredentials.Config{
+		ClientID:     c.ClientID,
+		ClientSecret: c.ClientSecret,
+		TokenURL:     strings.TrimSuffix(info.AuthServer.URL, "/") + "/oauth/token",
+	}
+	c.client = credentials.Client(context.WithValue(context.Background(), oauth2.HTTPClient, plain))
+	return c.client, nil
+}
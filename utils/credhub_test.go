@@ -0,0 +1,119 @@
+package utils_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/cloud-gov/cf-cdn-service-broker/config"
+	. "github.com/cloud-gov/cf-cdn-service-broker/utils"
+)
+
+func TestCredhub(t *testing.T) {
+	suite.Run(t, new(CredhubSuite))
+}
+
+// CredhubSuite reads credentials from a fake CredHub that is its own UAA.
+type CredhubSuite struct {
+	suite.Suite
+
+	server  *httptest.Server
+	credhub *Credhub
+	data    map[string]interface{}
+}
+
+func (s *CredhubSuite) SetupTest() {
+	s.data = map[string]interface{}{}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/info", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"auth-server": map[string]string{"url": s.server.URL},
+		})
+	})
+	mux.HandleFunc("/oauth/token", func(w http.ResponseWriter, r *http.Request) {
+		if user, password, ok := r.BasicAuth(); !ok || user != "broker" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "token",
+			"token_type":   "bearer",
+			"expires_in":   3600,
+		})
+	})
+	mux.HandleFunc("/api/v1/data", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		credential, ok := s.data[r.URL.Query().Get("name")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": []interface{}{credential},
+		})
+	})
+	s.server = httptest.NewServer(mux)
+
+	s.credhub = NewCredhub(config.Settings{
+		CredhubURL:          s.server.URL + "/",
+		CredhubClientID:     "broker",
+		CredhubClientSecret: "secret",
+	})
+}
+
+func (s *CredhubSuite) TearDownTest() {
+	s.server.Close()
+}
+
+func (s *CredhubSuite) TestNotConfigured() {
+	s.Nil(NewCredhub(config.Settings{}))
+
+	var credhub *Credhub
+	_, err := credhub.GetCertificate("/agency/cert")
+	s.Error(err)
+}
+
+func (s *CredhubSuite) TestGetCertificate() {
+	s.data["/agency/cert"] = map[string]interface{}{
+		"type": "certificate",
+		"value": map[string]string{
+			"ca":          "chain",
+			"certificate": "cert",
+			"private_key": "key",
+		},
+	}
+
+	cert, err := s.credhub.GetCertificate("/agency/cert")
+	s.Require().NoError(err)
+	s.Equal(CustomCertificate{
+		Certificate: "cert",
+		Chain:       "chain",
+		PrivateKey:  "key",
+		CredhubRef:  "/agency/cert",
+	}, cert)
+}
+
+func (s *CredhubSuite) TestNotCertificate() {
+	s.data["/agency/password"] = map[string]interface{}{
+		"type":  "password",
+		"value": "hunter2",
+	}
+
+	_, err := s.credhub.GetCertificate("/agency/password")
+	s.Require().Error(err)
+	s.Contains(err.Error(), "is a password, not a certificate")
+}
+
+func (s *CredhubSuite) TestNotFound() {
+	_, err := s.credhub.GetCertificate("/agency/missing")
+	s.Require().Error(err)
+	s.Contains(err.Error(), "not found")
+}
@@ -0,0 +1,278 @@
+package utils
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
+
+	"github.com/cloud-gov/cf-cdn-service-broker/config"
+)
+
+// Prefixes of key versions, which name the master key that a secret's data
+// key is wrapped with.
+const (
+	KeyProviderLocal = "local"
+	KeyProviderKms   = "kms"
+)
+
+// kmsEncryptionContext is bound to every data key wrapped with KMS.
+var kmsEncryptionContext = map[string]*string{"application": aws.String("cf-cdn-service-broker")}
+
+// KeyProviderIface wraps the data keys that secrets are encrypted with.
+type KeyProviderIface interface {
+	// Version identifies the master key, and is stored with each secret.
+	Version() string
+	WrapKey(dataKey []byte) ([]byte, error)
+	UnwrapKey(wrapped []byte) ([]byte, error)
+}
+
+// LocalKeyProvider wraps data keys with a 32-byte AES master key from the
+// broker's settings.
+type LocalKeyProvider struct {
+	version string
+	aead    cipher.AEAD
+}
+
+func NewLocalKeyProvider(version string, key []byte) (*LocalKeyProvider, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, fmt.Errorf("key encryption key %s: %v", version, err)
+	}
+	return &LocalKeyProvider{version: version, aead: aead}, nil
+}
+
+func (p *LocalKeyProvider) Version() string {
+	return KeyProviderLocal + ":" + p.version
+}
+
+func (p *LocalKeyProvider) WrapKey(dataKey []byte) ([]byte, error) {
+	return seal(p.aead, dataKey)
+}
+
+func (p *LocalKeyProvider) UnwrapKey(wrapped []byte) ([]byte, error) {
+	return open(p.aead, wrapped)
+}
+
+// KmsKeyProvider wraps data keys with a KMS key.
+type KmsKeyProvider struct {
+	KeyId   string
+	Service kmsiface.KMSAPI
+}
+
+func (p *KmsKeyProvider) Version() string {
+	return KeyProviderKms + ":" + p.KeyId
+}
+
+func (p *KmsKeyProvider) WrapKey(dataKey []byte) ([]byte, error) {
+	resp, err := p.Service.Encrypt(&kms.EncryptInput{
+		KeyId:             aws.String(p.KeyId),
+		Plaintext:         dataKey,
+		EncryptionContext: kmsEncryptionContext,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.CiphertextBlob, nil
+}
+
+func (p *KmsKeyProvider) UnwrapKey(wrapped []byte) ([]byte, error) {
+	resp, err := p.Service.Decrypt(&kms.DecryptInput{
+		KeyId:             aws.String(p.KeyId),
+		CiphertextBlob:    wrapped,
+		EncryptionContext: kmsEncryptionContext,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Plaintext, nil
+}
+
+// envelope is a secret encrypted with its own data key, stored alongside
+// the wrapped data key.
+type envelope struct {
+	Key  []byte `json:"key"`
+	Data []byte `json:"data"`
+}
+
+// Encryptor envelope-encrypts the secrets that the broker stores in its
+// database. New secrets are encrypted with the current key provider, and
+// secrets are decrypted with the provider named by their key version. A nil
+// or zero Encryptor stores secrets in plain text.
+type Encryptor struct {
+	current   KeyProviderIface
+	providers map[string]KeyProviderIface
+	kms       kmsiface.KMSAPI
+}
+
+// NewEncryptor returns an Encryptor that encrypts with current, which may be
+// nil, and decrypts with current or any of others.
+func NewEncryptor(current KeyProviderIface, others ...KeyProviderIface) *Encryptor {
+	e := &Encryptor{current: current, providers: map[string]KeyProviderIface{}}
+	for _, provider := range append(others, current) {
+		if provider != nil {
+			e.providers[provider.Version()] = provider
+		}
+	}
+	return e
+}
+
+// NewEncryptorFromSettings encrypts with the KMS key KMS_KEY_ID if it is set,
+// or else with the local key KEY_ENCRYPTION_KEYS[KEY_ENCRYPTION_KEY_VERSION].
+// Every local key, and any KMS key, can be used to decrypt.
+func NewEncryptorFromSettings(settings config.Settings, session *session.Session) (*Encryptor, error) {
+	locals := []KeyProviderIface{}
+	var current KeyProviderIface
+	for version, encoded := range settings.KeyEncryptionKeys {
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("decoding key encryption key %s: %v", version, err)
+		}
+		provider, err := NewLocalKeyProvider(version, key)
+		if err != nil {
+			return nil, err
+		}
+		locals = append(locals, provider)
+		if version == settings.KeyEncryptionKeyVersion {
+			current = provider
+		}
+	}
+
+	if settings.KeyEncryptionKeyVersion != "" && current == nil {
+		return nil, fmt.Errorf("no key encryption key has version %s", settings.KeyEncryptionKeyVersion)
+	}
+
+	service := kms.New(session)
+	if settings.KmsKeyId != "" {
+		current = &KmsKeyProvider{KeyId: settings.KmsKeyId, Service: service}
+	}
+
+	e := NewEncryptor(current, locals...)
+	e.kms = service
+	return e, nil
+}
+
+// Version returns the key version that new secrets are encrypted with, or ""
+// if they are stored in plain text.
+func (e *Encryptor) Version() string {
+	if e == nil || e.current == nil {
+		return ""
+	}
+	return e.current.Version()
+}
+
+// Encrypt returns the encrypted secret and the version of the key it was
+// encrypted with.
+func (e *Encryptor) Encrypt(plaintext []byte) ([]byte, string, error) {
+	if e == nil || e.current == nil {
+		return plaintext, "", nil
+	}
+
+	dataKey := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return nil, "", err
+	}
+
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, "", err
+	}
+	data, err := seal(aead, plaintext)
+	if err != nil {
+		return nil, "", err
+	}
+
+	wrapped, err := e.current.WrapKey(dataKey)
+	if err != nil {
+		return nil, "", err
+	}
+
+	ciphertext, err := json.Marshal(envelope{Key: wrapped, Data: data})
+	if err != nil {
+		return nil, "", err
+	}
+	return ciphertext, e.current.Version(), nil
+}
+
+// Decrypt decrypts a secret encrypted with the given key version. Secrets
+// without a version are stored in plain text.
+func (e *Encryptor) Decrypt(ciphertext []byte, version string) ([]byte, error) {
+	if version == "" {
+		return ciphertext, nil
+	}
+
+	provider, err := e.provider(version)
+	if err != nil {
+		return nil, err
+	}
+
+	var env envelope
+	if err := json.Unmarshal(ciphertext, &env); err != nil {
+		return nil, fmt.Errorf("decoding encrypted secret: %v", err)
+	}
+
+	dataKey, err := provider.UnwrapKey(env.Key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return open(aead, env.Data)
+}
+
+func (e *Encryptor) provider(version string) (KeyProviderIface, error) {
+	if e == nil {
+		return nil, fmt.Errorf("no key encryption key has version %s", version)
+	}
+	if provider, ok := e.providers[version]; ok {
+		return provider, nil
+	}
+
+	// KMS keys are resolved by the ciphertext, so any KMS key the broker can
+	// use will do, e.g. after KMS_KEY_ID has been changed.
+	if keyId := strings.TrimPrefix(version, KeyProviderKms+":"); keyId != version && e.kms != nil {
+		return &KmsKeyProvider{KeyId: keyId, Service: e.kms}, nil
+	}
+
+	return nil, fmt.Errorf("no key encryption key has version %s", version)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("key must be 32 bytes, got %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts plaintext, prefixed with a random nonce.
+func seal(aead cipher.AEAD, plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func open(aead cipher.AEAD, ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, errors.New("ciphertext is too short")
+	}
+	nonce, sealed := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	return aead.Open(nil, nonce, sealed, nil)
+}
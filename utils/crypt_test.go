@@ -0,0 +1,189 @@
+package utils_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/cloud-gov/cf-cdn-service-broker/config"
+	. "github.com/cloud-gov/cf-cdn-service-broker/utils"
+)
+
+func TestCrypt(t *testing.T) {
+	suite.Run(t, new(CryptSuite))
+}
+
+type CryptSuite struct {
+	suite.Suite
+
+	session *session.Session
+	kms     *kms.KMS
+	kmsKeys map[string]bool
+}
+
+func (s *CryptSuite) SetupTest() {
+	s.session = session.New(aws.NewConfig().WithRegion("us-east-1"))
+
+	// The fake KMS "wraps" data keys by prefixing them with the key id.
+	s.kmsKeys = map[string]bool{"alias/cdn-broker": true}
+	s.kms = kms.New(s.session)
+	s.kms.Handlers.Clear()
+	s.kms.Handlers.Send.PushBack(func(r *request.Request) {
+		switch r.Operation.Name {
+		case "Encrypt":
+			input := r.Params.(*kms.EncryptInput)
+			s.Equal("cf-cdn-service-broker", aws.StringValue(input.EncryptionContext["application"]))
+			r.Data.(*kms.EncryptOutput).CiphertextBlob = append([]byte(aws.StringValue(input.KeyId)+":"), input.Plaintext...)
+		case "Decrypt":
+			input := r.Params.(*kms.DecryptInput)
+			prefix := []byte(aws.StringValue(input.KeyId) + ":")
+			if !s.kmsKeys[aws.StringValue(input.KeyId)] || !bytes.HasPrefix(input.CiphertextBlob, prefix) {
+				r.Error = errors.New("AccessDeniedException")
+				return
+			}
+			r.Data.(*kms.DecryptOutput).Plaintext = bytes.TrimPrefix(input.CiphertextBlob, prefix)
+		}
+	})
+}
+
+func (s *CryptSuite) localKey(version, key string) *LocalKeyProvider {
+	provider, err := NewLocalKeyProvider(version, []byte(key))
+	s.Require().NoError(err)
+	return provider
+}
+
+func (s *CryptSuite) TestRoundTrip() {
+	keys := NewEncryptor(s.localKey("1", "0123456789abcdef0123456789abcdef"))
+	s.Equal("local:1", keys.Version())
+
+	ciphertext, version, err := keys.Encrypt([]byte("private key"))
+	s.Require().NoError(err)
+	s.Equal("local:1", version)
+	s.NotContains(string(ciphertext), "private key")
+
+	again, _, err := keys.Encrypt([]byte("private key"))
+	s.Require().NoError(err)
+	s.NotEqual(ciphertext, again)
+
+	plaintext, err := keys.Decrypt(ciphertext, version)
+	s.NoError(err)
+	s.Equal("private key", string(plaintext))
+}
+
+func (s *CryptSuite) TestRotation() {
+	old := NewEncryptor(s.localKey("1", "0123456789abcdef0123456789abcdef"))
+	ciphertext, version, err := old.Encrypt([]byte("private key"))
+	s.Require().NoError(err)
+
+	rotated := NewEncryptor(s.localKey("2", "fedcba9876543210fedcba9876543210"), s.localKey("1", "0123456789abcdef0123456789abcdef"))
+	s.Equal("local:2", rotated.Version())
+
+	plaintext, err := rotated.Decrypt(ciphertext, version)
+	s.NoError(err)
+	s.Equal("private key", string(plaintext))
+
+	retired := NewEncryptor(s.localKey("2", "fedcba9876543210fedcba9876543210"))
+	_, err = retired.Decrypt(ciphertext, version)
+	s.EqualError(err, "no key encryption key has version local:1")
+}
+
+func (s *CryptSuite) TestWrongKey() {
+	keys := NewEncryptor(s.localKey("1", "0123456789abcdef0123456789abcdef"))
+	other := NewEncryptor(s.localKey("1", "fedcba9876543210fedcba9876543210"))
+
+	ciphertext, version, err := keys.Encrypt([]byte("private key"))
+	s.Require().NoError(err)
+
+	_, err = other.Decrypt(ciphertext, version)
+	s.Error(err)
+
+	_, err = keys.Decrypt([]byte("short"), version)
+	s.Error(err)
+}
+
+func (s *CryptSuite) TestPlaintext() {
+	for _, keys := range []*Encryptor{nil, {}, NewEncryptor(nil)} {
+		s.Equal("", keys.Version())
+
+		ciphertext, version, err := keys.Encrypt([]byte("private key"))
+		s.NoError(err)
+		s.Equal("", version)
+		s.Equal("private key", string(ciphertext))
+
+		plaintext, err := keys.Decrypt(ciphertext, version)
+		s.NoError(err)
+		s.Equal("private key", string(plaintext))
+
+		_, err = keys.Decrypt(ciphertext, "local:1")
+		s.Error(err)
+	}
+
+	// Secrets stored before a key was configured can still be read.
+	keys := NewEncryptor(s.localKey("1", "0123456789abcdef0123456789abcdef"))
+	plaintext, err := keys.Decrypt([]byte("private key"), "")
+	s.NoError(err)
+	s.Equal("private key", string(plaintext))
+}
+
+func (s *CryptSuite) TestKms() {
+	keys := NewEncryptor(&KmsKeyProvider{KeyId: "alias/cdn-broker", Service: s.kms})
+	s.Equal("kms:alias/cdn-broker", keys.Version())
+
+	ciphertext, version, err := keys.Encrypt([]byte("private key"))
+	s.Require().NoError(err)
+	s.Equal("kms:alias/cdn-broker", version)
+	s.NotContains(string(ciphertext), "private key")
+
+	plaintext, err := keys.Decrypt(ciphertext, version)
+	s.NoError(err)
+	s.Equal("private key", string(plaintext))
+
+	s.kmsKeys["alias/cdn-broker"] = false
+	_, err = keys.Decrypt(ciphertext, version)
+	s.Error(err)
+}
+
+func (s *CryptSuite) TestSettings() {
+	key := base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef"))
+
+	keys, err := NewEncryptorFromSettings(config.Settings{}, s.session)
+	s.NoError(err)
+	s.Equal("", keys.Version())
+
+	keys, err = NewEncryptorFromSettings(config.Settings{
+		KeyEncryptionKeys:       map[string]string{"1": key, "2": key},
+		KeyEncryptionKeyVersion: "2",
+	}, s.session)
+	s.NoError(err)
+	s.Equal("local:2", keys.Version())
+
+	keys, err = NewEncryptorFromSettings(config.Settings{
+		KeyEncryptionKeys: map[string]string{"1": key},
+		KmsKeyId:          "alias/cdn-broker",
+	}, s.session)
+	s.NoError(err)
+	s.Equal("kms:alias/cdn-broker", keys.Version())
+
+	_, err = NewEncryptorFromSettings(config.Settings{
+		KeyEncryptionKeys:       map[string]string{"1": key},
+		KeyEncryptionKeyVersion: "2",
+	}, s.session)
+	s.Error(err)
+
+	_, err = NewEncryptorFromSettings(config.Settings{
+		KeyEncryptionKeys: map[string]string{"1": base64.StdEncoding.EncodeToString([]byte("too short"))},
+	}, s.session)
+	s.Error(err)
+
+	_, err = NewEncryptorFromSettings(config.Settings{
+		KeyEncryptionKeys: map[string]string{"1": "not base64!"},
+	}, s.session)
+	s.Error(err)
+}
@@ -0,0 +1,127 @@
+package utils
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-acme/lego/v4/certificate"
+)
+
+// CustomCertificate is a certificate from a customer's own CA, given as PEM,
+// or as the name of a CredHub certificate credential in CredhubRef. Chain
+// holds the intermediate certificates, if any.
+type CustomCertificate struct {
+	Certificate string
+	Chain       string
+	PrivateKey  string
+	CredhubRef  string
+}
+
+// ParseCustomCertificate checks that a customer's certificate matches its
+// private key, is issued by the first certificate of its chain, covers every
+// one of domains and is valid at now. The returned resource bundles the
+// certificate with its chain, as it is uploaded to the certificate store.
+func ParseCustomCertificate(cert CustomCertificate, domains []string, now time.Time) (certificate.Resource, error) {
+	if cert.Certificate == "" || cert.PrivateKey == "" {
+		return certificate.Resource{}, errors.New("must pass both a certificate and its private key")
+	}
+
+	pair, err := tls.X509KeyPair([]byte(cert.Certificate), []byte(cert.PrivateKey))
+	if err != nil {
+		return certificate.Resource{}, fmt.Errorf("invalid certificate or private key: %v", err)
+	}
+	if len(pair.Certificate) > 1 {
+		return certificate.Resource{}, errors.New("certificate must hold a single certificate; pass intermediates in the chain")
+	}
+	leaf, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return certificate.Resource{}, err
+	}
+
+	chain, err := parseCertificates([]byte(cert.Chain))
+	if err != nil {
+		return certificate.Resource{}, fmt.Errorf("invalid chain: %v", err)
+	}
+	if len(chain) > 0 {
+		if err := leaf.CheckSignatureFrom(chain[0]); err != nil {
+			return certificate.Resource{}, fmt.Errorf("certificate isn't issued by the first certificate of the chain: %v", err)
+		}
+	}
+
+	if now.Before(leaf.NotBefore) {
+		return certificate.Resource{}, fmt.Errorf("certificate isn't valid until %s", leaf.NotBefore.UTC().Format(time.RFC3339))
+	}
+	if now.After(leaf.NotAfter) {
+		return certificate.Resource{}, fmt.Errorf("certificate expired on %s", leaf.NotAfter.UTC().Format(time.RFC3339))
+	}
+
+	uncovered := []string{}
+	for _, domain := range domains {
+		if !certificateCovers(leaf.DNSNames, domain) {
+			uncovered = append(uncovered, domain)
+		}
+	}
+	if len(uncovered) > 0 {
+		return certificate.Resource{}, fmt.Errorf("certificate doesn't cover %s", strings.Join(uncovered, ", "))
+	}
+
+	leafPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leaf.Raw})
+	issuerPEM := []byte{}
+	for _, c := range chain {
+		issuerPEM = append(issuerPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.Raw})...)
+	}
+
+	return certificate.Resource{
+		Domain:            domains[0],
+		Certificate:       append(leafPEM, issuerPEM...),
+		IssuerCertificate: issuerPEM,
+		PrivateKey:        []byte(cert.PrivateKey),
+	}, nil
+}
+
+func parseCertificates(data []byte) ([]*x509.Certificate, error) {
+	certs := []*x509.Certificate{}
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			return nil, fmt.Errorf("unexpected PEM block %s", block.Type)
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 && len(strings.TrimSpace(string(data))) > 0 {
+		return nil, errors.New("no PEM certificates found")
+	}
+	return certs, nil
+}
+
+// certificateCovers reports whether a certificate for names is valid for
+// domain. A wildcard name covers a single label, so *.agency.gov covers
+// www.agency.gov and itself, but not agency.gov.
+func certificateCovers(names []string, domain string) bool {
+	domain = strings.ToLower(domain)
+	for _, name := range names {
+		name = strings.ToLower(name)
+		if name == domain {
+			return true
+		}
+		if strings.HasPrefix(name, "*.") && !strings.HasPrefix(domain, "*.") {
+			if i := strings.Index(domain, "."); i > 0 && domain[i:] == name[1:] {
+				return true
+			}
+		}
+	}
+	return false
+}
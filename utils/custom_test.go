@@ -0,0 +1,141 @@
+package utils_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+
+	. "github.com/cloud-gov/cf-cdn-service-broker/utils"
+)
+
+func TestCustom(t *testing.T) {
+	suite.Run(t, new(CustomSuite))
+}
+
+// CustomSuite checks customers' certificates issued by a throwaway CA.
+type CustomSuite struct {
+	suite.Suite
+
+	now   time.Time
+	ca    *x509.Certificate
+	caKey *ecdsa.PrivateKey
+	caPEM string
+}
+
+func (s *CustomSuite) SetupTest() {
+	s.now = time.Now()
+
+	var err error
+	s.caKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	s.Require().NoError(err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Agency CA"},
+		NotBefore:             s.now.Add(-time.Hour),
+		NotAfter:              s.now.Add(365 * 24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &s.caKey.PublicKey, s.caKey)
+	s.Require().NoError(err)
+	s.ca, err = x509.ParseCertificate(der)
+	s.Require().NoError(err)
+	s.caPEM = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
+
+// issue returns a certificate for names signed by the CA, and its key.
+func (s *CustomSuite) issue(names []string, notBefore, notAfter time.Time) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	s.Require().NoError(err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: names[0]},
+		DNSNames:     names,
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, s.ca, &key.PublicKey, s.caKey)
+	s.Require().NoError(err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	s.Require().NoError(err)
+
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+}
+
+func (s *CustomSuite) TestValid() {
+	cert, key := s.issue([]string{"agency.gov", "*.agency.gov"}, s.now.Add(-time.Hour), s.now.Add(90*24*time.Hour))
+
+	resource, err := ParseCustomCertificate(CustomCertificate{
+		Certificate: cert,
+		Chain:       s.caPEM,
+		PrivateKey:  key,
+	}, []string{"agency.gov", "www.agency.gov", "*.agency.gov"}, s.now)
+	s.Require().NoError(err)
+	s.Equal("agency.gov", resource.Domain)
+	s.Equal(cert+s.caPEM, string(resource.Certificate))
+	s.Equal(s.caPEM, string(resource.IssuerCertificate))
+	s.Equal(key, string(resource.PrivateKey))
+}
+
+func (s *CustomSuite) TestUncoveredDomains() {
+	cert, key := s.issue([]string{"*.agency.gov"}, s.now.Add(-time.Hour), s.now.Add(90*24*time.Hour))
+
+	_, err := ParseCustomCertificate(CustomCertificate{Certificate: cert, PrivateKey: key},
+		[]string{"www.agency.gov", "agency.gov", "a.b.agency.gov"}, s.now)
+	s.Require().Error(err)
+	s.Equal("certificate doesn't cover agency.gov, a.b.agency.gov", err.Error())
+}
+
+func (s *CustomSuite) TestExpired() {
+	cert, key := s.issue([]string{"agency.gov"}, s.now.Add(-48*time.Hour), s.now.Add(-time.Hour))
+
+	_, err := ParseCustomCertificate(CustomCertificate{Certificate: cert, PrivateKey: key}, []string{"agency.gov"}, s.now)
+	s.Require().Error(err)
+	s.Contains(err.Error(), "certificate expired on")
+}
+
+func (s *CustomSuite) TestNotYetValid() {
+	cert, key := s.issue([]string{"agency.gov"}, s.now.Add(time.Hour), s.now.Add(48*time.Hour))
+
+	_, err := ParseCustomCertificate(CustomCertificate{Certificate: cert, PrivateKey: key}, []string{"agency.gov"}, s.now)
+	s.Require().Error(err)
+	s.Contains(err.Error(), "isn't valid until")
+}
+
+func (s *CustomSuite) TestMismatchedKey() {
+	cert, _ := s.issue([]string{"agency.gov"}, s.now.Add(-time.Hour), s.now.Add(48*time.Hour))
+	_, key := s.issue([]string{"agency.gov"}, s.now.Add(-time.Hour), s.now.Add(48*time.Hour))
+
+	_, err := ParseCustomCertificate(CustomCertificate{Certificate: cert, PrivateKey: key}, []string{"agency.gov"}, s.now)
+	s.Require().Error(err)
+	s.Contains(err.Error(), "invalid certificate or private key")
+}
+
+func (s *CustomSuite) TestWrongChain() {
+	cert, key := s.issue([]string{"agency.gov"}, s.now.Add(-time.Hour), s.now.Add(48*time.Hour))
+	other, _ := s.issue([]string{"other.gov"}, s.now.Add(-time.Hour), s.now.Add(48*time.Hour))
+
+	_, err := ParseCustomCertificate(CustomCertificate{Certificate: cert, Chain: other, PrivateKey: key}, []string{"agency.gov"}, s.now)
+	s.Require().Error(err)
+	s.Contains(err.Error(), "isn't issued by the first certificate of the chain")
+}
+
+func (s *CustomSuite) TestMissingKey() {
+	cert, _ := s.issue([]string{"agency.gov"}, s.now.Add(-time.Hour), s.now.Add(48*time.Hour))
+
+	_, err := ParseCustomCertificate(CustomCertificate{Certificate: cert}, []string{"agency.gov"}, s.now)
+	s.Error(err)
+}
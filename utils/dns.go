@@ -0,0 +1,193 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/go-acme/lego/v4/challenge"
+	"github.com/go-acme/lego/v4/challenge/dns01"
+	"github.com/go-acme/lego/v4/providers/dns/rfc2136"
+
+	"github.com/cloud-gov/cf-cdn-service-broker/config"
+)
+
+// Types of DNS-01 provider that can be configured in DNS_PROVIDERS. The
+// Route 53 provider is always registered under its type name.
+const (
+	DNSProviderRoute53 = "route53"
+	DNSProviderRFC2136 = "rfc2136"
+)
+
+// DNSProviderIface writes DNS-01 challenge records.
+type DNSProviderIface interface {
+	challenge.Provider
+
+	// Automated reports whether the provider writes the records for domain
+	// itself. Records for other domains are created by customers, following
+	// the records reported by GetDomainStatus.
+	Automated(domain string) bool
+}
+
+// DNSProviderConfig is the configuration of a named DNS-01 provider.
+type DNSProviderConfig struct {
+	Type          string `json:"type"`
+	Nameserver    string `json:"nameserver"`
+	TSIGKey       string `json:"tsig_key"`
+	TSIGSecret    string `json:"tsig_secret"`
+	TSIGAlgorithm string `json:"tsig_algorithm"`
+}
+
+// DNSProviders is the registry of DNS-01 providers that instances can choose
+// from with the dns_provider parameter.
+type DNSProviders struct {
+	// Route53 is also used to manage alias records, whichever DNS-01 provider
+	// an instance uses.
+	Route53 Route53Iface
+
+	providers map[string]DNSProviderIface
+}
+
+// NewDNSProviders registers the Route 53 provider, along with the providers
+// configured in DNS_PROVIDERS, a JSON object of DNSProviderConfig keyed by name.
+func NewDNSProviders(settings config.Settings, route53 Route53Iface) (*DNSProviders, error) {
+	d := &DNSProviders{
+		Route53: route53,
+		providers: map[string]DNSProviderIface{
+			DNSProviderRoute53: &Route53DNSProvider{Route53: route53},
+		},
+	}
+
+	if settings.DNSProviders == "" {
+		return d, nil
+	}
+
+	var configs map[string]DNSProviderConfig
+	if err := json.Unmarshal([]byte(settings.DNSProviders), &configs); err != nil {
+		return nil, fmt.Errorf("parsing DNS providers: %v", err)
+	}
+
+	for name, providerConfig := range configs {
+		if _, ok := d.providers[name]; ok {
+			return nil, fmt.Errorf("DNS provider %q is already registered", name)
+		}
+
+		switch providerConfig.Type {
+		case DNSProviderRFC2136:
+			provider, err := NewRFC2136DNSProvider(providerConfig)
+			if err != nil {
+				return nil, fmt.Errorf("DNS provider %q: %v", name, err)
+			}
+			d.providers[name] = provider
+		default:
+			return nil, fmt.Errorf("DNS provider %q has unknown type %q", name, providerConfig.Type)
+		}
+	}
+
+	return d, nil
+}
+
+// Get returns the provider registered under name, or the Route 53 provider if
+// name is empty.
+func (d *DNSProviders) Get(name string) (DNSProviderIface, error) {
+	if name == "" {
+		name = DNSProviderRoute53
+	}
+
+	provider, ok := d.providers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown DNS provider %q; available providers are %v", name, d.Names())
+	}
+	return provider, nil
+}
+
+func (d *DNSProviders) Names() []string {
+	names := []string{}
+	for name := range d.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ManualDNSProvider leaves DNS-01 records to customers.
+type ManualDNSProvider struct{}
+
+func (p *ManualDNSProvider) Automated(domain string) bool {
+	return false
+}
+
+func (p *ManualDNSProvider) Present(domain, token, keyAuth string) error {
+	return nil
+}
+
+func (p *ManualDNSProvider) CleanUp(domain, token, keyAuth string) error {
+	return nil
+}
+
+// Route53DNSProvider writes DNS-01 records into the Route 53 hosted zones
+// that the broker manages. For any other domain, customers create the
+// records, so Present and CleanUp do nothing.
+type Route53DNSProvider struct {
+	Route53 Route53Iface
+}
+
+func (p *Route53DNSProvider) Automated(domain string) bool {
+	zoneId, err := p.hostedZone(domain)
+	return err == nil && zoneId != ""
+}
+
+func (p *Route53DNSProvider) hostedZone(domain string) (string, error) {
+	if p.Route53 == nil {
+		return "", nil
+	}
+	return p.Route53.HostedZone(domain)
+}
+
+func (p *Route53DNSProvider) Present(domain, token, keyAuth string) error {
+	zoneId, err := p.hostedZone(domain)
+	if err != nil || zoneId == "" {
+		return err
+	}
+	fqdn, value := dns01.GetRecord(domain, keyAuth)
+	return p.Route53.UpsertTXT(zoneId, fqdn, value)
+}
+
+func (p *Route53DNSProvider) CleanUp(domain, token, keyAuth string) error {
+	zoneId, err := p.hostedZone(domain)
+	if err != nil || zoneId == "" {
+		return err
+	}
+	fqdn, value := dns01.GetRecord(domain, keyAuth)
+	return p.Route53.DeleteTXT(zoneId, fqdn, value)
+}
+
+func (p *Route53DNSProvider) Timeout() (time.Duration, time.Duration) {
+	return 10 * time.Second, 2 * time.Second
+}
+
+// RFC2136DNSProvider writes DNS-01 records with TSIG-signed RFC 2136 dynamic
+// updates, e.g. to a BIND primary. Instances only choose it for domains
+// in zones the server accepts updates for, so every domain is automated.
+type RFC2136DNSProvider struct {
+	*rfc2136.DNSProvider
+}
+
+func NewRFC2136DNSProvider(providerConfig DNSProviderConfig) (*RFC2136DNSProvider, error) {
+	legoConfig := rfc2136.NewDefaultConfig()
+	legoConfig.Nameserver = providerConfig.Nameserver
+	legoConfig.TSIGKey = providerConfig.TSIGKey
+	legoConfig.TSIGSecret = providerConfig.TSIGSecret
+	legoConfig.TSIGAlgorithm = providerConfig.TSIGAlgorithm
+
+	provider, err := rfc2136.NewDNSProviderConfig(legoConfig)
+	if err != nil {
+		return nil, err
+	}
+	return &RFC2136DNSProvider{DNSProvider: provider}, nil
+}
+
+func (p *RFC2136DNSProvider) Automated(domain string) bool {
+	return true
+}
@@ -0,0 +1,200 @@
+package utils_test
+
+import (
+	"encoding/base64"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-acme/lego/v4/challenge/dns01"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/cloud-gov/cf-cdn-service-broker/config"
+	. "github.com/cloud-gov/cf-cdn-service-broker/utils"
+)
+
+const (
+	testZone     = "agency.gov."
+	testTSIGKey  = "cdn-broker."
+	testKeyAuth  = "token.thumbprint"
+	testProvider = "agency-bind"
+)
+
+func TestDNS(t *testing.T) {
+	suite.Run(t, new(DNSSuite))
+}
+
+// DNSSuite runs the RFC 2136 provider against a local server that, like a
+// BIND primary, answers SOA queries for its zone and applies TSIG-signed
+// dynamic updates to it.
+type DNSSuite struct {
+	suite.Suite
+
+	server *dns.Server
+	secret string
+
+	mu      sync.Mutex
+	records map[string][]string
+}
+
+func (s *DNSSuite) SetupTest() {
+	// The provider otherwise looks for CNAMEs on the system resolvers.
+	s.T().Setenv("LEGO_DISABLE_CNAME_SUPPORT", "true")
+
+	s.records = map[string][]string{}
+	s.secret = base64.StdEncoding.EncodeToString([]byte("a-shared-secret-of-some-length"))
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	s.Require().NoError(err)
+
+	started := make(chan struct{})
+	s.server = &dns.Server{
+		PacketConn:        conn,
+		TsigSecret:        map[string]string{testTSIGKey: s.secret},
+		Handler:           dns.HandlerFunc(s.serveDNS),
+		MsgAcceptFunc:     acceptUpdates,
+		NotifyStartedFunc: func() { close(started) },
+	}
+	go s.server.ActivateAndServe()
+	<-started
+}
+
+// acceptUpdates accepts dynamic updates, which the default accept function
+// rejects, as well as queries.
+func acceptUpdates(dh dns.Header) dns.MsgAcceptAction {
+	if int(dh.Bits>>11)&0xF == dns.OpcodeUpdate {
+		return dns.MsgAccept
+	}
+	return dns.DefaultMsgAcceptFunc(dh)
+}
+
+func (s *DNSSuite) TearDownTest() {
+	s.server.Shutdown()
+}
+
+func (s *DNSSuite) serveDNS(w dns.ResponseWriter, r *dns.Msg) {
+	m := new(dns.Msg)
+	m.SetReply(r)
+
+	switch r.Opcode {
+	case dns.OpcodeQuery:
+		if r.Question[0].Qtype == dns.TypeSOA && r.Question[0].Name == testZone {
+			m.Answer = []dns.RR{&dns.SOA{
+				Hdr:    dns.RR_Header{Name: testZone, Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: 300},
+				Ns:     "ns1." + testZone,
+				Mbox:   "hostmaster." + testZone,
+				Serial: 1,
+			}}
+		} else {
+			m.Rcode = dns.RcodeNameError
+		}
+	case dns.OpcodeUpdate:
+		if r.IsTsig() == nil || w.TsigStatus() != nil {
+			m.Rcode = dns.RcodeNotAuth
+			break
+		}
+		s.update(r.Ns)
+		m.SetTsig(testTSIGKey, dns.HmacSHA256, 300, time.Now().Unix())
+	}
+
+	w.WriteMsg(m)
+}
+
+// update applies the update section of a dynamic update (RFC 2136 section 2.5).
+func (s *DNSSuite) update(rrs []dns.RR) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, rr := range rrs {
+		name := rr.Header().Name
+		switch rr.Header().Class {
+		case dns.ClassANY:
+			delete(s.records, name)
+		case dns.ClassNONE:
+			value := strings.Join(rr.(*dns.TXT).Txt, "")
+			kept := []string{}
+			for _, existing := range s.records[name] {
+				if existing != value {
+					kept = append(kept, existing)
+				}
+			}
+			s.records[name] = kept
+		default:
+			s.records[name] = append(s.records[name], strings.Join(rr.(*dns.TXT).Txt, ""))
+		}
+	}
+}
+
+func (s *DNSSuite) record(fqdn string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.records[fqdn]
+}
+
+func (s *DNSSuite) providers(secret string) *DNSProviders {
+	providers, err := NewDNSProviders(config.Settings{
+		DNSProviders: `{"` + testProvider + `": {
+			"type": "rfc2136",
+			"nameserver": "` + s.server.PacketConn.LocalAddr().String() + `",
+			"tsig_key": "` + testTSIGKey + `",
+			"tsig_secret": "` + secret + `",
+			"tsig_algorithm": "hmac-sha256."
+		}}`,
+	}, nil)
+	s.Require().NoError(err)
+	return providers
+}
+
+func (s *DNSSuite) TestRFC2136PresentAndCleanUp() {
+	provider, err := s.providers(s.secret).Get(testProvider)
+	s.Require().NoError(err)
+	s.True(provider.Automated("www.agency.gov"))
+
+	fqdn, value := dns01.GetRecord("www.agency.gov", testKeyAuth)
+
+	s.Require().NoError(provider.Present("www.agency.gov", "token", testKeyAuth))
+	s.Equal([]string{value}, s.record(fqdn))
+
+	s.Require().NoError(provider.CleanUp("www.agency.gov", "token", testKeyAuth))
+	s.Empty(s.record(fqdn))
+}
+
+func (s *DNSSuite) TestRFC2136BadSecret() {
+	wrong := base64.StdEncoding.EncodeToString([]byte("not-the-shared-secret"))
+	provider, err := s.providers(wrong).Get(testProvider)
+	s.Require().NoError(err)
+
+	err = provider.Present("www.agency.gov", "token", testKeyAuth)
+	s.Error(err)
+
+	fqdn, _ := dns01.GetRecord("www.agency.gov", testKeyAuth)
+	s.Empty(s.record(fqdn))
+}
+
+func (s *DNSSuite) TestGetDefaultsToRoute53() {
+	providers, err := NewDNSProviders(config.Settings{}, nil)
+	s.Require().NoError(err)
+
+	provider, err := providers.Get("")
+	s.Require().NoError(err)
+	s.IsType(&Route53DNSProvider{}, provider)
+	s.False(provider.Automated("www.agency.gov"))
+
+	_, err = providers.Get(testProvider)
+	s.Error(err)
+}
+
+func (s *DNSSuite) TestInvalidProviders() {
+	for _, providers := range []string{
+		`not json`,
+		`{"route53": {"type": "rfc2136", "nameserver": "127.0.0.1"}}`,
+		`{"other": {"type": "cloudflare"}}`,
+		`{"other": {"type": "rfc2136"}}`,
+	} {
+		_, err := NewDNSProviders(config.Settings{DNSProviders: providers}, nil)
+		s.Error(err, providers)
+	}
+}
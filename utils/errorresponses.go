@@ -0,0 +1,106 @@
+package utils
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudfront"
+	"github.com/aws/aws-sdk-go/service/s3"
+
+	"github.com/cloud-gov/cf-cdn-service-broker/config"
+)
+
+// ErrorResponse configures what viewers get when the origin answers with the
+// HTTP status ErrorCode, or can't be reached. If ResponsePagePath is set, the
+// page at that path is served instead, with the status ResponseCode.
+// CachingMinTTL is how long, in seconds, CloudFront caches the error before
+// asking the origin again; if it is nil, CloudFront's default of 10 seconds
+// applies.
+type ErrorResponse struct {
+	ErrorCode        int64  `json:"error_code"`
+	ResponseCode     int64  `json:"response_code"`
+	ResponsePagePath string `json:"response_page_path"`
+	CachingMinTTL    *int64 `json:"caching_min_ttl"`
+}
+
+// Statuses that CloudFront can replace with custom error responses, and those
+// it can serve them with.
+var (
+	ErrorCodes    = []int64{400, 403, 404, 405, 414, 416, 500, 501, 502, 503, 504}
+	ResponseCodes = []int64{200, 400, 403, 404, 405, 414, 416, 500, 501, 502, 503, 504}
+)
+
+// The broker's maintenance page is served from the bucket, next to ACME
+// challenges, by a cache behavior that distributions get if their error
+// responses use the page.
+const (
+	MaintenancePathPattern = "/.well-known/cdn-maintenance/*"
+	MaintenancePagePath    = "/.well-known/cdn-maintenance/index.html"
+)
+
+// MaintenanceErrorResponses serve the maintenance page when the origin is
+// down, retrying the origin after CachingMinTTL.
+func MaintenanceErrorResponses() []ErrorResponse {
+	responses := []ErrorResponse{}
+	for _, code := range []int64{502, 503, 504} {
+		responses = append(responses, ErrorResponse{
+			ErrorCode:        code,
+			ResponseCode:     503,
+			ResponsePagePath: MaintenancePagePath,
+			CachingMinTTL:    aws.Int64(10),
+		})
+	}
+	return responses
+}
+
+// UploadMaintenancePage copies the maintenance page from the file
+// settings.MaintenancePage to the bucket.
+func UploadMaintenancePage(settings config.Settings, service *s3.S3) error {
+	page, err := ioutil.ReadFile(settings.MaintenancePage)
+	if err != nil {
+		return err
+	}
+	input := s3.PutObjectInput{
+		Bucket:       aws.String(settings.Bucket),
+		Key:          aws.String(strings.TrimPrefix(MaintenancePagePath, "/")),
+		Body:         bytes.NewReader(page),
+		ContentType:  aws.String("text/html; charset=utf-8"),
+		CacheControl: aws.String("max-age=300"),
+	}
+	if settings.ServerSideEncryption != "" {
+		input.ServerSideEncryption = aws.String(settings.ServerSideEncryption)
+	}
+	_, err = service.PutObject(&input)
+	return err
+}
+
+func usesMaintenancePage(responses []ErrorResponse) bool {
+	for _, response := range responses {
+		if response.ResponsePagePath == MaintenancePagePath {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *Distribution) getCustomErrorResponses(responses []ErrorResponse) *cloudfront.CustomErrorResponses {
+	items := []*cloudfront.CustomErrorResponse{}
+	for _, response := range responses {
+		item := &cloudfront.CustomErrorResponse{
+			ErrorCode:          aws.Int64(response.ErrorCode),
+			ErrorCachingMinTTL: response.CachingMinTTL,
+		}
+		if response.ResponsePagePath != "" {
+			item.ResponsePagePath = aws.String(response.ResponsePagePath)
+			item.ResponseCode = aws.String(strconv.FormatInt(response.ResponseCode, 10))
+		}
+		items = append(items, item)
+	}
+	return &cloudfront.CustomErrorResponses{
+		Quantity: aws.Int64(int64(len(items))),
+		Items:    items,
+	}
+}
@@ -6,37 +6,37 @@ import (
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/iam"
 
-	"github.com/xenolf/lego/acme"
+	"github.com/go-acme/lego/v4/certificate"
 
 	"github.com/cloud-gov/cf-cdn-service-broker/config"
 )
 
-type IamIface interface {
-	UploadCertificate(name string, cert acme.CertificateResource) (string, error)
-	DeleteCertificate(name string) error
-	ListCertificates(callback func(iam.ServerCertificateMetadata) bool) error
-}
-
 type Iam struct {
 	Settings config.Settings
 	Service  *iam.IAM
 }
 
-func (i *Iam) UploadCertificate(name string, cert acme.CertificateResource) (string, error) {
+func (i *Iam) Source() string {
+	return CertificateSourceIam
+}
+
+// UploadCertificate uploads a new server certificate; IAM can't replace a
+// server certificate in place, so arn is ignored.
+func (i *Iam) UploadCertificate(name string, cert certificate.Resource, arn string) (StoredCertificate, error) {
 	resp, err := i.Service.UploadServerCertificate(&iam.UploadServerCertificateInput{
 		CertificateBody:       aws.String(string(cert.Certificate)),
 		PrivateKey:            aws.String(string(cert.PrivateKey)),
 		ServerCertificateName: aws.String(name),
-		Path: aws.String(fmt.Sprintf("/cloudfront/%s/", i.Settings.IamPathPrefix)),
+		Path:                  aws.String(fmt.Sprintf("/cloudfront/%s/", i.Settings.IamPathPrefix)),
 	})
 	if err != nil {
-		return "", err
+		return StoredCertificate{}, err
 	}
 
-	return *resp.ServerCertificateMetadata.ServerCertificateId, nil
+	return storedIamCertificate(*resp.ServerCertificateMetadata), nil
 }
 
-func (i *Iam) ListCertificates(callback func(iam.ServerCertificateMetadata) bool) error {
+func (i *Iam) ListCertificates(callback func(StoredCertificate) bool) error {
 	return i.Service.ListServerCertificatesPages(
 		&iam.ListServerCertificatesInput{
 			PathPrefix: aws.String(fmt.Sprintf("/cloudfront/%s/", i.Settings.IamPathPrefix)),
@@ -44,7 +44,7 @@ func (i *Iam) ListCertificates(callback func(iam.ServerCertificateMetadata) bool
 		func(page *iam.ListServerCertificatesOutput, lastPage bool) bool {
 			for _, v := range page.ServerCertificateMetadataList {
 				// stop iteration if the callback tells us to
-				if callback(*v) == false {
+				if callback(storedIamCertificate(*v)) == false {
 					return false
 				}
 			}
@@ -54,10 +54,19 @@ func (i *Iam) ListCertificates(callback func(iam.ServerCertificateMetadata) bool
 	)
 }
 
-func (i *Iam) DeleteCertificate(name string) error {
+func (i *Iam) DeleteCertificate(cert StoredCertificate) error {
 	_, err := i.Service.DeleteServerCertificate(&iam.DeleteServerCertificateInput{
-		ServerCertificateName: aws.String(name),
+		ServerCertificateName: aws.String(cert.Name),
 	})
 
 	return err
 }
+
+func storedIamCertificate(metadata iam.ServerCertificateMetadata) StoredCertificate {
+	return StoredCertificate{
+		Id:       aws.StringValue(metadata.ServerCertificateId),
+		Arn:      aws.StringValue(metadata.Arn),
+		Name:     aws.StringValue(metadata.ServerCertificateName),
+		Uploaded: aws.TimeValue(metadata.UploadDate),
+	}
+}
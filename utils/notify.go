@@ -0,0 +1,142 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloud-gov/cf-cdn-service-broker/config"
+)
+
+// ExpiryAlert reports a certificate that is within Threshold days of expiry
+// and hasn't been renewed.
+type ExpiryAlert struct {
+	InstanceId string    `json:"instance_id"`
+	Domains    []string  `json:"domains"`
+	Expires    time.Time `json:"expires"`
+	Threshold  int       `json:"threshold_days"`
+	State      string    `json:"state"`
+	LastError  string    `json:"last_error,omitempty"`
+}
+
+func (a ExpiryAlert) Subject() string {
+	return fmt.Sprintf("CDN certificate for %s expires in less than %d days", strings.Join(a.Domains, ", "), a.Threshold)
+}
+
+func (a ExpiryAlert) Message() string {
+	message := fmt.Sprintf(
+		"The certificate for CDN service instance %s (%s) expires at %s and hasn't been renewed. The route is %s.",
+		a.InstanceId, strings.Join(a.Domains, ", "), a.Expires.UTC().Format(time.RFC3339), a.State,
+	)
+	if a.LastError != "" {
+		message += "\n\nThe last renewal failed with: " + a.LastError
+	}
+	return message
+}
+
+// NotifierIface sends expiry alerts somewhere operators will see them.
+type NotifierIface interface {
+	Name() string
+	Notify(alert ExpiryAlert) error
+}
+
+// NewNotifiers returns a notifier for each destination configured in settings.
+func NewNotifiers(settings config.Settings) []NotifierIface {
+	client := &http.Client{Timeout: 30 * time.Second}
+
+	notifiers := []NotifierIface{}
+	if settings.SmtpHost != "" && len(settings.ExpiryAlertEmails) > 0 {
+		notifiers = append(notifiers, &SMTPNotifier{Settings: settings})
+	}
+	if settings.ExpiryAlertWebhookUrl != "" {
+		notifiers = append(notifiers, &WebhookNotifier{URL: settings.ExpiryAlertWebhookUrl, Client: client})
+	}
+	if settings.ExpiryAlertSlackUrl != "" {
+		notifiers = append(notifiers, &SlackNotifier{URL: settings.ExpiryAlertSlackUrl, Client: client})
+	}
+	return notifiers
+}
+
+// SMTPNotifier emails alerts to ExpiryAlertEmails.
+type SMTPNotifier struct {
+	Settings config.Settings
+}
+
+func (n *SMTPNotifier) Name() string {
+	return "smtp"
+}
+
+func (n *SMTPNotifier) Notify(alert ExpiryAlert) error {
+	addr := net.JoinHostPort(n.Settings.SmtpHost, strconv.Itoa(n.Settings.SmtpPort))
+
+	var auth smtp.Auth
+	if n.Settings.SmtpUser != "" {
+		auth = smtp.PlainAuth("", n.Settings.SmtpUser, n.Settings.SmtpPassword, n.Settings.SmtpHost)
+	}
+
+	from := n.Settings.SmtpFrom
+	if from == "" {
+		from = n.Settings.Email
+	}
+
+	message := fmt.Sprintf(
+		"From: %s\r\nTo: %s\r\nSubject: %s\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n%s\r\n",
+		from, strings.Join(n.Settings.ExpiryAlertEmails, ", "), alert.Subject(), alert.Message(),
+	)
+
+	return smtp.SendMail(addr, auth, from, n.Settings.ExpiryAlertEmails, []byte(message))
+}
+
+// WebhookNotifier posts alerts as JSON.
+type WebhookNotifier struct {
+	URL    string
+	Client *http.Client
+}
+
+func (n *WebhookNotifier) Name() string {
+	return "webhook"
+}
+
+func (n *WebhookNotifier) Notify(alert ExpiryAlert) error {
+	return postJSON(n.Client, n.URL, alert)
+}
+
+// SlackNotifier posts alerts to a Slack-compatible incoming webhook.
+type SlackNotifier struct {
+	URL    string
+	Client *http.Client
+}
+
+func (n *SlackNotifier) Name() string {
+	return "slack"
+}
+
+func (n *SlackNotifier) Notify(alert ExpiryAlert) error {
+	return postJSON(n.Client, n.URL, map[string]string{
+		"text": fmt.Sprintf("*%s*\n%s", alert.Subject(), alert.Message()),
+	})
+}
+
+func postJSON(client *http.Client, url string, body interface{}) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Post(url, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("%s responded with %s", url, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package utils_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/cloud-gov/cf-cdn-service-broker/config"
+	. "github.com/cloud-gov/cf-cdn-service-broker/utils"
+)
+
+func TestNotify(t *testing.T) {
+	suite.Run(t, new(NotifySuite))
+}
+
+type NotifySuite struct {
+	suite.Suite
+
+	server   *httptest.Server
+	status   int
+	received []map[string]interface{}
+	alert    ExpiryAlert
+}
+
+func (s *NotifySuite) SetupTest() {
+	s.status = http.StatusOK
+	s.received = nil
+	s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		s.NoError(json.NewDecoder(r.Body).Decode(&body))
+		s.received = append(s.received, body)
+		w.WriteHeader(s.status)
+	}))
+	s.alert = ExpiryAlert{
+		InstanceId: "123",
+		Domains:    []string{"www.agency.gov", "agency.gov"},
+		Expires:    time.Date(2020, 3, 31, 0, 0, 0, 0, time.UTC),
+		Threshold:  7,
+		State:      "renewal-at-risk",
+		LastError:  "canary check failed",
+	}
+}
+
+func (s *NotifySuite) TearDownTest() {
+	s.server.Close()
+}
+
+func (s *NotifySuite) TestNewNotifiers() {
+	s.Empty(NewNotifiers(config.Settings{}))
+
+	notifiers := NewNotifiers(config.Settings{
+		SmtpHost:              "smtp.agency.gov",
+		ExpiryAlertEmails:     []string{"ops@agency.gov"},
+		ExpiryAlertWebhookUrl: s.server.URL,
+		ExpiryAlertSlackUrl:   s.server.URL,
+	})
+	s.Require().Len(notifiers, 3)
+	s.Equal("smtp", notifiers[0].Name())
+	s.Equal("webhook", notifiers[1].Name())
+	s.Equal("slack", notifiers[2].Name())
+}
+
+func (s *NotifySuite) TestWebhook() {
+	notifier := &WebhookNotifier{URL: s.server.URL, Client: http.DefaultClient}
+
+	s.NoError(notifier.Notify(s.alert))
+	s.Require().Len(s.received, 1)
+	s.Equal("123", s.received[0]["instance_id"])
+	s.Equal(float64(7), s.received[0]["threshold_days"])
+	s.Equal("canary check failed", s.received[0]["last_error"])
+}
+
+func (s *NotifySuite) TestSlack() {
+	notifier := &SlackNotifier{URL: s.server.URL, Client: http.DefaultClient}
+
+	s.NoError(notifier.Notify(s.alert))
+	s.Require().Len(s.received, 1)
+	s.Contains(s.received[0]["text"], "www.agency.gov, agency.gov expires in less than 7 days")
+	s.Contains(s.received[0]["text"], "canary check failed")
+}
+
+func (s *NotifySuite) TestWebhookError() {
+	s.status = http.StatusInternalServerError
+	notifier := &WebhookNotifier{URL: s.server.URL, Client: http.DefaultClient}
+
+	s.Error(notifier.Notify(s.alert))
+}
@@ -0,0 +1,338 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/textproto"
+	"sort"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/cloudfront"
+)
+
+// Managed policies that CloudFront provides in every account.
+const (
+	CachingDisabledPolicyId = "4135ea2d-6df8-44a3-9df3-4b5a84be39ad"
+	AllViewerPolicyId       = "216adef6-5c7f-47e4-b989-5492eafa07d3"
+)
+
+// cachingDisabled reports whether a behavior caches nothing, either because
+// its maximum TTL is zero or because it forwards every header.
+func (b CacheBehavior) cachingDisabled() bool {
+	return b.MaxTTL == 0 || containsHeader(b.Headers, "*")
+}
+
+// UsesPolicies reports whether all of a distribution's cache behaviors use
+// cache policies rather than forwarded values.
+func UsesPolicies(config *cloudfront.DistributionConfig) bool {
+	if config.DefaultCacheBehavior != nil && config.DefaultCacheBehavior.ForwardedValues != nil {
+		return false
+	}
+	if config.CacheBehaviors != nil {
+		for _, behavior := range config.CacheBehaviors.Items {
+			if behavior.ForwardedValues != nil {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// cachePolicyConfig returns the cache policy for a behavior that caches,
+// which keys the cache on its headers, and on its cookies and query strings
+// if it forwards them, or just on those it names.
+func (d *Distribution) cachePolicyConfig(b CacheBehavior) *cloudfront.CachePolicyConfig {
+	headers := &cloudfront.CachePolicyHeadersConfig{HeaderBehavior: aws.String("none")}
+	if len(b.Headers) > 0 {
+		headers = &cloudfront.CachePolicyHeadersConfig{
+			HeaderBehavior: aws.String("whitelist"),
+			Headers:        d.getHeaders(sortedStrings(b.Headers)),
+		}
+	}
+
+	return &cloudfront.CachePolicyConfig{
+		Comment:    aws.String("cdn route service"),
+		MinTTL:     aws.Int64(b.MinTTL),
+		DefaultTTL: aws.Int64(b.DefaultTTL),
+		MaxTTL:     aws.Int64(b.MaxTTL),
+		ParametersInCacheKeyAndForwardedToOrigin: &cloudfront.ParametersInCacheKeyAndForwardedToOrigin{
+			CookiesConfig: &cloudfront.CachePolicyCookiesConfig{
+				CookieBehavior: aws.String(whitelistAllOrNone(b.CookieNames, b.Cookies)),
+				Cookies:        getCookieNames(b.CookieNames),
+			},
+			EnableAcceptEncodingGzip: aws.Bool(false),
+			HeadersConfig:            headers,
+			QueryStringsConfig: &cloudfront.CachePolicyQueryStringsConfig{
+				QueryStringBehavior: aws.String(whitelistAllOrNone(b.QueryStringKeys, b.QueryString)),
+				QueryStrings:        getQueryStringNames(b.QueryStringKeys),
+			},
+		},
+	}
+}
+
+// originRequestPolicyConfig returns the origin request policy for a
+// behavior, or nil if the cache key already holds everything it forwards.
+// Behaviors that don't cache forward their headers, cookies and query strings
+// with this policy, as caching-disabled cache policies can't key on them;
+// others forward their origin headers, and the query strings that aren't
+// keyed on, with it.
+func (d *Distribution) originRequestPolicyConfig(b CacheBehavior) *cloudfront.OriginRequestPolicyConfig {
+	headers := Headers{}
+	cookies := false
+	var cookieNames []string
+	queryString := b.QueryString && len(b.QueryStringKeys) > 0
+	if b.cachingDisabled() {
+		for _, header := range b.Headers {
+			headers.Add(header)
+		}
+		cookies, cookieNames, queryString = b.Cookies, b.CookieNames, b.QueryString
+	}
+	for _, header := range b.OriginHeaders {
+		// Headers in the cache key are forwarded anyway.
+		if b.cachingDisabled() || !containsHeader(b.Headers, header) {
+			headers.Add(header)
+		}
+	}
+
+	headersConfig := &cloudfront.OriginRequestPolicyHeadersConfig{HeaderBehavior: aws.String("none")}
+	if headers.Contains("*") {
+		headersConfig.HeaderBehavior = aws.String("allViewer")
+	} else if len(headers) > 0 {
+		headersConfig = &cloudfront.OriginRequestPolicyHeadersConfig{
+			HeaderBehavior: aws.String("whitelist"),
+			Headers:        d.getHeaders(sortedStrings(headers.Strings())),
+		}
+	}
+
+	if *headersConfig.HeaderBehavior == "none" && !cookies && len(cookieNames) == 0 && !queryString {
+		return nil
+	}
+
+	return &cloudfront.OriginRequestPolicyConfig{
+		Comment:       aws.String("cdn route service"),
+		HeadersConfig: headersConfig,
+		CookiesConfig: &cloudfront.OriginRequestPolicyCookiesConfig{
+			CookieBehavior: aws.String(whitelistAllOrNone(cookieNames, cookies)),
+			Cookies:        getCookieNames(cookieNames),
+		},
+		QueryStringsConfig: &cloudfront.OriginRequestPolicyQueryStringsConfig{
+			QueryStringBehavior: aws.String(allOrNone(queryString)),
+		},
+	}
+}
+
+// getPolicyIds returns the ids of the cache policy and origin request policy
+// for a behavior, creating them if need be. The origin request policy id is
+// nil if it needs none. Managed policies are used where they fit.
+func (d *Distribution) getPolicyIds(b CacheBehavior) (*string, *string, error) {
+	cachePolicyId := aws.String(CachingDisabledPolicyId)
+	if !b.cachingDisabled() {
+		id, err := d.ensureCachePolicy(d.cachePolicyConfig(b))
+		if err != nil {
+			return nil, nil, err
+		}
+		cachePolicyId = aws.String(id)
+	}
+
+	originRequestPolicy := d.originRequestPolicyConfig(b)
+	if originRequestPolicy == nil {
+		return cachePolicyId, nil, nil
+	}
+	if *originRequestPolicy.HeadersConfig.HeaderBehavior == "allViewer" &&
+		*originRequestPolicy.CookiesConfig.CookieBehavior == "all" &&
+		*originRequestPolicy.QueryStringsConfig.QueryStringBehavior == "all" {
+		return cachePolicyId, aws.String(AllViewerPolicyId), nil
+	}
+	id, err := d.ensureOriginRequestPolicy(originRequestPolicy)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cachePolicyId, aws.String(id), nil
+}
+
+// policyName names a policy after a hash of its config, so that
+// distributions with the same settings share it.
+func (d *Distribution) policyName(config interface{}) (string, error) {
+	data, err := json.Marshal(config)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return fmt.Sprintf("%s-%x", d.Settings.CloudFrontPolicyPrefix, sum[:16]), nil
+}
+
+// ensureCachePolicy returns the id of the broker's cache policy with config,
+// creating it if it doesn't exist.
+func (d *Distribution) ensureCachePolicy(config *cloudfront.CachePolicyConfig) (string, error) {
+	name, err := d.policyName(config)
+	if err != nil {
+		return "", err
+	}
+	if id, err := d.findCachePolicy(name); err != nil || id != "" {
+		return id, err
+	}
+
+	config.Name = aws.String(name)
+	resp, err := d.Service.CreateCachePolicy(&cloudfront.CreateCachePolicyInput{
+		CachePolicyConfig: config,
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == cloudfront.ErrCodeCachePolicyAlreadyExists {
+		// Another process created the policy since it was looked up.
+		return d.findCachePolicy(name)
+	}
+	if err != nil {
+		return "", err
+	}
+	return *resp.CachePolicy.Id, nil
+}
+
+func (d *Distribution) findCachePolicy(name string) (string, error) {
+	input := &cloudfront.ListCachePoliciesInput{Type: aws.String(cloudfront.CachePolicyTypeCustom)}
+	for {
+		resp, err := d.Service.ListCachePolicies(input)
+		if err != nil {
+			return "", err
+		}
+		for _, item := range resp.CachePolicyList.Items {
+			if aws.StringValue(item.CachePolicy.CachePolicyConfig.Name) == name {
+				return *item.CachePolicy.Id, nil
+			}
+		}
+		if resp.CachePolicyList.NextMarker == nil {
+			return "", nil
+		}
+		input.Marker = resp.CachePolicyList.NextMarker
+	}
+}
+
+// ensureOriginRequestPolicy returns the id of the broker's origin request
+// policy with config, creating it if it doesn't exist.
+func (d *Distribution) ensureOriginRequestPolicy(config *cloudfront.OriginRequestPolicyConfig) (string, error) {
+	name, err := d.policyName(config)
+	if err != nil {
+		return "", err
+	}
+	if id, err := d.findOriginRequestPolicy(name); err != nil || id != "" {
+		return id, err
+	}
+
+	config.Name = aws.String(name)
+	resp, err := d.Service.CreateOriginRequestPolicy(&cloudfront.CreateOriginRequestPolicyInput{
+		OriginRequestPolicyConfig: config,
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == cloudfront.ErrCodeOriginRequestPolicyAlreadyExists {
+		return d.findOriginRequestPolicy(name)
+	}
+	if err != nil {
+		return "", err
+	}
+	return *resp.OriginRequestPolicy.Id, nil
+}
+
+func (d *Distribution) findOriginRequestPolicy(name string) (string, error) {
+	input := &cloudfront.ListOriginRequestPoliciesInput{Type: aws.String(cloudfront.OriginRequestPolicyTypeCustom)}
+	for {
+		resp, err := d.Service.ListOriginRequestPolicies(input)
+		if err != nil {
+			return "", err
+		}
+		for _, item := range resp.OriginRequestPolicyList.Items {
+			if aws.StringValue(item.OriginRequestPolicy.OriginRequestPolicyConfig.Name) == name {
+				return *item.OriginRequestPolicy.Id, nil
+			}
+		}
+		if resp.OriginRequestPolicyList.NextMarker == nil {
+			return "", nil
+		}
+		input.Marker = resp.OriginRequestPolicyList.NextMarker
+	}
+}
+
+// legacyCacheBehavior returns the settings of a cache behavior configured with
+// forwarded values, so that it can be converted to policies.
+func legacyCacheBehavior(forwardedValues *cloudfront.ForwardedValues, minTTL, defaultTTL, maxTTL *int64) (CacheBehavior, error) {
+	behavior := CacheBehavior{
+		MinTTL:      aws.Int64Value(minTTL),
+		DefaultTTL:  aws.Int64Value(defaultTTL),
+		MaxTTL:      aws.Int64Value(maxTTL),
+		Headers:     []string{},
+		QueryString: aws.BoolValue(forwardedValues.QueryString),
+	}
+	if forwardedValues.Headers != nil {
+		behavior.Headers = aws.StringValueSlice(forwardedValues.Headers.Items)
+	}
+	if forwardedValues.QueryStringCacheKeys != nil {
+		behavior.QueryStringKeys = aws.StringValueSlice(forwardedValues.QueryStringCacheKeys.Items)
+	}
+
+	switch cookies := aws.StringValue(forwardedValues.Cookies.Forward); cookies {
+	case "all":
+		behavior.Cookies = true
+	case "none":
+	case "whitelist":
+		if forwardedValues.Cookies.WhitelistedNames == nil || len(forwardedValues.Cookies.WhitelistedNames.Items) == 0 {
+			return behavior, errors.New("can't convert a cookie whitelist without names")
+		}
+		behavior.Cookies = true
+		behavior.CookieNames = aws.StringValueSlice(forwardedValues.Cookies.WhitelistedNames.Items)
+	default:
+		return behavior, fmt.Errorf("can't convert cookie forwarding %q", cookies)
+	}
+	return behavior, nil
+}
+
+func containsHeader(headers []string, header string) bool {
+	for _, h := range headers {
+		if textproto.CanonicalMIMEHeaderKey(h) == textproto.CanonicalMIMEHeaderKey(header) {
+			return true
+		}
+	}
+	return false
+}
+
+func getCookieNames(names []string) *cloudfront.CookieNames {
+	if len(names) == 0 {
+		return nil
+	}
+	sorted := sortedStrings(names)
+	return &cloudfront.CookieNames{
+		Quantity: aws.Int64(int64(len(sorted))),
+		Items:    aws.StringSlice(sorted),
+	}
+}
+
+func getQueryStringNames(names []string) *cloudfront.QueryStringNames {
+	if len(names) == 0 {
+		return nil
+	}
+	sorted := sortedStrings(names)
+	return &cloudfront.QueryStringNames{
+		Quantity: aws.Int64(int64(len(sorted))),
+		Items:    aws.StringSlice(sorted),
+	}
+}
+
+// whitelistAllOrNone is the behavior of policies that forward names if there
+// are any, or else everything or nothing.
+func whitelistAllOrNone(names []string, all bool) string {
+	if len(names) > 0 {
+		return "whitelist"
+	}
+	return allOrNone(all)
+}
+
+func allOrNone(all bool) string {
+	if all {
+		return "all"
+	}
+	return "none"
+}
+
+func sortedStrings(strs []string) []string {
+	sorted := append([]string{}, strs...)
+	sort.Strings(sorted)
+	return sorted
+}
@@ -0,0 +1,239 @@
+package utils
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// Statuses of a domain's DNS, as reported to customers while their instance
+// is provisioning.
+const (
+	DomainReady        = "ready"
+	DomainWrongTarget  = "wrong target"
+	DomainMissing      = "missing"
+	DomainNXDomain     = "NXDOMAIN"
+	DomainLookupFailed = "lookup failed"
+)
+
+// ValidationRecord is a DNS record that proves control of a domain to the CA.
+type ValidationRecord struct {
+	Name  string
+	Type  string
+	Value string
+}
+
+func (r ValidationRecord) String() string {
+	return fmt.Sprintf("name: %s, value: %s, type: %s", r.Name, r.Value, r.Type)
+}
+
+// DomainStatus describes whether a domain is ready for its certificate to be
+// validated. Found is what the domain resolves to, e.g. "CNAME to
+// example.com". Record is the domain's validation record, if the customer
+// has to create one, and RecordFound whether it exists.
+type DomainStatus struct {
+	Domain      string
+	Status      string
+	Found       string
+	Record      *ValidationRecord
+	RecordFound bool
+}
+
+func (s DomainStatus) String() string {
+	status := s.Domain + ": " + s.Status
+	if s.Found != "" {
+		status += " (" + s.Found + ")"
+	}
+	if s.Status != DomainReady && s.Record != nil && !s.RecordFound {
+		status += "; " + s.Record.Type + " record " + s.Record.String()
+	}
+	return status
+}
+
+type DomainCheckerIface interface {
+	Check(domain, target string, record *ValidationRecord, recordRequired bool) DomainStatus
+}
+
+// DomainChecker resolves customers' domains to see whether they point at
+// their distribution, and whether their validation records exist.
+type DomainChecker struct {
+	Resolver *Resolver
+}
+
+// Check resolves domain, which should be a CNAME or ALIAS to target. If
+// recordRequired is set, the domain is only ready once its validation
+// record also exists; otherwise either will do.
+func (c *DomainChecker) Check(domain, target string, record *ValidationRecord, recordRequired bool) DomainStatus {
+	status := c.checkTarget(domain, target)
+
+	if record != nil {
+		found, err := c.hasRecord(*record)
+		if err != nil && status.Status == DomainReady {
+			status.Status = DomainLookupFailed
+			status.Found = err.Error()
+		}
+		status.Record = record
+		status.RecordFound = found
+	}
+
+	switch {
+	case recordRequired && status.Status == DomainReady && !status.RecordFound:
+		status.Status = DomainMissing
+		status.Found = "validation record not found"
+	case !recordRequired && status.Status != DomainReady && status.RecordFound:
+		status.Status = DomainReady
+		status.Found = record.Type + " record found"
+	}
+	return status
+}
+
+// checkTarget reports whether domain is a CNAME to target, possibly through
+// other CNAMEs, or an ALIAS resolving to the same addresses.
+func (c *DomainChecker) checkTarget(domain, target string) DomainStatus {
+	status := DomainStatus{Domain: domain}
+
+	in, err := c.Resolver.Lookup(domain, dns.TypeCNAME)
+	if err != nil {
+		status.Status = DomainLookupFailed
+		status.Found = err.Error()
+		return status
+	}
+	if in.Rcode == dns.RcodeNameError {
+		status.Status = DomainNXDomain
+		return status
+	}
+
+	chain, err := c.cnameChain(domain, target, in)
+	if err != nil {
+		status.Status = DomainLookupFailed
+		status.Found = err.Error()
+		return status
+	}
+	end := domain
+	if len(chain) > 0 {
+		status.Found = "CNAME to " + strings.Join(chain, " to ")
+		end = chain[len(chain)-1]
+		if strings.EqualFold(end, target) {
+			status.Status = DomainReady
+			return status
+		}
+	}
+
+	// the end of the chain may still be an ALIAS to target
+	addresses, err := c.addresses(end)
+	if err != nil {
+		status.Status = DomainLookupFailed
+		status.Found = err.Error()
+		return status
+	}
+	if len(addresses) == 0 && len(chain) == 0 {
+		status.Status = DomainMissing
+		return status
+	}
+
+	targetAddresses, err := c.addresses(target)
+	if err != nil {
+		status.Status = DomainLookupFailed
+		status.Found = err.Error()
+		return status
+	}
+	for address := range addresses {
+		if targetAddresses[address] {
+			status.Status = DomainReady
+			if len(chain) == 0 {
+				status.Found = "ALIAS to " + target
+			}
+			return status
+		}
+	}
+
+	status.Status = DomainWrongTarget
+	if len(chain) == 0 {
+		found := []string{}
+		for address := range addresses {
+			found = append(found, address)
+		}
+		sort.Strings(found)
+		status.Found = "A " + strings.Join(found, ", ")
+	}
+	return status
+}
+
+// maxCNAMEs bounds the CNAME chains followed, in case of loops.
+const maxCNAMEs = 8
+
+// cnameChain follows the CNAMEs from domain until target or the end of the
+// chain, and returns the names along the way. Resolvers usually answer with
+// the whole chain in in, but the rest is looked up if not.
+func (c *DomainChecker) cnameChain(domain, target string, in *dns.Msg) ([]string, error) {
+	chain := []string{}
+	name := dns.Fqdn(domain)
+	for len(chain) < maxCNAMEs && !strings.EqualFold(name, dns.Fqdn(target)) {
+		next := cnameTarget(in, name)
+		if next == "" && len(chain) > 0 {
+			var err error
+			if in, err = c.Resolver.Lookup(name, dns.TypeCNAME); err != nil {
+				return nil, err
+			}
+			next = cnameTarget(in, name)
+		}
+		if next == "" {
+			break
+		}
+		chain = append(chain, strings.TrimSuffix(next, "."))
+		name = next
+	}
+	return chain, nil
+}
+
+func cnameTarget(in *dns.Msg, name string) string {
+	for _, rr := range in.Answer {
+		if cname, ok := rr.(*dns.CNAME); ok && strings.EqualFold(cname.Hdr.Name, name) {
+			return cname.Target
+		}
+	}
+	return ""
+}
+
+func (c *DomainChecker) addresses(name string) (map[string]bool, error) {
+	in, err := c.Resolver.Lookup(name, dns.TypeA)
+	if err != nil {
+		return nil, err
+	}
+
+	addresses := map[string]bool{}
+	for _, rr := range in.Answer {
+		if a, ok := rr.(*dns.A); ok {
+			addresses[a.A.String()] = true
+		}
+	}
+	return addresses, nil
+}
+
+func (c *DomainChecker) hasRecord(record ValidationRecord) (bool, error) {
+	qtype, ok := dns.StringToType[strings.ToUpper(record.Type)]
+	if !ok {
+		return false, fmt.Errorf("unknown record type %s", record.Type)
+	}
+
+	in, err := c.Resolver.Lookup(record.Name, qtype)
+	if err != nil {
+		return false, err
+	}
+
+	for _, rr := range in.Answer {
+		switch rr := rr.(type) {
+		case *dns.TXT:
+			if qtype == dns.TypeTXT && strings.Join(rr.Txt, "") == record.Value {
+				return true, nil
+			}
+		case *dns.CNAME:
+			if qtype == dns.TypeCNAME && strings.EqualFold(rr.Target, dns.Fqdn(record.Value)) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,189 @@
+package utils_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/suite"
+
+	. "github.com/cloud-gov/cf-cdn-service-broker/utils"
+)
+
+func TestReadiness(t *testing.T) {
+	suite.Run(t, new(ReadinessSuite))
+}
+
+// ReadinessSuite checks domains against a local nameserver.
+type ReadinessSuite struct {
+	suite.Suite
+
+	server  *dns.Server
+	checker *DomainChecker
+	records map[string][]dns.RR
+}
+
+func (s *ReadinessSuite) SetupTest() {
+	s.records = map[string][]dns.RR{}
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	s.Require().NoError(err)
+
+	started := make(chan struct{})
+	s.server = &dns.Server{
+		PacketConn:        conn,
+		Handler:           dns.HandlerFunc(s.serveDNS),
+		NotifyStartedFunc: func() { close(started) },
+	}
+	go s.server.ActivateAndServe()
+	<-started
+
+	s.checker = &DomainChecker{Resolver: &Resolver{
+		Nameservers: []string{conn.LocalAddr().String()},
+		Timeout:     time.Second,
+	}}
+
+	s.add("abc.cloudfront.net. 60 IN A 192.0.2.1")
+	s.add("abc.cloudfront.net. 60 IN A 192.0.2.2")
+}
+
+func (s *ReadinessSuite) TearDownTest() {
+	s.server.Shutdown()
+}
+
+// serveDNS answers like a recursive resolver: CNAMEs are returned for any
+// query type, and names without records are NXDOMAIN.
+func (s *ReadinessSuite) serveDNS(w dns.ResponseWriter, r *dns.Msg) {
+	m := new(dns.Msg)
+	m.SetReply(r)
+
+	q := r.Question[0]
+	records, ok := s.records[q.Name]
+	if q.Name == "servfail.gov." {
+		m.Rcode = dns.RcodeServerFailure
+	} else if !ok {
+		m.Rcode = dns.RcodeNameError
+	}
+	for _, rr := range records {
+		if rr.Header().Rrtype == q.Qtype || rr.Header().Rrtype == dns.TypeCNAME {
+			m.Answer = append(m.Answer, rr)
+		}
+	}
+
+	w.WriteMsg(m)
+}
+
+func (s *ReadinessSuite) add(record string) {
+	rr, err := dns.NewRR(record)
+	s.Require().NoError(err)
+	s.records[rr.Header().Name] = append(s.records[rr.Header().Name], rr)
+}
+
+func (s *ReadinessSuite) TestCNAME() {
+	s.add("www.agency.gov. 60 IN CNAME abc.cloudfront.net.")
+
+	status := s.checker.Check("www.agency.gov", "abc.cloudfront.net", nil, false)
+	s.Equal(DomainReady, status.Status)
+	s.Equal("www.agency.gov: ready (CNAME to abc.cloudfront.net)", status.String())
+}
+
+func (s *ReadinessSuite) TestCNAMEChain() {
+	s.add("www.agency.gov. 60 IN CNAME cdn.agency.gov.")
+	s.add("cdn.agency.gov. 60 IN CNAME abc.cloudfront.net.")
+
+	status := s.checker.Check("www.agency.gov", "abc.cloudfront.net", nil, false)
+	s.Equal(DomainReady, status.Status)
+	s.Equal("CNAME to cdn.agency.gov to abc.cloudfront.net", status.Found)
+
+	s.add("old.agency.gov. 60 IN CNAME cdn.agency.gov.")
+	s.add("cdn.agency.gov. 60 IN CNAME old.example.com.")
+	s.records["cdn.agency.gov."] = s.records["cdn.agency.gov."][1:]
+
+	status = s.checker.Check("old.agency.gov", "abc.cloudfront.net", nil, false)
+	s.Equal(DomainWrongTarget, status.Status)
+	s.Equal("CNAME to cdn.agency.gov to old.example.com", status.Found)
+}
+
+func (s *ReadinessSuite) TestCNAMEToAlias() {
+	s.add("www.agency.gov. 60 IN CNAME agency.gov.")
+	s.add("agency.gov. 60 IN A 192.0.2.2")
+
+	status := s.checker.Check("www.agency.gov", "abc.cloudfront.net", nil, false)
+	s.Equal(DomainReady, status.Status)
+	s.Equal("CNAME to agency.gov", status.Found)
+}
+
+func (s *ReadinessSuite) TestCNAMELoop() {
+	s.add("www.agency.gov. 60 IN CNAME cdn.agency.gov.")
+	s.add("cdn.agency.gov. 60 IN CNAME www.agency.gov.")
+
+	status := s.checker.Check("www.agency.gov", "abc.cloudfront.net", nil, false)
+	s.Equal(DomainWrongTarget, status.Status)
+}
+
+func (s *ReadinessSuite) TestAlias() {
+	s.add("agency.gov. 60 IN A 192.0.2.2")
+
+	status := s.checker.Check("agency.gov", "abc.cloudfront.net", nil, false)
+	s.Equal(DomainReady, status.Status)
+	s.Equal("ALIAS to abc.cloudfront.net", status.Found)
+}
+
+func (s *ReadinessSuite) TestWrongTarget() {
+	s.add("www.agency.gov. 60 IN CNAME old.example.com.")
+	s.add("agency.gov. 60 IN A 198.51.100.1")
+
+	status := s.checker.Check("www.agency.gov", "abc.cloudfront.net", nil, false)
+	s.Equal(DomainWrongTarget, status.Status)
+	s.Equal("CNAME to old.example.com", status.Found)
+
+	status = s.checker.Check("agency.gov", "abc.cloudfront.net", nil, false)
+	s.Equal(DomainWrongTarget, status.Status)
+	s.Equal("A 198.51.100.1", status.Found)
+}
+
+func (s *ReadinessSuite) TestMissing() {
+	s.add("agency.gov. 60 IN MX 10 mail.agency.gov.")
+
+	status := s.checker.Check("agency.gov", "abc.cloudfront.net", nil, false)
+	s.Equal(DomainMissing, status.Status)
+}
+
+func (s *ReadinessSuite) TestNXDomain() {
+	record := &ValidationRecord{Name: "_acme-challenge.www.agency.gov.", Type: "TXT", Value: "token"}
+
+	status := s.checker.Check("www.agency.gov", "abc.cloudfront.net", record, false)
+	s.Equal(DomainNXDomain, status.Status)
+	s.False(status.RecordFound)
+	s.Equal("www.agency.gov: NXDOMAIN; TXT record name: _acme-challenge.www.agency.gov., value: token, type: TXT", status.String())
+}
+
+func (s *ReadinessSuite) TestValidationRecordSuffices() {
+	s.add(`_acme-challenge.www.agency.gov. 60 IN TXT "token"`)
+	record := &ValidationRecord{Name: "_acme-challenge.www.agency.gov.", Type: "TXT", Value: "token"}
+
+	status := s.checker.Check("www.agency.gov", "abc.cloudfront.net", record, false)
+	s.Equal(DomainReady, status.Status)
+	s.True(status.RecordFound)
+	s.Equal("TXT record found", status.Found)
+}
+
+func (s *ReadinessSuite) TestValidationRecordRequired() {
+	s.add("www.agency.gov. 60 IN CNAME abc.cloudfront.net.")
+	record := &ValidationRecord{Name: "_x1.www.agency.gov.", Type: "CNAME", Value: "_x2.acm-validations.aws."}
+
+	status := s.checker.Check("www.agency.gov", "abc.cloudfront.net", record, true)
+	s.Equal(DomainMissing, status.Status)
+	s.Equal("validation record not found", status.Found)
+
+	s.add("_x1.www.agency.gov. 60 IN CNAME _x2.acm-validations.aws.")
+	status = s.checker.Check("www.agency.gov", "abc.cloudfront.net", record, true)
+	s.Equal(DomainReady, status.Status)
+	s.True(status.RecordFound)
+}
+
+func (s *ReadinessSuite) TestLookupFailure() {
+	status := s.checker.Check("servfail.gov", "abc.cloudfront.net", nil, false)
+	s.Equal(DomainLookupFailed, status.Status)
+}
@@ -0,0 +1,133 @@
+package utils
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"hash/fnv"
+	"net/http"
+	"time"
+
+	"github.com/go-acme/lego/v4/acme/api"
+	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/go-acme/lego/v4/certificate"
+
+	"github.com/cloud-gov/cf-cdn-service-broker/config"
+)
+
+// defaultCertificateLifetime is assumed for certificates that can't be parsed.
+const defaultCertificateLifetime = 90 * 24 * time.Hour
+
+// defaultRenewalInfoRetry is how long to wait before asking for a
+// certificate's renewal information again if the CA doesn't say.
+const defaultRenewalInfoRetry = 6 * time.Hour
+
+// ErrNoRenewalInfo is returned by NewRenewalInfoClient if the CA doesn't
+// support ACME Renewal Information.
+var ErrNoRenewalInfo = api.ErrNoARI
+
+// RenewalTime returns when a certificate should be renewed: RenewBefore ahead
+// of its expiry, or if RenewBefore is zero, once RenewBeforeFraction of its
+// lifetime remains. The time is brought forward by up to RenewJitter, by an
+// amount fixed for each instance, so that certificates issued together aren't
+// all renewed together.
+func RenewalTime(settings config.Settings, instanceId string, cert []byte, expires time.Time) time.Time {
+	notBefore := expires.Add(-defaultCertificateLifetime)
+	if parsed, err := certcrypto.ParsePEMCertificate(cert); err == nil {
+		notBefore, expires = parsed.NotBefore, parsed.NotAfter
+	}
+
+	renewBefore := settings.RenewBefore
+	if renewBefore <= 0 {
+		renewBefore = time.Duration(float64(expires.Sub(notBefore)) * settings.RenewBeforeFraction)
+	}
+
+	jitter := time.Duration(float64(settings.RenewJitter) * jitterFraction(instanceId))
+	return expires.Add(-renewBefore - jitter)
+}
+
+// jitterFraction maps an instance to a fraction in [0, 1).
+func jitterFraction(instanceId string) float64 {
+	h := fnv.New64a()
+	h.Write([]byte(instanceId))
+	return float64(h.Sum64()%1000000) / 1000000
+}
+
+// RenewalInfo is the renewal window that the CA suggests for a certificate
+// through ACME Renewal Information (ARI).
+type RenewalInfo struct {
+	Start time.Time
+	End   time.Time
+
+	// RetryAt is when the CA should be asked for the window again.
+	RetryAt time.Time
+}
+
+// RenewAt picks the instance's time within the suggested window.
+func (i RenewalInfo) RenewAt(instanceId string) time.Time {
+	window := i.End.Sub(i.Start)
+	if window <= 0 {
+		return i.Start
+	}
+	return i.Start.Add(time.Duration(float64(window) * jitterFraction(instanceId)))
+}
+
+type RenewalInfoIface interface {
+	GetRenewalInfo(cert []byte) (RenewalInfo, error)
+}
+
+// RenewalInfoClient fetches ACME Renewal Information. Renewal information
+// isn't tied to an account, so the client doesn't use one.
+type RenewalInfoClient struct {
+	certifier *certificate.Certifier
+}
+
+func NewRenewalInfoClient(settings config.Settings) (*RenewalInfoClient, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	core, err := api.New(&http.Client{Timeout: 30 * time.Second}, userAgent, settings.AcmeUrl, "", key)
+	if err != nil {
+		return nil, err
+	}
+
+	if core.GetDirectory().RenewalInfo == "" {
+		return nil, ErrNoRenewalInfo
+	}
+
+	return &RenewalInfoClient{
+		certifier: certificate.NewCertifier(core, nil, certificate.CertifierOptions{}),
+	}, nil
+}
+
+// GetRenewalInfo returns the suggested renewal window for the first
+// certificate in a PEM bundle.
+func (c *RenewalInfoClient) GetRenewalInfo(cert []byte) (RenewalInfo, error) {
+	parsed, err := certcrypto.ParsePEMCertificate(cert)
+	if err != nil {
+		return RenewalInfo{}, err
+	}
+
+	resp, err := c.certifier.GetRenewalInfo(certificate.RenewalInfoRequest{Cert: parsed})
+	if err != nil {
+		return RenewalInfo{}, err
+	}
+
+	if resp.SuggestedWindow.Start.IsZero() || resp.SuggestedWindow.End.Before(resp.SuggestedWindow.Start) {
+		return RenewalInfo{}, errors.New("invalid suggested renewal window")
+	}
+
+	retry := resp.RetryAfter
+	if retry <= 0 {
+		retry = defaultRenewalInfoRetry
+	}
+
+	return RenewalInfo{
+		Start:   resp.SuggestedWindow.Start,
+		End:     resp.SuggestedWindow.End,
+		RetryAt: time.Now().Add(retry),
+	}, nil
+}
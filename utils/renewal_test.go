@@ -0,0 +1,142 @@
+package utils_test
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/cloud-gov/cf-cdn-service-broker/config"
+	. "github.com/cloud-gov/cf-cdn-service-broker/utils"
+	"github.com/cloud-gov/cf-cdn-service-broker/utils/acmetest"
+)
+
+func TestRenewal(t *testing.T) {
+	suite.Run(t, new(RenewalSuite))
+}
+
+type RenewalSuite struct {
+	suite.Suite
+
+	notBefore time.Time
+	notAfter  time.Time
+	cert      []byte
+}
+
+func (s *RenewalSuite) SetupTest() {
+	s.notBefore = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	s.notAfter = s.notBefore.Add(90 * 24 * time.Hour)
+
+	cert, _, err := acmetest.SelfSigned(&x509.Certificate{
+		SerialNumber: big.NewInt(1234),
+		Subject:      pkix.Name{CommonName: "www.agency.gov"},
+		NotBefore:    s.notBefore,
+		NotAfter:     s.notAfter,
+		// ARI certificate ids are built from the authority key id.
+		AuthorityKeyId: []byte{1, 2, 3, 4},
+	})
+	s.Require().NoError(err)
+	s.cert = cert
+}
+
+func (s *RenewalSuite) TestRenewalTimeFraction() {
+	settings := config.Settings{RenewBeforeFraction: 1.0 / 3}
+
+	renewAt := RenewalTime(settings, "instance", s.cert, s.notAfter)
+	s.Equal(s.notAfter.Add(-30*24*time.Hour), renewAt)
+}
+
+func (s *RenewalSuite) TestRenewalTimeAbsolute() {
+	settings := config.Settings{RenewBefore: 14 * 24 * time.Hour, RenewBeforeFraction: 1.0 / 3}
+
+	renewAt := RenewalTime(settings, "instance", s.cert, s.notAfter)
+	s.Equal(s.notAfter.Add(-14*24*time.Hour), renewAt)
+}
+
+func (s *RenewalSuite) TestRenewalTimeUnparseable() {
+	settings := config.Settings{RenewBeforeFraction: 1.0 / 3}
+
+	renewAt := RenewalTime(settings, "instance", []byte("cheese"), s.notAfter)
+	s.Equal(s.notAfter.Add(-30*24*time.Hour), renewAt)
+}
+
+func (s *RenewalSuite) TestRenewalTimeJitter() {
+	settings := config.Settings{RenewBefore: 30 * 24 * time.Hour, RenewJitter: 72 * time.Hour}
+	latest := s.notAfter.Add(-30 * 24 * time.Hour)
+
+	times := map[time.Time]bool{}
+	for _, instanceId := range []string{"a", "b", "c", "d", "e"} {
+		renewAt := RenewalTime(settings, instanceId, s.cert, s.notAfter)
+		s.False(renewAt.After(latest))
+		s.True(renewAt.After(latest.Add(-72 * time.Hour)))
+		s.Equal(renewAt, RenewalTime(settings, instanceId, s.cert, s.notAfter))
+		times[renewAt] = true
+	}
+	s.Len(times, 5)
+}
+
+func (s *RenewalSuite) TestRenewAtInWindow() {
+	info := RenewalInfo{Start: s.notBefore.Add(60 * 24 * time.Hour), End: s.notBefore.Add(62 * 24 * time.Hour)}
+
+	renewAt := info.RenewAt("instance")
+	s.False(renewAt.Before(info.Start))
+	s.True(renewAt.Before(info.End))
+	s.Equal(renewAt, info.RenewAt("instance"))
+}
+
+func (s *RenewalSuite) TestGetRenewalInfo() {
+	start := time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(48 * time.Hour)
+
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/directory":
+			json.NewEncoder(w).Encode(map[string]string{
+				"newNonce":    server.URL + "/nonce",
+				"newAccount":  server.URL + "/account",
+				"newOrder":    server.URL + "/order",
+				"renewalInfo": server.URL + "/renewal-info",
+			})
+		case strings.HasPrefix(r.URL.Path, "/renewal-info/"):
+			w.Header().Set("Retry-After", "3600")
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"suggestedWindow": map[string]time.Time{"start": start, "end": end},
+			})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	client, err := NewRenewalInfoClient(config.Settings{AcmeUrl: server.URL + "/directory"})
+	s.Require().NoError(err)
+
+	info, err := client.GetRenewalInfo(s.cert)
+	s.Require().NoError(err)
+	s.True(start.Equal(info.Start))
+	s.True(end.Equal(info.End))
+	s.WithinDuration(time.Now().Add(time.Hour), info.RetryAt, time.Minute)
+}
+
+func (s *RenewalSuite) TestRenewalInfoUnsupported() {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"newNonce":   server.URL + "/nonce",
+			"newAccount": server.URL + "/account",
+			"newOrder":   server.URL + "/order",
+		})
+	}))
+	defer server.Close()
+
+	_, err := NewRenewalInfoClient(config.Settings{AcmeUrl: server.URL + "/directory"})
+	s.Equal(ErrNoRenewalInfo, err)
+}
@@ -0,0 +1,85 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/cloud-gov/cf-cdn-service-broker/config"
+)
+
+// defaultNameservers are used if neither DNS_RESOLVERS nor /etc/resolv.conf
+// name any.
+var defaultNameservers = []string{"8.8.8.8:53", "8.8.4.4:53"}
+
+// Resolver queries recursive nameservers directly, for the record types and
+// response details that net.Resolver doesn't expose.
+type Resolver struct {
+	Nameservers []string
+	Timeout     time.Duration
+}
+
+// NewResolver uses the nameservers in DNS_RESOLVERS, or else those in
+// /etc/resolv.conf.
+func NewResolver(settings config.Settings) *Resolver {
+	nameservers := []string{}
+	for _, nameserver := range settings.DNSResolvers {
+		if _, _, err := net.SplitHostPort(nameserver); err != nil {
+			nameserver = net.JoinHostPort(nameserver, "53")
+		}
+		nameservers = append(nameservers, nameserver)
+	}
+
+	if len(nameservers) == 0 {
+		if conf, err := dns.ClientConfigFromFile("/etc/resolv.conf"); err == nil {
+			for _, server := range conf.Servers {
+				nameservers = append(nameservers, net.JoinHostPort(server, conf.Port))
+			}
+		}
+	}
+
+	if len(nameservers) == 0 {
+		nameservers = defaultNameservers
+	}
+
+	return &Resolver{Nameservers: nameservers, Timeout: 5 * time.Second}
+}
+
+// Lookup queries the nameservers in turn for name's records of type qtype,
+// until one of them answers. Negative answers such as NXDOMAIN aren't errors;
+// the caller checks the response code.
+func (r *Resolver) Lookup(name string, qtype uint16) (*dns.Msg, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(name), qtype)
+	m.SetEdns0(4096, false)
+
+	client := &dns.Client{Timeout: r.Timeout}
+
+	var lastErr error
+	for _, nameserver := range r.Nameservers {
+		in, _, err := client.Exchange(m, nameserver)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if in.Truncated {
+			tcp := &dns.Client{Net: "tcp", Timeout: r.Timeout}
+			if in, _, err = tcp.Exchange(m, nameserver); err != nil {
+				lastErr = err
+				continue
+			}
+		}
+		if in.Rcode != dns.RcodeSuccess && in.Rcode != dns.RcodeNameError {
+			lastErr = fmt.Errorf("%s answered %s for %s %s", nameserver, dns.RcodeToString[in.Rcode], name, dns.TypeToString[qtype])
+			continue
+		}
+		return in, nil
+	}
+
+	if lastErr == nil {
+		lastErr = fmt.Errorf("no nameservers to look up %s", name)
+	}
+	return nil, lastErr
+}
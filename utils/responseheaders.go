@@ -0,0 +1,300 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/textproto"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/cloudfront"
+)
+
+// ResponseHeaders are headers that CloudFront adds to a distribution's
+// responses: security headers, CustomHeaders and CORS headers. If Override is
+// set, they replace the origin's own headers of the same names; otherwise the
+// origin's are kept.
+type ResponseHeaders struct {
+	StrictTransportSecurity *StrictTransportSecurity `json:"strict_transport_security"`
+	ContentSecurityPolicy   string                   `json:"content_security_policy"`
+	ContentTypeOptions      bool                     `json:"content_type_options"`
+	FrameOptions            string                   `json:"frame_options"`
+	ReferrerPolicy          string                   `json:"referrer_policy"`
+	CustomHeaders           map[string]string        `json:"custom_headers"`
+	Cors                    *Cors                    `json:"cors"`
+	Override                bool                     `json:"override"`
+}
+
+// StrictTransportSecurity configures the Strict-Transport-Security header.
+// MaxAge is in seconds.
+type StrictTransportSecurity struct {
+	MaxAge            int64 `json:"max_age"`
+	IncludeSubdomains bool  `json:"include_subdomains"`
+	Preload           bool  `json:"preload"`
+}
+
+// Cors configures the headers that answer cross-origin requests. MaxAge, in
+// seconds, is how long browsers may cache preflight responses, if it is set.
+type Cors struct {
+	AllowOrigins     []string `json:"allow_origins"`
+	AllowMethods     []string `json:"allow_methods"`
+	AllowHeaders     []string `json:"allow_headers"`
+	ExposeHeaders    []string `json:"expose_headers"`
+	AllowCredentials bool     `json:"allow_credentials"`
+	MaxAge           *int64   `json:"max_age"`
+}
+
+// CloudFront adds up to 10 custom headers to responses.
+const MaxResponseCustomHeaders = 10
+
+var (
+	frameOptions = []string{
+		cloudfront.FrameOptionsListDeny,
+		cloudfront.FrameOptionsListSameorigin,
+	}
+	referrerPolicies = cloudfront.ReferrerPolicyList_Values()
+	corsMethods      = cloudfront.ResponseHeadersPolicyAccessControlAllowMethodsValues_Values()
+
+	// Headers that CloudFront sets itself from a policy's security headers
+	// or CORS config, rather than as custom headers.
+	reservedResponseHeaders = []string{
+		"Strict-Transport-Security",
+		"Content-Security-Policy",
+		"X-Content-Type-Options",
+		"X-Frame-Options",
+		"Referrer-Policy",
+		"X-Xss-Protection",
+	}
+)
+
+// ParseResponseHeaders parses and checks response headers given as JSON. It
+// returns nil if data is empty.
+func ParseResponseHeaders(data string) (*ResponseHeaders, error) {
+	if strings.TrimSpace(data) == "" {
+		return nil, nil
+	}
+	headers := &ResponseHeaders{}
+	if err := json.Unmarshal([]byte(data), headers); err != nil {
+		return nil, err
+	}
+	if err := headers.Validate(); err != nil {
+		return nil, err
+	}
+	return headers, nil
+}
+
+// Validate checks that CloudFront accepts the response headers, and fills in
+// the CORS defaults: the GET, HEAD and OPTIONS methods and any headers.
+func (h *ResponseHeaders) Validate() error {
+	if hsts := h.StrictTransportSecurity; hsts != nil && hsts.MaxAge < 0 {
+		return errors.New("`strict_transport_security` `max_age` must not be negative")
+	}
+	if h.FrameOptions != "" {
+		h.FrameOptions = strings.ToUpper(h.FrameOptions)
+		if !containsString(frameOptions, h.FrameOptions) {
+			return fmt.Errorf("`frame_options` must be one of %s", strings.Join(frameOptions, ", "))
+		}
+	}
+	if h.ReferrerPolicy != "" && !containsString(referrerPolicies, h.ReferrerPolicy) {
+		return fmt.Errorf("`referrer_policy` must be one of %s", strings.Join(referrerPolicies, ", "))
+	}
+
+	if len(h.CustomHeaders) > MaxResponseCustomHeaders {
+		return fmt.Errorf("must not set more than %d `custom_headers`; got %d", MaxResponseCustomHeaders, len(h.CustomHeaders))
+	}
+	for header, value := range h.CustomHeaders {
+		if header == "" || strings.ContainsAny(header, " :\t\r\n") {
+			return fmt.Errorf("invalid custom header name %q", header)
+		}
+		if strings.ContainsAny(value, "\r\n") {
+			return fmt.Errorf("invalid value for custom header %q", header)
+		}
+		if containsHeader(reservedResponseHeaders, header) || strings.HasPrefix(textproto.CanonicalMIMEHeaderKey(header), "Access-Control-") {
+			return fmt.Errorf("custom header %q must be set with its own option", header)
+		}
+	}
+
+	if cors := h.Cors; cors != nil {
+		if len(cors.AllowOrigins) == 0 {
+			return errors.New("`cors` must have `allow_origins`")
+		}
+		if len(cors.AllowMethods) == 0 {
+			cors.AllowMethods = []string{"GET", "HEAD", "OPTIONS"}
+		}
+		for idx, method := range cors.AllowMethods {
+			cors.AllowMethods[idx] = strings.ToUpper(method)
+			if !containsString(corsMethods, cors.AllowMethods[idx]) {
+				return fmt.Errorf("`cors` `allow_methods` must be among %s", strings.Join(corsMethods, ", "))
+			}
+		}
+		if len(cors.AllowHeaders) == 0 {
+			cors.AllowHeaders = []string{"*"}
+		}
+		if cors.MaxAge != nil && *cors.MaxAge < 0 {
+			return errors.New("`cors` `max_age` must not be negative")
+		}
+		if cors.AllowCredentials && containsString(cors.AllowOrigins, "*") {
+			return errors.New("`cors` must not allow credentials from any origin")
+		}
+	}
+	return nil
+}
+
+// Empty reports whether the response headers add no headers at all.
+func (h *ResponseHeaders) Empty() bool {
+	return h.StrictTransportSecurity == nil && h.ContentSecurityPolicy == "" &&
+		!h.ContentTypeOptions && h.FrameOptions == "" && h.ReferrerPolicy == "" &&
+		len(h.CustomHeaders) == 0 && h.Cors == nil
+}
+
+// responseHeadersPolicyConfig returns the response headers policy that adds
+// the headers.
+func (d *Distribution) responseHeadersPolicyConfig(h ResponseHeaders) *cloudfront.ResponseHeadersPolicyConfig {
+	override := aws.Bool(h.Override)
+	security := &cloudfront.ResponseHeadersPolicySecurityHeadersConfig{}
+	if hsts := h.StrictTransportSecurity; hsts != nil {
+		security.StrictTransportSecurity = &cloudfront.ResponseHeadersPolicyStrictTransportSecurity{
+			AccessControlMaxAgeSec: aws.Int64(hsts.MaxAge),
+			IncludeSubdomains:      aws.Bool(hsts.IncludeSubdomains),
+			Preload:                aws.Bool(hsts.Preload),
+			Override:               override,
+		}
+	}
+	if h.ContentSecurityPolicy != "" {
+		security.ContentSecurityPolicy = &cloudfront.ResponseHeadersPolicyContentSecurityPolicy{
+			ContentSecurityPolicy: aws.String(h.ContentSecurityPolicy),
+			Override:              override,
+		}
+	}
+	if h.ContentTypeOptions {
+		security.ContentTypeOptions = &cloudfront.ResponseHeadersPolicyContentTypeOptions{
+			Override: override,
+		}
+	}
+	if h.FrameOptions != "" {
+		security.FrameOptions = &cloudfront.ResponseHeadersPolicyFrameOptions{
+			FrameOption: aws.String(h.FrameOptions),
+			Override:    override,
+		}
+	}
+	if h.ReferrerPolicy != "" {
+		security.ReferrerPolicy = &cloudfront.ResponseHeadersPolicyReferrerPolicy{
+			ReferrerPolicy: aws.String(h.ReferrerPolicy),
+			Override:       override,
+		}
+	}
+
+	names := make([]string, 0, len(h.CustomHeaders))
+	for header := range h.CustomHeaders {
+		names = append(names, header)
+	}
+	sort.Strings(names)
+	custom := &cloudfront.ResponseHeadersPolicyCustomHeadersConfig{
+		Quantity: aws.Int64(int64(len(names))),
+	}
+	for _, header := range names {
+		custom.Items = append(custom.Items, &cloudfront.ResponseHeadersPolicyCustomHeader{
+			Header:   aws.String(header),
+			Value:    aws.String(h.CustomHeaders[header]),
+			Override: override,
+		})
+	}
+
+	config := &cloudfront.ResponseHeadersPolicyConfig{
+		Comment:               aws.String("cdn route service"),
+		SecurityHeadersConfig: security,
+		CustomHeadersConfig:   custom,
+	}
+	if cors := h.Cors; cors != nil {
+		config.CorsConfig = &cloudfront.ResponseHeadersPolicyCorsConfig{
+			AccessControlAllowCredentials: aws.Bool(cors.AllowCredentials),
+			AccessControlAllowHeaders: &cloudfront.ResponseHeadersPolicyAccessControlAllowHeaders{
+				Quantity: aws.Int64(int64(len(cors.AllowHeaders))),
+				Items:    aws.StringSlice(cors.AllowHeaders),
+			},
+			AccessControlAllowMethods: &cloudfront.ResponseHeadersPolicyAccessControlAllowMethods{
+				Quantity: aws.Int64(int64(len(cors.AllowMethods))),
+				Items:    aws.StringSlice(cors.AllowMethods),
+			},
+			AccessControlAllowOrigins: &cloudfront.ResponseHeadersPolicyAccessControlAllowOrigins{
+				Quantity: aws.Int64(int64(len(cors.AllowOrigins))),
+				Items:    aws.StringSlice(cors.AllowOrigins),
+			},
+			AccessControlExposeHeaders: &cloudfront.ResponseHeadersPolicyAccessControlExposeHeaders{
+				Quantity: aws.Int64(int64(len(cors.ExposeHeaders))),
+				Items:    aws.StringSlice(cors.ExposeHeaders),
+			},
+			AccessControlMaxAgeSec: cors.MaxAge,
+			OriginOverride:         override,
+		}
+	}
+	return config
+}
+
+// getResponseHeadersPolicyId returns the id of the response headers policy
+// that adds the headers, creating it if need be, or nil if there are none.
+func (d *Distribution) getResponseHeadersPolicyId(h *ResponseHeaders) (*string, error) {
+	if h == nil || h.Empty() {
+		return nil, nil
+	}
+	id, err := d.ensureResponseHeadersPolicy(d.responseHeadersPolicyConfig(*h))
+	if err != nil {
+		return nil, err
+	}
+	return aws.String(id), nil
+}
+
+// ensureResponseHeadersPolicy returns the id of the broker's response headers
+// policy with config, creating it if it doesn't exist.
+func (d *Distribution) ensureResponseHeadersPolicy(config *cloudfront.ResponseHeadersPolicyConfig) (string, error) {
+	name, err := d.policyName(config)
+	if err != nil {
+		return "", err
+	}
+	if id, err := d.findResponseHeadersPolicy(name); err != nil || id != "" {
+		return id, err
+	}
+
+	config.Name = aws.String(name)
+	resp, err := d.Service.CreateResponseHeadersPolicy(&cloudfront.CreateResponseHeadersPolicyInput{
+		ResponseHeadersPolicyConfig: config,
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == cloudfront.ErrCodeResponseHeadersPolicyAlreadyExists {
+		return d.findResponseHeadersPolicy(name)
+	}
+	if err != nil {
+		return "", err
+	}
+	return *resp.ResponseHeadersPolicy.Id, nil
+}
+
+func (d *Distribution) findResponseHeadersPolicy(name string) (string, error) {
+	input := &cloudfront.ListResponseHeadersPoliciesInput{Type: aws.String(cloudfront.ResponseHeadersPolicyTypeCustom)}
+	for {
+		resp, err := d.Service.ListResponseHeadersPolicies(input)
+		if err != nil {
+			return "", err
+		}
+		for _, item := range resp.ResponseHeadersPolicyList.Items {
+			if aws.StringValue(item.ResponseHeadersPolicy.ResponseHeadersPolicyConfig.Name) == name {
+				return *item.ResponseHeadersPolicy.Id, nil
+			}
+		}
+		if resp.ResponseHeadersPolicyList.NextMarker == nil {
+			return "", nil
+		}
+		input.Marker = resp.ResponseHeadersPolicyList.NextMarker
+	}
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,70 @@
+package utils_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/cloud-gov/cf-cdn-service-broker/config"
+	. "github.com/cloud-gov/cf-cdn-service-broker/utils"
+)
+
+func TestResponseHeaders(t *testing.T) {
+	suite.Run(t, new(ResponseHeadersSuite))
+}
+
+type ResponseHeadersSuite struct {
+	suite.Suite
+}
+
+func (s *ResponseHeadersSuite) TestParseEmpty() {
+	headers, err := ParseResponseHeaders("")
+	s.NoError(err)
+	s.Nil(headers)
+
+	headers, err = ParseResponseHeaders("{}")
+	s.NoError(err)
+	s.True(headers.Empty())
+}
+
+func (s *ResponseHeadersSuite) TestParseDefault() {
+	field, _ := reflect.TypeOf(config.Settings{}).FieldByName("ResponseHeaders")
+	headers, err := ParseResponseHeaders(field.Tag.Get("default"))
+	s.NoError(err)
+	s.False(headers.Empty())
+}
+
+func (s *ResponseHeadersSuite) TestParse() {
+	headers, err := ParseResponseHeaders(`{
+		"content_security_policy": "default-src 'self'",
+		"frame_options": "sameorigin",
+		"referrer_policy": "no-referrer",
+		"cors": {"allow_origins": ["https://www.agency.gov"], "allow_methods": ["get", "post"]},
+		"override": true
+	}`)
+	s.Require().NoError(err)
+	s.Equal("default-src 'self'", headers.ContentSecurityPolicy)
+	s.Equal("SAMEORIGIN", headers.FrameOptions)
+	s.Equal([]string{"GET", "POST"}, headers.Cors.AllowMethods)
+	s.Equal([]string{"*"}, headers.Cors.AllowHeaders)
+	s.True(headers.Override)
+}
+
+func (s *ResponseHeadersSuite) TestParseInvalid() {
+	for _, data := range []string{
+		`{"frame_options": "ALLOW-FROM"}`,
+		`{"referrer_policy": "sometimes"}`,
+		`{"strict_transport_security": {"max_age": -1}}`,
+		`{"custom_headers": {"Strict-Transport-Security": "max-age=1"}}`,
+		`{"custom_headers": {"Access-Control-Allow-Origin": "*"}}`,
+		`{"custom_headers": {"X Agency": "gsa"}}`,
+		`{"cors": {}}`,
+		`{"cors": {"allow_origins": ["*"], "allow_credentials": true}}`,
+		`{"cors": {"allow_origins": ["*"], "allow_methods": ["TRACE"]}}`,
+		`{"custom_headers": {"A": "1", "B": "2", "C": "3", "D": "4", "E": "5", "F": "6", "G": "7", "H": "8", "I": "9", "J": "10", "K": "11"}}`,
+	} {
+		_, err := ParseResponseHeaders(data)
+		s.Error(err, data)
+	}
+}
@@ -0,0 +1,197 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/route53"
+	"github.com/go-acme/lego/v4/challenge/dns01"
+
+	"github.com/cloud-gov/cf-cdn-service-broker/config"
+)
+
+// cloudFrontHostedZoneId is the hosted zone of every CloudFront distribution,
+// used as the target zone of alias records.
+const cloudFrontHostedZoneId = "Z2FDTNDATAQYW2"
+
+// hostedZoneCacheTTL is how long discovered hosted zones are cached for.
+const hostedZoneCacheTTL = 10 * time.Minute
+
+// Route53Iface writes DNS records into the Route 53 hosted zones that the
+// broker manages.
+type Route53Iface interface {
+	// HostedZone returns the id of the managed hosted zone that domain belongs
+	// to, or "" if the broker doesn't manage the domain's zone.
+	HostedZone(domain string) (string, error)
+	UpsertTXT(zoneId, fqdn, value string) error
+	DeleteTXT(zoneId, fqdn, value string) error
+	UpsertAlias(zoneId, domain, target string) error
+	DeleteAlias(zoneId, domain, target string) error
+}
+
+type hostedZone struct {
+	id   string
+	name string
+}
+
+type Route53 struct {
+	Settings config.Settings
+	Service  *route53.Route53
+
+	mu       sync.Mutex
+	zones    []hostedZone
+	loadedAt time.Time
+}
+
+// HostedZone returns the most specific managed hosted zone for domain.
+func (r *Route53) HostedZone(domain string) (string, error) {
+	zones, err := r.hostedZones()
+	if err != nil {
+		return "", err
+	}
+
+	domain = strings.TrimPrefix(dns01.UnFqdn(strings.ToLower(domain)), "*.")
+
+	var match hostedZone
+	for _, zone := range zones {
+		if (domain == zone.name || strings.HasSuffix(domain, "."+zone.name)) && len(zone.name) > len(match.name) {
+			match = zone
+		}
+	}
+	return match.id, nil
+}
+
+// hostedZones returns the configured hosted zones, or if zone discovery is
+// enabled, every public hosted zone the broker can access.
+func (r *Route53) hostedZones() ([]hostedZone, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.zones != nil && time.Since(r.loadedAt) < hostedZoneCacheTTL {
+		return r.zones, nil
+	}
+
+	zones := []hostedZone{}
+	for _, id := range r.Settings.Route53ZoneIds {
+		resp, err := r.Service.GetHostedZone(&route53.GetHostedZoneInput{Id: aws.String(id)})
+		if err != nil {
+			return nil, err
+		}
+		zones = append(zones, newHostedZone(resp.HostedZone))
+	}
+
+	if r.Settings.Route53ZoneDiscovery {
+		err := r.Service.ListHostedZonesPages(&route53.ListHostedZonesInput{},
+			func(page *route53.ListHostedZonesOutput, lastPage bool) bool {
+				for _, zone := range page.HostedZones {
+					if zone.Config == nil || !aws.BoolValue(zone.Config.PrivateZone) {
+						zones = append(zones, newHostedZone(zone))
+					}
+				}
+				return true
+			},
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	r.zones = zones
+	r.loadedAt = time.Now()
+	return zones, nil
+}
+
+func newHostedZone(zone *route53.HostedZone) hostedZone {
+	return hostedZone{
+		id:   strings.TrimPrefix(aws.StringValue(zone.Id), "/hostedzone/"),
+		name: dns01.UnFqdn(strings.ToLower(aws.StringValue(zone.Name))),
+	}
+}
+
+// UpsertTXT creates or replaces a TXT record and waits until Route 53 serves it.
+func (r *Route53) UpsertTXT(zoneId, fqdn, value string) error {
+	return r.changeRecord(zoneId, route53.ChangeActionUpsert, txtRecord(fqdn, value), true)
+}
+
+func (r *Route53) DeleteTXT(zoneId, fqdn, value string) error {
+	return r.changeRecord(zoneId, route53.ChangeActionDelete, txtRecord(fqdn, value), false)
+}
+
+// UpsertAlias points A and AAAA alias records for domain at a CloudFront
+// distribution's domain name.
+func (r *Route53) UpsertAlias(zoneId, domain, target string) error {
+	for _, recordType := range []string{route53.RRTypeA, route53.RRTypeAaaa} {
+		if err := r.changeRecord(zoneId, route53.ChangeActionUpsert, aliasRecord(domain, target, recordType), false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Route53) DeleteAlias(zoneId, domain, target string) error {
+	for _, recordType := range []string{route53.RRTypeA, route53.RRTypeAaaa} {
+		if err := r.changeRecord(zoneId, route53.ChangeActionDelete, aliasRecord(domain, target, recordType), false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Route53) changeRecord(zoneId, action string, record *route53.ResourceRecordSet, wait bool) error {
+	resp, err := r.Service.ChangeResourceRecordSets(&route53.ChangeResourceRecordSetsInput{
+		HostedZoneId: aws.String(zoneId),
+		ChangeBatch: &route53.ChangeBatch{
+			Comment: aws.String("cf-cdn-service-broker"),
+			Changes: []*route53.Change{
+				{Action: aws.String(action), ResourceRecordSet: record},
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	if !wait {
+		return nil
+	}
+
+	// Polls run within broker requests, so don't wait for long; if the change
+	// hasn't propagated, the next poll upserts the record and waits again.
+	err = r.Service.WaitUntilResourceRecordSetsChangedWithContext(
+		aws.BackgroundContext(),
+		&route53.GetChangeInput{Id: resp.ChangeInfo.Id},
+		request.WithWaiterMaxAttempts(4),
+		request.WithWaiterDelay(request.ConstantWaiterDelay(5*time.Second)),
+	)
+	if err != nil {
+		return fmt.Errorf("waiting for %s record %s: %v", aws.StringValue(record.Type), aws.StringValue(record.Name), err)
+	}
+	return nil
+}
+
+func txtRecord(fqdn, value string) *route53.ResourceRecordSet {
+	return &route53.ResourceRecordSet{
+		Name: aws.String(fqdn),
+		Type: aws.String(route53.RRTypeTxt),
+		TTL:  aws.Int64(int64(dns01.DefaultTTL)),
+		ResourceRecords: []*route53.ResourceRecord{
+			{Value: aws.String(fmt.Sprintf("%q", value))},
+		},
+	}
+}
+
+func aliasRecord(domain, target, recordType string) *route53.ResourceRecordSet {
+	return &route53.ResourceRecordSet{
+		Name: aws.String(dns01.ToFqdn(domain)),
+		Type: aws.String(recordType),
+		AliasTarget: &route53.AliasTarget{
+			DNSName:              aws.String(dns01.ToFqdn(target)),
+			HostedZoneId:         aws.String(cloudFrontHostedZoneId),
+			EvaluateTargetHealth: aws.Bool(false),
+		},
+	}
+}
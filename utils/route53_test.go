@@ -0,0 +1,119 @@
+package utils_test
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/route53"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/cloud-gov/cf-cdn-service-broker/config"
+	. "github.com/cloud-gov/cf-cdn-service-broker/utils"
+)
+
+func TestRoute53(t *testing.T) {
+	suite.Run(t, new(Route53Suite))
+}
+
+type Route53Suite struct {
+	suite.Suite
+
+	service *route53.Route53
+	changes []*route53.Change
+}
+
+func (s *Route53Suite) SetupTest() {
+	s.changes = nil
+	s.service = route53.New(session.New(aws.NewConfig().WithRegion("us-east-1")))
+	s.service.Handlers.Clear()
+	s.service.Handlers.Send.PushBack(func(r *request.Request) {
+		switch r.Operation.Name {
+		case "GetHostedZone":
+			id := aws.StringValue(r.Params.(*route53.GetHostedZoneInput).Id)
+			names := map[string]string{"Z1": "agency.gov.", "Z2": "sub.agency.gov."}
+			r.Data.(*route53.GetHostedZoneOutput).HostedZone = &route53.HostedZone{
+				Id:   aws.String("/hostedzone/" + id),
+				Name: aws.String(names[id]),
+			}
+		case "ListHostedZones":
+			data := r.Data.(*route53.ListHostedZonesOutput)
+			data.IsTruncated = aws.Bool(false)
+			data.HostedZones = []*route53.HostedZone{
+				{
+					Id:     aws.String("/hostedzone/Z3"),
+					Name:   aws.String("bureau.gov."),
+					Config: &route53.HostedZoneConfig{PrivateZone: aws.Bool(false)},
+				},
+				{
+					Id:     aws.String("/hostedzone/Z4"),
+					Name:   aws.String("internal.gov."),
+					Config: &route53.HostedZoneConfig{PrivateZone: aws.Bool(true)},
+				},
+			}
+		case "ChangeResourceRecordSets":
+			s.changes = append(s.changes, r.Params.(*route53.ChangeResourceRecordSetsInput).ChangeBatch.Changes...)
+			r.Data.(*route53.ChangeResourceRecordSetsOutput).ChangeInfo = &route53.ChangeInfo{
+				Id:     aws.String("change"),
+				Status: aws.String(route53.ChangeStatusPending),
+			}
+		case "GetChange":
+			r.Data.(*route53.GetChangeOutput).ChangeInfo = &route53.ChangeInfo{
+				Id:     aws.String("change"),
+				Status: aws.String(route53.ChangeStatusInsync),
+			}
+		}
+	})
+}
+
+func (s *Route53Suite) TestHostedZoneConfigured() {
+	r := &Route53{Settings: config.Settings{Route53ZoneIds: []string{"Z1", "Z2"}}, Service: s.service}
+
+	for domain, expected := range map[string]string{
+		"agency.gov":           "Z1",
+		"www.agency.gov":       "Z1",
+		"www.sub.agency.gov":   "Z2",
+		"*.sub.agency.gov":     "Z2",
+		"notagency.gov":        "",
+		"www.bureau.gov":       "",
+		"www.sub.agency.gov.":  "Z2",
+		"WWW.AGENCY.GOV":       "Z1",
+		"agency.gov.other.com": "",
+	} {
+		zoneId, err := r.HostedZone(domain)
+		s.NoError(err)
+		s.Equal(expected, zoneId, domain)
+	}
+}
+
+func (s *Route53Suite) TestHostedZoneDiscovery() {
+	r := &Route53{Settings: config.Settings{Route53ZoneDiscovery: true}, Service: s.service}
+
+	zoneId, err := r.HostedZone("www.bureau.gov")
+	s.NoError(err)
+	s.Equal("Z3", zoneId)
+
+	zoneId, err = r.HostedZone("www.internal.gov")
+	s.NoError(err)
+	s.Equal("", zoneId)
+}
+
+func (s *Route53Suite) TestUpsertTXT() {
+	r := &Route53{Service: s.service}
+
+	s.NoError(r.UpsertTXT("Z1", "_acme-challenge.agency.gov.", "value"))
+	s.Require().Len(s.changes, 1)
+	s.Equal(route53.ChangeActionUpsert, aws.StringValue(s.changes[0].Action))
+	s.Equal(`"value"`, aws.StringValue(s.changes[0].ResourceRecordSet.ResourceRecords[0].Value))
+}
+
+func (s *Route53Suite) TestUpsertAlias() {
+	r := &Route53{Service: s.service}
+
+	s.NoError(r.UpsertAlias("Z1", "agency.gov", "d123.cloudfront.net"))
+	s.Require().Len(s.changes, 2)
+	s.Equal(route53.RRTypeA, aws.StringValue(s.changes[0].ResourceRecordSet.Type))
+	s.Equal(route53.RRTypeAaaa, aws.StringValue(s.changes[1].ResourceRecordSet.Type))
+	s.Equal("d123.cloudfront.net.", aws.StringValue(s.changes[0].ResourceRecordSet.AliasTarget.DNSName))
+}
@@ -0,0 +1,165 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+
+	"github.com/cloud-gov/cf-cdn-service-broker/config"
+)
+
+// OCSP statuses of a served certificate.
+const (
+	OCSPGood       = "good"
+	OCSPRevoked    = "revoked"
+	OCSPUnknown    = "unknown"
+	OCSPNotOffered = "not offered"
+	OCSPFailed     = "failed"
+)
+
+// TLSProbe is what a domain served in a TLS handshake. ChainError is why the
+// served chain doesn't verify against the trusted roots, if it doesn't, and
+// OCSPError why the leaf's OCSP status couldn't be checked.
+type TLSProbe struct {
+	Domain     string
+	Leaf       *x509.Certificate
+	Chain      []*x509.Certificate
+	ChainError error
+	OCSPStatus string
+	OCSPError  error
+}
+
+// SerialNumber is the served certificate's serial, formatted as it is stored.
+func (p TLSProbe) SerialNumber() string {
+	return fmt.Sprintf("%x", p.Leaf.SerialNumber)
+}
+
+type TLSProberIface interface {
+	Probe(domain string) (TLSProbe, error)
+}
+
+// TLSProber connects to domains as browsers do, to check the certificates
+// that CloudFront actually serves. Roots are the trusted roots, or the
+// system's if nil, and Dial connects to the domain's address, or net.Dial
+// with Timeout if nil.
+type TLSProber struct {
+	Roots   *x509.CertPool
+	Dial    func(network, address string) (net.Conn, error)
+	Client  *http.Client
+	Timeout time.Duration
+}
+
+func NewTLSProber(settings config.Settings) *TLSProber {
+	return &TLSProber{
+		Client:  &http.Client{Timeout: settings.TlsMonitorTimeout},
+		Timeout: settings.TlsMonitorTimeout,
+	}
+}
+
+// Probe performs a TLS handshake with domain on port 443, sending it as the
+// SNI server name, then verifies the served chain for domain and asks the
+// leaf's OCSP responder for its status. An error means the handshake itself
+// failed.
+func (p *TLSProber) Probe(domain string) (TLSProbe, error) {
+	dial := p.Dial
+	if dial == nil {
+		dial = (&net.Dialer{Timeout: p.Timeout}).Dial
+	}
+
+	conn, err := dial("tcp", net.JoinHostPort(domain, "443"))
+	if err != nil {
+		return TLSProbe{}, err
+	}
+	defer conn.Close()
+
+	if p.Timeout > 0 {
+		conn.SetDeadline(time.Now().Add(p.Timeout))
+	}
+
+	// The chain is verified below, so that what was served is reported even
+	// if it isn't trusted.
+	client := tls.Client(conn, &tls.Config{ServerName: domain, InsecureSkipVerify: true})
+	if err := client.Handshake(); err != nil {
+		return TLSProbe{}, err
+	}
+
+	served := client.ConnectionState().PeerCertificates
+	if len(served) == 0 {
+		return TLSProbe{}, errors.New("no certificate served")
+	}
+
+	probe := TLSProbe{
+		Domain: domain,
+		Leaf:   served[0],
+		Chain:  served[1:],
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range probe.Chain {
+		intermediates.AddCert(cert)
+	}
+	_, probe.ChainError = probe.Leaf.Verify(x509.VerifyOptions{
+		DNSName:       domain,
+		Roots:         p.Roots,
+		Intermediates: intermediates,
+	})
+
+	probe.OCSPStatus, probe.OCSPError = p.ocspStatus(probe)
+	return probe, nil
+}
+
+// ocspStatus asks the leaf's OCSP responder whether it has been revoked.
+func (p *TLSProber) ocspStatus(probe TLSProbe) (string, error) {
+	if len(probe.Leaf.OCSPServer) == 0 {
+		return OCSPNotOffered, nil
+	}
+	if len(probe.Chain) == 0 {
+		return OCSPFailed, errors.New("no issuer served to check OCSP status with")
+	}
+	issuer := probe.Chain[0]
+
+	request, err := ocsp.CreateRequest(probe.Leaf, issuer, nil)
+	if err != nil {
+		return OCSPFailed, err
+	}
+
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Post(probe.Leaf.OCSPServer[0], "application/ocsp-request", bytes.NewReader(request))
+	if err != nil {
+		return OCSPFailed, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return OCSPFailed, fmt.Errorf("OCSP responder answered %s", resp.Status)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return OCSPFailed, err
+	}
+
+	response, err := ocsp.ParseResponseForCert(body, probe.Leaf, issuer)
+	if err != nil {
+		return OCSPFailed, err
+	}
+
+	switch response.Status {
+	case ocsp.Good:
+		return OCSPGood, nil
+	case ocsp.Revoked:
+		return OCSPRevoked, nil
+	default:
+		return OCSPUnknown, nil
+	}
+}
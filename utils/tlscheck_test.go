@@ -0,0 +1,190 @@
+package utils_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"golang.org/x/crypto/ocsp"
+
+	. "github.com/cloud-gov/cf-cdn-service-broker/utils"
+)
+
+func TestTLSCheck(t *testing.T) {
+	suite.Run(t, new(TLSCheckSuite))
+}
+
+// TLSCheckSuite probes a local TLS server, whose certificate is issued by a
+// throwaway CA that also answers OCSP requests.
+type TLSCheckSuite struct {
+	suite.Suite
+
+	ca         *x509.Certificate
+	caKey      *ecdsa.PrivateKey
+	ocsp       *httptest.Server
+	ocspStatus int
+	server     *httptest.Server
+	roots      *x509.CertPool
+}
+
+func (s *TLSCheckSuite) SetupTest() {
+	var err error
+	s.caKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	s.Require().NoError(err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Agency CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &s.caKey.PublicKey, s.caKey)
+	s.Require().NoError(err)
+	s.ca, err = x509.ParseCertificate(der)
+	s.Require().NoError(err)
+
+	s.roots = x509.NewCertPool()
+	s.roots.AddCert(s.ca)
+
+	s.ocspStatus = ocsp.Good
+	s.ocsp = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		request, err := ocsp.ParseRequest(body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		response, err := ocsp.CreateResponse(s.ca, s.ca, ocsp.Response{
+			Status:       s.ocspStatus,
+			SerialNumber: request.SerialNumber,
+			ThisUpdate:   time.Now().Add(-time.Minute),
+			NextUpdate:   time.Now().Add(time.Hour),
+			RevokedAt:    time.Now().Add(-time.Minute),
+		}, s.caKey)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write(response)
+	}))
+}
+
+func (s *TLSCheckSuite) TearDownTest() {
+	s.ocsp.Close()
+	if s.server != nil {
+		s.server.Close()
+		s.server = nil
+	}
+}
+
+// serve starts a TLS server for name, with an OCSP responder if ocspServer.
+func (s *TLSCheckSuite) serve(name string, serial int64, ocspServer bool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	s.Require().NoError(err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if ocspServer {
+		template.OCSPServer = []string{s.ocsp.URL}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, s.ca, &key.PublicKey, s.caKey)
+	s.Require().NoError(err)
+
+	s.server = httptest.NewUnstartedServer(http.NotFoundHandler())
+	s.server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{{
+			Certificate: [][]byte{der, s.ca.Raw},
+			PrivateKey:  key,
+		}},
+	}
+	s.server.StartTLS()
+}
+
+func (s *TLSCheckSuite) prober(roots *x509.CertPool) *TLSProber {
+	return &TLSProber{
+		Roots: roots,
+		Dial: func(network, address string) (net.Conn, error) {
+			return net.Dial(network, s.server.Listener.Addr().String())
+		},
+		Timeout: 5 * time.Second,
+	}
+}
+
+func (s *TLSCheckSuite) TestGood() {
+	s.serve("cdn.agency.gov", 0xabc, true)
+
+	probe, err := s.prober(s.roots).Probe("cdn.agency.gov")
+	s.Require().NoError(err)
+	s.Equal("abc", probe.SerialNumber())
+	s.Len(probe.Chain, 1)
+	s.NoError(probe.ChainError)
+	s.Equal(OCSPGood, probe.OCSPStatus)
+	s.NoError(probe.OCSPError)
+}
+
+func (s *TLSCheckSuite) TestRevoked() {
+	s.ocspStatus = ocsp.Revoked
+	s.serve("cdn.agency.gov", 2, true)
+
+	probe, err := s.prober(s.roots).Probe("cdn.agency.gov")
+	s.Require().NoError(err)
+	s.Equal(OCSPRevoked, probe.OCSPStatus)
+}
+
+func (s *TLSCheckSuite) TestOCSPNotOffered() {
+	s.serve("cdn.agency.gov", 2, false)
+
+	probe, err := s.prober(s.roots).Probe("cdn.agency.gov")
+	s.Require().NoError(err)
+	s.Equal(OCSPNotOffered, probe.OCSPStatus)
+	s.NoError(probe.OCSPError)
+}
+
+func (s *TLSCheckSuite) TestUntrustedChain() {
+	s.serve("cdn.agency.gov", 2, false)
+
+	probe, err := s.prober(x509.NewCertPool()).Probe("cdn.agency.gov")
+	s.Require().NoError(err)
+	s.Error(probe.ChainError)
+}
+
+func (s *TLSCheckSuite) TestWrongName() {
+	s.serve("cdn.agency.gov", 2, false)
+
+	probe, err := s.prober(s.roots).Probe("www.agency.gov")
+	s.Require().NoError(err)
+	s.Equal("cdn.agency.gov", probe.Leaf.Subject.CommonName)
+	s.Error(probe.ChainError)
+}
+
+func (s *TLSCheckSuite) TestHandshakeFailed() {
+	prober := &TLSProber{
+		Dial: func(network, address string) (net.Conn, error) {
+			return net.Dial(network, s.ocsp.Listener.Addr().String())
+		},
+		Timeout: 5 * time.Second,
+	}
+
+	_, err := prober.Probe("cdn.agency.gov")
+	s.Error(err)
+}